@@ -0,0 +1,503 @@
+package api
+
+import (
+    "context"
+    "fmt"
+    "net/url"
+    "strings"
+    "time"
+
+    "github.com/gofiber/fiber/v2"
+    "github.com/golang-jwt/jwt/v5"
+    "go.mongodb.org/mongo-driver/bson"
+    "go.mongodb.org/mongo-driver/bson/primitive"
+    "go.mongodb.org/mongo-driver/mongo"
+    "go.mongodb.org/mongo-driver/mongo/options"
+    "golang.org/x/crypto/bcrypt"
+    "formbuilder/backend/config"
+)
+
+const (
+    emailChangePurpose = "email_change"
+    emailChangeTTL     = 24 * time.Hour
+)
+
+type ProfileRequest struct {
+    Name     *string `json:"name"`
+    Email    string  `json:"email"`
+    Password string  `json:"password"` // required to change email
+}
+
+type ChangePasswordRequest struct {
+    CurrentPassword string `json:"currentPassword"`
+    NewPassword     string `json:"newPassword"`
+}
+
+type DeleteAccountRequest struct {
+    Password string `json:"password"`
+    Code     string `json:"code"` // TOTP code when 2FA is enabled
+    // Forms is "transfer" or "delete" and applies to forms the user owns.
+    Forms      string `json:"forms"`
+    TransferTo string `json:"transferTo"` // email of the new owner
+}
+
+type EmailConfirmRequest struct {
+    Token string `json:"token"`
+}
+
+func generateEmailChangeJWT(userID, email, secret string) (string, error) {
+    token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+        "user_id": userID,
+        "email":   email,
+        "purpose": emailChangePurpose,
+        "exp":     time.Now().Add(emailChangeTTL).Unix(),
+    })
+    return token.SignedString([]byte(secret))
+}
+
+func sendEmailVerification(c *fiber.Ctx, cfg *config.Config, user *User, email string) error {
+    token, err := generateEmailChangeJWT(user.ID.Hex(), email, cfg.JWTSecret)
+    if err != nil {
+        return err
+    }
+    link := cfg.AppURL + "/account/confirm-email?token=" + url.QueryEscape(token)
+    return getMailer(cfg).Send(c.Context(), Message{
+        To:      []string{email},
+        Subject: "Confirm your new email address",
+        Text: fmt.Sprintf("Hi %s,\n\nConfirm %s as the new email for your account by opening:\n\n%s\n\nThe link expires in 24 hours. If you did not request this, ignore this email.\n",
+            user.Name, email, link),
+    })
+}
+
+func GetAccountHandler(cfg *config.Config) fiber.Handler {
+    return func(c *fiber.Ctx) error {
+        user, err := currentUser(c, cfg)
+        if err != nil {
+            return err
+        }
+        return c.JSON(user)
+    }
+}
+
+// UpdateProfileHandler changes the name immediately. A new email is only
+// applied once the link sent to that address is confirmed.
+func UpdateProfileHandler(cfg *config.Config) fiber.Handler {
+    return func(c *fiber.Ctx) error {
+        var req ProfileRequest
+        if err := c.BodyParser(&req); err != nil {
+            return fiber.NewError(fiber.StatusBadRequest, err.Error())
+        }
+        user, err := currentUser(c, cfg)
+        if err != nil {
+            return err
+        }
+
+        set := bson.M{}
+        if req.Name != nil {
+            name := strings.TrimSpace(*req.Name)
+            if name == "" {
+                return fiber.NewError(fiber.StatusBadRequest, "Name must not be empty")
+            }
+            set["name"] = name
+        }
+
+        email := strings.TrimSpace(req.Email)
+        emailChanged := email != "" && !strings.EqualFold(email, user.Email)
+        if emailChanged {
+            if !strings.Contains(email, "@") {
+                return fiber.NewError(fiber.StatusBadRequest, "A valid email is required")
+            }
+            if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(req.Password)); err != nil {
+                return fiber.NewError(fiber.StatusUnauthorized, "Invalid credentials")
+            }
+            set["pendingEmail"] = email
+        }
+
+        if len(set) > 0 {
+            if _, err := usersCol(cfg).UpdateByID(c.Context(), user.ID, bson.M{"$set": set}); err != nil {
+                return fiber.NewError(fiber.StatusInternalServerError, err.Error())
+            }
+        }
+        // Always report success for the email step so the response does not
+        // reveal whether the address is already registered; the conflict is
+        // caught at confirmation.
+        if emailChanged {
+            if err := sendEmailVerification(c, cfg, user, email); err != nil {
+                return fiber.NewError(fiber.StatusInternalServerError, "Failed to send verification email")
+            }
+            recordAudit(c, cfg, AuditEvent{Action: "account.email_change_requested", TargetType: "user", TargetID: user.ID.Hex()})
+        }
+        if _, ok := set["name"]; ok {
+            recordAudit(c, cfg, AuditEvent{Action: "account.profile_updated", TargetType: "user", TargetID: user.ID.Hex()})
+        }
+
+        user, err = currentUser(c, cfg)
+        if err != nil {
+            return err
+        }
+        return c.JSON(user)
+    }
+}
+
+// ConfirmEmailHandler applies a pending email change from the emailed link.
+// It is public because the link may be opened in another browser.
+func ConfirmEmailHandler(cfg *config.Config) fiber.Handler {
+    return func(c *fiber.Ctx) error {
+        var req EmailConfirmRequest
+        if err := c.BodyParser(&req); err != nil {
+            return fiber.NewError(fiber.StatusBadRequest, err.Error())
+        }
+        claims, err := parseJWT(req.Token, cfg.JWTSecret)
+        if err != nil || claims["purpose"] != emailChangePurpose {
+            return fiber.NewError(fiber.StatusBadRequest, "Invalid or expired link")
+        }
+        userID, _ := claims["user_id"].(string)
+        email, _ := claims["email"].(string)
+        oid, err := primitive.ObjectIDFromHex(userID)
+        if err != nil || email == "" {
+            return fiber.NewError(fiber.StatusBadRequest, "Invalid or expired link")
+        }
+
+        // Only the most recent request is honoured
+        res, err := usersCol(cfg).UpdateOne(c.Context(),
+            bson.M{"_id": oid, "pendingEmail": email},
+            bson.M{"$set": bson.M{"email": email, "emailVerified": true}, "$unset": bson.M{"pendingEmail": ""}})
+        if err != nil {
+            if mongo.IsDuplicateKeyError(err) {
+                return fiber.NewError(fiber.StatusConflict, "That email is already in use")
+            }
+            return fiber.NewError(fiber.StatusInternalServerError, err.Error())
+        }
+        if res.MatchedCount == 0 {
+            return fiber.NewError(fiber.StatusBadRequest, "Invalid or expired link")
+        }
+        recordAudit(c, cfg, AuditEvent{ActorID: userID, Action: "account.email_changed", TargetType: "user", TargetID: userID})
+        return c.JSON(fiber.Map{"email": email})
+    }
+}
+
+// VerifyEmailHandler mails a verification link for the account's current
+// email, for accounts registered before addresses were verified.
+func VerifyEmailHandler(cfg *config.Config) fiber.Handler {
+    return func(c *fiber.Ctx) error {
+        user, err := currentUser(c, cfg)
+        if err != nil {
+            return err
+        }
+        if user.EmailVerified {
+            return fiber.NewError(fiber.StatusConflict, "Your email address is already verified")
+        }
+        if err := sendActivationEmail(c, cfg, user); err != nil {
+            return fiber.NewError(fiber.StatusInternalServerError, "Failed to send verification email")
+        }
+        return c.SendStatus(fiber.StatusAccepted)
+    }
+}
+
+// ChangePasswordHandler sets a new password and revokes every other session
+// by bumping the token version. The caller gets a fresh token.
+func ChangePasswordHandler(cfg *config.Config) fiber.Handler {
+    return func(c *fiber.Ctx) error {
+        var req ChangePasswordRequest
+        if err := c.BodyParser(&req); err != nil {
+            return fiber.NewError(fiber.StatusBadRequest, err.Error())
+        }
+        user, err := currentUser(c, cfg)
+        if err != nil {
+            return err
+        }
+        if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(req.CurrentPassword)); err != nil {
+            return fiber.NewError(fiber.StatusUnauthorized, "Invalid credentials")
+        }
+        if err := validatePassword(req.NewPassword, user.Email); err != nil {
+            return err
+        }
+        hashed, err := bcrypt.GenerateFromPassword([]byte(req.NewPassword), bcrypt.DefaultCost)
+        if err != nil {
+            return fiber.NewError(fiber.StatusInternalServerError, "Failed to hash password")
+        }
+
+        var updated User
+        err = usersCol(cfg).FindOneAndUpdate(c.Context(),
+            bson.M{"_id": user.ID},
+            bson.M{"$set": bson.M{"password": string(hashed)}, "$inc": bson.M{"tokenVersion": 1}},
+            options.FindOneAndUpdate().SetReturnDocument(options.After),
+        ).Decode(&updated)
+        if err != nil {
+            return fiber.NewError(fiber.StatusInternalServerError, err.Error())
+        }
+        recordAudit(c, cfg, AuditEvent{Action: "account.password_changed", TargetType: "user", TargetID: user.ID.Hex()})
+
+        mfa, _ := c.Locals("mfa").(bool)
+        s := sessionFromLocals(c, mfa)
+        s.TokenVersion = updated.TokenVersion
+        token, err := generateJWT(s, cfg.JWTSecret)
+        if err != nil {
+            return fiber.NewError(fiber.StatusInternalServerError, "Failed to generate token")
+        }
+        return c.JSON(fiber.Map{"token": token})
+    }
+}
+
+// DeleteAccountHandler removes the user. Owned forms are either handed to
+// another member or deleted with their responses. Workspaces the user is the
+// last owner of are handed to the transfer recipient when they are a member,
+// deleted when nobody else is left, and otherwise block the deletion.
+func DeleteAccountHandler(cfg *config.Config) fiber.Handler {
+    return func(c *fiber.Ctx) error {
+        var req DeleteAccountRequest
+        if err := c.BodyParser(&req); err != nil {
+            return fiber.NewError(fiber.StatusBadRequest, err.Error())
+        }
+        if req.Forms != "transfer" && req.Forms != "delete" {
+            return fiber.NewError(fiber.StatusBadRequest, `forms must be "transfer" or "delete"`)
+        }
+        user, err := currentUser(c, cfg)
+        if err != nil {
+            return err
+        }
+        if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(req.Password)); err != nil {
+            return fiber.NewError(fiber.StatusUnauthorized, "Invalid credentials")
+        }
+        if user.MFAEnabled && !checkSecondFactor(c, cfg, user, req.Code, "") {
+            return fiber.NewError(fiber.StatusUnauthorized, "Invalid verification code")
+        }
+        ctx := c.Context()
+        userID := user.ID.Hex()
+
+        var recipient *User
+        if req.Forms == "transfer" {
+            var r User
+            if err := usersCol(cfg).FindOne(ctx, bson.M{"email": req.TransferTo}).Decode(&r); err != nil || r.ID == user.ID {
+                return fiber.NewError(fiber.StatusBadRequest, "transferTo must be another user's email")
+            }
+            recipient = &r
+        }
+
+        cur, err := membersCol(cfg).Find(ctx, bson.M{"userId": userID})
+        if err != nil {
+            return fiber.NewError(fiber.StatusInternalServerError, err.Error())
+        }
+        var memberships []Membership
+        if err := cur.All(ctx, &memberships); err != nil {
+            return fiber.NewError(fiber.StatusInternalServerError, err.Error())
+        }
+
+        // Plan before changing anything so a blocked deletion leaves no trace
+        var dropWorkspaces []primitive.ObjectID
+        promote := map[primitive.ObjectID]*Membership{}
+        for _, m := range memberships {
+            if m.Role != RoleOwner {
+                continue
+            }
+            owners, err := countOwners(ctx, cfg, m.WorkspaceID)
+            if err != nil {
+                return fiber.NewError(fiber.StatusInternalServerError, err.Error())
+            }
+            if owners > 1 {
+                continue
+            }
+            others, err := membersCol(cfg).CountDocuments(ctx, bson.M{"workspaceId": m.WorkspaceID, "userId": bson.M{"$ne": userID}})
+            if err != nil {
+                return fiber.NewError(fiber.StatusInternalServerError, err.Error())
+            }
+            if others == 0 {
+                dropWorkspaces = append(dropWorkspaces, m.WorkspaceID)
+                continue
+            }
+            if recipient != nil {
+                if rm, err := findMembership(ctx, cfg, m.WorkspaceID, recipient.ID.Hex()); err == nil {
+                    promote[m.WorkspaceID] = rm
+                    continue
+                }
+            }
+            return fiber.NewError(fiber.StatusConflict, "You are the last owner of a shared workspace; transfer ownership first")
+        }
+
+        owned := bson.M{"ownerId": userID, "workspaceId": bson.M{"$nin": dropWorkspaces}}
+        wsIDs, err := formsCol(cfg).Distinct(ctx, "workspaceId", owned)
+        if err != nil {
+            return fiber.NewError(fiber.StatusInternalServerError, err.Error())
+        }
+        for _, raw := range wsIDs {
+            wsID, _ := raw.(primitive.ObjectID)
+            if recipient != nil {
+                // The recipient must be able to edit forms in every workspace involved
+                rm, err := findMembership(ctx, cfg, wsID, recipient.ID.Hex())
+                if err != nil || !roleAllows(rm.Role, permFormsWrite) {
+                    return fiber.NewError(fiber.StatusBadRequest, "transferTo must be an editor in every workspace you own forms in")
+                }
+                continue
+            }
+            // Deleting forms deletes their responses, which the caller's
+            // current role may no longer allow
+            m, err := findMembership(ctx, cfg, wsID, userID)
+            if err != nil || !roleAllows(m.Role, permResponsesDelete) {
+                return fiber.NewError(fiber.StatusForbidden, "You may not delete responses in every workspace you own forms in; transfer your forms instead")
+            }
+        }
+
+        for _, wsID := range dropWorkspaces {
+            if err := deleteWorkspaceData(c, cfg, wsID); err != nil {
+                return fiber.NewError(fiber.StatusInternalServerError, err.Error())
+            }
+        }
+        for wsID, rm := range promote {
+            if _, err := membersCol(cfg).UpdateByID(ctx, rm.ID, bson.M{"$set": bson.M{"role": RoleOwner}}); err != nil {
+                return fiber.NewError(fiber.StatusInternalServerError, err.Error())
+            }
+            recordAudit(c, cfg, AuditEvent{
+                Action:      "workspace.member_role_changed",
+                TargetType:  "workspace",
+                TargetID:    wsID.Hex(),
+                WorkspaceID: wsID.Hex(),
+                Metadata:    bson.M{"userId": rm.UserID, "from": rm.Role, "to": RoleOwner},
+            })
+        }
+
+        if recipient != nil {
+            res, err := formsCol(cfg).UpdateMany(ctx, owned, bson.M{"$set": bson.M{"ownerId": recipient.ID.Hex()}})
+            if err != nil {
+                return fiber.NewError(fiber.StatusInternalServerError, err.Error())
+            }
+            recordAudit(c, cfg, AuditEvent{Action: "account.forms_transferred", TargetType: "user", TargetID: recipient.ID.Hex(), Metadata: bson.M{"forms": res.ModifiedCount}})
+        } else {
+            if err := deleteForms(c, cfg, owned); err != nil {
+                return fiber.NewError(fiber.StatusInternalServerError, err.Error())
+            }
+        }
+
+        if _, err := membersCol(cfg).DeleteMany(ctx, bson.M{"userId": userID}); err != nil {
+            return fiber.NewError(fiber.StatusInternalServerError, err.Error())
+        }
+        if _, err := usersCol(cfg).DeleteOne(ctx, bson.M{"_id": user.ID}); err != nil {
+            return fiber.NewError(fiber.StatusInternalServerError, err.Error())
+        }
+        recordAudit(c, cfg, AuditEvent{Action: "account.deleted", TargetType: "user", TargetID: userID, Metadata: bson.M{"forms": req.Forms}})
+        return c.SendStatus(fiber.StatusNoContent)
+    }
+}
+
+// deleteForms hard-deletes the matching forms and all of their responses.
+func deleteForms(c *fiber.Ctx, cfg *config.Config, filter bson.M) error {
+    ids, err := deleteFormData(c.Context(), cfg, filter)
+    if err != nil {
+        return err
+    }
+    for _, id := range ids {
+        recordAudit(c, cfg, AuditEvent{Action: "form.deleted", TargetType: "form", TargetID: id.Hex()})
+    }
+    return nil
+}
+
+// deleteFormData removes the matching forms and everything stored for them,
+// returning the IDs of the deleted forms.
+func deleteFormData(ctx context.Context, cfg *config.Config, filter bson.M) ([]primitive.ObjectID, error) {
+    raw, err := formsCol(cfg).Distinct(ctx, "_id", filter)
+    if err != nil || len(raw) == 0 {
+        return nil, err
+    }
+    if _, err := responsesCol(cfg).DeleteMany(ctx, bson.M{"formId": bson.M{"$in": raw}}); err != nil {
+        return nil, err
+    }
+    for _, col := range []*mongo.Collection{webhooksCol(cfg), deliveriesCol(cfg), notesCol(cfg), formKeysCol(cfg)} {
+        if _, err := col.DeleteMany(ctx, bson.M{"formId": bson.M{"$in": raw}}); err != nil {
+            return nil, err
+        }
+    }
+    if err := deleteExportJobs(ctx, cfg, raw); err != nil {
+        return nil, err
+    }
+    if _, err := formsCol(cfg).DeleteMany(ctx, bson.M{"_id": bson.M{"$in": raw}}); err != nil {
+        return nil, err
+    }
+    var ids []primitive.ObjectID
+    for _, v := range raw {
+        if id, ok := v.(primitive.ObjectID); ok {
+            ids = append(ids, id)
+        }
+    }
+    return ids, nil
+}
+
+func deleteWorkspaceData(c *fiber.Ctx, cfg *config.Config, workspaceID primitive.ObjectID) error {
+    if err := deleteForms(c, cfg, bson.M{"workspaceId": workspaceID}); err != nil {
+        return err
+    }
+    if _, err := membersCol(cfg).DeleteMany(c.Context(), bson.M{"workspaceId": workspaceID}); err != nil {
+        return err
+    }
+    for _, col := range []*mongo.Collection{foldersCol(cfg), templatesCol(cfg)} {
+        if _, err := col.DeleteMany(c.Context(), bson.M{"workspaceId": workspaceID}); err != nil {
+            return err
+        }
+    }
+    if _, err := workspacesCol(cfg).DeleteOne(c.Context(), bson.M{"_id": workspaceID}); err != nil {
+        return err
+    }
+    recordAudit(c, cfg, AuditEvent{Action: "workspace.deleted", TargetType: "workspace", TargetID: workspaceID.Hex(), WorkspaceID: workspaceID.Hex()})
+    return nil
+}
+
+// ExportAccountHandler returns everything stored about the caller as a
+// single JSON document (GDPR data portability).
+func ExportAccountHandler(cfg *config.Config) fiber.Handler {
+    return func(c *fiber.Ctx) error {
+        user, err := currentUser(c, cfg)
+        if err != nil {
+            return err
+        }
+        ctx := c.Context()
+        userID := user.ID.Hex()
+
+        cur, err := membersCol(cfg).Find(ctx, bson.M{"userId": userID})
+        if err != nil {
+            return fiber.NewError(fiber.StatusInternalServerError, err.Error())
+        }
+        memberships := []Membership{}
+        if err := cur.All(ctx, &memberships); err != nil {
+            return fiber.NewError(fiber.StatusInternalServerError, err.Error())
+        }
+        wsIDs := make([]primitive.ObjectID, 0, len(memberships))
+        for _, m := range memberships {
+            wsIDs = append(wsIDs, m.WorkspaceID)
+        }
+        cur, err = workspacesCol(cfg).Find(ctx, bson.M{"_id": bson.M{"$in": wsIDs}})
+        if err != nil {
+            return fiber.NewError(fiber.StatusInternalServerError, err.Error())
+        }
+        workspaces := []Workspace{}
+        if err := cur.All(ctx, &workspaces); err != nil {
+            return fiber.NewError(fiber.StatusInternalServerError, err.Error())
+        }
+
+        cur, err = formsCol(cfg).Find(ctx, bson.M{"ownerId": userID})
+        if err != nil {
+            return fiber.NewError(fiber.StatusInternalServerError, err.Error())
+        }
+        forms := []Form{}
+        if err := cur.All(ctx, &forms); err != nil {
+            return fiber.NewError(fiber.StatusInternalServerError, err.Error())
+        }
+
+        cur, err = auditCol(cfg).Find(ctx, bson.M{"actorId": userID}, options.Find().SetSort(bson.M{"_id": 1}))
+        if err != nil {
+            return fiber.NewError(fiber.StatusInternalServerError, err.Error())
+        }
+        activity := []AuditEvent{}
+        if err := cur.All(ctx, &activity); err != nil {
+            return fiber.NewError(fiber.StatusInternalServerError, err.Error())
+        }
+
+        recordAudit(c, cfg, AuditEvent{Action: "account.exported", TargetType: "user", TargetID: userID})
+        c.Set("Content-Disposition", "attachment; filename=account-export.json")
+        return c.JSON(fiber.Map{
+            "exportedAt":  time.Now(),
+            "user":        user,
+            "memberships": memberships,
+            "workspaces":  workspaces,
+            "forms":       forms,
+            "activity":    activity,
+        })
+    }
+}
@@ -5,8 +5,6 @@ import (
     "time"
 
     "go.mongodb.org/mongo-driver/bson"
-    "go.mongodb.org/mongo-driver/bson/primitive"
-    "formbuilder/backend/config"
 )
 
 type TrendData struct {
@@ -31,12 +29,7 @@ type EnhancedAnalytics struct {
     CompletionRate     float64                  `json:"completionRate"`
 }
 
-func computeAnalytics(ctx context.Context, cfg *config.Config, formID primitive.ObjectID) (*EnhancedAnalytics, error) {
-    // Get form details
-    var form Form
-    if err := formsCol(cfg).FindOne(ctx, bson.M{"_id": formID}).Decode(&form); err != nil {
-        return nil, err
-    }
+func computeAnalytics(ctx context.Context, store *tenantStore, form *Form) (*EnhancedAnalytics, error) {
 
     an := &EnhancedAnalytics{
         FieldBreakdown:    map[string]Distribution{},
@@ -46,7 +39,7 @@ func computeAnalytics(ctx context.Context, cfg *config.Config, formID primitive.
         SkippedFields:     []SkippedField{},
     }
 
-    cur, err := responsesCol(cfg).Find(ctx, bson.M{"formId": formID})
+    cur, err := store.FindResponses(ctx, bson.M{"formId": form.ID, "spam": bson.M{"$ne": true}})
     if err != nil { return nil, err }
     defer cur.Close(ctx)
 
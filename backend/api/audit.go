@@ -0,0 +1,300 @@
+package api
+
+import (
+    "bytes"
+    "context"
+    "encoding/json"
+    "fmt"
+    "log"
+    "regexp"
+    "strings"
+    "time"
+
+    "github.com/gofiber/fiber/v2"
+    "go.mongodb.org/mongo-driver/bson"
+    "go.mongodb.org/mongo-driver/bson/primitive"
+    "go.mongodb.org/mongo-driver/mongo"
+    "go.mongodb.org/mongo-driver/mongo/options"
+    "formbuilder/backend/config"
+)
+
+// AuditEvent is an append-only record of a security- or data-relevant
+// action. Events are only ever inserted; there is no update or delete path.
+type AuditEvent struct {
+    ID         primitive.ObjectID     `bson:"_id,omitempty" json:"id"`
+    ActorID    string                 `bson:"actorId,omitempty" json:"actorId,omitempty"`
+    Action     string                 `bson:"action" json:"action"`
+    TargetType string                 `bson:"targetType,omitempty" json:"targetType,omitempty"`
+    TargetID   string                 `bson:"targetId,omitempty" json:"targetId,omitempty"`
+    FormID     string                 `bson:"formId,omitempty" json:"formId,omitempty"`
+    WorkspaceID string                `bson:"workspaceId,omitempty" json:"workspaceId,omitempty"`
+    IP         string                 `bson:"ip,omitempty" json:"ip,omitempty"`
+    UserAgent  string                 `bson:"userAgent,omitempty" json:"userAgent,omitempty"`
+    Changes    []string               `bson:"changes,omitempty" json:"changes,omitempty"`
+    Metadata   map[string]interface{} `bson:"metadata,omitempty" json:"metadata,omitempty"`
+    CreatedAt  time.Time              `bson:"createdAt" json:"createdAt"`
+}
+
+const (
+    auditDefaultLimit = 100
+    auditMaxLimit     = 1000
+)
+
+func auditCol(cfg *config.Config) *mongo.Collection {
+    return mongoClient(cfg).Database(cfg.MongoDB).Collection("audit_log")
+}
+
+// recordAudit appends an event, filling request details from the context.
+// Failures are logged rather than returned so auditing never breaks the
+// request that triggered it.
+func recordAudit(c *fiber.Ctx, cfg *config.Config, ev AuditEvent) {
+    insertAudit(c.Context(), cfg, requestAudit(c, ev))
+}
+
+// requestAudit fills in the actor and client of an event from the request,
+// for events recorded after the handler returns, e.g. once a streamed
+// response has been written.
+func requestAudit(c *fiber.Ctx, ev AuditEvent) AuditEvent {
+    if ev.ActorID == "" {
+        if userID, ok := c.Locals("userID").(string); ok {
+            ev.ActorID = userID
+        } else {
+            ev.ActorID = "anonymous"
+        }
+    }
+    if ev.WorkspaceID == "" {
+        if ws, ok := c.Locals("workspaceID").(primitive.ObjectID); ok {
+            ev.WorkspaceID = ws.Hex()
+        }
+    }
+    ev.IP = c.IP()
+    ev.UserAgent = c.Get(fiber.HeaderUserAgent)
+    return ev
+}
+
+// insertAudit records an event outside of a request, e.g. from a
+// background job. Such events have ActorID "system".
+func insertAudit(ctx context.Context, cfg *config.Config, ev AuditEvent) {
+    if ev.ActorID == "" {
+        ev.ActorID = "system"
+    }
+    if ev.FormID == "" && ev.TargetType == "form" {
+        ev.FormID = ev.TargetID
+    }
+    ev.ID = primitive.NewObjectID()
+    ev.CreatedAt = time.Now()
+    if _, err := auditCol(cfg).InsertOne(ctx, ev); err != nil {
+        log.Printf("audit: failed to record %s: %v", ev.Action, err)
+    }
+}
+
+func formAudit(action string, f *Form) AuditEvent {
+    return AuditEvent{Action: action, TargetType: "form", TargetID: f.ID.Hex()}
+}
+
+// diffForms summarizes what changed between two versions of a form.
+func diffForms(before, after *Form) []string {
+    var changes []string
+    if before.Title != after.Title {
+        changes = append(changes, fmt.Sprintf("title: %q -> %q", before.Title, after.Title))
+    }
+    if before.Status != after.Status {
+        changes = append(changes, fmt.Sprintf("status: %s -> %s", before.Status, after.Status))
+    }
+
+    old := map[string]Field{}
+    var oldOrder []string
+    for _, f := range before.Fields {
+        old[f.ID] = f
+        oldOrder = append(oldOrder, f.ID)
+    }
+    var newOrder []string
+    for _, f := range after.Fields {
+        newOrder = append(newOrder, f.ID)
+        prev, ok := old[f.ID]
+        if !ok {
+            changes = append(changes, fmt.Sprintf("field added: %q (%s)", f.Label, f.ID))
+            continue
+        }
+        delete(old, f.ID)
+        if attrs := diffField(prev, f); len(attrs) > 0 {
+            changes = append(changes, fmt.Sprintf("field changed: %q (%s): %s", f.Label, f.ID, strings.Join(attrs, ", ")))
+        }
+    }
+    for _, id := range oldOrder {
+        if f, ok := old[id]; ok {
+            changes = append(changes, fmt.Sprintf("field removed: %q (%s)", f.Label, f.ID))
+        }
+    }
+    if len(old) == 0 && len(oldOrder) == len(newOrder) && strings.Join(oldOrder, ",") != strings.Join(newOrder, ",") {
+        changes = append(changes, "fields reordered")
+    }
+    return changes
+}
+
+func diffField(a, b Field) []string {
+    var attrs []string
+    if a.Label != b.Label {
+        attrs = append(attrs, "label")
+    }
+    if a.Type != b.Type {
+        attrs = append(attrs, "type")
+    }
+    if a.Required != b.Required {
+        attrs = append(attrs, "required")
+    }
+    if strings.Join(a.Options, "\x00") != strings.Join(b.Options, "\x00") {
+        attrs = append(attrs, "options")
+    }
+    if a.Min != b.Min || a.Max != b.Max {
+        attrs = append(attrs, "range")
+    }
+    if fmt.Sprint(a.ShowIf) != fmt.Sprint(b.ShowIf) {
+        attrs = append(attrs, "showIf")
+    }
+    if a.IsPII != b.IsPII {
+        attrs = append(attrs, "isPII")
+    }
+    return attrs
+}
+
+// AdminMiddleware restricts a route group to the users listed in
+// ADMIN_EMAILS. The address must be verified: registering or switching to
+// a listed address someone does not control must not make them an admin.
+func AdminMiddleware(cfg *config.Config) fiber.Handler {
+    return func(c *fiber.Ctx) error {
+        user, err := currentUser(c, cfg)
+        if err != nil {
+            return err
+        }
+        if !user.EmailVerified {
+            return fiber.NewError(fiber.StatusForbidden, "Admin access required")
+        }
+        for _, email := range cfg.AdminEmails {
+            if strings.EqualFold(email, user.Email) {
+                return c.Next()
+            }
+        }
+        return fiber.NewError(fiber.StatusForbidden, "Admin access required")
+    }
+}
+
+// auditFilter builds a query from the request. Path parameters (per-form and
+// per-user routes) take precedence over query parameters.
+func auditFilter(c *fiber.Ctx) (bson.M, error) {
+    filter := bson.M{}
+    if v := c.Query("formId"); v != "" {
+        filter["formId"] = v
+    }
+    if v := c.Query("actorId"); v != "" {
+        filter["actorId"] = v
+    }
+    if v := c.Params("formId"); v != "" {
+        filter["formId"] = v
+    }
+    if v := c.Params("userId"); v != "" {
+        filter["actorId"] = v
+    }
+    if v := c.Query("action"); v != "" {
+        // "form." matches every form action
+        if strings.HasSuffix(v, ".") {
+            filter["action"] = bson.M{"$regex": "^" + regexp.QuoteMeta(v)}
+        } else {
+            filter["action"] = v
+        }
+    }
+    if v := c.Query("workspaceId"); v != "" {
+        filter["workspaceId"] = v
+    }
+    if v := c.Query("targetId"); v != "" {
+        filter["targetId"] = v
+    }
+
+    created := bson.M{}
+    for param, op := range map[string]string{"since": "$gte", "until": "$lt"} {
+        if v := c.Query(param); v != "" {
+            t, err := time.Parse(time.RFC3339, v)
+            if err != nil {
+                return nil, fiber.NewError(fiber.StatusBadRequest, "invalid "+param+": expected RFC3339")
+            }
+            created[op] = t
+        }
+    }
+    if len(created) > 0 {
+        filter["createdAt"] = created
+    }
+    return filter, nil
+}
+
+// AuditLogHandler lists events newest first. Pass the last event's id as
+// "before" to fetch the next page.
+func AuditLogHandler(cfg *config.Config) fiber.Handler {
+    return func(c *fiber.Ctx) error {
+        filter, err := auditFilter(c)
+        if err != nil {
+            return err
+        }
+        if v := c.Query("before"); v != "" {
+            oid, err := primitive.ObjectIDFromHex(v)
+            if err != nil {
+                return fiber.NewError(fiber.StatusBadRequest, "invalid before cursor")
+            }
+            filter["_id"] = bson.M{"$lt": oid}
+        }
+        limit := c.QueryInt("limit", auditDefaultLimit)
+        if limit <= 0 || limit > auditMaxLimit {
+            limit = auditDefaultLimit
+        }
+
+        opts := options.Find().SetSort(bson.D{{Key: "_id", Value: -1}}).SetLimit(int64(limit))
+        cur, err := auditCol(cfg).Find(c.Context(), filter, opts)
+        if err != nil {
+            return fiber.NewError(fiber.StatusInternalServerError, err.Error())
+        }
+        defer cur.Close(c.Context())
+
+        events := []AuditEvent{}
+        if err := cur.All(c.Context(), &events); err != nil {
+            return fiber.NewError(fiber.StatusInternalServerError, err.Error())
+        }
+        resp := fiber.Map{"events": events}
+        if len(events) == limit {
+            resp["nextBefore"] = events[len(events)-1].ID.Hex()
+        }
+        return c.JSON(resp)
+    }
+}
+
+// AuditExportHandler writes every matching event as JSON Lines, oldest first.
+func AuditExportHandler(cfg *config.Config) fiber.Handler {
+    return func(c *fiber.Ctx) error {
+        filter, err := auditFilter(c)
+        if err != nil {
+            return err
+        }
+        cur, err := auditCol(cfg).Find(c.Context(), filter, options.Find().SetSort(bson.D{{Key: "_id", Value: 1}}))
+        if err != nil {
+            return fiber.NewError(fiber.StatusInternalServerError, err.Error())
+        }
+        defer cur.Close(c.Context())
+
+        buf := &bytes.Buffer{}
+        enc := json.NewEncoder(buf)
+        for cur.Next(c.Context()) {
+            var ev AuditEvent
+            if err := cur.Decode(&ev); err != nil {
+                return fiber.NewError(fiber.StatusInternalServerError, err.Error())
+            }
+            if err := enc.Encode(ev); err != nil {
+                return fiber.NewError(fiber.StatusInternalServerError, err.Error())
+            }
+        }
+        if err := cur.Err(); err != nil {
+            return fiber.NewError(fiber.StatusInternalServerError, err.Error())
+        }
+
+        c.Set("Content-Type", "application/x-ndjson")
+        c.Set("Content-Disposition", "attachment; filename=audit.jsonl")
+        return c.Send(buf.Bytes())
+    }
+}
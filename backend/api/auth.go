@@ -1,7 +1,13 @@
 package api
 
 import (
+    "context"
+    "crypto/sha256"
+    "encoding/hex"
     "errors"
+    "fmt"
+    "net/url"
+    "strings"
     "time"
 
     "github.com/gofiber/fiber/v2"
@@ -9,6 +15,7 @@ import (
     "go.mongodb.org/mongo-driver/bson"
     "go.mongodb.org/mongo-driver/bson/primitive"
     "go.mongodb.org/mongo-driver/mongo"
+    "go.mongodb.org/mongo-driver/mongo/options"
     "golang.org/x/crypto/bcrypt"
     "formbuilder/backend/config"
 )
@@ -19,6 +26,18 @@ type User struct {
     Password string             `bson:"password" json:"-"`
     Name     string             `bson:"name" json:"name"`
     CreatedAt time.Time         `bson:"createdAt" json:"createdAt"`
+    DefaultWorkspaceID primitive.ObjectID `bson:"defaultWorkspaceId,omitempty" json:"defaultWorkspaceId,omitempty"`
+    PendingEmail string             `bson:"pendingEmail,omitempty" json:"pendingEmail,omitempty"`
+    EmailVerified bool              `bson:"emailVerified" json:"emailVerified"`
+    Pending      bool               `bson:"pending,omitempty" json:"-"` // registered but not yet activated
+    TokenVersion int                `bson:"tokenVersion" json:"-"` // bumped to revoke existing sessions
+
+    // Two-factor authentication
+    MFAEnabled       bool     `bson:"mfaEnabled" json:"mfaEnabled"`
+    MFASecret        string   `bson:"mfaSecret,omitempty" json:"-"`
+    MFAPendingSecret string   `bson:"mfaPendingSecret,omitempty" json:"-"`
+    MFALastStep      int64    `bson:"mfaLastStep,omitempty" json:"-"`
+    RecoveryCodes    []string `bson:"recoveryCodes,omitempty" json:"-"` // sha256 hashes
 }
 
 type LoginRequest struct {
@@ -32,10 +51,74 @@ type RegisterRequest struct {
     Name     string `json:"name"`
 }
 
+const (
+    emailVerifyPurpose = "email_verify"
+    emailVerifyTTL     = 24 * time.Hour
+
+    // registrationMsg is the answer to every accepted registration, so the
+    // response does not reveal whether the email is already registered.
+    registrationMsg = "Check your email to finish creating your account"
+)
+
+type ActivateRequest struct {
+    Token    string `json:"token"`
+    Password string `json:"password"`
+}
+
 func usersCol(cfg *config.Config) *mongo.Collection {
     return mongoClient(cfg).Database(cfg.MongoDB).Collection("users")
 }
 
+// passwordFingerprint ties an email link to the password the account had
+// when it was sent, so links sent before a password change stop working.
+func passwordFingerprint(hash string) string {
+    sum := sha256.Sum256([]byte(hash))
+    return hex.EncodeToString(sum[:8])
+}
+
+func generateEmailVerifyJWT(user *User, secret string) (string, error) {
+    token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+        "user_id": user.ID.Hex(),
+        "email":   user.Email,
+        "pw":      passwordFingerprint(user.Password),
+        "purpose": emailVerifyPurpose,
+        "exp":     time.Now().Add(emailVerifyTTL).Unix(),
+    })
+    return token.SignedString([]byte(secret))
+}
+
+// sendActivationEmail mails the link that verifies the user's email and,
+// for a new registration, activates the account.
+func sendActivationEmail(c *fiber.Ctx, cfg *config.Config, user *User) error {
+    token, err := generateEmailVerifyJWT(user, cfg.JWTSecret)
+    if err != nil {
+        return err
+    }
+    link := cfg.AppURL + "/auth/activate?token=" + url.QueryEscape(token)
+    return getMailer(cfg).Send(c.Context(), Message{
+        To:      []string{user.Email},
+        Subject: "Confirm your email address",
+        Text: fmt.Sprintf("Hi %s,\n\nConfirm your email address by opening:\n\n%s\n\nThe link expires in 24 hours. If you did not request this, ignore this email.\n",
+            user.Name, link),
+    })
+}
+
+// sendAccountExistsEmail tells the holder of an already registered email
+// that someone tried to register with it.
+func sendAccountExistsEmail(c *fiber.Ctx, cfg *config.Config, user *User) error {
+    return getMailer(cfg).Send(c.Context(), Message{
+        To:      []string{user.Email},
+        Subject: "You already have an account",
+        Text: fmt.Sprintf("Hi %s,\n\nSomeone tried to create an account with this email, which is already registered. If it was you, log in at %s/auth/login instead. Otherwise you can ignore this email.\n",
+            user.Name, cfg.AppURL),
+    })
+}
+
+// RegisterHandler creates an account that stays pending until the link
+// mailed to the address is opened. Every accepted request gets the same
+// answer; an email that is already registered gets a notice instead of a
+// link. A pending account registered again takes the new password and a
+// fresh link, so nobody can hold an address they cannot read.
 func RegisterHandler(cfg *config.Config) fiber.Handler {
     return func(c *fiber.Ctx) error {
         var req RegisterRequest
@@ -43,37 +126,127 @@ func RegisterHandler(cfg *config.Config) fiber.Handler {
             return fiber.NewError(fiber.StatusBadRequest, err.Error())
         }
 
-        // Check if user exists
-        var existingUser User
-        err := usersCol(cfg).FindOne(c.Context(), bson.M{"email": req.Email}).Decode(&existingUser)
-        if err == nil {
-            return fiber.NewError(fiber.StatusConflict, "User already exists")
+        req.Email = normalizeEmail(req.Email)
+        if !strings.Contains(req.Email, "@") {
+            return fiber.NewError(fiber.StatusBadRequest, "A valid email is required")
         }
+        if err := validatePassword(req.Password, req.Email); err != nil {
+            return err
+        }
+
+        // Every registration sends an email, so all of them count towards
+        // the per-IP limit
+        ipAttempts := ipKey("register", c.IP())
+        if err := checkLocked(c, cfg, ipAttempts); err != nil {
+            return err
+        }
+        registerFailure(c, cfg, "", ipAttempts)
 
-        // Hash password
+        // Hash before the existence check so both outcomes take the same time
         hashedPassword, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
         if err != nil {
             return fiber.NewError(fiber.StatusInternalServerError, "Failed to hash password")
         }
 
-        user := User{
-            ID:        primitive.NewObjectID(),
-            Email:     req.Email,
-            Password:  string(hashedPassword),
-            Name:      req.Name,
-            CreatedAt: time.Now(),
+        var existing User
+        err = findUserByEmail(c.Context(), cfg, req.Email, &existing)
+        switch {
+        case err == nil && existing.Pending:
+            existing.Name, existing.Password = req.Name, string(hashedPassword)
+            if _, err := usersCol(cfg).UpdateByID(c.Context(), existing.ID, bson.M{"$set": bson.M{"password": string(hashedPassword), "name": req.Name}}); err != nil {
+                return fiber.NewError(fiber.StatusInternalServerError, err.Error())
+            }
+            err = sendActivationEmail(c, cfg, &existing)
+        case err == nil:
+            recordAudit(c, cfg, AuditEvent{Action: "auth.register_rejected", TargetType: "user", TargetID: existing.ID.Hex()})
+            err = sendAccountExistsEmail(c, cfg, &existing)
+        case err == mongo.ErrNoDocuments:
+            user := User{
+                ID:        primitive.NewObjectID(),
+                Email:     req.Email,
+                Password:  string(hashedPassword),
+                Name:      req.Name,
+                CreatedAt: time.Now(),
+                Pending:   true,
+            }
+            if _, err := usersCol(cfg).InsertOne(c.Context(), user); err != nil {
+                // A concurrent registration of the same email got there first
+                if mongo.IsDuplicateKeyError(err) {
+                    return c.Status(fiber.StatusAccepted).JSON(fiber.Map{"message": registrationMsg})
+                }
+                return fiber.NewError(fiber.StatusInternalServerError, err.Error())
+            }
+            recordAudit(c, cfg, AuditEvent{ActorID: user.ID.Hex(), Action: "auth.register", TargetType: "user", TargetID: user.ID.Hex()})
+            err = sendActivationEmail(c, cfg, &user)
+        default:
+            return fiber.NewError(fiber.StatusInternalServerError, err.Error())
+        }
+        if err != nil {
+            return fiber.NewError(fiber.StatusInternalServerError, "Failed to send email")
         }
+        return c.Status(fiber.StatusAccepted).JSON(fiber.Map{"message": registrationMsg})
+    }
+}
 
-        _, err = usersCol(cfg).InsertOne(c.Context(), user)
+// ActivateHandler confirms an email link: the email is marked verified and
+// a pending account is activated. The account's password is required too,
+// so whoever registered an address cannot have its owner activate the
+// account for them by opening an unsolicited link. The caller is then
+// logged in, as after a password login, unless the account has 2FA.
+func ActivateHandler(cfg *config.Config) fiber.Handler {
+    return func(c *fiber.Ctx) error {
+        var req ActivateRequest
+        if err := c.BodyParser(&req); err != nil {
+            return fiber.NewError(fiber.StatusBadRequest, err.Error())
+        }
+        claims, err := parseJWT(req.Token, cfg.JWTSecret)
+        if err != nil || claims["purpose"] != emailVerifyPurpose {
+            return fiber.NewError(fiber.StatusBadRequest, "Invalid or expired link")
+        }
+        userID, _ := claims["user_id"].(string)
+        email, _ := claims["email"].(string)
+        oid, err := primitive.ObjectIDFromHex(userID)
+        if err != nil || email == "" {
+            return fiber.NewError(fiber.StatusBadRequest, "Invalid or expired link")
+        }
+
+        // The link only counts for the address and password it was sent for
+        var user User
+        if err := usersCol(cfg).FindOne(c.Context(), bson.M{"_id": oid, "email": email}).Decode(&user); err != nil {
+            return fiber.NewError(fiber.StatusBadRequest, "Invalid or expired link")
+        }
+        if claims["pw"] != passwordFingerprint(user.Password) {
+            return fiber.NewError(fiber.StatusBadRequest, "Invalid or expired link")
+        }
+        account := accountKey(user.Email)
+        ipAttempts := ipKey("login", c.IP())
+        if err := checkLocked(c, cfg, account, ipAttempts); err != nil {
+            return err
+        }
+        if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(req.Password)); err != nil {
+            registerFailure(c, cfg, account, ipAttempts)
+            return fiber.NewError(fiber.StatusUnauthorized, "Invalid credentials")
+        }
+        clearFailures(c.Context(), cfg, account)
+        res, err := usersCol(cfg).UpdateOne(c.Context(),
+            bson.M{"_id": oid, "email": email, "password": user.Password},
+            bson.M{"$set": bson.M{"emailVerified": true}, "$unset": bson.M{"pending": ""}})
         if err != nil {
             return fiber.NewError(fiber.StatusInternalServerError, err.Error())
         }
+        if res.MatchedCount == 0 {
+            return fiber.NewError(fiber.StatusBadRequest, "Invalid or expired link")
+        }
+        user.EmailVerified, user.Pending = true, false
+        recordAudit(c, cfg, AuditEvent{ActorID: userID, Action: "account.email_verified", TargetType: "user", TargetID: userID})
 
-        token, err := generateJWT(user.ID.Hex(), cfg.JWTSecret)
+        if user.MFAEnabled {
+            return c.JSON(fiber.Map{"user": user})
+        }
+        token, err := issueSession(c, cfg, &user, false)
         if err != nil {
-            return fiber.NewError(fiber.StatusInternalServerError, "Failed to generate token")
+            return err
         }
-
         return c.JSON(fiber.Map{
             "token": token,
             "user":  user,
@@ -81,6 +254,14 @@ func RegisterHandler(cfg *config.Config) fiber.Handler {
     }
 }
 
+// emailCollation matches addresses case-insensitively, so accounts
+// registered before addresses were lowercased are still found.
+var emailCollation = &options.Collation{Locale: "en", Strength: 2}
+
+func findUserByEmail(ctx context.Context, cfg *config.Config, email string, user *User) error {
+    return usersCol(cfg).FindOne(ctx, bson.M{"email": email}, options.FindOne().SetCollation(emailCollation)).Decode(user)
+}
+
 func LoginHandler(cfg *config.Config) fiber.Handler {
     return func(c *fiber.Ctx) error {
         var req LoginRequest
@@ -88,21 +269,53 @@ func LoginHandler(cfg *config.Config) fiber.Handler {
             return fiber.NewError(fiber.StatusBadRequest, err.Error())
         }
 
+        // One spelling of the address for the lockout counter and the lookup
+        email := normalizeEmail(req.Email)
+        account := accountKey(email)
+        ipAttempts := ipKey("login", c.IP())
+        if err := checkLocked(c, cfg, account, ipAttempts); err != nil {
+            return err
+        }
+
         var user User
-        err := usersCol(cfg).FindOne(c.Context(), bson.M{"email": req.Email}).Decode(&user)
-        if err != nil {
+        err := findUserByEmail(c.Context(), cfg, email, &user)
+        found := err == nil
+
+        // Always run bcrypt so unknown emails are not faster to reject
+        hash := []byte(user.Password)
+        if !found {
+            hash = dummyHash
+        }
+        err = bcrypt.CompareHashAndPassword(hash, []byte(req.Password))
+        if err != nil || !found {
+            registerFailure(c, cfg, account, ipAttempts)
+            recordAudit(c, cfg, AuditEvent{Action: "auth.login_failed", TargetType: "account", TargetID: email})
             return fiber.NewError(fiber.StatusUnauthorized, "Invalid credentials")
         }
+        clearFailures(c.Context(), cfg, account)
+        if user.Pending {
+            return fiber.NewError(fiber.StatusForbidden, "Confirm your email address before logging in")
+        }
 
-        err = bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(req.Password))
-        if err != nil {
-            return fiber.NewError(fiber.StatusUnauthorized, "Invalid credentials")
+        // Second step required: hand out a short-lived token that only
+        // MFAVerifyHandler accepts.
+        if user.MFAEnabled {
+            mfaToken, err := generateMFAPendingJWT(user.ID.Hex(), cfg.JWTSecret)
+            if err != nil {
+                return fiber.NewError(fiber.StatusInternalServerError, "Failed to generate token")
+            }
+            recordAudit(c, cfg, AuditEvent{ActorID: user.ID.Hex(), Action: "auth.login_mfa_challenge", TargetType: "user", TargetID: user.ID.Hex()})
+            return c.JSON(fiber.Map{
+                "mfaRequired": true,
+                "mfaToken":    mfaToken,
+            })
         }
 
-        token, err := generateJWT(user.ID.Hex(), cfg.JWTSecret)
+        token, err := issueSession(c, cfg, &user, false)
         if err != nil {
-            return fiber.NewError(fiber.StatusInternalServerError, "Failed to generate token")
+            return err
         }
+        recordAudit(c, cfg, AuditEvent{ActorID: user.ID.Hex(), Action: "auth.login", TargetType: "user", TargetID: user.ID.Hex()})
 
         return c.JSON(fiber.Map{
             "token": token,
@@ -111,14 +324,87 @@ func LoginHandler(cfg *config.Config) fiber.Handler {
     }
 }
 
-func generateJWT(userID, secret string) (string, error) {
+// session is what a session token asserts about its bearer. MFA records
+// whether the session was established with a second factor.
+type session struct {
+    UserID       string
+    WorkspaceID  string
+    MFA          bool
+    TokenVersion int
+}
+
+func generateJWT(s session, secret string) (string, error) {
+    token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+        "user_id":      s.UserID,
+        "workspace_id": s.WorkspaceID,
+        "mfa":          s.MFA,
+        "tv":           s.TokenVersion,
+        "exp":          time.Now().Add(time.Hour * 24 * 7).Unix(), // 7 days
+    })
+    return token.SignedString([]byte(secret))
+}
+
+// issueSession signs a token for the user's default workspace, creating a
+// personal workspace on first use.
+func issueSession(c *fiber.Ctx, cfg *config.Config, user *User, mfa bool) (string, error) {
+    m, err := defaultMembership(c.Context(), cfg, user)
+    if err != nil {
+        return "", fiber.NewError(fiber.StatusInternalServerError, "Failed to resolve workspace")
+    }
+    token, err := generateJWT(session{UserID: user.ID.Hex(), WorkspaceID: m.WorkspaceID.Hex(), MFA: mfa, TokenVersion: user.TokenVersion}, cfg.JWTSecret)
+    if err != nil {
+        return "", fiber.NewError(fiber.StatusInternalServerError, "Failed to generate token")
+    }
+    return token, nil
+}
+
+// sessionFromLocals re-issues the caller's current session, optionally with
+// a different MFA state.
+func sessionFromLocals(c *fiber.Ctx, mfa bool) session {
+    ws, _ := c.Locals("workspaceID").(primitive.ObjectID)
+    tv, _ := c.Locals("tokenVersion").(int)
+    return session{UserID: c.Locals("userID").(string), WorkspaceID: ws.Hex(), MFA: mfa, TokenVersion: tv}
+}
+
+func generateMFAPendingJWT(userID, secret string) (string, error) {
     token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
         "user_id": userID,
-        "exp":     time.Now().Add(time.Hour * 24 * 7).Unix(), // 7 days
+        "purpose": mfaPendingPurpose,
+        "exp":     time.Now().Add(mfaPendingTTL).Unix(),
     })
     return token.SignedString([]byte(secret))
 }
 
+func parseJWT(tokenString, secret string) (jwt.MapClaims, error) {
+    token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
+        if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+            return nil, errors.New("unexpected signing method")
+        }
+        return []byte(secret), nil
+    })
+    if err != nil || !token.Valid {
+        return nil, errors.New("invalid token")
+    }
+    claims, ok := token.Claims.(jwt.MapClaims)
+    if !ok {
+        return nil, errors.New("invalid token claims")
+    }
+    return claims, nil
+}
+
+// currentUser loads the authenticated user behind the request.
+func currentUser(c *fiber.Ctx, cfg *config.Config) (*User, error) {
+    oid, err := primitive.ObjectIDFromHex(c.Locals("userID").(string))
+    if err != nil {
+        return nil, fiber.NewError(fiber.StatusUnauthorized, "Invalid user ID in token")
+    }
+    var user User
+    if err := usersCol(cfg).FindOne(c.Context(), bson.M{"_id": oid}).Decode(&user); err != nil {
+        return nil, fiber.NewError(fiber.StatusUnauthorized, "User not found")
+    }
+    return &user, nil
+}
+
 func AuthMiddleware(cfg *config.Config) fiber.Handler {
     return func(c *fiber.Ctx) error {
         authHeader := c.Get("Authorization")
@@ -131,28 +417,73 @@ func AuthMiddleware(cfg *config.Config) fiber.Handler {
             tokenString = authHeader[7:]
         }
 
-        token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-            if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-                return nil, errors.New("unexpected signing method")
-            }
-            return []byte(cfg.JWTSecret), nil
-        })
-
-        if err != nil || !token.Valid {
-            return fiber.NewError(fiber.StatusUnauthorized, "Invalid token")
+        if err := authenticate(c, cfg, tokenString); err != nil {
+            return err
         }
+        return c.Next()
+    }
+}
 
-        claims, ok := token.Claims.(jwt.MapClaims)
-        if !ok {
-            return fiber.NewError(fiber.StatusUnauthorized, "Invalid token claims")
-        }
+// authenticate validates a session token and stores the caller's identity
+// and workspace role in the request locals.
+func authenticate(c *fiber.Ctx, cfg *config.Config, tokenString string) error {
+    claims, tokenVersion, err := verifySession(c.Context(), cfg, tokenString)
+    if err != nil {
+        return err
+    }
+    userID := claims["user_id"].(string)
 
-        userID, ok := claims["user_id"].(string)
-        if !ok {
-            return fiber.NewError(fiber.StatusUnauthorized, "Invalid user ID in token")
-        }
+    // Membership is checked on every request so removing someone from a
+    // workspace takes effect immediately.
+    workspaceID, _ := claims["workspace_id"].(string)
+    m, err := resolveMembership(c.Context(), cfg, userID, workspaceID)
+    if err != nil {
+        return fiber.NewError(fiber.StatusUnauthorized, "No access to workspace")
+    }
 
-        c.Locals("userID", userID)
-        return c.Next()
+    mfa, _ := claims["mfa"].(bool)
+    c.Locals("userID", userID)
+    c.Locals("mfa", mfa)
+    c.Locals("workspaceID", m.WorkspaceID)
+    c.Locals("role", m.Role)
+    c.Locals("tokenVersion", tokenVersion)
+    return nil
+}
+
+// verifySession checks a session token without touching the request, for
+// public routes that only need to know who the caller is. It returns the
+// token's claims, whose user_id is set, and the user's token version.
+func verifySession(ctx context.Context, cfg *config.Config, tokenString string) (jwt.MapClaims, int, error) {
+    claims, err := parseJWT(tokenString, cfg.JWTSecret)
+    if err != nil {
+        return nil, 0, fiber.NewError(fiber.StatusUnauthorized, "Invalid token")
+    }
+
+    // Purpose-bound tokens (e.g. MFA pending) are not session tokens
+    if _, ok := claims["purpose"]; ok {
+        return nil, 0, fiber.NewError(fiber.StatusUnauthorized, "Invalid token")
+    }
+
+    userID, ok := claims["user_id"].(string)
+    if !ok {
+        return nil, 0, fiber.NewError(fiber.StatusUnauthorized, "Invalid user ID in token")
+    }
+
+    // Deleted users and sessions revoked by a password change are rejected
+    uid, err := primitive.ObjectIDFromHex(userID)
+    if err != nil {
+        return nil, 0, fiber.NewError(fiber.StatusUnauthorized, "Invalid user ID in token")
+    }
+    var current struct {
+        TokenVersion int `bson:"tokenVersion"`
+    }
+    err = usersCol(cfg).FindOne(ctx, bson.M{"_id": uid}, options.FindOne().SetProjection(bson.M{"tokenVersion": 1})).Decode(&current)
+    if err != nil {
+        return nil, 0, fiber.NewError(fiber.StatusUnauthorized, "Invalid token")
+    }
+    tv, _ := claims["tv"].(float64)
+    if int(tv) != current.TokenVersion {
+        return nil, 0, fiber.NewError(fiber.StatusUnauthorized, "Session has been revoked")
     }
+    return claims, current.TokenVersion, nil
 }
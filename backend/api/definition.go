@@ -0,0 +1,445 @@
+package api
+
+import (
+    "bytes"
+    "encoding/json"
+    "fmt"
+    "reflect"
+    "regexp"
+    "sort"
+    "strings"
+    "time"
+
+    "github.com/gofiber/fiber/v2"
+    "go.mongodb.org/mongo-driver/bson/primitive"
+    "gopkg.in/yaml.v3"
+    "formbuilder/backend/config"
+)
+
+// definitionVersion is the schema version written by exports. Imports must
+// declare it; older versions will be upgraded here when the schema changes.
+const definitionVersion = 1
+
+var fieldTypes = []string{"text", "single_choice", "multi_select", "rating"}
+
+var slugPattern = regexp.MustCompile(`[^a-z0-9]+`)
+
+// FormDefinition is the portable form of a Form: what an author edits, and
+// nothing about its state (status, responses, ownership, lifecycle). The
+// schema is documented in the README under "Form definitions".
+type FormDefinition struct {
+    SchemaVersion int                `json:"schemaVersion"`
+    Title         string             `json:"title"`
+    Tags          []string           `json:"tags,omitempty"`
+    Fields        []Field            `json:"fields"`
+    Logic         DefinitionLogic    `json:"logic"`
+    Settings      DefinitionSettings `json:"settings"`
+}
+
+// DefinitionLogic holds form-level logic. Conditional visibility is part of
+// each field (showIf).
+type DefinitionLogic struct {
+    Rules []Rule `json:"rules,omitempty"`
+}
+
+type DefinitionSettings struct {
+    Protection        *SpamProtection `json:"protection,omitempty"`
+    RespondentPolicy  string          `json:"respondentPolicy,omitempty"`
+    EditWindowMinutes int             `json:"editWindowMinutes,omitempty"`
+    OpensAt           *time.Time      `json:"opensAt,omitempty"`
+    ClosesAt          *time.Time      `json:"closesAt,omitempty"`
+    MaxResponses      int             `json:"maxResponses,omitempty"`
+    ClosedMessage     string          `json:"closedMessage,omitempty"`
+    Notifications     *Notifications  `json:"notifications,omitempty"`
+}
+
+// DefinitionChange is one difference between two definitions. Path uses
+// dots for objects and [id=...] for fields and rules.
+type DefinitionChange struct {
+    Path   string      `json:"path"`
+    Change string      `json:"change"` // added, removed, changed or reordered
+    From   interface{} `json:"from,omitempty"`
+    To     interface{} `json:"to,omitempty"`
+}
+
+func definitionOf(f *Form) *FormDefinition {
+    d := &FormDefinition{
+        SchemaVersion: definitionVersion,
+        Title:         f.Title,
+        Tags:          f.Tags,
+        Fields:        f.Fields,
+        Logic:         DefinitionLogic{Rules: f.Rules},
+        Settings: DefinitionSettings{
+            Protection:        f.Protection,
+            RespondentPolicy:  f.RespondentPolicy,
+            EditWindowMinutes: f.EditWindowMinutes,
+            OpensAt:           f.OpensAt,
+            ClosesAt:          f.ClosesAt,
+            MaxResponses:      f.MaxResponses,
+            ClosedMessage:     f.ClosedMessage,
+        },
+    }
+    if f.Notifications != nil {
+        n := *f.Notifications
+        n.LastDigestAt = nil
+        d.Settings.Notifications = &n
+    }
+    if d.Fields == nil {
+        d.Fields = []Field{}
+    }
+    return d
+}
+
+// apply copies the definition onto f, replacing everything it covers.
+func (d *FormDefinition) apply(f *Form) {
+    f.Title = d.Title
+    f.Tags = d.Tags
+    f.Fields = d.Fields
+    f.Rules = d.Logic.Rules
+    f.Protection = d.Settings.Protection
+    f.RespondentPolicy = d.Settings.RespondentPolicy
+    f.EditWindowMinutes = d.Settings.EditWindowMinutes
+    f.OpensAt = d.Settings.OpensAt
+    f.ClosesAt = d.Settings.ClosesAt
+    f.MaxResponses = d.Settings.MaxResponses
+    f.ClosedMessage = d.Settings.ClosedMessage
+    f.Notifications = d.Settings.Notifications
+}
+
+// validateDefinition checks what the builder guarantees but an edited file
+// may not, then the same rules as any new form.
+func validateDefinition(d *FormDefinition) error {
+    if d.SchemaVersion != definitionVersion {
+        return fiber.NewError(fiber.StatusBadRequest, fmt.Sprintf("unsupported schemaVersion %d; expected %d", d.SchemaVersion, definitionVersion))
+    }
+    ids := map[string]bool{}
+    for _, field := range d.Fields {
+        if strings.TrimSpace(field.ID) == "" {
+            return fiber.NewError(fiber.StatusBadRequest, "every field needs an id")
+        }
+        if ids[field.ID] {
+            return fiber.NewError(fiber.StatusBadRequest, "duplicate field id "+field.ID)
+        }
+        ids[field.ID] = true
+        if !containsString(fieldTypes, field.Type) {
+            return fiber.NewError(fiber.StatusBadRequest, "field "+field.ID+": type must be one of "+strings.Join(fieldTypes, ", "))
+        }
+        if field.Type == "rating" && field.Max != 0 && field.Min > field.Max {
+            return fiber.NewError(fiber.StatusBadRequest, "field "+field.ID+": min must not exceed max")
+        }
+    }
+    for _, field := range d.Fields {
+        if field.ShowIf != nil && (!ids[field.ShowIf.FieldID] || field.ShowIf.FieldID == field.ID) {
+            return fiber.NewError(fiber.StatusBadRequest, "field "+field.ID+": showIf must refer to another field")
+        }
+    }
+    var f Form
+    d.apply(&f)
+    return validateForm(&f)
+}
+
+func definitionFormat(c *fiber.Ctx) (string, error) {
+    format := c.Query("format")
+    if format == "" {
+        if strings.Contains(c.Get(fiber.HeaderContentType), "yaml") {
+            format = "yaml"
+        } else {
+            format = "json"
+        }
+    }
+    if format != "json" && format != "yaml" {
+        return "", fiber.NewError(fiber.StatusBadRequest, "format must be json or yaml")
+    }
+    return format, nil
+}
+
+// parseDefinition reads a JSON or YAML definition. YAML is converted to
+// JSON first so both formats share the JSON field names and the same
+// rejection of unknown keys.
+func parseDefinition(body []byte, format string) (*FormDefinition, error) {
+    if format == "yaml" {
+        var doc interface{}
+        if err := yaml.Unmarshal(body, &doc); err != nil {
+            return nil, fiber.NewError(fiber.StatusBadRequest, "invalid YAML: "+err.Error())
+        }
+        b, err := json.Marshal(doc)
+        if err != nil {
+            return nil, fiber.NewError(fiber.StatusBadRequest, "invalid YAML: "+err.Error())
+        }
+        body = b
+    }
+    dec := json.NewDecoder(bytes.NewReader(body))
+    dec.DisallowUnknownFields()
+    var d FormDefinition
+    if err := dec.Decode(&d); err != nil {
+        return nil, fiber.NewError(fiber.StatusBadRequest, "invalid definition: "+err.Error())
+    }
+    d.Title = strings.TrimSpace(d.Title)
+    return &d, nil
+}
+
+// encodeDefinition writes a definition as indented JSON or as block-style
+// YAML with keys in schema order.
+func encodeDefinition(d *FormDefinition, format string) ([]byte, error) {
+    b, err := json.MarshalIndent(d, "", "  ")
+    if err != nil || format == "json" {
+        return b, err
+    }
+    // JSON is YAML; decoding it into a node keeps the key order
+    var node yaml.Node
+    if err := yaml.Unmarshal(b, &node); err != nil {
+        return nil, err
+    }
+    blockStyle(&node)
+    node.HeadComment = fmt.Sprintf("FormBuilder form definition (schema version %d)", definitionVersion)
+    var buf bytes.Buffer
+    enc := yaml.NewEncoder(&buf)
+    enc.SetIndent(2)
+    if err := enc.Encode(&node); err != nil {
+        return nil, err
+    }
+    return buf.Bytes(), nil
+}
+
+func blockStyle(n *yaml.Node) {
+    // The encoder still quotes strings that would read back as another type
+    n.Style = 0
+    for _, child := range n.Content {
+        blockStyle(child)
+    }
+}
+
+// generic converts a value to plain maps, slices and float64s for diffing.
+func generic(v interface{}) interface{} {
+    b, _ := json.Marshal(v)
+    var out interface{}
+    json.Unmarshal(b, &out)
+    return out
+}
+
+// diffDefinitions lists what changes between two definitions.
+func diffDefinitions(from, to *FormDefinition) []DefinitionChange {
+    changes := []DefinitionChange{}
+    diffValues("", generic(from), generic(to), &changes)
+    return changes
+}
+
+func joinPath(base, key string) string {
+    if base == "" {
+        return key
+    }
+    return base + "." + key
+}
+
+func diffValues(path string, a, b interface{}, out *[]DefinitionChange) {
+    if reflect.DeepEqual(a, b) {
+        return
+    }
+    switch {
+    case a == nil:
+        *out = append(*out, DefinitionChange{Path: path, Change: "added", To: b})
+        return
+    case b == nil:
+        *out = append(*out, DefinitionChange{Path: path, Change: "removed", From: a})
+        return
+    }
+    am, aok := a.(map[string]interface{})
+    bm, bok := b.(map[string]interface{})
+    if aok && bok {
+        keys := map[string]bool{}
+        for k := range am {
+            keys[k] = true
+        }
+        for k := range bm {
+            keys[k] = true
+        }
+        sorted := make([]string, 0, len(keys))
+        for k := range keys {
+            sorted = append(sorted, k)
+        }
+        sort.Strings(sorted)
+        for _, k := range sorted {
+            diffValues(joinPath(path, k), am[k], bm[k], out)
+        }
+        return
+    }
+    al, aok := a.([]interface{})
+    bl, bok := b.([]interface{})
+    if aok && bok {
+        if aIDs, ok := listIDs(al); ok {
+            if bIDs, ok := listIDs(bl); ok {
+                diffByID(path, al, bl, aIDs, bIDs, out)
+                return
+            }
+        }
+    }
+    *out = append(*out, DefinitionChange{Path: path, Change: "changed", From: a, To: b})
+}
+
+// listIDs returns the ids of a list of objects that all have one.
+func listIDs(list []interface{}) ([]string, bool) {
+    ids := make([]string, len(list))
+    for i, v := range list {
+        m, ok := v.(map[string]interface{})
+        if !ok {
+            return nil, false
+        }
+        id, ok := m["id"].(string)
+        if !ok {
+            return nil, false
+        }
+        ids[i] = id
+    }
+    return ids, true
+}
+
+// diffByID compares fields or rules by id, so inserting one does not show
+// every later one as changed.
+func diffByID(path string, a, b []interface{}, aIDs, bIDs []string, out *[]DefinitionChange) {
+    old := map[string]interface{}{}
+    for i, id := range aIDs {
+        old[id] = a[i]
+    }
+    var kept []string
+    for i, id := range bIDs {
+        p := fmt.Sprintf("%s[id=%s]", path, id)
+        prev, ok := old[id]
+        if !ok {
+            *out = append(*out, DefinitionChange{Path: p, Change: "added", To: b[i]})
+            continue
+        }
+        delete(old, id)
+        kept = append(kept, id)
+        diffValues(p, prev, b[i], out)
+    }
+    var oldKept []string
+    for _, id := range aIDs {
+        if v, ok := old[id]; ok {
+            *out = append(*out, DefinitionChange{Path: fmt.Sprintf("%s[id=%s]", path, id), Change: "removed", From: v})
+        } else {
+            oldKept = append(oldKept, id)
+        }
+    }
+    if strings.Join(oldKept, "\x00") != strings.Join(kept, "\x00") {
+        *out = append(*out, DefinitionChange{Path: path, Change: "reordered", From: oldKept, To: kept})
+    }
+}
+
+// ExportDefinitionHandler downloads a form's definition as JSON or YAML.
+func ExportDefinitionHandler(cfg *config.Config) fiber.Handler {
+    return func(c *fiber.Ctx) error {
+        if err := requirePermission(c, permFormsRead); err != nil {
+            return err
+        }
+        f, err := formFromParam(c, cfg)
+        if err != nil {
+            return err
+        }
+        format := c.Query("format", "json")
+        if format != "json" && format != "yaml" {
+            return fiber.NewError(fiber.StatusBadRequest, "format must be json or yaml")
+        }
+        b, err := encodeDefinition(definitionOf(f), format)
+        if err != nil {
+            return fiber.NewError(fiber.StatusInternalServerError, err.Error())
+        }
+        name := formSlug(f)
+        contentType := fiber.MIMEApplicationJSON
+        if format == "yaml" {
+            contentType = "application/yaml"
+        }
+        c.Set(fiber.HeaderContentType, contentType)
+        c.Set(fiber.HeaderContentDisposition, fmt.Sprintf(`attachment; filename="%s.form.%s"`, name, format))
+        return c.Send(b)
+    }
+}
+
+// formSlug names downloaded files after the form's title.
+func formSlug(f *Form) string {
+    name := strings.Trim(slugPattern.ReplaceAllString(strings.ToLower(f.Title), "-"), "-")
+    if name == "" {
+        name = f.ID.Hex()
+    }
+    return name
+}
+
+// ImportFormHandler creates a draft form from a definition. Field IDs are
+// kept so the file can later be imported over the new form. With
+// ?dryRun=true it only validates and returns the resulting changes.
+func ImportFormHandler(cfg *config.Config) fiber.Handler {
+    return func(c *fiber.Ctx) error {
+        if err := requirePermission(c, permFormsWrite); err != nil {
+            return err
+        }
+        format, err := definitionFormat(c)
+        if err != nil {
+            return err
+        }
+        d, err := parseDefinition(c.Body(), format)
+        if err != nil {
+            return err
+        }
+        if err := validateDefinition(d); err != nil {
+            return err
+        }
+        if c.QueryBool("dryRun") {
+            return c.JSON(fiber.Map{"action": "create", "changes": diffDefinitions(&FormDefinition{}, d)})
+        }
+
+        f := &Form{}
+        d.apply(f)
+        f.ID = primitive.NewObjectID()
+        f.Status = statusDraft
+        f.OwnerID = c.Locals("userID").(string)
+        f.CreatedAt = time.Now()
+        f.UpdatedAt = f.CreatedAt
+        if err := storeFor(c, cfg).InsertForm(c.Context(), f); err != nil {
+            return fiber.NewError(fiber.StatusInternalServerError, err.Error())
+        }
+        ev := formAudit("form.created", f)
+        ev.Metadata = map[string]interface{}{"source": "import", "format": format}
+        recordAudit(c, cfg, ev)
+        return c.Status(fiber.StatusCreated).JSON(f)
+    }
+}
+
+// ImportDefinitionHandler replaces a form's definition from a file. Status,
+// responses and everything else outside the definition are kept. With
+// ?dryRun=true nothing is saved and the changes are returned.
+func ImportDefinitionHandler(cfg *config.Config) fiber.Handler {
+    return func(c *fiber.Ctx) error {
+        if err := requirePermission(c, permFormsWrite); err != nil {
+            return err
+        }
+        before, err := formFromParam(c, cfg)
+        if err != nil {
+            return err
+        }
+        format, err := definitionFormat(c)
+        if err != nil {
+            return err
+        }
+        d, err := parseDefinition(c.Body(), format)
+        if err != nil {
+            return err
+        }
+        if err := validateDefinition(d); err != nil {
+            return err
+        }
+        changes := diffDefinitions(definitionOf(before), d)
+        if c.QueryBool("dryRun") {
+            return c.JSON(fiber.Map{"action": "update", "changes": changes})
+        }
+        if len(changes) == 0 {
+            return c.JSON(fiber.Map{"form": before, "changes": changes})
+        }
+
+        f := *before
+        d.apply(&f)
+        updated, err := saveFormUpdate(c, cfg, before, &f, "form.imported")
+        if err != nil {
+            return err
+        }
+        return c.JSON(fiber.Map{"form": updated, "changes": changes})
+    }
+}
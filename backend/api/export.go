@@ -0,0 +1,466 @@
+package api
+
+import (
+    "bufio"
+    "bytes"
+    "context"
+    "encoding/csv"
+    "encoding/json"
+    "fmt"
+    "io"
+    "log"
+    "sort"
+    "strconv"
+    "strings"
+    "time"
+
+    "github.com/gofiber/fiber/v2"
+    "go.mongodb.org/mongo-driver/bson"
+    "go.mongodb.org/mongo-driver/mongo"
+    "go.mongodb.org/mongo-driver/mongo/options"
+    "formbuilder/backend/config"
+)
+
+const (
+    // multiDelimiter joins multi_select options in a single text cell.
+    multiDelimiter = "; "
+    // exportBatchSize is how many responses are fetched per cursor batch
+    // and written between flushes, which bounds an export's memory.
+    exportBatchSize = 500
+    // exportStreamTimeout caps how long a streamed download may take;
+    // larger exports should use export jobs.
+    exportStreamTimeout = time.Hour
+)
+
+// exportWriter writes responses in one format. header is called before the
+// first response, except when a job resumes a partly written file; flush
+// after every batch; close once at the end.
+type exportWriter interface {
+    header() error
+    write(r *Response) error
+    flush() error
+    close() error
+}
+
+// exportFormat describes an export format. Resumable formats can be
+// appended to, so an interrupted job continues its file; the others are
+// rewritten from the start.
+type exportFormat struct {
+    contentType string
+    resumable   bool
+    open        func(w io.Writer, f *Form, opts exportOptions) exportWriter
+}
+
+var exportFormats = map[string]exportFormat{
+    "csv": {contentType: "text/csv", resumable: true, open: func(w io.Writer, f *Form, opts exportOptions) exportWriter {
+        return newCSVExport(w, f, opts)
+    }},
+    "jsonl": {contentType: "application/x-ndjson", resumable: true, open: func(w io.Writer, f *Form, opts exportOptions) exportWriter {
+        return &jsonlExport{w: w, f: f}
+    }},
+    "xlsx": {contentType: "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet", open: func(w io.Writer, f *Form, opts exportOptions) exportWriter {
+        return newXLSXExport(w, f, opts)
+    }},
+    "parquet": {contentType: "application/vnd.apache.parquet", open: func(w io.Writer, f *Form, opts exportOptions) exportWriter {
+        return newParquetExport(w, f, opts)
+    }},
+}
+
+// How PII answers are exported: as stored, replaced by redactedAnswer, or
+// replaced by a pseudonym that is stable within the form.
+const (
+    piiInclude      = "include"
+    piiRedact       = "redact"
+    piiPseudonymize = "pseudonymize"
+)
+
+// exportOptions are the export parameters besides the response filters.
+type exportOptions struct {
+    format string
+    wide   bool
+    oneHot bool
+    pii    string
+}
+
+// parseExportOptions reads format (csv, the default, jsonl, xlsx or
+// parquet), layout (long, the default, or wide; CSV only, the other
+// tabular formats are always wide), multi (delimited, the default, or
+// onehot) and pii (redact, the default, pseudonymize or include).
+func parseExportOptions(c *fiber.Ctx) (exportOptions, error) {
+    opts := exportOptions{format: c.Query("format", "csv"), pii: c.Query("pii", piiRedact)}
+    if _, ok := exportFormats[opts.format]; !ok {
+        return opts, fiber.NewError(fiber.StatusBadRequest, "format must be csv, jsonl, xlsx or parquet")
+    }
+    if opts.pii != piiInclude && opts.pii != piiRedact && opts.pii != piiPseudonymize {
+        return opts, fiber.NewError(fiber.StatusBadRequest, "pii must be include, redact or pseudonymize")
+    }
+    switch c.Query("layout", "long") {
+    case "long":
+    case "wide":
+        opts.wide = true
+    default:
+        return opts, fiber.NewError(fiber.StatusBadRequest, "layout must be long or wide")
+    }
+    switch c.Query("multi", "delimited") {
+    case "delimited":
+    case "onehot":
+        opts.oneHot = true
+    default:
+        return opts, fiber.NewError(fiber.StatusBadRequest, "multi must be delimited or onehot")
+    }
+    return opts, nil
+}
+
+func isMultiField(field *Field) bool {
+    return field.Type == "multi_select" || field.Type == "checkboxes"
+}
+
+// cellText formats an answer for a text cell, joining multi_select
+// selections with multiDelimiter.
+func cellText(v interface{}) string {
+    list, ok := answerList(v)
+    if !ok {
+        return toString(v)
+    }
+    parts := make([]string, len(list))
+    for i, x := range list {
+        parts[i] = toString(x)
+    }
+    return strings.Join(parts, multiDelimiter)
+}
+
+// exportColumn is one wide-format answer column. In one-hot mode a
+// multi_select field has a column per option, plus an Other column for
+// selections that are no longer among its options. Masked columns hold
+// redacted or pseudonymized PII text.
+type exportColumn struct {
+    header string
+    field  *Field
+    option string
+    other  bool
+    masked bool
+}
+
+func wideColumns(f *Form, opts exportOptions) []exportColumn {
+    var cols []exportColumn
+    seen := map[string]int{}
+    label := func(field *Field) string {
+        l := strings.TrimSpace(field.Label)
+        if l == "" {
+            l = field.ID
+        }
+        seen[l]++
+        if n := seen[l]; n > 1 {
+            l += " (" + strconv.Itoa(n) + ")"
+        }
+        return l
+    }
+    for i := range f.Fields {
+        field := &f.Fields[i]
+        l := label(field)
+        masked := field.IsPII && opts.pii != piiInclude
+        if !opts.oneHot || !isMultiField(field) || masked {
+            cols = append(cols, exportColumn{header: l, field: field, masked: masked})
+            continue
+        }
+        for _, opt := range field.Options {
+            cols = append(cols, exportColumn{header: l + ": " + opt, field: field, option: opt})
+        }
+        cols = append(cols, exportColumn{header: l + ": Other", field: field, other: true})
+    }
+    return cols
+}
+
+// wideValue is a response's value in a wide column: nil when unanswered,
+// a bool for one-hot option columns, a []string for multi_select answers
+// and Other columns, and otherwise the answer as stored.
+func wideValue(r *Response, col exportColumn) interface{} {
+    v, ok := r.Answers[col.field.ID]
+    if !ok || v == nil {
+        return nil
+    }
+    list, isList := answerList(v)
+    if !isList {
+        return v
+    }
+    switch {
+    case col.other:
+        rest := []string{}
+        for _, x := range list {
+            if s := toString(x); !containsString(col.field.Options, s) {
+                rest = append(rest, s)
+            }
+        }
+        return rest
+    case col.option != "":
+        for _, x := range list {
+            if toString(x) == col.option {
+                return true
+            }
+        }
+        return false
+    }
+    out := make([]string, len(list))
+    for i, x := range list {
+        out[i] = toString(x)
+    }
+    return out
+}
+
+func wideHeader(cols []exportColumn) []string {
+    row := []string{"response_id", "created_at"}
+    for _, col := range cols {
+        row = append(row, col.header)
+    }
+    return row
+}
+
+// wideRow formats a response against wideColumns. Unanswered fields are
+// empty; one-hot cells are 1 or 0.
+func wideRow(r *Response, cols []exportColumn) []string {
+    row := []string{r.ID.Hex(), r.CreatedAt.Format(time.RFC3339)}
+    for _, col := range cols {
+        switch v := wideValue(r, col).(type) {
+        case nil:
+            row = append(row, "")
+        case bool:
+            if v {
+                row = append(row, "1")
+            } else {
+                row = append(row, "0")
+            }
+        case []string:
+            row = append(row, strings.Join(v, multiDelimiter))
+        default:
+            row = append(row, toString(v))
+        }
+    }
+    return row
+}
+
+// answerKeys lists the answered field IDs in form order, then answers to
+// fields that have since been removed, by ID.
+func answerKeys(f *Form, r *Response) []string {
+    var keys []string
+    known := map[string]bool{}
+    for _, field := range f.Fields {
+        known[field.ID] = true
+        if _, ok := r.Answers[field.ID]; ok {
+            keys = append(keys, field.ID)
+        }
+    }
+    var rest []string
+    for k := range r.Answers {
+        if !known[k] {
+            rest = append(rest, k)
+        }
+    }
+    sort.Strings(rest)
+    return append(keys, rest...)
+}
+
+// longRows formats a response as one row per answer, in answerKeys order.
+func longRows(f *Form, r *Response) [][]string {
+    created := r.CreatedAt.Format(time.RFC3339)
+    var rows [][]string
+    for _, k := range answerKeys(f, r) {
+        rows = append(rows, []string{r.ID.Hex(), created, k, cellText(r.Answers[k])})
+    }
+    return rows
+}
+
+// csvExport writes responses as CSV in the requested layout.
+type csvExport struct {
+    w    *csv.Writer
+    f    *Form
+    wide bool
+    cols []exportColumn
+}
+
+func newCSVExport(w io.Writer, f *Form, opts exportOptions) *csvExport {
+    e := &csvExport{w: csv.NewWriter(w), f: f, wide: opts.wide}
+    if opts.wide {
+        e.cols = wideColumns(f, opts)
+    }
+    return e
+}
+
+func (e *csvExport) header() error {
+    if e.wide {
+        return e.w.Write(wideHeader(e.cols))
+    }
+    return e.w.Write([]string{"response_id", "created_at", "field_id", "value"})
+}
+
+func (e *csvExport) write(r *Response) error {
+    if e.wide {
+        return e.w.Write(wideRow(r, e.cols))
+    }
+    return e.w.WriteAll(longRows(e.f, r))
+}
+
+func (e *csvExport) flush() error {
+    e.w.Flush()
+    return e.w.Error()
+}
+
+func (e *csvExport) close() error {
+    return e.flush()
+}
+
+// jsonlExport writes one JSON object per response with answers keyed by
+// field ID in form order, keeping numbers and lists as they were stored.
+type jsonlExport struct {
+    w   io.Writer
+    f   *Form
+    buf bytes.Buffer
+}
+
+func (e *jsonlExport) header() error { return nil }
+
+func (e *jsonlExport) write(r *Response) error {
+    e.buf.Reset()
+    fmt.Fprintf(&e.buf, `{"id":%q,"createdAt":%q,"answers":{`, r.ID.Hex(), r.CreatedAt.UTC().Format(time.RFC3339Nano))
+    for i, k := range answerKeys(e.f, r) {
+        if i > 0 {
+            e.buf.WriteByte(',')
+        }
+        key, _ := json.Marshal(k)
+        val, err := json.Marshal(r.Answers[k])
+        if err != nil {
+            return err
+        }
+        e.buf.Write(key)
+        e.buf.WriteByte(':')
+        e.buf.Write(val)
+    }
+    e.buf.WriteString("}}\n")
+    _, err := e.w.Write(e.buf.Bytes())
+    return err
+}
+
+func (e *jsonlExport) flush() error { return nil }
+func (e *jsonlExport) close() error { return nil }
+
+// exportFindOptions reads responses oldest first, in batches, so exports
+// can be resumed from the last (createdAt, _id) written.
+func exportFindOptions() *options.FindOptions {
+    return options.Find().
+        SetSort(bson.D{{Key: "createdAt", Value: 1}, {Key: "_id", Value: 1}}).
+        SetBatchSize(exportBatchSize)
+}
+
+// streamResponses writes every response from the cursor, calling
+// checkpoint after each batch with the last response written. Only one
+// batch is held in memory at a time.
+func streamResponses(ctx context.Context, cur *mongo.Cursor, out exportWriter, checkpoint func(rows int, last *Response) error) (int, error) {
+    rows := 0
+    var last Response
+    for cur.Next(ctx) {
+        var r Response
+        if err := cur.Decode(&r); err != nil {
+            return rows, err
+        }
+        if err := out.write(&r); err != nil {
+            return rows, err
+        }
+        rows++
+        last = r
+        if rows%exportBatchSize == 0 {
+            if err := out.flush(); err != nil {
+                return rows, err
+            }
+            if err := checkpoint(rows, &last); err != nil {
+                return rows, err
+            }
+        }
+    }
+    if err := cur.Err(); err != nil {
+        return rows, err
+    }
+    if err := out.flush(); err != nil {
+        return rows, err
+    }
+    if rows%exportBatchSize != 0 {
+        return rows, checkpoint(rows, &last)
+    }
+    return rows, nil
+}
+
+// ExportResponsesHandler streams a form's responses straight from the
+// cursor in the requested format. It accepts the filters of
+// ListResponsesHandler (spam responses are excluded unless spam=all) and
+// the options of parseExportOptions. The export is audited once it has
+// been written, with the number of responses sent, and exports that
+// include raw PII are audited again as pii.exported.
+func ExportResponsesHandler(cfg *config.Config) fiber.Handler {
+    return func(c *fiber.Ctx) error {
+        f, err := formFromParam(c, cfg)
+        if err != nil {
+            return err
+        }
+        opts, err := parseExportOptions(c)
+        if err != nil {
+            return err
+        }
+        if err := exportAccess(c, cfg, f, opts); err != nil {
+            return err
+        }
+        q, err := parseResponseQuery(c, cfg, f)
+        if err != nil {
+            return err
+        }
+
+        keys, err := loadFormKeys(c.Context(), cfg, f.ID)
+        if err != nil {
+            return fiber.NewError(fiber.StatusInternalServerError, err.Error())
+        }
+
+        // The body is written after the handler returns, so the cursor
+        // cannot use the request's context.
+        ctx, cancel := context.WithTimeout(context.Background(), exportStreamTimeout)
+        cur, err := storeFor(c, cfg).FindResponses(ctx, q.filter, exportFindOptions())
+        if err != nil {
+            cancel()
+            return fiber.NewError(fiber.StatusInternalServerError, err.Error())
+        }
+        ev := formAudit("responses.exported", f)
+        ev.Metadata = bson.M{"format": opts.format, "pii": opts.pii, "query": string(c.Request().URI().QueryString())}
+        ev = requestAudit(c, ev)
+        piiEv := requestAudit(c, formAudit("pii.exported", f))
+        var pii []string
+        if opts.pii == piiInclude {
+            pii = piiFieldIDs(f)
+        }
+
+        c.Set(fiber.HeaderContentType, exportFormats[opts.format].contentType)
+        c.Set(fiber.HeaderContentDisposition, fmt.Sprintf(`attachment; filename="%s-responses.%s"`, formSlug(f), opts.format))
+        c.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+            defer cancel()
+            defer cur.Close(ctx)
+            out := openExport(cfg, w, f, opts, keys)
+            rows := 0
+            err := out.header()
+            if err == nil {
+                rows, err = streamResponses(ctx, cur, out, func(int, *Response) error { return w.Flush() })
+            }
+            if err == nil {
+                err = out.close()
+            }
+            if err != nil {
+                log.Printf("export: form=%s: %v", f.ID.Hex(), err)
+            }
+            // Audit even when the stream timed out or the client went away
+            actx, acancel := context.WithTimeout(context.Background(), 10*time.Second)
+            defer acancel()
+            ev.Metadata["responses"] = rows
+            ev.Metadata["complete"] = err == nil
+            insertAudit(actx, cfg, ev)
+            if len(pii) > 0 && rows > 0 {
+                piiEv.Metadata = bson.M{"format": opts.format, "fields": pii, "responses": rows}
+                insertAudit(actx, cfg, piiEv)
+            }
+        })
+        return nil
+    }
+}
@@ -0,0 +1,484 @@
+package api
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "io"
+    "log"
+    "os"
+    "path/filepath"
+    "time"
+
+    "github.com/gofiber/fiber/v2"
+    "go.mongodb.org/mongo-driver/bson"
+    "go.mongodb.org/mongo-driver/bson/primitive"
+    "go.mongodb.org/mongo-driver/mongo"
+    "go.mongodb.org/mongo-driver/mongo/options"
+    "formbuilder/backend/config"
+)
+
+const (
+    exportPending = "pending"
+    exportRunning = "running"
+    exportDone    = "done"
+    exportFailed  = "failed"
+
+    exportPollInterval = 10 * time.Second
+    // exportLease is how long a worker owns a running job without
+    // checkpointing; a job whose lease lapses is resumed by the next poll.
+    exportLease       = 2 * time.Minute
+    exportRetryDelay  = time.Minute
+    exportMaxAttempts = 5
+    exportListLimit   = 50
+)
+
+// ExportJob is an export written to a file in the background, for forms
+// too large to download in one request. Progress is checkpointed after
+// each batch, so a job interrupted by a crash or restart resumes from the
+// last response written rather than starting over.
+type ExportJob struct {
+    ID          primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+    WorkspaceID primitive.ObjectID `bson:"workspaceId" json:"-"`
+    FormID      primitive.ObjectID `bson:"formId" json:"formId"`
+    CreatedBy   string             `bson:"createdBy" json:"createdBy"`
+    Status      string             `bson:"status" json:"status"`
+    Format      string             `bson:"format" json:"format"`
+    Query       string             `bson:"query" json:"query"` // the request's query string, for display
+    Wide        bool               `bson:"wide" json:"-"`
+    OneHot      bool               `bson:"oneHot" json:"-"`
+    PIIMode     string             `bson:"piiMode" json:"piiMode"`
+    Filter      string             `bson:"filter" json:"-"` // Extended JSON; Mongo operators cannot be stored as keys
+    Fields      []Field            `bson:"fields" json:"-"` // the form's fields when the job was created
+    Rows        int                `bson:"rows" json:"rows"`
+    Size        int64              `bson:"size" json:"size"` // bytes written up to the last checkpoint
+    LastCreatedAt *time.Time       `bson:"lastCreatedAt,omitempty" json:"-"`
+    LastID      primitive.ObjectID `bson:"lastId,omitempty" json:"-"`
+    Attempts    int                `bson:"attempts" json:"attempts"`
+    LeaseUntil  time.Time          `bson:"leaseUntil" json:"-"`
+    Error       string             `bson:"error,omitempty" json:"error,omitempty"`
+    CreatedAt   time.Time          `bson:"createdAt" json:"createdAt"`
+    FinishedAt  *time.Time         `bson:"finishedAt,omitempty" json:"finishedAt,omitempty"`
+    ExpiresAt   *time.Time         `bson:"expiresAt,omitempty" json:"expiresAt,omitempty"`
+}
+
+func exportJobsCol(cfg *config.Config) *mongo.Collection {
+    return mongoClient(cfg).Database(cfg.MongoDB).Collection("export_jobs")
+}
+
+// options returns the job's export options. Jobs from before PII modes
+// required PII access and exported PII as stored.
+func (job *ExportJob) options() exportOptions {
+    opts := exportOptions{format: job.Format, wide: job.Wide, oneHot: job.OneHot, pii: job.PIIMode}
+    if opts.pii == "" {
+        opts.pii = piiInclude
+    }
+    return opts
+}
+
+func exportPath(cfg *config.Config, job *ExportJob) string {
+    return filepath.Join(cfg.ExportDir, job.ID.Hex()+"."+job.Format)
+}
+
+// countingWriter tracks how many bytes reached the file, which is the
+// offset a resumed job truncates back to.
+type countingWriter struct {
+    w io.Writer
+    n int64
+}
+
+func (cw *countingWriter) Write(p []byte) (int, error) {
+    n, err := cw.w.Write(p)
+    cw.n += int64(n)
+    return n, err
+}
+
+// findExportJob loads a job of the form from the :exportId parameter.
+func findExportJob(c *fiber.Ctx, cfg *config.Config, f *Form) (*ExportJob, error) {
+    oid, err := primitive.ObjectIDFromHex(c.Params("exportId"))
+    if err != nil {
+        return nil, fiber.NewError(fiber.StatusBadRequest, "invalid export id")
+    }
+    var job ExportJob
+    err = exportJobsCol(cfg).FindOne(c.Context(), bson.M{"_id": oid, "formId": f.ID, "workspaceId": f.WorkspaceID}).Decode(&job)
+    if err == mongo.ErrNoDocuments {
+        return nil, fiber.NewError(fiber.StatusNotFound, "export not found")
+    }
+    if err != nil {
+        return nil, fiber.NewError(fiber.StatusInternalServerError, err.Error())
+    }
+    return &job, nil
+}
+
+// CreateExportJobHandler queues an export. It takes the same parameters
+// as ExportResponsesHandler; the filter and the form's fields are fixed when the
+// job is created.
+func CreateExportJobHandler(cfg *config.Config) fiber.Handler {
+    return func(c *fiber.Ctx) error {
+        f, err := formFromParam(c, cfg)
+        if err != nil {
+            return err
+        }
+        opts, err := parseExportOptions(c)
+        if err != nil {
+            return err
+        }
+        if err := exportAccess(c, cfg, f, opts); err != nil {
+            return err
+        }
+        q, err := parseResponseQuery(c, cfg, f)
+        if err != nil {
+            return err
+        }
+        filter, err := bson.MarshalExtJSON(q.filter, true, false)
+        if err != nil {
+            return fiber.NewError(fiber.StatusInternalServerError, err.Error())
+        }
+        now := time.Now()
+        job := ExportJob{
+            ID:          primitive.NewObjectID(),
+            WorkspaceID: f.WorkspaceID,
+            FormID:      f.ID,
+            CreatedBy:   c.Locals("userID").(string),
+            Status:      exportPending,
+            Format:      opts.format,
+            Query:       string(c.Request().URI().QueryString()),
+            Wide:        opts.wide,
+            OneHot:      opts.oneHot,
+            PIIMode:     opts.pii,
+            Filter:      string(filter),
+            Fields:      f.Fields,
+            LeaseUntil:  now,
+            CreatedAt:   now,
+        }
+        if _, err := exportJobsCol(cfg).InsertOne(c.Context(), job); err != nil {
+            return fiber.NewError(fiber.StatusInternalServerError, err.Error())
+        }
+        ev := formAudit("export.created", f)
+        ev.Metadata = bson.M{"exportId": job.ID.Hex(), "query": job.Query}
+        recordAudit(c, cfg, ev)
+        return c.Status(fiber.StatusAccepted).JSON(job)
+    }
+}
+
+func ListExportJobsHandler(cfg *config.Config) fiber.Handler {
+    return func(c *fiber.Ctx) error {
+        if err := requirePermission(c, permResponsesExport); err != nil {
+            return err
+        }
+        f, err := formFromParam(c, cfg)
+        if err != nil {
+            return err
+        }
+        cur, err := exportJobsCol(cfg).Find(c.Context(), bson.M{"formId": f.ID, "workspaceId": f.WorkspaceID},
+            options.Find().SetSort(bson.M{"_id": -1}).SetLimit(exportListLimit))
+        if err != nil {
+            return fiber.NewError(fiber.StatusInternalServerError, err.Error())
+        }
+        jobs := []ExportJob{}
+        if err := cur.All(c.Context(), &jobs); err != nil {
+            return fiber.NewError(fiber.StatusInternalServerError, err.Error())
+        }
+        return c.JSON(jobs)
+    }
+}
+
+func GetExportJobHandler(cfg *config.Config) fiber.Handler {
+    return func(c *fiber.Ctx) error {
+        if err := requirePermission(c, permResponsesExport); err != nil {
+            return err
+        }
+        f, err := formFromParam(c, cfg)
+        if err != nil {
+            return err
+        }
+        job, err := findExportJob(c, cfg, f)
+        if err != nil {
+            return err
+        }
+        return c.JSON(job)
+    }
+}
+
+// DownloadExportHandler sends a finished export's file. Access is checked
+// against the fields and PII mode the job exported, and each download is
+// audited, and audited again as pii.exported when it carries raw PII.
+func DownloadExportHandler(cfg *config.Config) fiber.Handler {
+    return func(c *fiber.Ctx) error {
+        f, err := formFromParam(c, cfg)
+        if err != nil {
+            return err
+        }
+        job, err := findExportJob(c, cfg, f)
+        if err != nil {
+            return err
+        }
+        snapshot := *f
+        snapshot.Fields = job.Fields
+        if err := exportAccess(c, cfg, &snapshot, job.options()); err != nil {
+            return err
+        }
+        if job.Status != exportDone {
+            return fiber.NewError(fiber.StatusConflict, "export is "+job.Status)
+        }
+        ev := formAudit("responses.exported", f)
+        ev.Metadata = bson.M{"format": job.Format, "pii": job.options().pii, "exportId": job.ID.Hex(), "query": job.Query, "responses": job.Rows}
+        recordAudit(c, cfg, ev)
+        if pii := piiFieldIDs(&snapshot); len(pii) > 0 && job.Rows > 0 && job.options().pii == piiInclude {
+            ev := formAudit("pii.exported", f)
+            ev.Metadata = bson.M{"format": job.Format, "exportId": job.ID.Hex(), "fields": pii, "responses": job.Rows}
+            recordAudit(c, cfg, ev)
+        }
+        c.Set(fiber.HeaderContentType, exportFormats[job.Format].contentType)
+        return c.Download(exportPath(cfg, job), fmt.Sprintf("%s-responses.%s", formSlug(f), job.Format))
+    }
+}
+
+// DeleteExportJobHandler cancels a job or deletes a finished export. A
+// running worker notices at its next checkpoint and stops.
+func DeleteExportJobHandler(cfg *config.Config) fiber.Handler {
+    return func(c *fiber.Ctx) error {
+        if err := requirePermission(c, permResponsesExport); err != nil {
+            return err
+        }
+        f, err := formFromParam(c, cfg)
+        if err != nil {
+            return err
+        }
+        job, err := findExportJob(c, cfg, f)
+        if err != nil {
+            return err
+        }
+        if _, err := exportJobsCol(cfg).DeleteOne(c.Context(), bson.M{"_id": job.ID}); err != nil {
+            return fiber.NewError(fiber.StatusInternalServerError, err.Error())
+        }
+        removeExportFile(cfg, job)
+        ev := formAudit("export.deleted", f)
+        ev.Metadata = bson.M{"exportId": job.ID.Hex()}
+        recordAudit(c, cfg, ev)
+        return c.SendStatus(fiber.StatusNoContent)
+    }
+}
+
+func removeExportFile(cfg *config.Config, job *ExportJob) {
+    if err := os.Remove(exportPath(cfg, job)); err != nil && !os.IsNotExist(err) {
+        log.Printf("exports: export=%s: %v", job.ID.Hex(), err)
+    }
+}
+
+// processExportJobs runs queued jobs, and resumes jobs whose worker
+// stopped checkpointing, one at a time until none are due.
+func processExportJobs(ctx context.Context, cfg *config.Config) {
+    for ctx.Err() == nil {
+        now := time.Now()
+        var job ExportJob
+        err := exportJobsCol(cfg).FindOneAndUpdate(ctx,
+            bson.M{"status": bson.M{"$in": bson.A{exportPending, exportRunning}}, "leaseUntil": bson.M{"$lte": now}},
+            bson.M{"$set": bson.M{"status": exportRunning, "leaseUntil": now.Add(exportLease)}, "$inc": bson.M{"attempts": 1}},
+            options.FindOneAndUpdate().SetSort(bson.M{"leaseUntil": 1}).SetReturnDocument(options.After),
+        ).Decode(&job)
+        if err == mongo.ErrNoDocuments {
+            return
+        }
+        if err != nil {
+            log.Printf("exports: %v", err)
+            return
+        }
+        runExportJob(cfg, &job)
+    }
+}
+
+// runExportJob writes a job's file from its last checkpoint. It uses its
+// own context: a large export outlives the polling job's deadline, and
+// its lease is renewed at every checkpoint instead.
+func runExportJob(cfg *config.Config, job *ExportJob) {
+    ctx := context.Background()
+    err := writeExportJob(ctx, cfg, job)
+    now := time.Now()
+    set := bson.M{}
+    switch {
+    case err == errExportCancelled:
+        removeExportFile(cfg, job)
+        return
+    case err == errExportLeaseLost:
+        log.Printf("exports: export=%s attempt %d: lease taken over by another worker", job.ID.Hex(), job.Attempts)
+        return
+    case err == nil:
+        expires := now.Add(cfg.ExportRetention)
+        set = bson.M{"status": exportDone, "finishedAt": now, "expiresAt": expires}
+        f := &Form{ID: job.FormID}
+        ev := formAudit("export.completed", f)
+        ev.ActorID = job.CreatedBy
+        ev.WorkspaceID = job.WorkspaceID.Hex()
+        ev.Metadata = bson.M{"exportId": job.ID.Hex(), "responses": job.Rows, "size": job.Size}
+        insertAudit(ctx, cfg, ev)
+    case job.Attempts >= exportMaxAttempts:
+        log.Printf("exports: export=%s failed: %v", job.ID.Hex(), err)
+        expires := now.Add(cfg.ExportRetention)
+        set = bson.M{"status": exportFailed, "error": err.Error(), "finishedAt": now, "expiresAt": expires}
+    default:
+        log.Printf("exports: export=%s attempt %d: %v", job.ID.Hex(), job.Attempts, err)
+        set = bson.M{"error": err.Error(), "leaseUntil": now.Add(exportRetryDelay)}
+    }
+    res, err := exportJobsCol(cfg).UpdateOne(ctx, leasedJob(job), bson.M{"$set": set})
+    if err != nil {
+        log.Printf("exports: export=%s: %v", job.ID.Hex(), err)
+        return
+    }
+    // The file belongs to whichever attempt holds the job now
+    if res.MatchedCount > 0 && set["status"] == exportFailed {
+        removeExportFile(cfg, job)
+    }
+}
+
+var (
+    errExportCancelled = errors.New("export cancelled")
+    errExportLeaseLost = errors.New("export lease lost")
+)
+
+// leasedJob matches the job only while this attempt still holds it. Each
+// claim increments attempts, so a worker whose lease lapsed and was taken
+// over stops at its next write instead of racing the new one on the file.
+func leasedJob(job *ExportJob) bson.M {
+    return bson.M{"_id": job.ID, "status": exportRunning, "attempts": job.Attempts}
+}
+
+// lostJob explains why leasedJob no longer matched: the job was deleted or
+// stopped, or another attempt holds it.
+func lostJob(ctx context.Context, cfg *config.Config, job *ExportJob) error {
+    var now ExportJob
+    err := exportJobsCol(cfg).FindOne(ctx, bson.M{"_id": job.ID}).Decode(&now)
+    if err == mongo.ErrNoDocuments || err == nil && now.Status != exportRunning {
+        return errExportCancelled
+    }
+    if err != nil {
+        return err
+    }
+    return errExportLeaseLost
+}
+
+func writeExportJob(ctx context.Context, cfg *config.Config, job *ExportJob) error {
+    format, ok := exportFormats[job.Format]
+    if !ok {
+        return fmt.Errorf("unknown format %q", job.Format)
+    }
+    if !format.resumable {
+        job.Rows, job.Size, job.LastCreatedAt, job.LastID = 0, 0, nil, primitive.NilObjectID
+    }
+    var filter bson.M
+    if err := bson.UnmarshalExtJSON([]byte(job.Filter), true, &filter); err != nil {
+        return err
+    }
+    if job.LastCreatedAt != nil {
+        after := cursorFilter("createdAt", false, listCursor{Value: *job.LastCreatedAt, ID: job.LastID})
+        filter = bson.M{"$and": bson.A{filter, after}}
+    }
+    if err := os.MkdirAll(cfg.ExportDir, 0o700); err != nil {
+        return err
+    }
+    file, err := os.OpenFile(exportPath(cfg, job), os.O_CREATE|os.O_WRONLY, 0o600)
+    if err != nil {
+        return err
+    }
+    defer file.Close()
+    // Drop anything written after the last checkpoint
+    if err := file.Truncate(job.Size); err != nil {
+        return err
+    }
+    if _, err := file.Seek(job.Size, io.SeekStart); err != nil {
+        return err
+    }
+
+    keys, err := loadFormKeys(ctx, cfg, job.FormID)
+    if err != nil {
+        return err
+    }
+    store := &tenantStore{cfg: cfg, workspaceID: job.WorkspaceID}
+    cur, err := store.FindResponses(ctx, filter, exportFindOptions())
+    if err != nil {
+        return err
+    }
+    defer cur.Close(ctx)
+
+    cw := &countingWriter{w: file, n: job.Size}
+    out := openExport(cfg, cw, &Form{ID: job.FormID, Fields: job.Fields}, job.options(), keys)
+    if job.Size == 0 {
+        if err := out.header(); err != nil {
+            return err
+        }
+    }
+    base := job.Rows
+    checkpoint := func(rows int, last *Response) error {
+        if err := file.Sync(); err != nil {
+            return err
+        }
+        created := last.CreatedAt
+        job.Rows, job.Size, job.LastCreatedAt, job.LastID = base+rows, cw.n, &created, last.ID
+        res, err := exportJobsCol(cfg).UpdateOne(ctx, leasedJob(job), bson.M{"$set": bson.M{
+            "rows": job.Rows, "size": job.Size, "lastCreatedAt": created, "lastId": last.ID,
+            "leaseUntil": time.Now().Add(exportLease),
+        }})
+        if err != nil {
+            return err
+        }
+        if res.MatchedCount == 0 {
+            return lostJob(ctx, cfg, job)
+        }
+        return nil
+    }
+    if _, err := streamResponses(ctx, cur, out, checkpoint); err != nil {
+        return err
+    }
+    if err := out.close(); err != nil {
+        return err
+    }
+    if err := file.Sync(); err != nil {
+        return err
+    }
+    job.Size = cw.n
+    res, err := exportJobsCol(cfg).UpdateOne(ctx, leasedJob(job), bson.M{"$set": bson.M{"size": job.Size}})
+    if err != nil {
+        return err
+    }
+    if res.MatchedCount == 0 {
+        return lostJob(ctx, cfg, job)
+    }
+    return nil
+}
+
+// expireExports deletes exports, and their files, once they expire.
+func expireExports(ctx context.Context, cfg *config.Config) {
+    cur, err := exportJobsCol(cfg).Find(ctx, bson.M{"expiresAt": bson.M{"$lte": time.Now()}})
+    if err != nil {
+        log.Printf("exports: %v", err)
+        return
+    }
+    var jobs []ExportJob
+    if err := cur.All(ctx, &jobs); err != nil {
+        log.Printf("exports: %v", err)
+        return
+    }
+    for i := range jobs {
+        removeExportFile(cfg, &jobs[i])
+        if _, err := exportJobsCol(cfg).DeleteOne(ctx, bson.M{"_id": jobs[i].ID}); err != nil {
+            log.Printf("exports: export=%s: %v", jobs[i].ID.Hex(), err)
+        }
+    }
+}
+
+// deleteExportJobs removes the exports of deleted forms.
+func deleteExportJobs(ctx context.Context, cfg *config.Config, formIDs interface{}) error {
+    cur, err := exportJobsCol(cfg).Find(ctx, bson.M{"formId": bson.M{"$in": formIDs}})
+    if err != nil {
+        return err
+    }
+    var jobs []ExportJob
+    if err := cur.All(ctx, &jobs); err != nil {
+        return err
+    }
+    for i := range jobs {
+        removeExportFile(cfg, &jobs[i])
+    }
+    _, err = exportJobsCol(cfg).DeleteMany(ctx, bson.M{"formId": bson.M{"$in": formIDs}})
+    return err
+}
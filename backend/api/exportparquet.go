@@ -0,0 +1,457 @@
+package api
+
+import (
+    "bytes"
+    "encoding/binary"
+    "io"
+    "math"
+)
+
+// A minimal Parquet writer: uncompressed, PLAIN-encoded, one data page
+// per column chunk, with a row group per parquetRowGroupRows responses.
+// That is enough for every reader to load the file with its schema, and
+// avoids a dependency for a single export format.
+
+const parquetRowGroupRows = 10000
+
+// Parquet physical types, repetitions and converted types
+const (
+    parquetBoolean   = 0
+    parquetInt64     = 2
+    parquetDouble    = 5
+    parquetByteArray = 6
+
+    parquetRequired = 0
+    parquetOptional = 1
+    parquetRepeated = 2
+
+    parquetUTF8            = 0
+    parquetList            = 3
+    parquetTimestampMillis = 9
+
+    parquetEncodingPlain = 0
+    parquetEncodingRLE   = 3
+)
+
+// parquetColumn buffers one column of the current row group. List columns
+// use the three-level LIST layout with required elements, so their
+// definition level is 0 for no answer, 1 for an empty list and 2 for an
+// element.
+type parquetColumn struct {
+    name     string
+    physical int32
+    list     bool
+    col      *exportColumn // nil for response_id and created_at
+
+    maxDef    int
+    defLevels []int
+    repLevels []int
+    bools     []bool
+    values    bytes.Buffer
+}
+
+func (pc *parquetColumn) path() []string {
+    if pc.list {
+        return []string{pc.name, "list", "element"}
+    }
+    return []string{pc.name}
+}
+
+// parquetExport writes responses in the wide layout. Rating fields are
+// doubles, one-hot columns booleans, multi_select answers lists of
+// strings, and everything else, including masked PII, strings.
+type parquetExport struct {
+    w         *countingWriter
+    columns   []*parquetColumn
+    rows      int
+    total     int64
+    rowGroups [][]parquetChunk
+    groupRows []int
+}
+
+// parquetChunk records where a column chunk was written, for the footer.
+type parquetChunk struct {
+    offset    int64
+    size      int64
+    numValues int
+}
+
+func newParquetExport(w io.Writer, f *Form, opts exportOptions) *parquetExport {
+    e := &parquetExport{w: &countingWriter{w: w}}
+    e.columns = append(e.columns,
+        &parquetColumn{name: "response_id", physical: parquetByteArray},
+        &parquetColumn{name: "created_at", physical: parquetInt64},
+    )
+    for _, col := range wideColumns(f, opts) {
+        col := col
+        pc := &parquetColumn{name: col.header, col: &col, physical: parquetByteArray, maxDef: 1}
+        switch {
+        case col.masked:
+        case col.option != "":
+            pc.physical = parquetBoolean
+        case col.other || isMultiField(col.field):
+            pc.list, pc.maxDef = true, 2
+        case col.field.Type == "rating":
+            pc.physical = parquetDouble
+        }
+        e.columns = append(e.columns, pc)
+    }
+    return e
+}
+
+func (e *parquetExport) header() error {
+    _, err := e.w.Write([]byte("PAR1"))
+    return err
+}
+
+func (e *parquetExport) write(r *Response) error {
+    for _, pc := range e.columns {
+        if pc.col == nil {
+            if pc.physical == parquetByteArray {
+                pc.putString(r.ID.Hex())
+            } else {
+                binary.Write(&pc.values, binary.LittleEndian, r.CreatedAt.UnixMilli())
+            }
+            continue
+        }
+        v := wideValue(r, *pc.col)
+        switch {
+        case v == nil:
+            pc.defLevels = append(pc.defLevels, 0)
+            if pc.list {
+                pc.repLevels = append(pc.repLevels, 0)
+            }
+        case pc.list:
+            list, ok := v.([]string)
+            if !ok {
+                list = []string{cellText(v)}
+            }
+            if len(list) == 0 {
+                pc.defLevels = append(pc.defLevels, 1)
+                pc.repLevels = append(pc.repLevels, 0)
+            }
+            for i, s := range list {
+                pc.defLevels = append(pc.defLevels, 2)
+                pc.repLevels = append(pc.repLevels, min(i, 1))
+                pc.putString(s)
+            }
+        case pc.physical == parquetBoolean:
+            b, _ := v.(bool)
+            pc.defLevels = append(pc.defLevels, 1)
+            pc.bools = append(pc.bools, b)
+        case pc.physical == parquetDouble:
+            n, ok := toFloat(v)
+            if !ok {
+                pc.defLevels = append(pc.defLevels, 0)
+                continue
+            }
+            pc.defLevels = append(pc.defLevels, 1)
+            binary.Write(&pc.values, binary.LittleEndian, math.Float64bits(n))
+        default:
+            pc.defLevels = append(pc.defLevels, 1)
+            pc.putString(cellText(v))
+        }
+    }
+    e.rows++
+    if e.rows >= parquetRowGroupRows {
+        return e.writeRowGroup()
+    }
+    return nil
+}
+
+func (pc *parquetColumn) putString(s string) {
+    binary.Write(&pc.values, binary.LittleEndian, uint32(len(s)))
+    pc.values.WriteString(s)
+}
+
+// flush leaves the current row group open: row groups are sized by rows,
+// not by the batches responses are read in.
+func (e *parquetExport) flush() error { return nil }
+
+func (e *parquetExport) writeRowGroup() error {
+    if e.rows == 0 {
+        return nil
+    }
+    var chunks []parquetChunk
+    for _, pc := range e.columns {
+        var page bytes.Buffer
+        if pc.list {
+            writeParquetLevels(&page, pc.repLevels, 1)
+        }
+        if pc.maxDef > 0 {
+            writeParquetLevels(&page, pc.defLevels, bitWidth(pc.maxDef))
+        }
+        if pc.physical == parquetBoolean {
+            page.Write(packBits(boolBits(pc.bools), 1))
+        }
+        page.Write(pc.values.Bytes())
+
+        numValues := e.rows
+        if pc.maxDef > 0 {
+            numValues = len(pc.defLevels)
+        }
+        var h thriftWriter
+        h.begin()
+        h.i32(1, 0) // DATA_PAGE
+        h.i32(2, int32(page.Len()))
+        h.i32(3, int32(page.Len()))
+        h.structField(5)
+        h.i32(1, int32(numValues))
+        h.i32(2, parquetEncodingPlain)
+        h.i32(3, parquetEncodingRLE)
+        h.i32(4, parquetEncodingRLE)
+        h.end()
+        h.end()
+
+        offset := e.w.n
+        if _, err := e.w.Write(h.buf.Bytes()); err != nil {
+            return err
+        }
+        if _, err := e.w.Write(page.Bytes()); err != nil {
+            return err
+        }
+        chunks = append(chunks, parquetChunk{offset: offset, size: e.w.n - offset, numValues: numValues})
+
+        pc.defLevels, pc.repLevels, pc.bools = pc.defLevels[:0], pc.repLevels[:0], pc.bools[:0]
+        pc.values.Reset()
+    }
+    e.rowGroups = append(e.rowGroups, chunks)
+    e.groupRows = append(e.groupRows, e.rows)
+    e.total += int64(e.rows)
+    e.rows = 0
+    return nil
+}
+
+// close writes the last row group and the footer: the file metadata with
+// the schema and the location of every column chunk.
+func (e *parquetExport) close() error {
+    if err := e.writeRowGroup(); err != nil {
+        return err
+    }
+    var m thriftWriter
+    m.begin()
+    m.i32(1, 1) // version
+    m.listField(2, thriftStruct, 1+len(e.columns)+2*countLists(e.columns))
+    m.begin()
+    m.str(4, "schema")
+    m.i32(5, int32(len(e.columns)))
+    m.end()
+    for _, pc := range e.columns {
+        if !pc.list {
+            m.begin()
+            m.i32(1, pc.physical)
+            rep := int32(parquetRequired)
+            if pc.maxDef > 0 {
+                rep = parquetOptional
+            }
+            m.i32(3, rep)
+            m.str(4, pc.name)
+            switch {
+            case pc.physical == parquetByteArray:
+                m.i32(6, parquetUTF8)
+            case pc.col == nil && pc.physical == parquetInt64:
+                m.i32(6, parquetTimestampMillis)
+            }
+            m.end()
+            continue
+        }
+        m.begin()
+        m.i32(3, parquetOptional)
+        m.str(4, pc.name)
+        m.i32(5, 1)
+        m.i32(6, parquetList)
+        m.end()
+        m.begin()
+        m.i32(3, parquetRepeated)
+        m.str(4, "list")
+        m.i32(5, 1)
+        m.end()
+        m.begin()
+        m.i32(1, parquetByteArray)
+        m.i32(3, parquetRequired)
+        m.str(4, "element")
+        m.i32(6, parquetUTF8)
+        m.end()
+    }
+    m.i64(3, e.total)
+    m.listField(4, thriftStruct, len(e.rowGroups))
+    for g, chunks := range e.rowGroups {
+        m.begin()
+        m.listField(1, thriftStruct, len(chunks))
+        var groupSize int64
+        for i, ch := range chunks {
+            pc := e.columns[i]
+            groupSize += ch.size
+            m.begin()
+            m.i64(2, ch.offset)
+            m.structField(3)
+            m.i32(1, pc.physical)
+            m.listField(2, thriftI32, 2)
+            m.listI32(parquetEncodingPlain)
+            m.listI32(parquetEncodingRLE)
+            path := pc.path()
+            m.listField(3, thriftBinary, len(path))
+            for _, p := range path {
+                m.listStr(p)
+            }
+            m.i32(4, 0) // uncompressed
+            m.i64(5, int64(ch.numValues))
+            m.i64(6, ch.size)
+            m.i64(7, ch.size)
+            m.i64(9, ch.offset)
+            m.end()
+            m.end()
+        }
+        m.i64(2, groupSize)
+        m.i64(3, int64(e.groupRows[g]))
+        m.end()
+    }
+    m.str(6, "formbuilder")
+    m.end()
+
+    if _, err := e.w.Write(m.buf.Bytes()); err != nil {
+        return err
+    }
+    if err := binary.Write(e.w, binary.LittleEndian, uint32(m.buf.Len())); err != nil {
+        return err
+    }
+    _, err := e.w.Write([]byte("PAR1"))
+    return err
+}
+
+func countLists(cols []*parquetColumn) int {
+    n := 0
+    for _, pc := range cols {
+        if pc.list {
+            n++
+        }
+    }
+    return n
+}
+
+func bitWidth(max int) int {
+    w := 0
+    for ; max > 0; max >>= 1 {
+        w++
+    }
+    return w
+}
+
+func boolBits(bools []bool) []int {
+    bits := make([]int, len(bools))
+    for i, b := range bools {
+        if b {
+            bits[i] = 1
+        }
+    }
+    return bits
+}
+
+// packBits packs values of the given bit width LSB first, padding to a
+// whole number of groups of eight.
+func packBits(values []int, width int) []byte {
+    groups := (len(values) + 7) / 8
+    out := make([]byte, groups*width)
+    for i, v := range values {
+        for b := 0; b < width; b++ {
+            if v&(1<<b) != 0 {
+                bit := i*width + b
+                out[bit/8] |= 1 << (bit % 8)
+            }
+        }
+    }
+    return out
+}
+
+// writeParquetLevels writes levels in the RLE/bit-packing hybrid encoding,
+// as a single bit-packed run, prefixed with its length as data page v1
+// requires.
+func writeParquetLevels(buf *bytes.Buffer, levels []int, width int) {
+    var run bytes.Buffer
+    groups := (len(levels) + 7) / 8
+    var hdr [binary.MaxVarintLen64]byte
+    run.Write(hdr[:binary.PutUvarint(hdr[:], uint64(groups<<1|1))])
+    run.Write(packBits(levels, width))
+    binary.Write(buf, binary.LittleEndian, uint32(run.Len()))
+    buf.Write(run.Bytes())
+}
+
+// Thrift compact protocol types, as used by Parquet metadata
+const (
+    thriftI32    = 5
+    thriftI64    = 6
+    thriftBinary = 8
+    thriftList   = 9
+    thriftStruct = 12
+)
+
+// thriftWriter encodes structs in the Thrift compact protocol. begin and
+// end bracket each struct, including list elements.
+type thriftWriter struct {
+    buf  bytes.Buffer
+    last []int16
+}
+
+func (t *thriftWriter) begin() { t.last = append(t.last, 0) }
+
+func (t *thriftWriter) end() {
+    t.buf.WriteByte(0)
+    t.last = t.last[:len(t.last)-1]
+}
+
+func (t *thriftWriter) field(id int16, typ byte) {
+    top := len(t.last) - 1
+    if delta := id - t.last[top]; delta > 0 && delta <= 15 {
+        t.buf.WriteByte(byte(delta)<<4 | typ)
+    } else {
+        t.buf.WriteByte(typ)
+        t.varint(int64(id))
+    }
+    t.last[top] = id
+}
+
+func (t *thriftWriter) uvarint(v uint64) {
+    var b [binary.MaxVarintLen64]byte
+    t.buf.Write(b[:binary.PutUvarint(b[:], v)])
+}
+
+func (t *thriftWriter) varint(v int64) {
+    t.uvarint(uint64(v<<1) ^ uint64(v>>63))
+}
+
+func (t *thriftWriter) i32(id int16, v int32) {
+    t.field(id, thriftI32)
+    t.varint(int64(v))
+}
+
+func (t *thriftWriter) i64(id int16, v int64) {
+    t.field(id, thriftI64)
+    t.varint(v)
+}
+
+func (t *thriftWriter) str(id int16, s string) {
+    t.field(id, thriftBinary)
+    t.listStr(s)
+}
+
+func (t *thriftWriter) structField(id int16) {
+    t.field(id, thriftStruct)
+    t.begin()
+}
+
+func (t *thriftWriter) listField(id int16, elem byte, n int) {
+    t.field(id, thriftList)
+    if n < 15 {
+        t.buf.WriteByte(byte(n)<<4 | elem)
+        return
+    }
+    t.buf.WriteByte(0xF0 | elem)
+    t.uvarint(uint64(n))
+}
+
+func (t *thriftWriter) listI32(v int32) { t.varint(int64(v)) }
+
+func (t *thriftWriter) listStr(s string) {
+    t.uvarint(uint64(len(s)))
+    t.buf.WriteString(s)
+}
@@ -0,0 +1,378 @@
+package api
+
+import (
+    "bytes"
+    "encoding/binary"
+    "fmt"
+    "math"
+    "testing"
+)
+
+// thriftReader decodes Thrift compact protocol structs into maps from field
+// ID to value: int64 for integers, []byte for binary, []interface{} for
+// lists and map[int16]interface{} for structs. It is independent of the
+// writer so the tests check the encoding, not just its symmetry.
+type thriftReader struct {
+    buf []byte
+    pos int
+    err error
+}
+
+func (r *thriftReader) byte() byte {
+    if r.pos >= len(r.buf) {
+        r.err = fmt.Errorf("thrift: unexpected end at %d", r.pos)
+        return 0
+    }
+    b := r.buf[r.pos]
+    r.pos++
+    return b
+}
+
+func (r *thriftReader) uvarint() uint64 {
+    var v uint64
+    for shift := 0; r.err == nil; shift += 7 {
+        b := r.byte()
+        v |= uint64(b&0x7f) << shift
+        if b < 0x80 {
+            break
+        }
+    }
+    return v
+}
+
+func (r *thriftReader) varint() int64 {
+    u := r.uvarint()
+    return int64(u>>1) ^ -int64(u&1)
+}
+
+func (r *thriftReader) value(typ byte) interface{} {
+    switch typ {
+    case 1:
+        return true
+    case 2:
+        return false
+    case 3:
+        return int64(int8(r.byte()))
+    case 4, 5, 6:
+        return r.varint()
+    case 7:
+        if r.pos+8 > len(r.buf) {
+            r.err = fmt.Errorf("thrift: short double")
+            return nil
+        }
+        v := math.Float64frombits(binary.LittleEndian.Uint64(r.buf[r.pos:]))
+        r.pos += 8
+        return v
+    case 8:
+        n := int(r.uvarint())
+        if r.pos+n > len(r.buf) {
+            r.err = fmt.Errorf("thrift: short binary")
+            return nil
+        }
+        b := r.buf[r.pos : r.pos+n]
+        r.pos += n
+        return b
+    case 9, 10:
+        h := r.byte()
+        n := int(h >> 4)
+        if n == 15 {
+            n = int(r.uvarint())
+        }
+        list := make([]interface{}, 0, n)
+        for i := 0; i < n && r.err == nil; i++ {
+            if h&0x0f == 1 || h&0x0f == 2 {
+                list = append(list, r.byte() == 1)
+                continue
+            }
+            list = append(list, r.value(h&0x0f))
+        }
+        return list
+    case 12:
+        return r.readStruct()
+    }
+    r.err = fmt.Errorf("thrift: unsupported type %d", typ)
+    return nil
+}
+
+func (r *thriftReader) readStruct() map[int16]interface{} {
+    out := map[int16]interface{}{}
+    var last int16
+    for r.err == nil {
+        h := r.byte()
+        if h == 0 {
+            break
+        }
+        id := last + int16(h>>4)
+        if h>>4 == 0 {
+            id = int16(r.varint())
+        }
+        out[id] = r.value(h & 0x0f)
+        last = id
+    }
+    return out
+}
+
+// parquetFile is what the tests read back from an export.
+type parquetFile struct {
+    meta    map[int16]interface{}
+    schema  []map[int16]interface{}
+    columns [][]parquetValues // per row group, per column
+}
+
+type parquetValues struct {
+    rep, def []int
+    values   []interface{}
+}
+
+func readParquet(t *testing.T, file []byte) *parquetFile {
+    t.Helper()
+    n := len(file)
+    if n < 12 || string(file[:4]) != "PAR1" || string(file[n-4:]) != "PAR1" {
+        t.Fatalf("missing PAR1 magic")
+    }
+    footer := int(binary.LittleEndian.Uint32(file[n-8:]))
+    if footer <= 0 || footer > n-12 {
+        t.Fatalf("footer length %d out of range for %d bytes", footer, n)
+    }
+    r := &thriftReader{buf: file[n-8-footer : n-8]}
+    p := &parquetFile{meta: r.readStruct()}
+    if r.err != nil || r.pos != footer {
+        t.Fatalf("footer: read %d of %d bytes: %v", r.pos, footer, r.err)
+    }
+    for _, el := range p.meta[2].([]interface{}) {
+        p.schema = append(p.schema, el.(map[int16]interface{}))
+    }
+
+    for _, g := range p.meta[4].([]interface{}) {
+        var group []parquetValues
+        for i, ch := range g.(map[int16]interface{})[1].([]interface{}) {
+            md := ch.(map[int16]interface{})[3].(map[int16]interface{})
+            el, maxRep, maxDef := p.leaf(i)
+            group = append(group, readParquetChunk(t, file, md, el, maxRep, maxDef))
+        }
+        p.columns = append(p.columns, group)
+    }
+    return p
+}
+
+// leaf returns the schema element of the i'th leaf column along with its
+// maximum repetition and definition levels.
+func (p *parquetFile) leaf(i int) (el map[int16]interface{}, maxRep, maxDef int) {
+    var walk func(pos, rep, def int) int
+    leaf := -1
+    walk = func(pos, rep, def int) int {
+        e := p.schema[pos]
+        switch e[3] {
+        case int64(parquetOptional):
+            def++
+        case int64(parquetRepeated):
+            rep++
+            def++
+        }
+        children, _ := e[5].(int64)
+        if children == 0 {
+            if leaf++; leaf == i {
+                el, maxRep, maxDef = e, rep, def
+            }
+            return pos + 1
+        }
+        pos++
+        for c := 0; c < int(children); c++ {
+            pos = walk(pos, rep, def)
+        }
+        return pos
+    }
+    // The root is required and does not count towards the levels
+    pos := 1
+    for c := 0; c < int(p.schema[0][5].(int64)); c++ {
+        pos = walk(pos, 0, 0)
+    }
+    return
+}
+
+func readParquetChunk(t *testing.T, file []byte, md map[int16]interface{}, el map[int16]interface{}, maxRep, maxDef int) parquetValues {
+    t.Helper()
+    r := &thriftReader{buf: file, pos: int(md[9].(int64))}
+    page := r.readStruct()
+    if r.err != nil || page[1] != int64(0) || page[2] != page[3] {
+        t.Fatalf("page header %v: %v", page, r.err)
+    }
+    dph := page[5].(map[int16]interface{})
+    n := int(dph[1].(int64))
+    if md[5] != int64(n) {
+        t.Fatalf("chunk num_values %v, page %d", md[5], n)
+    }
+    data := file[r.pos : r.pos+int(page[3].(int64))]
+
+    var out parquetValues
+    levels := func(max int) []int {
+        size := int(binary.LittleEndian.Uint32(data))
+        run := &thriftReader{buf: data[4 : 4+size]}
+        data = data[4+size:]
+        hdr := run.uvarint()
+        if hdr&1 != 1 {
+            t.Fatalf("expected a bit-packed run")
+        }
+        width := 0
+        for m := max; m > 0; m >>= 1 {
+            width++
+        }
+        return unpackBits(run.buf[run.pos:], width, n)
+    }
+    if maxRep > 0 {
+        out.rep = levels(maxRep)
+    }
+    present := n
+    if maxDef > 0 {
+        out.def = levels(maxDef)
+        present = 0
+        for _, d := range out.def {
+            if d == maxDef {
+                present++
+            }
+        }
+    }
+
+    switch el[1] {
+    case int64(parquetByteArray):
+        for i := 0; i < present; i++ {
+            size := int(binary.LittleEndian.Uint32(data))
+            out.values = append(out.values, string(data[4:4+size]))
+            data = data[4+size:]
+        }
+    case int64(parquetInt64):
+        for i := 0; i < present; i++ {
+            out.values = append(out.values, int64(binary.LittleEndian.Uint64(data[8*i:])))
+        }
+        data = data[8*present:]
+    case int64(parquetDouble):
+        for i := 0; i < present; i++ {
+            out.values = append(out.values, math.Float64frombits(binary.LittleEndian.Uint64(data[8*i:])))
+        }
+        data = data[8*present:]
+    case int64(parquetBoolean):
+        for _, b := range unpackBits(data, 1, present) {
+            out.values = append(out.values, b == 1)
+        }
+        data = data[(present+7)/8:]
+    default:
+        t.Fatalf("unexpected physical type %v", el[1])
+    }
+    if len(data) != 0 {
+        t.Fatalf("column %s: %d bytes left over", el[4], len(data))
+    }
+    return out
+}
+
+func unpackBits(buf []byte, width, n int) []int {
+    out := make([]int, n)
+    for i := range out {
+        for b := 0; b < width; b++ {
+            bit := i*width + b
+            if buf[bit/8]&(1<<(bit%8)) != 0 {
+                out[i] |= 1 << b
+            }
+        }
+    }
+    return out
+}
+
+func writeParquet(t *testing.T, f *Form, opts exportOptions, responses []*Response) []byte {
+    t.Helper()
+    var buf bytes.Buffer
+    e := newParquetExport(&buf, f, opts)
+    if err := e.header(); err != nil {
+        t.Fatal(err)
+    }
+    for _, r := range responses {
+        if err := e.write(r); err != nil {
+            t.Fatal(err)
+        }
+    }
+    if err := e.close(); err != nil {
+        t.Fatal(err)
+    }
+    return buf.Bytes()
+}
+
+func TestParquetExportRoundTrip(t *testing.T) {
+    f, responses := exportFixture()
+    p := readParquet(t, writeParquet(t, f, exportOptions{format: "parquet", wide: true, pii: piiInclude}, responses))
+
+    if p.meta[3] != int64(2) {
+        t.Fatalf("num_rows = %v", p.meta[3])
+    }
+    // Root, six columns, and the list and element groups of Tools
+    if len(p.schema) != 1+6+2 || p.schema[0][5] != int64(6) {
+        t.Fatalf("schema = %v", p.schema)
+    }
+    if len(p.columns) != 1 || len(p.columns[0]) != 6 {
+        t.Fatalf("%d row groups", len(p.columns))
+    }
+    group := p.meta[4].([]interface{})[0].(map[int16]interface{})
+    if group[3] != int64(2) {
+        t.Fatalf("row group num_rows = %v", group[3])
+    }
+
+    var names []string
+    for i := range p.columns[0] {
+        el, _, _ := p.leaf(i)
+        names = append(names, string(el[4].([]byte)))
+    }
+    if fmt.Sprint(names) != "[response_id created_at Name Score element Email]" {
+        t.Fatalf("leaf columns %v", names)
+    }
+
+    want := []parquetValues{
+        {values: []interface{}{responses[0].ID.Hex(), responses[1].ID.Hex()}},
+        {values: []interface{}{responses[0].CreatedAt.UnixMilli(), responses[1].CreatedAt.UnixMilli()}},
+        {def: []int{1, 1}, values: []interface{}{"Ada <& co>", "Grace"}},
+        {def: []int{1, 0}, values: []interface{}{4.0}},
+        {rep: []int{0, 1, 0}, def: []int{2, 2, 0}, values: []interface{}{"Go", "Rust"}},
+        {def: []int{1, 0}, values: []interface{}{"ada@example.com"}},
+    }
+    for i, w := range want {
+        if got := p.columns[0][i]; fmt.Sprint(got) != fmt.Sprint(w) {
+            t.Errorf("column %s = %+v, want %+v", names[i], got, w)
+        }
+    }
+}
+
+func TestParquetExportOneHot(t *testing.T) {
+    f, responses := exportFixture()
+    p := readParquet(t, writeParquet(t, f, exportOptions{format: "parquet", wide: true, oneHot: true, pii: piiRedact}, responses))
+
+    // Go, Rust and Other replace Tools; Email is masked, so stays a string
+    if p.schema[0][5] != int64(8) {
+        t.Fatalf("%v columns", p.schema[0][5])
+    }
+    got := p.columns[0]
+    if fmt.Sprint(got[4]) != fmt.Sprint(parquetValues{def: []int{1, 0}, values: []interface{}{true}}) {
+        t.Errorf("Tools: Go = %+v", got[4])
+    }
+    if fmt.Sprint(got[6]) != fmt.Sprint(parquetValues{rep: []int{0, 0}, def: []int{1, 0}}) {
+        t.Errorf("Tools: Other = %+v", got[6])
+    }
+    if el, _, _ := p.leaf(7); el[1] != int64(parquetByteArray) {
+        t.Errorf("masked Email column has type %v", el[1])
+    }
+}
+
+func TestParquetExportRowGroups(t *testing.T) {
+    f, responses := exportFixture()
+    var many []*Response
+    for i := 0; i < parquetRowGroupRows+1; i++ {
+        many = append(many, responses[i%2])
+    }
+    p := readParquet(t, writeParquet(t, f, exportOptions{format: "parquet", wide: true, pii: piiInclude}, many))
+
+    if p.meta[3] != int64(len(many)) || len(p.columns) != 2 {
+        t.Fatalf("num_rows = %v in %d row groups", p.meta[3], len(p.columns))
+    }
+    for g, rows := range []int{parquetRowGroupRows, 1} {
+        group := p.meta[4].([]interface{})[g].(map[int16]interface{})
+        if group[3] != int64(rows) || len(p.columns[g][0].values) != rows {
+            t.Errorf("row group %d: num_rows %v, %d ids", g, group[3], len(p.columns[g][0].values))
+        }
+    }
+}
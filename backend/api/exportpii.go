@@ -0,0 +1,104 @@
+package api
+
+import (
+    "crypto/hmac"
+    "crypto/sha256"
+    "encoding/hex"
+    "io"
+    "strings"
+
+    "github.com/gofiber/fiber/v2"
+    "formbuilder/backend/config"
+)
+
+// pseudonymPrefix marks pseudonymized values so they are not mistaken for
+// answers.
+const pseudonymPrefix = "pseudo:"
+
+// exportAccess checks that the caller may export the form. Exports that
+// mask PII only need responses:export; raw PII needs pii:export and, when
+// required, a session established with a second factor.
+func exportAccess(c *fiber.Ctx, cfg *config.Config, f *Form, opts exportOptions) error {
+    if err := requirePermission(c, permResponsesExport); err != nil {
+        return err
+    }
+    // Answers to removed fields may be PII, so include needs PII export
+    // access whether or not the form still has PII fields
+    if opts.pii != piiInclude {
+        return nil
+    }
+    if err := requirePermission(c, permPIIExport); err != nil {
+        return fiber.NewError(fiber.StatusForbidden, "Exporting PII unmasked requires PII export access; use pii=redact or pii=pseudonymize")
+    }
+    return requireMFAForPII(c, cfg, f)
+}
+
+// openExport opens a writer for the format. Encrypted answers are
+// decrypted with keys, and PII answers (see isPIIAnswer) masked unless they
+// are to be included; when redacting, they are replaced before decryption.
+func openExport(cfg *config.Config, w io.Writer, f *Form, opts exportOptions, keys *formKeys) exportWriter {
+    e := &piiExport{exportWriter: exportFormats[opts.format].open(w, f, opts), keys: keys}
+    if opts.pii != piiInclude {
+        e.mask = f
+    }
+    if opts.pii == piiPseudonymize {
+        e.key = pseudonymKey(cfg, f)
+    }
+    return e
+}
+
+// piiExport replaces the PII answers of responses to mask before they
+// reach the format writer: with redactedAnswer, or with a pseudonym when
+// key is set. A nil mask includes them.
+type piiExport struct {
+    exportWriter
+    keys *formKeys
+    mask *Form
+    key  []byte
+}
+
+func (e *piiExport) write(r *Response) error {
+    masked := *r
+    masked.Answers = make(map[string]interface{}, len(r.Answers))
+    var pii []string
+    for k, v := range r.Answers {
+        masked.Answers[k] = v
+        if e.mask != nil && isPIIAnswer(e.mask, k, v) {
+            pii = append(pii, k)
+        }
+    }
+    if e.mask != nil && e.key == nil {
+        for _, id := range pii {
+            if v := masked.Answers[id]; v != nil {
+                masked.Answers[id] = redactedAnswer
+            }
+        }
+    }
+    masked.Answers = e.keys.decryptAnswers(masked.Answers)
+    if e.key != nil {
+        for _, id := range pii {
+            // Answers that can no longer be decrypted would all share one pseudonym
+            if v, ok := masked.Answers[id]; ok && v != nil && v != unreadableAnswer {
+                masked.Answers[id] = pseudonym(e.key, v)
+            }
+        }
+    }
+    return e.exportWriter.write(&masked)
+}
+
+// pseudonymKey derives the form's pseudonymization key from the server
+// secret, so pseudonyms are stable across exports of a form but cannot be
+// joined across forms.
+func pseudonymKey(cfg *config.Config, f *Form) []byte {
+    mac := hmac.New(sha256.New, []byte(cfg.PseudonymKey))
+    mac.Write([]byte("pseudonym:" + f.ID.Hex()))
+    return mac.Sum(nil)
+}
+
+// pseudonym is a keyed hash of an answer's text, trimmed and lowercased
+// so trivially different spellings of an email address still match.
+func pseudonym(key []byte, v interface{}) string {
+    mac := hmac.New(sha256.New, key)
+    mac.Write([]byte(strings.ToLower(strings.TrimSpace(cellText(v)))))
+    return pseudonymPrefix + hex.EncodeToString(mac.Sum(nil)[:16])
+}
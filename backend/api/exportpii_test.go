@@ -0,0 +1,137 @@
+package api
+
+import (
+    "bytes"
+    "encoding/json"
+    "strings"
+    "testing"
+
+    "go.mongodb.org/mongo-driver/bson/primitive"
+    "formbuilder/backend/config"
+)
+
+// exportAnswers runs responses through openExport as JSON Lines and returns
+// the exported answers of each.
+func exportAnswers(t *testing.T, cfg *config.Config, f *Form, keys *formKeys, mode string, responses []*Response) []map[string]interface{} {
+    t.Helper()
+    var buf bytes.Buffer
+    e := openExport(cfg, &buf, f, exportOptions{format: "jsonl", pii: mode}, keys)
+    if err := e.header(); err != nil {
+        t.Fatal(err)
+    }
+    for _, r := range responses {
+        if err := e.write(r); err != nil {
+            t.Fatal(err)
+        }
+    }
+    if err := e.close(); err != nil {
+        t.Fatal(err)
+    }
+    var out []map[string]interface{}
+    for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+        var row struct{ Answers map[string]interface{} }
+        if err := json.Unmarshal([]byte(line), &row); err != nil {
+            t.Fatalf("%s: %v", line, err)
+        }
+        out = append(out, row.Answers)
+    }
+    return out
+}
+
+func TestPIIExportModes(t *testing.T) {
+    cfg := &config.Config{PseudonymKey: "pseudonym-test-key"}
+    f := piiForm()
+    keys := testKeys(f.ID)
+    destroyed := keys.current
+    seal := func(answers map[string]interface{}) *Response {
+        stored, err := keys.encryptAnswers(f, answers)
+        if err != nil {
+            t.Fatal(err)
+        }
+        return &Response{ID: primitive.NewObjectID(), Answers: stored}
+    }
+    lost := seal(map[string]interface{}{"email": "lost@example.com", "score": 1.0})
+    rotateTestKey(keys)
+    delete(keys.keys, destroyed)
+    responses := []*Response{
+        seal(map[string]interface{}{"email": "ada@example.com", "score": 4.0}),
+        seal(map[string]interface{}{"email": "  ADA@example.com ", "score": 5.0}),
+        lost,
+        {ID: primitive.NewObjectID(), Answers: map[string]interface{}{"email": nil, "score": 2.0}},
+    }
+
+    got := exportAnswers(t, cfg, f, keys, piiInclude, responses)
+    if got[0]["email"] != "ada@example.com" || got[1]["email"] != "  ADA@example.com " || got[2]["email"] != unreadableAnswer || got[3]["email"] != nil {
+        t.Errorf("include: %v", got)
+    }
+
+    got = exportAnswers(t, cfg, f, keys, piiRedact, responses)
+    for i := 0; i < 3; i++ {
+        if got[i]["email"] != redactedAnswer {
+            t.Errorf("redact: row %d email = %v", i, got[i]["email"])
+        }
+    }
+    if got[3]["email"] != nil || got[0]["score"] != 4.0 {
+        t.Errorf("redact: %v", got)
+    }
+
+    got = exportAnswers(t, cfg, f, keys, piiPseudonymize, responses)
+    p, _ := got[0]["email"].(string)
+    if !strings.HasPrefix(p, pseudonymPrefix) || strings.Contains(p, "ada") {
+        t.Fatalf("pseudonymize: email = %q", p)
+    }
+    if got[1]["email"] != p {
+        t.Errorf("pseudonymize: spellings of one address differ: %v, %v", p, got[1]["email"])
+    }
+    if got[2]["email"] != unreadableAnswer || got[3]["email"] != nil || got[0]["score"] != 4.0 {
+        t.Errorf("pseudonymize: %v", got)
+    }
+
+    // Pseudonyms are stable per form but cannot be joined across forms
+    again := exportAnswers(t, cfg, f, keys, piiPseudonymize, responses[:1])
+    other := *f
+    other.ID = primitive.NewObjectID()
+    otherKeys := &formKeys{formID: other.ID, current: keys.current, keys: keys.keys}
+    plain := keys.decryptResponse(responses[0])
+    elsewhere := exportAnswers(t, cfg, &other, otherKeys, piiPseudonymize, []*Response{plain})
+    if again[0]["email"] != p || elsewhere[0]["email"] == p {
+        t.Errorf("pseudonyms: same form %v, other form %v, want %v only for the same form", again[0]["email"], elsewhere[0]["email"], p)
+    }
+}
+
+// A PII field removed from the form leaves its answers behind, encrypted
+// or, if stored before encryption was on, in plaintext.
+func TestPIIExportRemovedFields(t *testing.T) {
+    cfg := &config.Config{PseudonymKey: "pseudonym-test-key"}
+    f := piiForm()
+    keys := testKeys(f.ID)
+    stored, err := keys.encryptAnswers(f, map[string]interface{}{"email": "ada@example.com", "score": 3.0})
+    if err != nil {
+        t.Fatal(err)
+    }
+    stored["legacy"] = "grace@example.com"
+    f.Fields = f.Fields[1:]
+    r := &Response{ID: primitive.NewObjectID(), Answers: stored}
+
+    got := exportAnswers(t, cfg, f, keys, piiInclude, []*Response{r})[0]
+    if got["email"] != "ada@example.com" || got["legacy"] != "grace@example.com" {
+        t.Errorf("include: %v", got)
+    }
+    got = exportAnswers(t, cfg, f, keys, piiRedact, []*Response{r})[0]
+    if got["email"] != redactedAnswer || got["legacy"] != redactedAnswer || got["score"] != 3.0 {
+        t.Errorf("redact: %v", got)
+    }
+    got = exportAnswers(t, cfg, f, keys, piiPseudonymize, []*Response{r})[0]
+    key := pseudonymKey(cfg, f)
+    if got["email"] != pseudonym(key, "ada@example.com") || got["legacy"] != pseudonym(key, "grace@example.com") || got["score"] != 3.0 {
+        t.Errorf("pseudonymize: %v", got)
+    }
+
+    masked := maskResponse(keys, f, r, false)
+    if masked.Answers["email"] != redactedAnswer || masked.Answers["legacy"] != redactedAnswer || masked.Answers["score"] != 3.0 {
+        t.Errorf("masked response: %v", masked.Answers)
+    }
+    if !responseHasPII(f, r) {
+        t.Error("removed PII answers not counted as PII")
+    }
+}
@@ -0,0 +1,239 @@
+package api
+
+import (
+    "archive/zip"
+    "bufio"
+    "encoding/xml"
+    "fmt"
+    "io"
+    "strconv"
+    "strings"
+    "time"
+)
+
+const (
+    // Excel's limits on rows per sheet and characters per cell
+    xlsxMaxRows     = 1048576
+    xlsxMaxCellText = 32767
+
+    xlsxStyleDate   = 1
+    xlsxStyleHeader = 2
+)
+
+var xlsxEpoch = time.Date(1899, 12, 30, 0, 0, 0, 0, time.UTC)
+
+const xlsxHeader = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>` + "\n"
+
+var xlsxParts = []struct{ name, body string }{
+    {"[Content_Types].xml", `<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types">` +
+        `<Default Extension="rels" ContentType="application/vnd.openxmlformats-package.relationships+xml"/>` +
+        `<Default Extension="xml" ContentType="application/xml"/>` +
+        `<Override PartName="/xl/workbook.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.sheet.main+xml"/>` +
+        `<Override PartName="/xl/worksheets/sheet1.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.worksheet+xml"/>` +
+        `<Override PartName="/xl/worksheets/sheet2.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.worksheet+xml"/>` +
+        `<Override PartName="/xl/styles.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.styles+xml"/>` +
+        `</Types>`},
+    {"_rels/.rels", `<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">` +
+        `<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/officeDocument" Target="xl/workbook.xml"/>` +
+        `</Relationships>`},
+    {"xl/workbook.xml", `<workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships">` +
+        `<sheets><sheet name="Responses" sheetId="1" r:id="rId1"/><sheet name="Fields" sheetId="2" r:id="rId2"/></sheets>` +
+        `</workbook>`},
+    {"xl/_rels/workbook.xml.rels", `<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">` +
+        `<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/worksheet" Target="worksheets/sheet1.xml"/>` +
+        `<Relationship Id="rId2" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/worksheet" Target="worksheets/sheet2.xml"/>` +
+        `<Relationship Id="rId3" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/styles" Target="styles.xml"/>` +
+        `</Relationships>`},
+    // Style 1 formats dates, style 2 makes header rows bold
+    {"xl/styles.xml", `<styleSheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main">` +
+        `<fonts count="2"><font><sz val="11"/><name val="Calibri"/></font><font><b/><sz val="11"/><name val="Calibri"/></font></fonts>` +
+        `<fills count="2"><fill><patternFill patternType="none"/></fill><fill><patternFill patternType="gray125"/></fill></fills>` +
+        `<borders count="1"><border><left/><right/><top/><bottom/><diagonal/></border></borders>` +
+        `<cellStyleXfs count="1"><xf numFmtId="0" fontId="0" fillId="0" borderId="0"/></cellStyleXfs>` +
+        `<cellXfs count="3"><xf numFmtId="0" fontId="0" fillId="0" borderId="0" xfId="0"/>` +
+        `<xf numFmtId="22" fontId="0" fillId="0" borderId="0" xfId="0" applyNumberFormat="1"/>` +
+        `<xf numFmtId="0" fontId="1" fillId="0" borderId="0" xfId="0" applyFont="1"/></cellXfs>` +
+        `</styleSheet>`},
+}
+
+const (
+    xlsxSheetStart = `<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main">` +
+        `<sheetViews><sheetView workbookViewId="0"><pane ySplit="1" topLeftCell="A2" activePane="bottomLeft" state="frozen"/></sheetView></sheetViews>` +
+        `<sheetData>`
+    xlsxSheetEnd = `</sheetData></worksheet>`
+)
+
+// xlsxExport writes a workbook with the responses, one row per response
+// in the wide layout, and a Fields sheet describing each field. The
+// responses sheet is written as a single zip entry while responses
+// stream in, using inline strings so no shared string table is held.
+type xlsxExport struct {
+    zw   *zip.Writer
+    w    *bufio.Writer
+    f    *Form
+    cols []exportColumn
+    row  int
+}
+
+func newXLSXExport(w io.Writer, f *Form, opts exportOptions) *xlsxExport {
+    return &xlsxExport{zw: zip.NewWriter(w), f: f, cols: wideColumns(f, opts)}
+}
+
+func (e *xlsxExport) header() error {
+    for _, part := range xlsxParts {
+        pw, err := e.zw.Create(part.name)
+        if err != nil {
+            return err
+        }
+        if _, err := io.WriteString(pw, xlsxHeader+part.body); err != nil {
+            return err
+        }
+    }
+    if err := e.writeFieldsSheet(); err != nil {
+        return err
+    }
+
+    pw, err := e.zw.Create("xl/worksheets/sheet1.xml")
+    if err != nil {
+        return err
+    }
+    e.w = bufio.NewWriter(pw)
+    e.w.WriteString(xlsxHeader + xlsxSheetStart)
+    header := wideHeader(e.cols)
+    cells := make([]interface{}, len(header))
+    for i, h := range header {
+        cells[i] = h
+    }
+    return e.writeRow(cells, xlsxStyleHeader)
+}
+
+// writeFieldsSheet describes the form's fields: one row per field, in form
+// order, with its options and range.
+func (e *xlsxExport) writeFieldsSheet() error {
+    pw, err := e.zw.Create("xl/worksheets/sheet2.xml")
+    if err != nil {
+        return err
+    }
+    w := bufio.NewWriter(pw)
+    w.WriteString(xlsxHeader + xlsxSheetStart)
+    row := 0
+    write := func(cells []interface{}, style int) error {
+        row++
+        return writeXLSXRow(w, row, cells, style)
+    }
+    if err := write([]interface{}{"Field ID", "Label", "Type", "Required", "PII", "Options", "Min", "Max"}, xlsxStyleHeader); err != nil {
+        return err
+    }
+    for _, field := range e.f.Fields {
+        var min, max interface{}
+        if field.Type == "rating" {
+            lo, hi := field.Min, field.Max
+            if lo == 0 {
+                lo = 1
+            }
+            if hi == 0 {
+                hi = 5
+            }
+            min, max = float64(lo), float64(hi)
+        }
+        cells := []interface{}{field.ID, field.Label, field.Type, yesNo(field.Required), yesNo(field.IsPII),
+            strings.Join(field.Options, multiDelimiter), min, max}
+        if err := write(cells, 0); err != nil {
+            return err
+        }
+    }
+    w.WriteString(xlsxSheetEnd)
+    return w.Flush()
+}
+
+func yesNo(b bool) string {
+    if b {
+        return "yes"
+    }
+    return "no"
+}
+
+func (e *xlsxExport) write(r *Response) error {
+    if e.row >= xlsxMaxRows {
+        return fmt.Errorf("more than %d responses do not fit in a worksheet; export CSV instead", xlsxMaxRows-1)
+    }
+    cells := []interface{}{r.ID.Hex(), r.CreatedAt}
+    for _, col := range e.cols {
+        cells = append(cells, wideValue(r, col))
+    }
+    return e.writeRow(cells, 0)
+}
+
+func (e *xlsxExport) writeRow(cells []interface{}, style int) error {
+    e.row++
+    return writeXLSXRow(e.w, e.row, cells, style)
+}
+
+func (e *xlsxExport) flush() error {
+    return e.w.Flush()
+}
+
+func (e *xlsxExport) close() error {
+    e.w.WriteString(xlsxSheetEnd)
+    if err := e.w.Flush(); err != nil {
+        return err
+    }
+    return e.zw.Close()
+}
+
+// writeXLSXRow writes one row. Numbers and times are numeric cells,
+// booleans are 1 or 0 (as in CSV one-hot columns), lists are joined, and
+// everything else is an inline string.
+func writeXLSXRow(w *bufio.Writer, row int, cells []interface{}, style int) error {
+    fmt.Fprintf(w, `<row r="%d">`, row)
+    for i, v := range cells {
+        ref := xlsxColumn(i) + strconv.Itoa(row)
+        s := ""
+        if style != 0 {
+            s = fmt.Sprintf(` s="%d"`, style)
+        }
+        switch t := v.(type) {
+        case nil:
+            continue
+        case float64:
+            fmt.Fprintf(w, `<c r="%s"%s><v>%s</v></c>`, ref, s, strconv.FormatFloat(t, 'f', -1, 64))
+        case bool:
+            n := 0
+            if t {
+                n = 1
+            }
+            fmt.Fprintf(w, `<c r="%s"%s><v>%d</v></c>`, ref, s, n)
+        case time.Time:
+            days := t.UTC().Sub(xlsxEpoch).Hours() / 24
+            fmt.Fprintf(w, `<c r="%s" s="%d"><v>%s</v></c>`, ref, xlsxStyleDate, strconv.FormatFloat(days, 'f', -1, 64))
+        case []string:
+            writeXLSXString(w, ref, s, strings.Join(t, multiDelimiter))
+        case string:
+            writeXLSXString(w, ref, s, t)
+        default:
+            writeXLSXString(w, ref, s, cellText(t))
+        }
+    }
+    _, err := w.WriteString("</row>")
+    return err
+}
+
+func writeXLSXString(w *bufio.Writer, ref, style, text string) {
+    if len(text) > xlsxMaxCellText {
+        // Keep a valid UTF-8 prefix
+        text = strings.ToValidUTF8(text[:xlsxMaxCellText], "")
+    }
+    fmt.Fprintf(w, `<c r="%s" t="inlineStr"%s><is><t xml:space="preserve">`, ref, style)
+    xml.EscapeText(w, []byte(text))
+    w.WriteString("</t></is></c>")
+}
+
+// xlsxColumn converts a zero-based column index to its letters: A, ..., Z,
+// AA, ...
+func xlsxColumn(i int) string {
+    name := ""
+    for i++; i > 0; i = (i - 1) / 26 {
+        name = string(rune('A'+(i-1)%26)) + name
+    }
+    return name
+}
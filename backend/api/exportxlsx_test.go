@@ -0,0 +1,156 @@
+package api
+
+import (
+    "archive/zip"
+    "bytes"
+    "encoding/xml"
+    "io"
+    "strconv"
+    "testing"
+    "time"
+
+    "go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// exportFixture is a form with one field of each exported kind and two
+// responses, the second leaving most fields unanswered.
+func exportFixture() (*Form, []*Response) {
+    f := &Form{ID: primitive.NewObjectID(), Fields: []Field{
+        {ID: "name", Label: "Name", Type: "text"},
+        {ID: "score", Label: "Score", Type: "rating"},
+        {ID: "tools", Label: "Tools", Type: "multi_select", Options: []string{"Go", "Rust"}},
+        {ID: "email", Label: "Email", Type: "text", IsPII: true},
+    }}
+    created := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+    return f, []*Response{
+        {ID: primitive.NewObjectID(), CreatedAt: created, Answers: map[string]interface{}{
+            "name": "Ada <& co>", "score": 4.0, "tools": primitive.A{"Go", "Rust"}, "email": "ada@example.com",
+        }},
+        {ID: primitive.NewObjectID(), CreatedAt: created.Add(time.Hour), Answers: map[string]interface{}{
+            "name": "Grace",
+        }},
+    }
+}
+
+type xlsxSheet struct {
+    Rows []struct {
+        R     int `xml:"r,attr"`
+        Cells []struct {
+            Ref    string `xml:"r,attr"`
+            Type   string `xml:"t,attr"`
+            Style  int    `xml:"s,attr"`
+            Value  string `xml:"v"`
+            Inline string `xml:"is>t"`
+        } `xml:"c"`
+    } `xml:"sheetData>row"`
+}
+
+// cells maps each cell reference to its text, or its raw value for
+// numeric cells.
+func (s *xlsxSheet) cells() map[string]string {
+    out := map[string]string{}
+    for _, row := range s.Rows {
+        for _, c := range row.Cells {
+            if c.Type == "inlineStr" {
+                out[c.Ref] = c.Inline
+            } else {
+                out[c.Ref] = c.Value
+            }
+        }
+    }
+    return out
+}
+
+func readXLSXSheet(t *testing.T, files map[string]*zip.File, name string) *xlsxSheet {
+    t.Helper()
+    zf, ok := files[name]
+    if !ok {
+        t.Fatalf("%s missing", name)
+    }
+    rc, err := zf.Open()
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer rc.Close()
+    body, _ := io.ReadAll(rc)
+    var sheet xlsxSheet
+    if err := xml.Unmarshal(body, &sheet); err != nil {
+        t.Fatalf("%s: %v", name, err)
+    }
+    return &sheet
+}
+
+func TestXLSXExportRoundTrip(t *testing.T) {
+    f, responses := exportFixture()
+    var buf bytes.Buffer
+    e := newXLSXExport(&buf, f, exportOptions{format: "xlsx", wide: true, pii: piiInclude})
+    if err := e.header(); err != nil {
+        t.Fatal(err)
+    }
+    for _, r := range responses {
+        if err := e.write(r); err != nil {
+            t.Fatal(err)
+        }
+    }
+    if err := e.close(); err != nil {
+        t.Fatal(err)
+    }
+
+    zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+    if err != nil {
+        t.Fatalf("not a zip: %v", err)
+    }
+    files := map[string]*zip.File{}
+    for _, zf := range zr.File {
+        files[zf.Name] = zf
+    }
+    for _, name := range []string{"[Content_Types].xml", "_rels/.rels", "xl/workbook.xml", "xl/_rels/workbook.xml.rels", "xl/styles.xml"} {
+        if _, ok := files[name]; !ok {
+            t.Errorf("%s missing", name)
+        }
+    }
+
+    sheet := readXLSXSheet(t, files, "xl/worksheets/sheet1.xml")
+    if len(sheet.Rows) != 3 {
+        t.Fatalf("%d rows, want header and 2 responses", len(sheet.Rows))
+    }
+    for i, row := range sheet.Rows {
+        if row.R != i+1 {
+            t.Errorf("row %d numbered %d", i+1, row.R)
+        }
+    }
+    if c := sheet.Rows[0].Cells[0]; c.Style != xlsxStyleHeader {
+        t.Errorf("header style %d", c.Style)
+    }
+
+    days := responses[0].CreatedAt.Sub(xlsxEpoch).Hours() / 24
+    want := map[string]string{
+        "A1": "response_id", "B1": "created_at", "C1": "Name", "D1": "Score", "E1": "Tools", "F1": "Email",
+        "A2": responses[0].ID.Hex(), "B2": strconv.FormatFloat(days, 'f', -1, 64),
+        "C2": "Ada <& co>", "D2": "4", "E2": "Go; Rust", "F2": "ada@example.com",
+        "A3": responses[1].ID.Hex(), "C3": "Grace",
+    }
+    got := sheet.cells()
+    for ref, v := range want {
+        if got[ref] != v {
+            t.Errorf("%s = %q, want %q", ref, got[ref], v)
+        }
+    }
+    for _, ref := range []string{"D3", "E3", "F3"} {
+        if _, ok := got[ref]; ok {
+            t.Errorf("unanswered %s written", ref)
+        }
+    }
+    if c := sheet.Rows[1].Cells[1]; c.Style != xlsxStyleDate || c.Type != "" {
+        t.Errorf("created_at cell t=%q s=%d, want a dated number", c.Type, c.Style)
+    }
+
+    fields := readXLSXSheet(t, files, "xl/worksheets/sheet2.xml")
+    if len(fields.Rows) != 1+len(f.Fields) {
+        t.Fatalf("Fields sheet has %d rows", len(fields.Rows))
+    }
+    got = fields.cells()
+    if got["A3"] != "score" || got["G3"] != "1" || got["H3"] != "5" || got["E5"] != "yes" || got["F4"] != "Go; Rust" {
+        t.Errorf("Fields sheet = %v", got)
+    }
+}
@@ -0,0 +1,764 @@
+package api
+
+import (
+    "context"
+    "crypto/aes"
+    "crypto/cipher"
+    "crypto/rand"
+    "crypto/sha256"
+    "encoding/base64"
+    "encoding/hex"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "log"
+    "regexp"
+    "strings"
+    "time"
+
+    "github.com/gofiber/fiber/v2"
+    "go.mongodb.org/mongo-driver/bson"
+    "go.mongodb.org/mongo-driver/bson/primitive"
+    "go.mongodb.org/mongo-driver/mongo"
+    "go.mongodb.org/mongo-driver/mongo/options"
+    "formbuilder/backend/config"
+)
+
+// PII answers are stored as "enc:v1:<form key id>:<base64 nonce and
+// ciphertext>", sealed with AES-256-GCM under one of the form's data keys.
+// The form and field IDs are authenticated with the value, so a ciphertext
+// cannot be moved to another field or form.
+const (
+    cipherPrefix = "enc:v1:"
+
+    // unreadableAnswer replaces encrypted answers whose form key was
+    // destroyed or cannot be unwrapped with the configured master keys.
+    unreadableAnswer = "[encrypted: key unavailable]"
+
+    encryptionBatch = 500
+)
+
+var errEncryptionOff = fiber.NewError(fiber.StatusConflict, "Encryption at rest is not configured; set MASTER_KEY or MASTER_KEY_FILE")
+
+// FormKey is one of a form's data keys, wrapped with a master key. Forms
+// get their first key when a PII answer is first stored and a new one on
+// each rotation; new answers are encrypted with the newest.
+type FormKey struct {
+    ID          primitive.ObjectID `bson:"_id" json:"id"`
+    FormID      primitive.ObjectID `bson:"formId" json:"formId"`
+    WrappedKey  []byte             `bson:"wrappedKey" json:"-"`
+    MasterKeyID string             `bson:"masterKeyId" json:"masterKeyId"`
+    CreatedAt   time.Time          `bson:"createdAt" json:"createdAt"`
+}
+
+func formKeysCol(cfg *config.Config) *mongo.Collection {
+    return mongoClient(cfg).Database(cfg.MongoDB).Collection("form_keys")
+}
+
+func encryptionEnabled(cfg *config.Config) bool {
+    return len(cfg.MasterKeys) > 0
+}
+
+// masterKeyID identifies a master key without revealing it.
+func masterKeyID(key []byte) string {
+    sum := sha256.Sum256(key)
+    return hex.EncodeToString(sum[:8])
+}
+
+func currentMasterKeyID(cfg *config.Config) string {
+    if !encryptionEnabled(cfg) {
+        return ""
+    }
+    return masterKeyID(cfg.MasterKeys[0])
+}
+
+func masterKey(cfg *config.Config, id string) []byte {
+    for _, key := range cfg.MasterKeys {
+        if masterKeyID(key) == id {
+            return key
+        }
+    }
+    return nil
+}
+
+// seal encrypts with AES-256-GCM, returning the nonce followed by the
+// ciphertext.
+func seal(key, plaintext, aad []byte) ([]byte, error) {
+    block, err := aes.NewCipher(key)
+    if err != nil {
+        return nil, err
+    }
+    gcm, err := cipher.NewGCM(block)
+    if err != nil {
+        return nil, err
+    }
+    nonce := make([]byte, gcm.NonceSize())
+    if _, err := rand.Read(nonce); err != nil {
+        return nil, err
+    }
+    return gcm.Seal(nonce, nonce, plaintext, aad), nil
+}
+
+func unseal(key, sealed, aad []byte) ([]byte, error) {
+    block, err := aes.NewCipher(key)
+    if err != nil {
+        return nil, err
+    }
+    gcm, err := cipher.NewGCM(block)
+    if err != nil {
+        return nil, err
+    }
+    if len(sealed) < gcm.NonceSize() {
+        return nil, errors.New("ciphertext too short")
+    }
+    n := gcm.NonceSize()
+    return gcm.Open(nil, sealed[:n], sealed[n:], aad)
+}
+
+func wrapAAD(formID primitive.ObjectID) []byte {
+    return []byte("form-key:" + formID.Hex())
+}
+
+func answerAAD(formID primitive.ObjectID, fieldID string) []byte {
+    return []byte(formID.Hex() + "/" + fieldID)
+}
+
+// formKeys holds a form's unwrapped data keys for the length of a request
+// or job.
+type formKeys struct {
+    formID  primitive.ObjectID
+    current primitive.ObjectID // zero when the form has no usable key
+    keys    map[primitive.ObjectID][]byte
+}
+
+// loadFormKeys unwraps a form's data keys. Keys wrapped with a master key
+// that is no longer configured are skipped, so the answers they protect
+// read as unreadableAnswer.
+func loadFormKeys(ctx context.Context, cfg *config.Config, formID primitive.ObjectID) (*formKeys, error) {
+    k := &formKeys{formID: formID, keys: map[primitive.ObjectID][]byte{}}
+    if !encryptionEnabled(cfg) {
+        return k, nil
+    }
+    cur, err := formKeysCol(cfg).Find(ctx, bson.M{"formId": formID},
+        options.Find().SetSort(bson.D{{Key: "createdAt", Value: 1}, {Key: "_id", Value: 1}}))
+    if err != nil {
+        return nil, err
+    }
+    var stored []FormKey
+    if err := cur.All(ctx, &stored); err != nil {
+        return nil, err
+    }
+    for _, fk := range stored {
+        mk := masterKey(cfg, fk.MasterKeyID)
+        if mk == nil {
+            log.Printf("encryption: form=%s key=%s: master key %s is not configured", formID.Hex(), fk.ID.Hex(), fk.MasterKeyID)
+            continue
+        }
+        dek, err := unseal(mk, fk.WrappedKey, wrapAAD(formID))
+        if err != nil {
+            log.Printf("encryption: form=%s key=%s: %v", formID.Hex(), fk.ID.Hex(), err)
+            continue
+        }
+        k.keys[fk.ID] = dek
+        k.current = fk.ID
+    }
+    return k, nil
+}
+
+// encryptionKeys loads a form's keys, creating its first data key if it
+// has PII fields and encryption at rest is on.
+func encryptionKeys(ctx context.Context, cfg *config.Config, f *Form) (*formKeys, error) {
+    k, err := loadFormKeys(ctx, cfg, f.ID)
+    if err != nil {
+        return nil, err
+    }
+    if encryptionEnabled(cfg) && formHasPII(f) && !k.canEncrypt() {
+        if _, err := k.add(ctx, cfg); err != nil {
+            return nil, err
+        }
+    }
+    return k, nil
+}
+
+// add creates a data key, wraps it with the current master key and makes
+// it the key new answers are encrypted with.
+func (k *formKeys) add(ctx context.Context, cfg *config.Config) (*FormKey, error) {
+    dek := make([]byte, 32)
+    if _, err := rand.Read(dek); err != nil {
+        return nil, err
+    }
+    wrapped, err := seal(cfg.MasterKeys[0], dek, wrapAAD(k.formID))
+    if err != nil {
+        return nil, err
+    }
+    fk := FormKey{
+        ID:          primitive.NewObjectID(),
+        FormID:      k.formID,
+        WrappedKey:  wrapped,
+        MasterKeyID: currentMasterKeyID(cfg),
+        CreatedAt:   time.Now(),
+    }
+    if _, err := formKeysCol(cfg).InsertOne(ctx, fk); err != nil {
+        return nil, err
+    }
+    k.keys[fk.ID] = dek
+    k.current = fk.ID
+    return &fk, nil
+}
+
+func (k *formKeys) canEncrypt() bool {
+    return k != nil && !k.current.IsZero()
+}
+
+func (k *formKeys) encrypt(fieldID string, v interface{}) (string, error) {
+    plaintext, err := json.Marshal(v)
+    if err != nil {
+        return "", err
+    }
+    sealed, err := seal(k.keys[k.current], plaintext, answerAAD(k.formID, fieldID))
+    if err != nil {
+        return "", err
+    }
+    return cipherPrefix + k.current.Hex() + ":" + base64.RawStdEncoding.EncodeToString(sealed), nil
+}
+
+// ciphertextKey returns the ID of the data key an encrypted answer was
+// sealed with, and false for answers that are not encrypted.
+func ciphertextKey(v interface{}) (primitive.ObjectID, bool) {
+    s, ok := v.(string)
+    if !ok || !strings.HasPrefix(s, cipherPrefix) {
+        return primitive.NilObjectID, false
+    }
+    id, _, _ := strings.Cut(strings.TrimPrefix(s, cipherPrefix), ":")
+    oid, err := primitive.ObjectIDFromHex(id)
+    return oid, err == nil
+}
+
+func (k *formKeys) decrypt(fieldID string, v interface{}) (interface{}, bool) {
+    id, ok := ciphertextKey(v)
+    if !ok || k == nil || k.keys[id] == nil {
+        return nil, false
+    }
+    _, body, _ := strings.Cut(strings.TrimPrefix(v.(string), cipherPrefix), ":")
+    sealed, err := base64.RawStdEncoding.DecodeString(body)
+    if err != nil {
+        return nil, false
+    }
+    plaintext, err := unseal(k.keys[id], sealed, answerAAD(k.formID, fieldID))
+    if err != nil {
+        return nil, false
+    }
+    var out interface{}
+    if err := json.Unmarshal(plaintext, &out); err != nil {
+        return nil, false
+    }
+    return out, true
+}
+
+// encryptAnswers returns a copy of answers with the form's PII answers
+// encrypted, or answers itself when there is nothing to encrypt.
+func (k *formKeys) encryptAnswers(f *Form, answers map[string]interface{}) (map[string]interface{}, error) {
+    if !k.canEncrypt() || len(answers) == 0 {
+        return answers, nil
+    }
+    out := make(map[string]interface{}, len(answers))
+    for id, v := range answers {
+        out[id] = v
+    }
+    for _, id := range piiFieldIDs(f) {
+        if v, ok := out[id]; ok && v != nil {
+            enc, err := k.encrypt(id, v)
+            if err != nil {
+                return nil, err
+            }
+            out[id] = enc
+        }
+    }
+    return out, nil
+}
+
+// decryptAnswers returns a copy of answers with encrypted answers
+// decrypted, or replaced by unreadableAnswer when their key is gone. It
+// returns answers itself when none are encrypted.
+func (k *formKeys) decryptAnswers(answers map[string]interface{}) map[string]interface{} {
+    var out map[string]interface{}
+    for id, v := range answers {
+        if _, ok := ciphertextKey(v); !ok {
+            continue
+        }
+        if out == nil {
+            out = make(map[string]interface{}, len(answers))
+            for id, v := range answers {
+                out[id] = v
+            }
+        }
+        if plain, ok := k.decrypt(id, v); ok {
+            out[id] = plain
+        } else {
+            out[id] = unreadableAnswer
+        }
+    }
+    if out == nil {
+        return answers
+    }
+    return out
+}
+
+// decryptResponse returns a copy of r with its answers and edit history
+// decrypted.
+func (k *formKeys) decryptResponse(r *Response) *Response {
+    out := *r
+    out.Answers = k.decryptAnswers(r.Answers)
+    if len(r.Edits) > 0 {
+        out.Edits = make([]ResponseEdit, len(r.Edits))
+        for i, e := range r.Edits {
+            out.Edits[i] = ResponseEdit{EditedAt: e.EditedAt, Previous: k.decryptAnswers(e.Previous)}
+        }
+    }
+    return &out
+}
+
+// piiFieldsChanged reports whether a form update marked or unmarked any
+// field as PII.
+func piiFieldsChanged(before, after *Form) bool {
+    return strings.Join(piiFieldIDs(before), ",") != strings.Join(piiFieldIDs(after), ",")
+}
+
+// syncAnswerEncryption brings a form's stored answers, including the edit
+// history, in line with its fields and keys: PII answers are encrypted with
+// the current key, answers under an older key are re-encrypted, and answers
+// to fields no longer marked PII are decrypted. Answers to fields removed
+// from the form stay encrypted. It returns the IDs of the keys that answers
+// are still encrypted with afterwards.
+func syncAnswerEncryption(ctx context.Context, cfg *config.Config, f *Form) (map[primitive.ObjectID]bool, error) {
+    k, err := encryptionKeys(ctx, cfg, f)
+    if err != nil {
+        return nil, err
+    }
+    pii := map[string]bool{}
+    for _, id := range piiFieldIDs(f) {
+        pii[id] = true
+    }
+    inUse := map[primitive.ObjectID]bool{}
+
+    // convert returns the value to store for an answer, and false when it
+    // is already right or cannot be changed
+    convert := func(fieldID string, v interface{}) (interface{}, bool, error) {
+        if v == nil {
+            return nil, false, nil
+        }
+        keyID, encrypted := ciphertextKey(v)
+        field := fieldByID(f, fieldID)
+        switch {
+        case encrypted && (pii[fieldID] || field == nil):
+            if keyID == k.current || !k.canEncrypt() {
+                inUse[keyID] = true
+                return nil, false, nil
+            }
+            plain, ok := k.decrypt(fieldID, v)
+            if !ok {
+                inUse[keyID] = true
+                return nil, false, nil
+            }
+            enc, err := k.encrypt(fieldID, plain)
+            inUse[k.current] = true
+            return enc, err == nil, err
+        case encrypted:
+            plain, ok := k.decrypt(fieldID, v)
+            if !ok {
+                inUse[keyID] = true
+            }
+            return plain, ok, nil
+        case pii[fieldID] && k.canEncrypt():
+            enc, err := k.encrypt(fieldID, v)
+            inUse[k.current] = true
+            return enc, err == nil, err
+        }
+        return nil, false, nil
+    }
+
+    cur, err := responsesCol(cfg).Find(ctx, bson.M{"formId": f.ID}, options.Find().SetProjection(bson.M{"answers": 1, "edits": 1}))
+    if err != nil {
+        return nil, err
+    }
+    defer cur.Close(ctx)
+    var models []mongo.WriteModel
+    flush := func() error {
+        if len(models) == 0 {
+            return nil
+        }
+        _, err := responsesCol(cfg).BulkWrite(ctx, models, options.BulkWrite().SetOrdered(false))
+        models = models[:0]
+        return err
+    }
+    for cur.Next(ctx) {
+        var r Response
+        if err := cur.Decode(&r); err != nil {
+            return nil, err
+        }
+        // Matching on the old values leaves answers a respondent edited
+        // meanwhile for the next sync
+        filter, set := bson.M{"_id": r.ID}, bson.M{}
+        visit := func(prefix string, answers map[string]interface{}) error {
+            for id, v := range answers {
+                nv, changed, err := convert(id, v)
+                if err != nil {
+                    return err
+                }
+                if changed {
+                    filter[prefix+id] = v
+                    set[prefix+id] = nv
+                }
+            }
+            return nil
+        }
+        if err := visit("answers.", r.Answers); err != nil {
+            return nil, err
+        }
+        for i, e := range r.Edits {
+            if err := visit(fmt.Sprintf("edits.%d.previous.", i), e.Previous); err != nil {
+                return nil, err
+            }
+        }
+        if len(set) == 0 {
+            continue
+        }
+        models = append(models, mongo.NewUpdateOneModel().SetFilter(filter).SetUpdate(bson.M{"$set": set}))
+        if len(models) == encryptionBatch {
+            if err := flush(); err != nil {
+                return nil, err
+            }
+        }
+    }
+    if err := cur.Err(); err != nil {
+        return nil, err
+    }
+    return inUse, flush()
+}
+
+// syncInBackground re-syncs a form's answers after a request that changed
+// its PII fields has returned.
+func syncInBackground(cfg *config.Config, f *Form) {
+    go func() {
+        ctx, cancel := context.WithTimeout(context.Background(), time.Hour)
+        defer cancel()
+        if _, err := syncAnswerEncryption(ctx, cfg, f); err != nil {
+            log.Printf("encryption: sync form=%s: %v", f.ID.Hex(), err)
+        }
+    }()
+}
+
+// needsSync reports whether any of a form's PII answers are stored in
+// plaintext, or answers to a field that is no longer PII are encrypted.
+// Answers left under an old key are handled by rotation instead.
+func needsSync(ctx context.Context, cfg *config.Config, f *Form) (bool, error) {
+    encrypted := primitive.Regex{Pattern: "^" + regexp.QuoteMeta(cipherPrefix)}
+    var or bson.A
+    for _, field := range f.Fields {
+        var cond bson.M
+        if field.IsPII {
+            cond = bson.M{"$exists": true, "$ne": nil, "$not": encrypted}
+        } else {
+            cond = bson.M{"$regex": encrypted.Pattern}
+        }
+        or = append(or,
+            bson.M{"answers." + field.ID: cond},
+            bson.M{"edits": bson.M{"$elemMatch": bson.M{"previous." + field.ID: cond}}})
+    }
+    if len(or) == 0 {
+        return false, nil
+    }
+    err := responsesCol(cfg).FindOne(ctx, bson.M{"formId": f.ID, "$or": or}, options.FindOne().SetProjection(bson.M{"_id": 1})).Err()
+    if err == mongo.ErrNoDocuments {
+        return false, nil
+    }
+    return err == nil, err
+}
+
+// backfillAnswerEncryption runs once at startup. It rewraps form keys under
+// the current master key, then encrypts PII answers stored before
+// encryption was configured.
+func backfillAnswerEncryption(ctx context.Context, cfg *config.Config) {
+    if !encryptionEnabled(cfg) {
+        if n, _ := formKeysCol(cfg).CountDocuments(ctx, bson.M{}); n > 0 {
+            log.Printf("encryption: %d form keys exist but no MASTER_KEY is configured; encrypted answers are unreadable", n)
+        }
+        return
+    }
+    if n, failed, err := rewrapFormKeys(ctx, cfg); err != nil {
+        log.Printf("encryption: rewrap: %v", err)
+    } else if n > 0 || failed > 0 {
+        log.Printf("encryption: rewrapped %d form keys, %d failed", n, failed)
+    }
+
+    keyed, err := formKeysCol(cfg).Distinct(ctx, "formId", bson.M{})
+    if err != nil {
+        log.Printf("encryption: backfill: %v", err)
+        return
+    }
+    cur, err := formsCol(cfg).Find(ctx, bson.M{"$or": bson.A{
+        bson.M{"fields.isPII": true},
+        bson.M{"_id": bson.M{"$in": keyed}},
+    }})
+    if err != nil {
+        log.Printf("encryption: backfill: %v", err)
+        return
+    }
+    defer cur.Close(ctx)
+    for cur.Next(ctx) {
+        var f Form
+        if err := cur.Decode(&f); err != nil {
+            continue
+        }
+        if ok, err := needsSync(ctx, cfg, &f); err != nil || !ok {
+            continue
+        }
+        if _, err := syncAnswerEncryption(ctx, cfg, &f); err != nil {
+            log.Printf("encryption: backfill form=%s: %v", f.ID.Hex(), err)
+        }
+    }
+}
+
+// rewrapFormKeys re-encrypts the form keys wrapped with a retired master
+// key under the current one. Keys whose master key is not configured are
+// counted as failed.
+func rewrapFormKeys(ctx context.Context, cfg *config.Config) (rewrapped, failed int, err error) {
+    current := currentMasterKeyID(cfg)
+    cur, err := formKeysCol(cfg).Find(ctx, bson.M{"masterKeyId": bson.M{"$ne": current}})
+    if err != nil {
+        return 0, 0, err
+    }
+    defer cur.Close(ctx)
+    for cur.Next(ctx) {
+        var fk FormKey
+        if err := cur.Decode(&fk); err != nil {
+            return rewrapped, failed, err
+        }
+        mk := masterKey(cfg, fk.MasterKeyID)
+        if mk == nil {
+            failed++
+            continue
+        }
+        dek, err := unseal(mk, fk.WrappedKey, wrapAAD(fk.FormID))
+        if err != nil {
+            failed++
+            continue
+        }
+        wrapped, err := seal(cfg.MasterKeys[0], dek, wrapAAD(fk.FormID))
+        if err != nil {
+            return rewrapped, failed, err
+        }
+        if _, err := formKeysCol(cfg).UpdateOne(ctx, bson.M{"_id": fk.ID, "masterKeyId": fk.MasterKeyID},
+            bson.M{"$set": bson.M{"wrappedKey": wrapped, "masterKeyId": current}}); err != nil {
+            return rewrapped, failed, err
+        }
+        rewrapped++
+    }
+    return rewrapped, failed, cur.Err()
+}
+
+// ListFormKeysHandler lists a form's data keys, oldest first. The last is
+// the one new answers are encrypted with.
+func ListFormKeysHandler(cfg *config.Config) fiber.Handler {
+    return func(c *fiber.Ctx) error {
+        if err := requirePermission(c, permKeysManage); err != nil {
+            return err
+        }
+        f, err := formFromParam(c, cfg)
+        if err != nil {
+            return err
+        }
+        cur, err := formKeysCol(cfg).Find(c.Context(), bson.M{"formId": f.ID},
+            options.Find().SetSort(bson.D{{Key: "createdAt", Value: 1}, {Key: "_id", Value: 1}}))
+        if err != nil {
+            return fiber.NewError(fiber.StatusInternalServerError, err.Error())
+        }
+        keys := []FormKey{}
+        if err := cur.All(c.Context(), &keys); err != nil {
+            return fiber.NewError(fiber.StatusInternalServerError, err.Error())
+        }
+        return c.JSON(fiber.Map{"encryption": encryptionEnabled(cfg), "keys": keys})
+    }
+}
+
+// keyStillUsed reports whether any stored answer, previous answer or
+// webhook payload of a form is encrypted with keyID. Answers are keyed by
+// field ID, including fields since removed, so they are matched by value.
+func keyStillUsed(ctx context.Context, cfg *config.Config, formID, keyID primitive.ObjectID) (bool, error) {
+    marker := regexp.QuoteMeta(cipherPrefix + keyID.Hex() + ":")
+    sealedWithKey := func(answers string) bson.M {
+        return bson.M{"$anyElementTrue": bson.A{bson.M{"$map": bson.M{
+            "input": bson.M{"$objectToArray": bson.M{"$ifNull": bson.A{answers, bson.M{}}}},
+            "as":    "a",
+            "in": bson.M{"$cond": bson.A{
+                bson.M{"$eq": bson.A{bson.M{"$type": "$$a.v"}, "string"}},
+                bson.M{"$regexMatch": bson.M{"input": "$$a.v", "regex": "^" + marker}},
+                false,
+            }},
+        }}}}
+    }
+    filter := bson.M{"formId": formID, "$expr": bson.M{"$or": bson.A{
+        sealedWithKey("$answers"),
+        bson.M{"$anyElementTrue": bson.A{bson.M{"$map": bson.M{
+            "input": bson.M{"$ifNull": bson.A{"$edits", bson.A{}}},
+            "as":    "e",
+            "in":    sealedWithKey("$$e.previous"),
+        }}}},
+    }}}
+    found := func(col *mongo.Collection, filter bson.M) (bool, error) {
+        err := col.FindOne(ctx, filter, options.FindOne().SetProjection(bson.M{"_id": 1})).Err()
+        if err == mongo.ErrNoDocuments {
+            return false, nil
+        }
+        return err == nil, err
+    }
+    if used, err := found(responsesCol(cfg), filter); used || err != nil {
+        return used, err
+    }
+    return found(deliveriesCol(cfg), bson.M{"formId": formID, "payload": bson.M{"$regex": marker}})
+}
+
+// RotateFormKeyHandler gives a form a new data key. New answers use it
+// straight away; stored answers are re-encrypted in the background, after
+// which the old keys nothing uses any more are destroyed.
+func RotateFormKeyHandler(cfg *config.Config) fiber.Handler {
+    return func(c *fiber.Ctx) error {
+        if err := requirePermission(c, permKeysManage); err != nil {
+            return err
+        }
+        if !encryptionEnabled(cfg) {
+            return errEncryptionOff
+        }
+        f, err := formFromParam(c, cfg)
+        if err != nil {
+            return err
+        }
+        k, err := loadFormKeys(c.Context(), cfg, f.ID)
+        if err != nil {
+            return fiber.NewError(fiber.StatusInternalServerError, err.Error())
+        }
+        fk, err := k.add(c.Context(), cfg)
+        if err != nil {
+            return fiber.NewError(fiber.StatusInternalServerError, err.Error())
+        }
+        ev := formAudit("form.key_rotated", f)
+        ev.Metadata = bson.M{"keyId": fk.ID.Hex()}
+        recordAudit(c, cfg, ev)
+
+        go func() {
+            ctx, cancel := context.WithTimeout(context.Background(), time.Hour)
+            defer cancel()
+            inUse, err := syncAnswerEncryption(ctx, cfg, f)
+            if err != nil {
+                log.Printf("encryption: rotate form=%s: %v", f.ID.Hex(), err)
+                return
+            }
+            // The sync only saw the answers as they were when it read
+            // them; answers edited or submitted meanwhile and webhook
+            // payloads may still use an old key, so look again now
+            retired := bson.A{}
+            for id := range k.keys {
+                if id == fk.ID || inUse[id] {
+                    continue
+                }
+                used, err := keyStillUsed(ctx, cfg, f.ID, id)
+                if err != nil {
+                    log.Printf("encryption: rotate form=%s: %v", f.ID.Hex(), err)
+                    return
+                }
+                if !used {
+                    retired = append(retired, id)
+                }
+            }
+            if len(retired) == 0 {
+                return
+            }
+            if _, err := formKeysCol(cfg).DeleteMany(ctx, bson.M{"formId": f.ID, "_id": bson.M{"$in": retired}}); err != nil {
+                log.Printf("encryption: rotate form=%s: %v", f.ID.Hex(), err)
+            }
+        }()
+        return c.Status(fiber.StatusAccepted).JSON(fk)
+    }
+}
+
+// DestroyFormKeysHandler crypto-shreds a form: its data keys are deleted,
+// making every PII answer stored so far permanently unreadable, including
+// copies in database backups. Finished exports of the form are deleted
+// too, since they may hold decrypted answers. The form keeps accepting
+// responses under a new key.
+func DestroyFormKeysHandler(cfg *config.Config) fiber.Handler {
+    return func(c *fiber.Ctx) error {
+        if err := requirePermission(c, permKeysManage); err != nil {
+            return err
+        }
+        f, err := formFromParam(c, cfg)
+        if err != nil {
+            return err
+        }
+        if err := requireMFAForPII(c, cfg, f); err != nil {
+            return err
+        }
+        res, err := formKeysCol(cfg).DeleteMany(c.Context(), bson.M{"formId": f.ID})
+        if err != nil {
+            return fiber.NewError(fiber.StatusInternalServerError, err.Error())
+        }
+        if err := deleteExportJobs(c.Context(), cfg, bson.A{f.ID}); err != nil {
+            return fiber.NewError(fiber.StatusInternalServerError, err.Error())
+        }
+        ev := formAudit("form.keys_destroyed", f)
+        ev.Metadata = bson.M{"keys": res.DeletedCount}
+        recordAudit(c, cfg, ev)
+        return c.SendStatus(fiber.StatusNoContent)
+    }
+}
+
+// MasterKeysHandler reports how many form keys each master key wraps, so
+// operators can tell when a retired master key is no longer needed.
+func MasterKeysHandler(cfg *config.Config) fiber.Handler {
+    return func(c *fiber.Ctx) error {
+        cur, err := formKeysCol(cfg).Aggregate(c.Context(), bson.A{
+            bson.M{"$group": bson.M{"_id": "$masterKeyId", "formKeys": bson.M{"$sum": 1}}},
+        })
+        if err != nil {
+            return fiber.NewError(fiber.StatusInternalServerError, err.Error())
+        }
+        var counts []struct {
+            ID       string `bson:"_id"`
+            FormKeys int    `bson:"formKeys"`
+        }
+        if err := cur.All(c.Context(), &counts); err != nil {
+            return fiber.NewError(fiber.StatusInternalServerError, err.Error())
+        }
+        wrapped := map[string]int{}
+        for _, n := range counts {
+            wrapped[n.ID] = n.FormKeys
+        }
+        keys := []fiber.Map{}
+        for i, key := range cfg.MasterKeys {
+            id := masterKeyID(key)
+            keys = append(keys, fiber.Map{"id": id, "current": i == 0, "configured": true, "formKeys": wrapped[id]})
+            delete(wrapped, id)
+        }
+        // Form keys wrapped with master keys that are no longer configured
+        for id, n := range wrapped {
+            keys = append(keys, fiber.Map{"id": id, "current": false, "configured": false, "formKeys": n})
+        }
+        return c.JSON(fiber.Map{"encryption": encryptionEnabled(cfg), "masterKeys": keys})
+    }
+}
+
+// RewrapKeysHandler rewraps form keys under the current master key; the
+// server also does this at startup.
+func RewrapKeysHandler(cfg *config.Config) fiber.Handler {
+    return func(c *fiber.Ctx) error {
+        if !encryptionEnabled(cfg) {
+            return errEncryptionOff
+        }
+        n, failed, err := rewrapFormKeys(c.Context(), cfg)
+        if err != nil {
+            return fiber.NewError(fiber.StatusInternalServerError, err.Error())
+        }
+        recordAudit(c, cfg, AuditEvent{Action: "keys.rewrapped", Metadata: bson.M{"rewrapped": n, "failed": failed, "masterKeyId": currentMasterKeyID(cfg)}})
+        return c.JSON(fiber.Map{"rewrapped": n, "failed": failed})
+    }
+}
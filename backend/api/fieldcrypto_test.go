@@ -0,0 +1,165 @@
+package api
+
+import (
+    "bytes"
+    "crypto/rand"
+    "testing"
+
+    "go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// testKeys builds a form key set in memory with one fresh data key.
+func testKeys(formID primitive.ObjectID) *formKeys {
+    k := &formKeys{formID: formID, keys: map[primitive.ObjectID][]byte{}}
+    rotateTestKey(k)
+    return k
+}
+
+// rotateTestKey does what a rotation does to the loaded keys, without the
+// database: a new data key becomes current and the old ones stay.
+func rotateTestKey(k *formKeys) primitive.ObjectID {
+    id := primitive.NewObjectID()
+    key := make([]byte, 32)
+    rand.Read(key)
+    k.keys[id] = key
+    k.current = id
+    return id
+}
+
+func piiForm() *Form {
+    return &Form{ID: primitive.NewObjectID(), Fields: []Field{
+        {ID: "email", Label: "Email", Type: "text", IsPII: true},
+        {ID: "phones", Label: "Phones", Type: "multi_select", IsPII: true},
+        {ID: "score", Label: "Score", Type: "rating"},
+    }}
+}
+
+func TestSealRejectsWrongAAD(t *testing.T) {
+    key := make([]byte, 32)
+    rand.Read(key)
+    form := primitive.NewObjectID()
+    sealed, err := seal(key, []byte("ada@example.com"), answerAAD(form, "email"))
+    if err != nil {
+        t.Fatal(err)
+    }
+    if plain, err := unseal(key, sealed, answerAAD(form, "email")); err != nil || string(plain) != "ada@example.com" {
+        t.Fatalf("unseal = %q, %v", plain, err)
+    }
+    if bytes.Contains(sealed, []byte("ada@example.com")) {
+        t.Fatal("plaintext visible in ciphertext")
+    }
+
+    for name, aad := range map[string][]byte{
+        "other field": answerAAD(form, "name"),
+        "other form":  answerAAD(primitive.NewObjectID(), "email"),
+        "wrapped key": wrapAAD(form),
+        "none":        nil,
+    } {
+        if _, err := unseal(key, sealed, aad); err == nil {
+            t.Errorf("%s: unsealed with the wrong AAD", name)
+        }
+    }
+
+    other := make([]byte, 32)
+    rand.Read(other)
+    if _, err := unseal(other, sealed, answerAAD(form, "email")); err == nil {
+        t.Error("unsealed with the wrong key")
+    }
+    sealed[len(sealed)-1] ^= 1
+    if _, err := unseal(key, sealed, answerAAD(form, "email")); err == nil {
+        t.Error("unsealed a tampered ciphertext")
+    }
+    if _, err := unseal(key, sealed[:4], nil); err == nil {
+        t.Error("unsealed a truncated ciphertext")
+    }
+}
+
+func TestEncryptAnswersAfterRotation(t *testing.T) {
+    f := piiForm()
+    k := testKeys(f.ID)
+    first := k.current
+    answers := map[string]interface{}{"email": "ada@example.com", "phones": []interface{}{"1", "2"}, "score": 4.0}
+
+    old, err := k.encryptAnswers(f, answers)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if id, ok := ciphertextKey(old["email"]); !ok || id != first {
+        t.Fatalf("email sealed with %v", old["email"])
+    }
+    if old["score"] != 4.0 {
+        t.Fatalf("non-PII answer changed: %v", old["score"])
+    }
+    if answers["email"] != "ada@example.com" {
+        t.Fatal("encryptAnswers modified its input")
+    }
+
+    second := rotateTestKey(k)
+    updated, err := k.encryptAnswers(f, map[string]interface{}{"email": "grace@example.com"})
+    if err != nil {
+        t.Fatal(err)
+    }
+    if id, _ := ciphertextKey(updated["email"]); id != second {
+        t.Fatalf("new answers sealed with %v, want the rotated key", id)
+    }
+
+    got := k.decryptAnswers(old)
+    if got["email"] != "ada@example.com" || got["score"] != 4.0 {
+        t.Fatalf("answers under the old key = %v", got)
+    }
+    if list, ok := got["phones"].([]interface{}); !ok || len(list) != 2 || list[1] != "2" {
+        t.Fatalf("list answer = %#v", got["phones"])
+    }
+    if got := k.decryptAnswers(updated); got["email"] != "grace@example.com" {
+        t.Fatalf("answers under the new key = %v", got)
+    }
+
+    // A ciphertext copied to another field or form does not decrypt
+    moved := map[string]interface{}{"phones": old["email"]}
+    if got := k.decryptAnswers(moved); got["phones"] != unreadableAnswer {
+        t.Errorf("moved ciphertext decrypted to %v", got["phones"])
+    }
+    elsewhere := &formKeys{formID: primitive.NewObjectID(), current: k.current, keys: k.keys}
+    if got := elsewhere.decryptAnswers(old); got["email"] != unreadableAnswer {
+        t.Errorf("ciphertext from another form decrypted to %v", got["email"])
+    }
+}
+
+func TestDestroyedKeyReadsAsUnreadable(t *testing.T) {
+    f := piiForm()
+    k := testKeys(f.ID)
+    destroyed := k.current
+    stored, err := k.encryptAnswers(f, map[string]interface{}{"email": "ada@example.com", "score": 3.0})
+    if err != nil {
+        t.Fatal(err)
+    }
+    rotateTestKey(k)
+    delete(k.keys, destroyed)
+
+    got := k.decryptAnswers(stored)
+    if got["email"] != unreadableAnswer || got["score"] != 3.0 {
+        t.Fatalf("answers = %v", got)
+    }
+    r := k.decryptResponse(&Response{Answers: stored, Edits: []ResponseEdit{{Previous: stored}}})
+    if r.Edits[0].Previous["email"] != unreadableAnswer {
+        t.Fatalf("edit history = %v", r.Edits[0].Previous)
+    }
+
+    // Nothing can be decrypted without keys at all, e.g. once the master
+    // key is gone
+    var none *formKeys
+    if got := none.decryptAnswers(stored); got["email"] != unreadableAnswer {
+        t.Fatalf("without keys = %v", got)
+    }
+}
+
+func TestSubmissionRejectsCiphertextLookalikes(t *testing.T) {
+    f := &Form{ID: primitive.NewObjectID(), Fields: []Field{{ID: "note", Label: "Note", Type: "text"}}}
+    fake := cipherPrefix + primitive.NewObjectID().Hex() + ":AAAA"
+    if err := validateSubmission(f, map[string]interface{}{"note": fake}); err == nil {
+        t.Fatal("accepted an answer that would read back as encrypted")
+    }
+    if err := validateSubmission(f, map[string]interface{}{"note": "enc:v1 is the format"}); err != nil {
+        t.Fatalf("rejected ordinary text: %v", err)
+    }
+}
@@ -0,0 +1,202 @@
+package api
+
+import (
+    "context"
+    "strings"
+    "time"
+
+    "github.com/gofiber/fiber/v2"
+    "go.mongodb.org/mongo-driver/bson"
+    "go.mongodb.org/mongo-driver/bson/primitive"
+    "go.mongodb.org/mongo-driver/mongo"
+    "go.mongodb.org/mongo-driver/mongo/options"
+    "formbuilder/backend/config"
+)
+
+const maxFolderName = 100
+
+// Folder groups forms within a workspace. Folders are flat; a form is in
+// at most one folder.
+type Folder struct {
+    ID          primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+    WorkspaceID primitive.ObjectID `bson:"workspaceId" json:"-"`
+    Name        string             `bson:"name" json:"name"`
+    CreatedBy   string             `bson:"createdBy" json:"createdBy"`
+    CreatedAt   time.Time          `bson:"createdAt" json:"createdAt"`
+}
+
+type FolderRequest struct {
+    Name string `json:"name"`
+}
+
+// MoveFormRequest moves a form into a folder; an empty FolderID moves it
+// back to the workspace root.
+type MoveFormRequest struct {
+    FolderID string `json:"folderId"`
+}
+
+func foldersCol(cfg *config.Config) *mongo.Collection {
+    return mongoClient(cfg).Database(cfg.MongoDB).Collection("folders")
+}
+
+func folderName(req FolderRequest) (string, error) {
+    name := strings.TrimSpace(req.Name)
+    if name == "" || len(name) > maxFolderName {
+        return "", fiber.NewError(fiber.StatusBadRequest, "Folder name must be between 1 and 100 characters")
+    }
+    return name, nil
+}
+
+// findFolder loads a folder in the given workspace.
+func findFolder(ctx context.Context, cfg *config.Config, workspaceID primitive.ObjectID, id string) (*Folder, error) {
+    oid, err := primitive.ObjectIDFromHex(id)
+    if err != nil {
+        return nil, fiber.NewError(fiber.StatusBadRequest, "invalid folder id")
+    }
+    var folder Folder
+    if err := foldersCol(cfg).FindOne(ctx, bson.M{"_id": oid, "workspaceId": workspaceID}).Decode(&folder); err != nil {
+        if err == mongo.ErrNoDocuments {
+            return nil, fiber.NewError(fiber.StatusNotFound, "folder not found")
+        }
+        return nil, fiber.NewError(fiber.StatusInternalServerError, err.Error())
+    }
+    return &folder, nil
+}
+
+func ListFoldersHandler(cfg *config.Config) fiber.Handler {
+    return func(c *fiber.Ctx) error {
+        if err := requirePermission(c, permFormsRead); err != nil {
+            return err
+        }
+        ws := c.Locals("workspaceID").(primitive.ObjectID)
+        cur, err := foldersCol(cfg).Find(c.Context(), bson.M{"workspaceId": ws}, options.Find().SetSort(bson.M{"name": 1}))
+        if err != nil {
+            return fiber.NewError(fiber.StatusInternalServerError, err.Error())
+        }
+        folders := []Folder{}
+        if err := cur.All(c.Context(), &folders); err != nil {
+            return fiber.NewError(fiber.StatusInternalServerError, err.Error())
+        }
+        return c.JSON(folders)
+    }
+}
+
+func CreateFolderHandler(cfg *config.Config) fiber.Handler {
+    return func(c *fiber.Ctx) error {
+        if err := requirePermission(c, permFormsWrite); err != nil {
+            return err
+        }
+        var req FolderRequest
+        if err := c.BodyParser(&req); err != nil {
+            return fiber.NewError(fiber.StatusBadRequest, "Invalid request body")
+        }
+        name, err := folderName(req)
+        if err != nil {
+            return err
+        }
+        folder := Folder{
+            ID:          primitive.NewObjectID(),
+            WorkspaceID: c.Locals("workspaceID").(primitive.ObjectID),
+            Name:        name,
+            CreatedBy:   c.Locals("userID").(string),
+            CreatedAt:   time.Now(),
+        }
+        if _, err := foldersCol(cfg).InsertOne(c.Context(), folder); err != nil {
+            if mongo.IsDuplicateKeyError(err) {
+                return fiber.NewError(fiber.StatusConflict, "A folder with this name already exists")
+            }
+            return fiber.NewError(fiber.StatusInternalServerError, err.Error())
+        }
+        recordAudit(c, cfg, AuditEvent{Action: "folder.created", TargetType: "folder", TargetID: folder.ID.Hex()})
+        return c.Status(fiber.StatusCreated).JSON(folder)
+    }
+}
+
+func RenameFolderHandler(cfg *config.Config) fiber.Handler {
+    return func(c *fiber.Ctx) error {
+        if err := requirePermission(c, permFormsWrite); err != nil {
+            return err
+        }
+        folder, err := findFolder(c.Context(), cfg, c.Locals("workspaceID").(primitive.ObjectID), c.Params("folderId"))
+        if err != nil {
+            return err
+        }
+        var req FolderRequest
+        if err := c.BodyParser(&req); err != nil {
+            return fiber.NewError(fiber.StatusBadRequest, "Invalid request body")
+        }
+        name, err := folderName(req)
+        if err != nil {
+            return err
+        }
+        if _, err := foldersCol(cfg).UpdateOne(c.Context(), bson.M{"_id": folder.ID}, bson.M{"$set": bson.M{"name": name}}); err != nil {
+            if mongo.IsDuplicateKeyError(err) {
+                return fiber.NewError(fiber.StatusConflict, "A folder with this name already exists")
+            }
+            return fiber.NewError(fiber.StatusInternalServerError, err.Error())
+        }
+        recordAudit(c, cfg, AuditEvent{Action: "folder.renamed", TargetType: "folder", TargetID: folder.ID.Hex(),
+            Changes: []string{"name: " + folder.Name + " -> " + name}})
+        folder.Name = name
+        return c.JSON(folder)
+    }
+}
+
+// DeleteFolderHandler deletes a folder. Its forms move to the workspace
+// root rather than being deleted.
+func DeleteFolderHandler(cfg *config.Config) fiber.Handler {
+    return func(c *fiber.Ctx) error {
+        if err := requirePermission(c, permFormsWrite); err != nil {
+            return err
+        }
+        ws := c.Locals("workspaceID").(primitive.ObjectID)
+        folder, err := findFolder(c.Context(), cfg, ws, c.Params("folderId"))
+        if err != nil {
+            return err
+        }
+        if _, err := formsCol(cfg).UpdateMany(c.Context(), bson.M{"workspaceId": ws, "folderId": folder.ID},
+            bson.M{"$unset": bson.M{"folderId": ""}}); err != nil {
+            return fiber.NewError(fiber.StatusInternalServerError, err.Error())
+        }
+        if _, err := foldersCol(cfg).DeleteOne(c.Context(), bson.M{"_id": folder.ID}); err != nil {
+            return fiber.NewError(fiber.StatusInternalServerError, err.Error())
+        }
+        recordAudit(c, cfg, AuditEvent{Action: "folder.deleted", TargetType: "folder", TargetID: folder.ID.Hex()})
+        return c.SendStatus(fiber.StatusNoContent)
+    }
+}
+
+func MoveFormHandler(cfg *config.Config) fiber.Handler {
+    return func(c *fiber.Ctx) error {
+        if err := requirePermission(c, permFormsWrite); err != nil {
+            return err
+        }
+        f, err := formFromParam(c, cfg)
+        if err != nil {
+            return err
+        }
+        var req MoveFormRequest
+        if err := c.BodyParser(&req); err != nil {
+            return fiber.NewError(fiber.StatusBadRequest, "Invalid request body")
+        }
+        update := bson.M{"$unset": bson.M{"folderId": ""}}
+        target := "root"
+        f.FolderID = nil
+        if req.FolderID != "" {
+            folder, err := findFolder(c.Context(), cfg, f.WorkspaceID, req.FolderID)
+            if err != nil {
+                return err
+            }
+            update = bson.M{"$set": bson.M{"folderId": folder.ID}}
+            target = folder.Name
+            f.FolderID = &folder.ID
+        }
+        if _, err := storeFor(c, cfg).UpdateForm(c.Context(), f.ID, update); err != nil {
+            return fiber.NewError(fiber.StatusInternalServerError, err.Error())
+        }
+        ev := formAudit("form.moved", f)
+        ev.Changes = []string{"folder: " + target}
+        recordAudit(c, cfg, ev)
+        return c.JSON(f)
+    }
+}
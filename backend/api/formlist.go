@@ -0,0 +1,169 @@
+package api
+
+import (
+    "context"
+    "strings"
+    "time"
+
+    "github.com/gofiber/fiber/v2"
+    "go.mongodb.org/mongo-driver/bson"
+    "go.mongodb.org/mongo-driver/bson/primitive"
+    "go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+    formsDefaultLimit = 50
+    formsMaxLimit     = 100
+)
+
+var formSortKeys = []string{"updatedAt", "createdAt", "title"}
+
+// FormSummary is a forms list entry. ResponseCount and LastResponseAt
+// count stored non-spam responses, unlike Form.ResponseCount which is the
+// quota counter.
+type FormSummary struct {
+    Form
+    ResponseCount  int        `json:"responseCount"`
+    LastResponseAt *time.Time `json:"lastResponseAt"`
+}
+
+type formQuery struct {
+    filter  bson.M
+    sortKey string
+    desc    bool
+    limit   int
+}
+
+// parseFormQuery reads the forms list parameters: status (comma-separated),
+// tags (comma-separated, all must match), archived (false by default, true
+// or all), folder (folder ID or "none"), sort (updatedAt, createdAt or
+// title, "-" prefix for descending), limit and cursor.
+func parseFormQuery(c *fiber.Ctx) (*formQuery, error) {
+    q := &formQuery{filter: bson.M{}, sortKey: "updatedAt", desc: true}
+    if v := c.Query("status"); v != "" {
+        var statuses bson.A
+        for _, s := range strings.Split(v, ",") {
+            statuses = append(statuses, strings.TrimSpace(s))
+        }
+        q.filter["status"] = bson.M{"$in": statuses}
+    }
+    if v := c.Query("tags"); v != "" {
+        if list, _ := cleanTags(strings.Split(v, ",")); len(list) > 0 {
+            q.filter["tags"] = bson.M{"$all": list}
+        }
+    }
+    switch c.Query("archived", "false") {
+    case "false":
+        q.filter["archivedAt"] = nil
+    case "true":
+        q.filter["archivedAt"] = bson.M{"$ne": nil}
+    case "all":
+    default:
+        return nil, fiber.NewError(fiber.StatusBadRequest, "archived must be true, false or all")
+    }
+    switch v := c.Query("folder"); v {
+    case "":
+    case "none":
+        q.filter["folderId"] = nil
+    default:
+        oid, err := primitive.ObjectIDFromHex(v)
+        if err != nil {
+            return nil, fiber.NewError(fiber.StatusBadRequest, "invalid folder id")
+        }
+        q.filter["folderId"] = oid
+    }
+
+    if s := c.Query("sort"); s != "" {
+        q.desc = strings.HasPrefix(s, "-")
+        q.sortKey = strings.TrimPrefix(s, "-")
+        if !containsString(formSortKeys, q.sortKey) {
+            return nil, fiber.NewError(fiber.StatusBadRequest, "sort must be one of "+strings.Join(formSortKeys, ", "))
+        }
+    }
+    if v := c.Query("cursor"); v != "" {
+        cur, err := decodeCursor(v, q.sortKey != "title")
+        if err != nil {
+            return nil, err
+        }
+        q.filter["$and"] = bson.A{cursorFilter(q.sortKey, q.desc, cur)}
+    }
+
+    q.limit = c.QueryInt("limit", formsDefaultLimit)
+    if q.limit <= 0 || q.limit > formsMaxLimit {
+        q.limit = formsDefaultLimit
+    }
+    return q, nil
+}
+
+func (q *formQuery) options() *options.FindOptions {
+    dir := 1
+    if q.desc {
+        dir = -1
+    }
+    return options.Find().
+        SetSort(bson.D{{Key: q.sortKey, Value: dir}, {Key: "_id", Value: dir}}).
+        SetLimit(int64(q.limit))
+}
+
+func formSortValue(f *Form, key string) interface{} {
+    switch key {
+    case "createdAt":
+        return f.CreatedAt
+    case "title":
+        return f.Title
+    }
+    return f.UpdatedAt
+}
+
+type responseStats struct {
+    FormID primitive.ObjectID `bson:"_id"`
+    Count  int                `bson:"count"`
+    Last   time.Time          `bson:"last"`
+}
+
+// ResponseStats counts the non-spam responses of the given forms and finds
+// the latest one, in one aggregation over the (workspaceId, formId,
+// createdAt) index.
+func (s *tenantStore) ResponseStats(ctx context.Context, formIDs []primitive.ObjectID) (map[primitive.ObjectID]responseStats, error) {
+    out := map[primitive.ObjectID]responseStats{}
+    if len(formIDs) == 0 {
+        return out, nil
+    }
+    cur, err := responsesCol(s.cfg).Aggregate(ctx, bson.A{
+        bson.M{"$match": s.scope(bson.M{"formId": bson.M{"$in": formIDs}, "spam": bson.M{"$ne": true}})},
+        bson.M{"$group": bson.M{"_id": "$formId", "count": bson.M{"$sum": 1}, "last": bson.M{"$max": "$createdAt"}}},
+    })
+    if err != nil {
+        return nil, err
+    }
+    var stats []responseStats
+    if err := cur.All(ctx, &stats); err != nil {
+        return nil, err
+    }
+    for _, st := range stats {
+        out[st.FormID] = st
+    }
+    return out, nil
+}
+
+// summarizeForms attaches response statistics to a page of forms.
+func summarizeForms(ctx context.Context, store *tenantStore, forms []Form) ([]FormSummary, error) {
+    ids := make([]primitive.ObjectID, len(forms))
+    for i := range forms {
+        ids[i] = forms[i].ID
+    }
+    stats, err := store.ResponseStats(ctx, ids)
+    if err != nil {
+        return nil, err
+    }
+    out := make([]FormSummary, len(forms))
+    for i := range forms {
+        out[i] = FormSummary{Form: forms[i]}
+        if st, ok := stats[forms[i].ID]; ok {
+            last := st.Last
+            out[i].ResponseCount = st.Count
+            out[i].LastResponseAt = &last
+        }
+    }
+    return out, nil
+}
@@ -1,9 +1,7 @@
 package api
 
 import (
-    "bytes"
     "context"
-    "encoding/csv"
     "errors"
     "fmt"
     "net/http"
@@ -36,154 +34,376 @@ func mongoClient(cfg *config.Config) *mongo.Client {
     return client
 }
 
+// GetAllFormsHandler lists a page of forms with their response counts; see
+// parseFormQuery for the parameters. nextCursor is set while more remain.
 func GetAllFormsHandler(cfg *config.Config) fiber.Handler {
     return func(c *fiber.Ctx) error {
-        userID := c.Locals("userID").(string)
-        cur, err := formsCol(cfg).Find(c.Context(), bson.M{"ownerId": userID}, options.Find().SetSort(bson.M{"updatedAt": -1}))
+        q, err := parseFormQuery(c)
+        if err != nil { return err }
+        store := storeFor(c, cfg)
+        forms, err := store.FindForms(c.Context(), q.filter, q.options())
         if err != nil {
             return fiber.NewError(fiber.StatusInternalServerError, err.Error())
         }
-        defer cur.Close(c.Context())
-
-        var forms []Form
-        if err := cur.All(c.Context(), &forms); err != nil {
+        summaries, err := summarizeForms(c.Context(), store, forms)
+        if err != nil {
             return fiber.NewError(fiber.StatusInternalServerError, err.Error())
         }
-        return c.JSON(forms)
+        resp := fiber.Map{"forms": summaries}
+        if len(forms) == q.limit {
+            last := &forms[len(forms)-1]
+            resp["nextCursor"] = encodeCursor(listCursor{Value: formSortValue(last, q.sortKey), ID: last.ID})
+        }
+        return c.JSON(resp)
     }
 }
 
-func CreateFormHandler(cfg *config.Config) fiber.Handler {
-    return func(c *fiber.Ctx) error {
-        userID := c.Locals("userID").(string)
-        var f Form
-        if err := c.BodyParser(&f); err != nil {
-            return fiber.NewError(fiber.StatusBadRequest, err.Error())
+// validateForm checks a complete form definition. It is used wherever a
+// form is created: from the builder, a template or an import.
+func validateForm(f *Form) error {
+    if f.Title == "" || f.Title == "Untitled Form" {
+        return fiber.NewError(fiber.StatusBadRequest, "Form title is required")
+    }
+    
+    if len(f.Fields) == 0 {
+        return fiber.NewError(fiber.StatusBadRequest, "At least one field is required")
+    }
+    if err := validateSpamProtection(f); err != nil {
+        return err
+    }
+    if err := validateSchedule(f); err != nil {
+        return err
+    }
+    if err := validateRespondentPolicy(f); err != nil {
+        return err
+    }
+    if err := validateEditWindow(f); err != nil {
+        return err
+    }
+    if err := validateNotifications(f.Notifications); err != nil {
+        return err
+    }
+    if err := validateRules(f, f.Rules); err != nil {
+        return err
+    }
+    tags, err := cleanTags(f.Tags)
+    if err != nil {
+        return err
+    }
+    f.Tags = tags
+    
+    hasRequiredField := false
+    for _, field := range f.Fields {
+        if field.Required {
+            hasRequiredField = true
+            break
         }
-        
-        // Validation
-        if f.Title == "" || f.Title == "Untitled Form" {
-            return fiber.NewError(fiber.StatusBadRequest, "Form title is required")
+    }
+    if !hasRequiredField {
+        return fiber.NewError(fiber.StatusBadRequest, "At least one field must be required")
+    }
+    
+    // Validate fields
+    for _, field := range f.Fields {
+        if field.Label == "" || field.Label == "Question" {
+            return fiber.NewError(fiber.StatusBadRequest, "All fields must have proper labels")
         }
         
-        if len(f.Fields) == 0 {
-            return fiber.NewError(fiber.StatusBadRequest, "At least one field is required")
+        // PII fields must be required
+        if field.IsPII && !field.Required {
+            return fiber.NewError(fiber.StatusBadRequest, "PII fields must be required")
         }
         
-        hasRequiredField := false
-        for _, field := range f.Fields {
-            if field.Required {
-                hasRequiredField = true
-                break
+        if field.Type == "single_choice" || field.Type == "multi_select" {
+            if len(field.Options) == 0 {
+                return fiber.NewError(fiber.StatusBadRequest, "Choice fields must have at least one option")
+            }
+            for _, option := range field.Options {
+                if option == "" {
+                    return fiber.NewError(fiber.StatusBadRequest, "All options must have text")
+                }
             }
         }
-        if !hasRequiredField {
-            return fiber.NewError(fiber.StatusBadRequest, "At least one field must be required")
+    }
+    return nil
+}
+
+func CreateFormHandler(cfg *config.Config) fiber.Handler {
+    return func(c *fiber.Ctx) error {
+        if err := requirePermission(c, permFormsWrite); err != nil { return err }
+        userID := c.Locals("userID").(string)
+        var f Form
+        if err := c.BodyParser(&f); err != nil {
+            return fiber.NewError(fiber.StatusBadRequest, err.Error())
         }
         
-        // Validate fields
-        for _, field := range f.Fields {
-            if field.Label == "" || field.Label == "Question" {
-                return fiber.NewError(fiber.StatusBadRequest, "All fields must have proper labels")
-            }
-            
-            // PII fields must be required
-            if field.IsPII && !field.Required {
-                return fiber.NewError(fiber.StatusBadRequest, "PII fields must be required")
-            }
-            
-            if field.Type == "single_choice" || field.Type == "multi_select" {
-                if len(field.Options) == 0 {
-                    return fiber.NewError(fiber.StatusBadRequest, "Choice fields must have at least one option")
-                }
-                for _, option := range field.Options {
-                    if option == "" {
-                        return fiber.NewError(fiber.StatusBadRequest, "All options must have text")
-                    }
-                }
+        if err := validateForm(&f); err != nil {
+            return err
+        }
+        if f.FolderID != nil {
+            if _, err := findFolder(c.Context(), cfg, c.Locals("workspaceID").(primitive.ObjectID), f.FolderID.Hex()); err != nil {
+                return err
             }
         }
         
         if f.Status == "" { f.Status = "draft" }
+        f.ResponseCount = 0
+        f.ArchivedAt, f.DeletedAt, f.DeletedBy = nil, nil, ""
+        normalizeSchedule(&f, time.Now())
         f.ID = primitive.NewObjectID()
         f.OwnerID = userID
         f.CreatedAt = time.Now()
         f.UpdatedAt = f.CreatedAt
 
-        _, err := formsCol(cfg).InsertOne(c.Context(), f)
-        if err != nil { return fiber.NewError(fiber.StatusInternalServerError, err.Error()) }
+        if err := storeFor(c, cfg).InsertForm(c.Context(), &f); err != nil {
+            return fiber.NewError(fiber.StatusInternalServerError, err.Error())
+        }
+        recordAudit(c, cfg, formAudit("form.created", &f))
+        if f.Status == "published" {
+            recordAudit(c, cfg, formAudit("form.published", &f))
+        }
         return c.Status(http.StatusCreated).JSON(f)
     }
 }
 
 func GetFormHandler(cfg *config.Config) fiber.Handler {
     return func(c *fiber.Ctx) error {
-        oid, err := primitive.ObjectIDFromHex(c.Params("id"))
-        if err != nil { return fiber.NewError(fiber.StatusBadRequest, "invalid id") }
-        var f Form
-        err = formsCol(cfg).FindOne(c.Context(), bson.M{"_id": oid}).Decode(&f)
-        if err != nil {
-            if err == mongo.ErrNoDocuments { return fiber.NewError(fiber.StatusNotFound, "not found") }
-            return fiber.NewError(fiber.StatusInternalServerError, err.Error())
-        }
+        f, err := formFromParam(c, cfg)
+        if err != nil { return err }
         return c.JSON(f)
     }
 }
 
 func UpdateFormHandler(cfg *config.Config) fiber.Handler {
     return func(c *fiber.Ctx) error {
-        oid, err := primitive.ObjectIDFromHex(c.Params("id"))
-        if err != nil { return fiber.NewError(fiber.StatusBadRequest, "invalid id") }
+        if err := requirePermission(c, permFormsWrite); err != nil { return err }
+        before, err := formFromParam(c, cfg)
+        if err != nil { return err }
         var f Form
         if err := c.BodyParser(&f); err != nil {
             return fiber.NewError(fiber.StatusBadRequest, err.Error())
         }
-        f.UpdatedAt = time.Now()
-        _, err = formsCol(cfg).UpdateByID(c.Context(), oid, bson.M{"$set": f})
-        if err != nil { return fiber.NewError(fiber.StatusInternalServerError, err.Error()) }
-        err = formsCol(cfg).FindOne(c.Context(), bson.M{"_id": oid}).Decode(&f)
-        if err != nil { return fiber.NewError(fiber.StatusInternalServerError, err.Error()) }
-        return c.JSON(f)
+        if err := validateSpamProtection(&f); err != nil { return err }
+        if err := validateSchedule(&f); err != nil { return err }
+        if err := validateRespondentPolicy(&f); err != nil { return err }
+        if err := validateEditWindow(&f); err != nil { return err }
+        if err := validateNotifications(f.Notifications); err != nil { return err }
+        if err := validateRules(&f, f.Rules); err != nil { return err }
+        if f.Tags, err = cleanTags(f.Tags); err != nil { return err }
+        if before.ArchivedAt != nil && (f.Status == statusPublished || f.Status == statusScheduled) {
+            return fiber.NewError(fiber.StatusConflict, "Unarchive the form before publishing it")
+        }
+        updated, err := saveFormUpdate(c, cfg, before, &f, "form.updated")
+        if err != nil { return err }
+        return c.JSON(updated)
     }
 }
 
+// saveFormUpdate stores an edited definition of before, keeping the fields
+// clients cannot change, and announces any resulting status change. Changes
+// to which fields are PII are checked here, for updates and imports alike.
+func saveFormUpdate(c *fiber.Ctx, cfg *config.Config, before, f *Form, action string) (*Form, error) {
+    if err := checkPIIChange(c, cfg, before, f); err != nil { return nil, err }
+    // Ownership and tenancy are not client-editable; folders and
+    // lifecycle change through their own endpoints
+    f.ID = before.ID
+    f.FolderID = before.FolderID
+    f.ArchivedAt = before.ArchivedAt
+    f.DeletedAt, f.DeletedBy = nil, ""
+    f.OwnerID = before.OwnerID
+    f.WorkspaceID = before.WorkspaceID
+    f.CreatedAt = before.CreatedAt
+    f.UpdatedAt = time.Now()
+    if f.Notifications != nil && before.Notifications != nil {
+        f.Notifications.LastDigestAt = before.Notifications.LastDigestAt
+    }
+    // normalizeSchedule closes forms at their quota, so it needs the
+    // stored count; the request body cannot set it
+    f.ResponseCount = before.ResponseCount
+    normalizeSchedule(f, f.UpdatedAt)
+    // The count is only ever changed by $inc; leave it out of $set
+    f.ResponseCount = 0
+
+    update := bson.M{"$set": f}
+    if unset := clearedSettings(f); len(unset) > 0 {
+        update["$unset"] = unset
+    }
+    store := storeFor(c, cfg)
+    if _, err := store.UpdateForm(c.Context(), before.ID, update); err != nil {
+        return nil, fiber.NewError(fiber.StatusInternalServerError, err.Error())
+    }
+    f, err := store.FindForm(c.Context(), before.ID)
+    if err != nil { return nil, fiber.NewError(fiber.StatusInternalServerError, err.Error()) }
+
+    if searchFieldsChanged(before, f) { reindexInBackground(cfg, f) }
+    if piiFieldsChanged(before, f) { syncInBackground(cfg, f) }
+
+    ev := formAudit(action, f)
+    ev.Changes = diffForms(before, f)
+    recordAudit(c, cfg, ev)
+    if before.Status != f.Status {
+        switch {
+        case f.Status == "published":
+            recordAudit(c, cfg, formAudit("form.published", f))
+        case f.Status == "closed":
+            recordAudit(c, cfg, formAudit("form.closed", f))
+        case before.Status == "published":
+            recordAudit(c, cfg, formAudit("form.unpublished", f))
+        }
+        BroadcastFormStatus(f.ID.Hex(), f.Status)
+        switch f.Status {
+        case "published":
+            enqueueWebhookEvent(c.Context(), cfg, f, eventFormOpened, fiber.Map{"status": f.Status, "reason": "manual"})
+        case "closed":
+            enqueueWebhookEvent(c.Context(), cfg, f, eventFormClosed, fiber.Map{"status": f.Status, "reason": "manual"})
+        }
+    }
+    return f, nil
+}
+
 func SubmitResponseHandler(cfg *config.Config) fiber.Handler {
     return func(c *fiber.Ctx) error {
         formOID, err := primitive.ObjectIDFromHex(c.Params("id"))
         if err != nil { return fiber.NewError(fiber.StatusBadRequest, "invalid id") }
 
         var f Form
-        if err := formsCol(cfg).FindOne(c.Context(), bson.M{"_id": formOID}).Decode(&f); err != nil {
+        if err := formsCol(cfg).FindOne(c.Context(), bson.M{"_id": formOID, "deletedAt": nil}).Decode(&f); err != nil {
             if err == mongo.ErrNoDocuments { return fiber.NewError(fiber.StatusNotFound, "form not found") }
             return fiber.NewError(fiber.StatusInternalServerError, err.Error())
         }
-        if f.Status != "published" {
-            return fiber.NewError(fiber.StatusBadRequest, "form not published")
-        }
 
         var r Response
         if err := c.BodyParser(&r); err != nil {
             return fiber.NewError(fiber.StatusBadRequest, err.Error())
         }
         if r.Answers == nil { r.Answers = map[string]interface{}{} }
+        honeypot := popHoneypot(&f, r.Answers)
 
+        // Retries of the same submission replay the original 201, even once
+        // the form has closed or the rate limit has tripped
+        var claim *idempotencyClaim
+        if key := submissionKey(c, &r); key != "" {
+            var handled bool
+            claim, handled, err = claimIdempotencyKey(c, cfg, &f, key, r.Answers)
+            if err != nil || handled { return err }
+            // Frees the key if this attempt fails before completing it
+            defer claim.release(c, cfg)
+            r.SubmissionID = key
+        }
+
+        if err := checkFormOpen(&f); err != nil { return err }
+        respondent, err := identifyRespondent(c, cfg, &f)
+        if err != nil { return err }
+        if err := checkSubmissionRate(c, cfg, &f); err != nil { return err }
         if err := validateSubmission(&f, r.Answers); err != nil {
             return fiber.NewError(fiber.StatusBadRequest, err.Error())
         }
+        if err := checkAlreadyResponded(c, cfg, formOID, respondent); err != nil { return err }
+
+        r.SpamScore, r.SpamSignals, err = scoreSubmission(c, cfg, &f, honeypot)
+        if err != nil { return err }
+        r.Spam = r.SpamScore >= spamThreshold(&f)
+
+        // PII answers are stored encrypted; the rest of the request works
+        // on the plaintext
+        keys, err := encryptionKeys(c.Context(), cfg, &f)
+        if err != nil { return fiber.NewError(fiber.StatusInternalServerError, err.Error()) }
+        stored, err := keys.encryptAnswers(&f, r.Answers)
+        if err != nil { return fiber.NewError(fiber.StatusInternalServerError, err.Error()) }
+
+        // Spam is stored for review but does not use up the quota
+        if !r.Spam {
+            if err := reserveResponseSlot(c, cfg, &f); err != nil { return err }
+        }
 
         r.ID = primitive.NewObjectID()
         r.FormID = formOID
+        r.WorkspaceID = f.WorkspaceID
+        r.Respondent = respondent
+        r.Status = responseNew
+        r.Tags, r.AssigneeID, r.MatchedRules = nil, "", nil
         r.CreatedAt = time.Now()
-        if _, err := responsesCol(cfg).InsertOne(c.Context(), r); err != nil {
+        r.SearchText = responseSearchText(&f, r.Answers)
+        doc := r
+        doc.Answers = stored
+        if _, err := responsesCol(cfg).InsertOne(c.Context(), doc); err != nil {
+            if !r.Spam { releaseResponseSlot(c, cfg, &f) }
+            // Two concurrent first submissions from the same respondent
+            if mongo.IsDuplicateKeyError(err) { return errAlreadyResponded }
+            return fiber.NewError(fiber.StatusInternalServerError, err.Error())
+        }
+
+        // The response exists now, so a retry must replay it even if the
+        // rest of this request fails
+        claim.complete(c, cfg, r.ID, http.StatusCreated)
+
+        if !r.Spam {
+            applyRules(c.Context(), cfg, &f, &r)
+            BroadcastResponse(&f, &r)
+            enqueueWebhookEvent(c.Context(), cfg, &f, eventResponseCreated, r)
+            notifyResponse(c.Context(), cfg, &f, &r)
+        }
+        // The edit token is for the respondent only
+        if !r.Spam && editWindow(&f) > 0 {
+            r.EditToken, err = issueEditToken(&f, &r, cfg.JWTSecret)
+            if err != nil { return fiber.NewError(fiber.StatusInternalServerError, err.Error()) }
+        }
+        return c.Status(http.StatusCreated).JSON(publicResponse(&r))
+    }
+}
+
+// GetPublicFormHandler serves a published form to respondents without
+// exposing ownership details, along with a signed load token and, when the
+// form requires it, a proof-of-work challenge.
+func GetPublicFormHandler(cfg *config.Config) fiber.Handler {
+    return func(c *fiber.Ctx) error {
+        oid, err := primitive.ObjectIDFromHex(c.Params("id"))
+        if err != nil { return fiber.NewError(fiber.StatusBadRequest, "invalid id") }
+        var f Form
+        if err := formsCol(cfg).FindOne(c.Context(), bson.M{"_id": oid, "deletedAt": nil}).Decode(&f); err != nil {
+            if err == mongo.ErrNoDocuments { return fiber.NewError(fiber.StatusNotFound, "form not found") }
             return fiber.NewError(fiber.StatusInternalServerError, err.Error())
         }
 
-        BroadcastResponse(formOID.Hex(), r)
-        return c.Status(http.StatusCreated).JSON(r)
+        out := fiber.Map{
+            "id":     f.ID,
+            "title":  f.Title,
+            "status": f.Status,
+            "fields": f.Fields,
+        }
+        if f.RespondentPolicy != "" { out["respondentPolicy"] = f.RespondentPolicy }
+        if f.OpensAt != nil { out["opensAt"] = f.OpensAt }
+        if f.ClosesAt != nil { out["closesAt"] = f.ClosesAt }
+        if checkFormOpen(&f) != nil {
+            if f.Status == "closed" { out["closedMessage"] = closedMessage(&f) }
+            return c.JSON(out)
+        }
+
+        token, nonce, err := issueLoadToken(f.ID.Hex(), cfg.JWTSecret)
+        if err != nil { return fiber.NewError(fiber.StatusInternalServerError, err.Error()) }
+        out["loadToken"] = token
+        if f.RespondentPolicy == policyBrowser {
+            rt, err := ensureBrowserToken(c, cfg)
+            if err != nil { return fiber.NewError(fiber.StatusInternalServerError, err.Error()) }
+            out["respondentToken"] = rt
+        }
+        if p := f.Protection; p != nil {
+            out["honeypotField"] = p.HoneypotField
+            if p.ProofOfWorkBits > 0 {
+                out["proofOfWork"] = fiber.Map{"challenge": nonce, "bits": p.ProofOfWorkBits}
+            }
+        }
+        return c.JSON(out)
     }
 }
 
 func validateSubmission(f *Form, answers map[string]interface{}) error {
+    // Stored answers with this prefix are taken to be encrypted
+    for _, v := range answers {
+        if _, encrypted := ciphertextKey(v); encrypted {
+            return errors.New("answers must not start with " + cipherPrefix)
+        }
+    }
     for _, field := range f.Fields {
         // Conditional visibility check
         if field.ShowIf != nil {
@@ -250,48 +470,15 @@ func validateSubmission(f *Form, answers map[string]interface{}) error {
 
 func AnalyticsHandler(cfg *config.Config) fiber.Handler {
     return func(c *fiber.Ctx) error {
-        oid, err := primitive.ObjectIDFromHex(c.Params("id"))
-        if err != nil { return fiber.NewError(fiber.StatusBadRequest, "invalid id") }
-        an, err := computeAnalytics(c.Context(), cfg, oid)
+        if err := requirePermission(c, permResponsesRead); err != nil { return err }
+        f, err := formFromParam(c, cfg)
+        if err != nil { return err }
+        an, err := computeAnalytics(c.Context(), storeFor(c, cfg), f)
         if err != nil { return fiber.NewError(fiber.StatusInternalServerError, err.Error()) }
         return c.JSON(an)
     }
 }
 
-func ExportCSVHandler(cfg *config.Config) fiber.Handler {
-    return func(c *fiber.Ctx) error {
-        oid, err := primitive.ObjectIDFromHex(c.Params("id"))
-        if err != nil { return fiber.NewError(fiber.StatusBadRequest, "invalid id") }
-
-        cur, err := responsesCol(cfg).Find(c.Context(), bson.M{"formId": oid})
-        if err != nil { return fiber.NewError(fiber.StatusInternalServerError, err.Error()) }
-        defer cur.Close(c.Context())
-
-        records := [][]string{{"response_id", "created_at", "field_id", "value"}}
-        for cur.Next(c.Context()) {
-            var r Response
-            if err := cur.Decode(&r); err != nil {
-                return fiber.NewError(fiber.StatusInternalServerError, err.Error())
-            }
-            for k, v := range r.Answers {
-                records = append(records, []string{
-                    r.ID.Hex(),
-                    r.CreatedAt.Format(time.RFC3339),
-                    k,
-                    toString(v),
-                })
-            }
-        }
-        buf := &bytes.Buffer{}
-        w := csv.NewWriter(buf)
-        _ = w.WriteAll(records)
-
-        c.Set("Content-Type", "text/csv")
-        c.Set("Content-Disposition", "attachment; filename=responses.csv")
-        return c.Send(buf.Bytes())
-    }
-}
-
 func toString(v interface{}) string {
     switch t := v.(type) {
     case string:
@@ -0,0 +1,158 @@
+package api
+
+import (
+    "crypto/sha256"
+    "encoding/hex"
+    "encoding/json"
+    "time"
+
+    "github.com/gofiber/fiber/v2"
+    "go.mongodb.org/mongo-driver/bson"
+    "go.mongodb.org/mongo-driver/bson/primitive"
+    "go.mongodb.org/mongo-driver/mongo"
+    "formbuilder/backend/config"
+)
+
+const maxIdempotencyKeyLength = 255
+
+// idempotencyRecord remembers the outcome of a submission so retries with
+// the same key replay it instead of creating a duplicate response. Only the
+// response ID and status are kept; the replayed body is rebuilt from the
+// stored response, so answers stay encrypted at rest and edit tokens are
+// never stored.
+type idempotencyRecord struct {
+    ID          string             `bson:"_id"` // formId + ":" + key
+    FormID      primitive.ObjectID `bson:"formId"`
+    PayloadHash string             `bson:"payloadHash"`
+    Completed   bool               `bson:"completed"`
+    ResponseID  primitive.ObjectID `bson:"responseId,omitempty"`
+    Status      int                `bson:"status,omitempty"`
+    CreatedAt   time.Time          `bson:"createdAt"`
+    ExpiresAt   time.Time          `bson:"expiresAt"`
+}
+
+// idempotencyClaim is held by the request that first used a key.
+type idempotencyClaim struct {
+    id        string
+    completed bool
+}
+
+func idempotencyCol(cfg *config.Config) *mongo.Collection {
+    return mongoClient(cfg).Database(cfg.MongoDB).Collection("idempotency_keys")
+}
+
+// submissionKey reads the Idempotency-Key header, falling back to a
+// client-generated submissionId in the body.
+func submissionKey(c *fiber.Ctx, r *Response) string {
+    if key := c.Get("Idempotency-Key"); key != "" {
+        return key
+    }
+    return r.SubmissionID
+}
+
+func payloadHash(answers map[string]interface{}) (string, error) {
+    // encoding/json sorts map keys, so equal answers hash equally
+    b, err := json.Marshal(answers)
+    if err != nil {
+        return "", err
+    }
+    sum := sha256.Sum256(b)
+    return hex.EncodeToString(sum[:]), nil
+}
+
+// claimIdempotencyKey reserves key for this request. If the key was used
+// before, it instead writes the replayed or conflicting response and returns
+// a nil claim with handled set.
+func claimIdempotencyKey(c *fiber.Ctx, cfg *config.Config, f *Form, key string, answers map[string]interface{}) (claim *idempotencyClaim, handled bool, err error) {
+    if len(key) > maxIdempotencyKeyLength {
+        return nil, false, fiber.NewError(fiber.StatusBadRequest, "Idempotency-Key is too long")
+    }
+    hash, err := payloadHash(answers)
+    if err != nil {
+        return nil, false, fiber.NewError(fiber.StatusBadRequest, err.Error())
+    }
+
+    now := time.Now()
+    rec := idempotencyRecord{
+        ID:          f.ID.Hex() + ":" + key,
+        FormID:      f.ID,
+        PayloadHash: hash,
+        CreatedAt:   now,
+        ExpiresAt:   now.Add(cfg.IdempotencyTTL),
+    }
+    _, err = idempotencyCol(cfg).InsertOne(c.Context(), rec)
+    if err == nil {
+        return &idempotencyClaim{id: rec.ID}, false, nil
+    }
+    if !mongo.IsDuplicateKeyError(err) {
+        return nil, false, fiber.NewError(fiber.StatusInternalServerError, err.Error())
+    }
+
+    var existing idempotencyRecord
+    if err := idempotencyCol(cfg).FindOne(c.Context(), bson.M{"_id": rec.ID}).Decode(&existing); err != nil {
+        return nil, false, fiber.NewError(fiber.StatusInternalServerError, err.Error())
+    }
+    if existing.PayloadHash != hash {
+        return nil, false, fiber.NewError(fiber.StatusConflict, "Idempotency-Key was already used with a different payload")
+    }
+    if !existing.Completed {
+        return nil, false, fiber.NewError(fiber.StatusConflict, "A request with this Idempotency-Key is still in progress")
+    }
+
+    return nil, true, replaySubmission(c, cfg, f, &existing)
+}
+
+// replaySubmission answers a retry with the response the first request
+// created, as it is stored now. The edit token is issued again while the
+// edit window is open; it is the same token the first request returned.
+func replaySubmission(c *fiber.Ctx, cfg *config.Config, f *Form, rec *idempotencyRecord) error {
+    status := rec.Status
+    if status == 0 {
+        status = fiber.StatusCreated
+    }
+    c.Set("Idempotent-Replayed", "true")
+    // The submit route is public, so there is no workspace in the request
+    store := &tenantStore{cfg: cfg, workspaceID: f.WorkspaceID}
+    stored, err := store.FindResponse(c.Context(), f.ID, rec.ResponseID)
+    if err == mongo.ErrNoDocuments {
+        // Deleted since; the key still must not create another response
+        return c.Status(status).JSON(fiber.Map{"id": rec.ResponseID})
+    }
+    if err != nil {
+        return fiber.NewError(fiber.StatusInternalServerError, err.Error())
+    }
+    keys, err := loadFormKeys(c.Context(), cfg, f.ID)
+    if err != nil {
+        return fiber.NewError(fiber.StatusInternalServerError, err.Error())
+    }
+    r := keys.decryptResponse(stored)
+    if !r.Spam && editWindow(f) > 0 && time.Now().Before(r.CreatedAt.Add(editWindow(f))) {
+        if r.EditToken, err = issueEditToken(f, r, cfg.JWTSecret); err != nil {
+            return fiber.NewError(fiber.StatusInternalServerError, err.Error())
+        }
+    }
+    return c.Status(status).JSON(publicResponse(r))
+}
+
+// complete records the response the key created so later retries can
+// replay it.
+func (cl *idempotencyClaim) complete(c *fiber.Ctx, cfg *config.Config, responseID primitive.ObjectID, status int) {
+    if cl == nil {
+        return
+    }
+    cl.completed = true
+    _, _ = idempotencyCol(cfg).UpdateByID(c.Context(), cl.id, bson.M{"$set": bson.M{
+        "completed":  true,
+        "responseId": responseID,
+        "status":     status,
+    }})
+}
+
+// release frees the key after a failed attempt so the client can retry. It
+// does nothing once the claim is completed.
+func (cl *idempotencyClaim) release(c *fiber.Ctx, cfg *config.Config) {
+    if cl == nil || cl.completed {
+        return
+    }
+    _, _ = idempotencyCol(cfg).DeleteOne(c.Context(), bson.M{"_id": cl.id, "completed": false})
+}
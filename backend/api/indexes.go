@@ -0,0 +1,121 @@
+package api
+
+import (
+    "context"
+    "log"
+    "time"
+
+    "go.mongodb.org/mongo-driver/bson"
+    "go.mongodb.org/mongo-driver/mongo"
+    "go.mongodb.org/mongo-driver/mongo/options"
+    "formbuilder/backend/config"
+)
+
+// EnsureIndexes creates the indexes the API relies on. Failures are logged
+// so a misconfigured index does not stop the server from starting.
+func EnsureIndexes(cfg *config.Config) {
+    ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+    defer cancel()
+
+    ensure := func(col *mongo.Collection, models ...mongo.IndexModel) {
+        if _, err := col.Indexes().CreateMany(ctx, models); err != nil {
+            log.Printf("indexes: %s: %v", col.Name(), err)
+        }
+    }
+
+    ensure(usersCol(cfg),
+        mongo.IndexModel{
+            Keys:    bson.D{{Key: "email", Value: 1}},
+            Options: options.Index().SetUnique(true),
+        },
+        // login and registration lookups, see findUserByEmail
+        mongo.IndexModel{
+            Keys:    bson.D{{Key: "email", Value: 1}},
+            Options: options.Index().SetCollation(emailCollation).SetName("email_ci"),
+        },
+    )
+    ensure(loginAttemptsCol(cfg), mongo.IndexModel{
+        Keys:    bson.D{{Key: "expiresAt", Value: 1}},
+        Options: options.Index().SetExpireAfterSeconds(0),
+    })
+    ensure(membersCol(cfg),
+        mongo.IndexModel{
+            Keys:    bson.D{{Key: "workspaceId", Value: 1}, {Key: "userId", Value: 1}},
+            Options: options.Index().SetUnique(true),
+        },
+        mongo.IndexModel{Keys: bson.D{{Key: "userId", Value: 1}}},
+    )
+    ensure(formsCol(cfg),
+        mongo.IndexModel{Keys: bson.D{{Key: "workspaceId", Value: 1}, {Key: "updatedAt", Value: -1}}},
+        // forms list filters and the trash purge
+        mongo.IndexModel{Keys: bson.D{{Key: "deletedAt", Value: 1}}, Options: options.Index().SetSparse(true)},
+        mongo.IndexModel{Keys: bson.D{{Key: "workspaceId", Value: 1}, {Key: "folderId", Value: 1}, {Key: "updatedAt", Value: -1}}},
+        mongo.IndexModel{Keys: bson.D{{Key: "workspaceId", Value: 1}, {Key: "tags", Value: 1}}},
+        // scheduler lookups
+        mongo.IndexModel{Keys: bson.D{{Key: "status", Value: 1}, {Key: "opensAt", Value: 1}}},
+        mongo.IndexModel{Keys: bson.D{{Key: "status", Value: 1}, {Key: "closesAt", Value: 1}}},
+        // search; the workspace prefix keeps text queries within a tenant
+        mongo.IndexModel{
+            Keys:    bson.D{{Key: "workspaceId", Value: 1}, {Key: "title", Value: "text"}, {Key: "fields.label", Value: "text"}},
+            Options: options.Index().SetWeights(bson.M{"title": 5, "fields.label": 1}).SetName("forms_text"),
+        },
+    )
+    ensure(responsesCol(cfg),
+        mongo.IndexModel{Keys: bson.D{{Key: "workspaceId", Value: 1}, {Key: "formId", Value: 1}, {Key: "createdAt", Value: -1}}},
+        mongo.IndexModel{Keys: bson.D{{Key: "workspaceId", Value: 1}, {Key: "createdAt", Value: 1}}},
+        // default listing order with its _id tiebreaker
+        mongo.IndexModel{Keys: bson.D{{Key: "formId", Value: 1}, {Key: "createdAt", Value: -1}, {Key: "_id", Value: -1}}},
+        mongo.IndexModel{
+            Keys:    bson.D{{Key: "workspaceId", Value: 1}, {Key: "searchText", Value: "text"}},
+            Options: options.Index().SetName("responses_text"),
+        },
+        // review queues
+        mongo.IndexModel{Keys: bson.D{{Key: "formId", Value: 1}, {Key: "status", Value: 1}, {Key: "_id", Value: -1}}},
+        mongo.IndexModel{Keys: bson.D{{Key: "formId", Value: 1}, {Key: "assigneeId", Value: 1}, {Key: "_id", Value: -1}}},
+        mongo.IndexModel{Keys: bson.D{{Key: "formId", Value: 1}, {Key: "tags", Value: 1}}},
+        // one response per respondent on restricted forms
+        mongo.IndexModel{
+            Keys: bson.D{{Key: "formId", Value: 1}, {Key: "respondent.key", Value: 1}},
+            Options: options.Index().SetUnique(true).
+                SetPartialFilterExpression(bson.M{"respondent.key": bson.M{"$exists": true}}),
+        },
+    )
+    ensure(foldersCol(cfg), mongo.IndexModel{
+        Keys:    bson.D{{Key: "workspaceId", Value: 1}, {Key: "name", Value: 1}},
+        Options: options.Index().SetUnique(true),
+    })
+    ensure(templatesCol(cfg), mongo.IndexModel{Keys: bson.D{{Key: "workspaceId", Value: 1}, {Key: "category", Value: 1}, {Key: "name", Value: 1}}})
+    ensure(notesCol(cfg), mongo.IndexModel{Keys: bson.D{{Key: "responseId", Value: 1}, {Key: "createdAt", Value: 1}}})
+    ensure(webhooksCol(cfg), mongo.IndexModel{Keys: bson.D{{Key: "formId", Value: 1}, {Key: "active", Value: 1}}})
+    ensure(deliveriesCol(cfg),
+        mongo.IndexModel{Keys: bson.D{{Key: "status", Value: 1}, {Key: "nextAttemptAt", Value: 1}}},
+        mongo.IndexModel{Keys: bson.D{{Key: "webhookId", Value: 1}, {Key: "_id", Value: -1}}},
+        mongo.IndexModel{Keys: bson.D{{Key: "expiresAt", Value: 1}}, Options: options.Index().SetExpireAfterSeconds(0)},
+    )
+    ensure(exportJobsCol(cfg),
+        mongo.IndexModel{Keys: bson.D{{Key: "status", Value: 1}, {Key: "leaseUntil", Value: 1}}},
+        mongo.IndexModel{Keys: bson.D{{Key: "formId", Value: 1}, {Key: "_id", Value: -1}}},
+        mongo.IndexModel{Keys: bson.D{{Key: "expiresAt", Value: 1}}},
+    )
+    ensure(formKeysCol(cfg),
+        mongo.IndexModel{Keys: bson.D{{Key: "formId", Value: 1}, {Key: "createdAt", Value: 1}}},
+        mongo.IndexModel{Keys: bson.D{{Key: "masterKeyId", Value: 1}}},
+    )
+    ensure(idempotencyCol(cfg), mongo.IndexModel{
+        Keys:    bson.D{{Key: "expiresAt", Value: 1}},
+        Options: options.Index().SetExpireAfterSeconds(0),
+    })
+    ensure(rateLimitsCol(cfg), mongo.IndexModel{
+        Keys:    bson.D{{Key: "expiresAt", Value: 1}},
+        Options: options.Index().SetExpireAfterSeconds(0),
+    })
+    ensure(usedLoadTokensCol(cfg), mongo.IndexModel{
+        Keys:    bson.D{{Key: "expiresAt", Value: 1}},
+        Options: options.Index().SetExpireAfterSeconds(0),
+    })
+    ensure(auditCol(cfg),
+        mongo.IndexModel{Keys: bson.D{{Key: "formId", Value: 1}, {Key: "_id", Value: -1}}},
+        mongo.IndexModel{Keys: bson.D{{Key: "actorId", Value: 1}, {Key: "_id", Value: -1}}},
+        mongo.IndexModel{Keys: bson.D{{Key: "action", Value: 1}, {Key: "_id", Value: -1}}},
+    )
+}
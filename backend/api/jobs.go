@@ -0,0 +1,73 @@
+package api
+
+import (
+    "context"
+    "log"
+    "time"
+
+    "go.mongodb.org/mongo-driver/bson"
+    "formbuilder/backend/config"
+)
+
+// StartJobs launches the background workers. Each runs on its own ticker
+// for the lifetime of the process.
+func StartJobs(cfg *config.Config) {
+    go every(time.Hour, func(ctx context.Context) { enforceRetention(ctx, cfg) })
+    go every(cfg.SchedulerInterval, func(ctx context.Context) { runScheduler(ctx, cfg) })
+    go every(webhookPollInterval, func(ctx context.Context) { processWebhookDeliveries(ctx, cfg) })
+    go every(time.Hour, func(ctx context.Context) { sendDigests(ctx, cfg) })
+    go every(time.Hour, func(ctx context.Context) { purgeTrash(ctx, cfg) })
+    go every(exportPollInterval, func(ctx context.Context) { processExportJobs(ctx, cfg) })
+    go every(time.Hour, func(ctx context.Context) { expireExports(ctx, cfg) })
+    startMailWorkers(cfg)
+    go func() {
+        ctx, cancel := context.WithTimeout(context.Background(), time.Hour)
+        defer cancel()
+        backfillSearchText(ctx, cfg)
+    }()
+    go func() {
+        ctx, cancel := context.WithTimeout(context.Background(), time.Hour)
+        defer cancel()
+        backfillAnswerEncryption(ctx, cfg)
+    }()
+}
+
+func every(interval time.Duration, job func(ctx context.Context)) {
+    run := func() {
+        ctx, cancel := context.WithTimeout(context.Background(), interval)
+        defer cancel()
+        job(ctx)
+    }
+    run()
+    ticker := time.NewTicker(interval)
+    defer ticker.Stop()
+    for range ticker.C {
+        run()
+    }
+}
+
+// enforceRetention deletes responses older than each workspace's
+// retention period.
+func enforceRetention(ctx context.Context, cfg *config.Config) {
+    cur, err := workspacesCol(cfg).Find(ctx, bson.M{"settings.retentionDays": bson.M{"$gt": 0}})
+    if err != nil {
+        log.Printf("retention: %v", err)
+        return
+    }
+    var workspaces []Workspace
+    if err := cur.All(ctx, &workspaces); err != nil {
+        log.Printf("retention: %v", err)
+        return
+    }
+    for _, ws := range workspaces {
+        cutoff := time.Now().AddDate(0, 0, -ws.Settings.RetentionDays)
+        res, err := responsesCol(cfg).DeleteMany(ctx, bson.M{"workspaceId": ws.ID, "createdAt": bson.M{"$lt": cutoff}})
+        if err != nil {
+            log.Printf("retention: workspace=%s: %v", ws.ID.Hex(), err)
+            continue
+        }
+        if res.DeletedCount > 0 {
+            log.Printf("retention: workspace=%s deleted %d responses", ws.ID.Hex(), res.DeletedCount)
+        }
+    }
+}
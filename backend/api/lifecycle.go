@@ -0,0 +1,408 @@
+package api
+
+import (
+    "context"
+    "crypto/rand"
+    "fmt"
+    "log"
+    "strings"
+    "time"
+
+    "github.com/gofiber/fiber/v2"
+    "go.mongodb.org/mongo-driver/bson"
+    "go.mongodb.org/mongo-driver/bson/primitive"
+    "go.mongodb.org/mongo-driver/mongo/options"
+    "formbuilder/backend/config"
+)
+
+const copyBatch = 500
+
+type DuplicateRequest struct {
+    Title            string `json:"title"` // defaults to "Copy of <title>"
+    IncludeResponses bool   `json:"includeResponses"`
+}
+
+// newFieldID returns a random UUID, the same form of ID the builder gives
+// new fields.
+func newFieldID() string {
+    b := make([]byte, 16)
+    rand.Read(b)
+    b[6] = b[6]&0x0f | 0x40
+    b[8] = b[8]&0x3f | 0x80
+    return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
+
+// remapFieldIDs gives every field of f a new ID and rewrites the settings
+// that refer to fields. It returns the old-to-new mapping for the answers.
+func remapFieldIDs(f *Form) map[string]string {
+    ids := map[string]string{}
+    for i := range f.Fields {
+        id := newFieldID()
+        ids[f.Fields[i].ID] = id
+        f.Fields[i].ID = id
+    }
+    mapped := func(id string) string {
+        if n, ok := ids[id]; ok {
+            return n
+        }
+        return id
+    }
+    for i := range f.Fields {
+        if s := f.Fields[i].ShowIf; s != nil {
+            f.Fields[i].ShowIf = &ShowIf{FieldID: mapped(s.FieldID), Equals: s.Equals}
+        }
+    }
+    rules := make([]Rule, len(f.Rules))
+    for i, rule := range f.Rules {
+        rule.Conditions = append([]Condition(nil), rule.Conditions...)
+        for j := range rule.Conditions {
+            rule.Conditions[j].FieldID = mapped(rule.Conditions[j].FieldID)
+        }
+        // Webhook actions point at the source form's webhooks, which are not copied
+        var actions []Action
+        for _, a := range rule.Actions {
+            if a.Type != "webhook" {
+                actions = append(actions, a)
+            }
+        }
+        rule.Actions = actions
+        rules[i] = rule
+    }
+    if len(rules) > 0 {
+        f.Rules = rules
+    }
+    if f.Notifications != nil {
+        n := *f.Notifications
+        n.LastDigestAt = nil
+        f.Notifications = &n
+    }
+    return ids
+}
+
+func remapAnswers(answers map[string]interface{}, ids map[string]string) map[string]interface{} {
+    out := make(map[string]interface{}, len(answers))
+    for k, v := range answers {
+        if n, ok := ids[k]; ok {
+            k = n
+        }
+        out[k] = v
+    }
+    return out
+}
+
+// copyResponses copies every response of src to dst in batches, rewriting
+// answer keys to the new field IDs. Notes are not copied. It returns the
+// number of non-spam responses copied, which becomes the new form's count.
+func copyResponses(ctx context.Context, cfg *config.Config, src, dst *Form, ids map[string]string) (int, error) {
+    // Answers are encrypted per form and field, so PII is re-encrypted
+    // with the copy's key under its new field IDs
+    srcKeys, err := loadFormKeys(ctx, cfg, src.ID)
+    if err != nil {
+        return 0, err
+    }
+    dstKeys, err := encryptionKeys(ctx, cfg, dst)
+    if err != nil {
+        return 0, err
+    }
+    copied := func(answers map[string]interface{}) (map[string]interface{}, error) {
+        return dstKeys.encryptAnswers(dst, remapAnswers(srcKeys.decryptAnswers(answers), ids))
+    }
+    cur, err := responsesCol(cfg).Find(ctx, bson.M{"formId": src.ID}, options.Find().SetSort(bson.M{"_id": 1}))
+    if err != nil {
+        return 0, err
+    }
+    defer cur.Close(ctx)
+    counted := 0
+    var batch []interface{}
+    flush := func() error {
+        if len(batch) == 0 {
+            return nil
+        }
+        _, err := responsesCol(cfg).InsertMany(ctx, batch)
+        batch = batch[:0]
+        return err
+    }
+    for cur.Next(ctx) {
+        var r Response
+        if err := cur.Decode(&r); err != nil {
+            return counted, err
+        }
+        r.ID = primitive.NewObjectID()
+        r.FormID = dst.ID
+        r.WorkspaceID = dst.WorkspaceID
+        r.SearchText = responseSearchText(dst, remapAnswers(r.Answers, ids))
+        if r.Answers, err = copied(r.Answers); err != nil {
+            return counted, err
+        }
+        for i := range r.Edits {
+            if r.Edits[i].Previous, err = copied(r.Edits[i].Previous); err != nil {
+                return counted, err
+            }
+        }
+        if !r.Spam {
+            counted++
+        }
+        batch = append(batch, r)
+        if len(batch) == copyBatch {
+            if err := flush(); err != nil {
+                return counted, err
+            }
+        }
+    }
+    if err := cur.Err(); err != nil {
+        return counted, err
+    }
+    return counted, flush()
+}
+
+// DuplicateFormHandler copies a form as a new draft with fresh field IDs,
+// optionally with its responses. Webhooks, notes and the lifecycle state of
+// the source are not copied.
+func DuplicateFormHandler(cfg *config.Config) fiber.Handler {
+    return func(c *fiber.Ctx) error {
+        if err := requirePermission(c, permFormsWrite); err != nil {
+            return err
+        }
+        src, err := formFromParam(c, cfg)
+        if err != nil {
+            return err
+        }
+        var req DuplicateRequest
+        if len(c.Body()) > 0 {
+            if err := c.BodyParser(&req); err != nil {
+                return fiber.NewError(fiber.StatusBadRequest, "Invalid request body")
+            }
+        }
+        if req.IncludeResponses {
+            if err := requirePermission(c, permResponsesRead); err != nil {
+                return err
+            }
+        }
+
+        f := *src
+        f.Fields = append([]Field(nil), src.Fields...)
+        ids := remapFieldIDs(&f)
+        f.ID = primitive.NewObjectID()
+        f.Title = strings.TrimSpace(req.Title)
+        if f.Title == "" {
+            f.Title = "Copy of " + src.Title
+        }
+        f.Status = statusDraft
+        f.OwnerID = c.Locals("userID").(string)
+        f.CreatedAt = time.Now()
+        f.UpdatedAt = f.CreatedAt
+        f.ResponseCount = 0
+        f.ArchivedAt, f.DeletedAt, f.DeletedBy = nil, nil, ""
+
+        store := storeFor(c, cfg)
+        if err := store.InsertForm(c.Context(), &f); err != nil {
+            return fiber.NewError(fiber.StatusInternalServerError, err.Error())
+        }
+        if req.IncludeResponses {
+            counted, err := copyResponses(c.Context(), cfg, src, &f, ids)
+            if err != nil {
+                // Don't leave a half-copied form behind
+                deleteFormData(c.Context(), cfg, bson.M{"_id": f.ID})
+                return fiber.NewError(fiber.StatusInternalServerError, err.Error())
+            }
+            if counted > 0 {
+                if _, err := store.UpdateForm(c.Context(), f.ID, bson.M{"$set": bson.M{"responseCount": counted}}); err != nil {
+                    return fiber.NewError(fiber.StatusInternalServerError, err.Error())
+                }
+                f.ResponseCount = counted
+            }
+        }
+
+        ev := formAudit("form.duplicated", &f)
+        ev.Metadata = map[string]interface{}{"sourceFormId": src.ID.Hex(), "includeResponses": req.IncludeResponses}
+        recordAudit(c, cfg, ev)
+        return c.Status(fiber.StatusCreated).JSON(f)
+    }
+}
+
+// ArchiveFormHandler archives a form, closing it if it is open. Archived
+// forms are left out of the forms list by default and cannot be published
+// until they are unarchived.
+func ArchiveFormHandler(cfg *config.Config) fiber.Handler {
+    return func(c *fiber.Ctx) error {
+        if err := requirePermission(c, permFormsWrite); err != nil {
+            return err
+        }
+        f, err := formFromParam(c, cfg)
+        if err != nil {
+            return err
+        }
+        if f.ArchivedAt != nil {
+            return c.JSON(f)
+        }
+        closeForm(c.Context(), cfg, f, "archived")
+        now := time.Now()
+        if _, err := storeFor(c, cfg).UpdateForm(c.Context(), f.ID, bson.M{"$set": bson.M{"archivedAt": now, "updatedAt": now}}); err != nil {
+            return fiber.NewError(fiber.StatusInternalServerError, err.Error())
+        }
+        recordAudit(c, cfg, formAudit("form.archived", f))
+        return reloadForm(c, cfg, f.ID)
+    }
+}
+
+// UnarchiveFormHandler restores an archived form. It stays closed until it
+// is published again.
+func UnarchiveFormHandler(cfg *config.Config) fiber.Handler {
+    return func(c *fiber.Ctx) error {
+        if err := requirePermission(c, permFormsWrite); err != nil {
+            return err
+        }
+        f, err := formFromParam(c, cfg)
+        if err != nil {
+            return err
+        }
+        if f.ArchivedAt == nil {
+            return c.JSON(f)
+        }
+        update := bson.M{"$unset": bson.M{"archivedAt": ""}, "$set": bson.M{"updatedAt": time.Now()}}
+        if _, err := storeFor(c, cfg).UpdateForm(c.Context(), f.ID, update); err != nil {
+            return fiber.NewError(fiber.StatusInternalServerError, err.Error())
+        }
+        recordAudit(c, cfg, formAudit("form.unarchived", f))
+        return reloadForm(c, cfg, f.ID)
+    }
+}
+
+func reloadForm(c *fiber.Ctx, cfg *config.Config, id primitive.ObjectID) error {
+    f, err := storeFor(c, cfg).FindForm(c.Context(), id)
+    if err != nil {
+        return fiber.NewError(fiber.StatusInternalServerError, err.Error())
+    }
+    return c.JSON(f)
+}
+
+// TrashFormHandler moves a form to the trash, closing it if it is open.
+// It is purged with its responses after cfg.TrashRetention.
+func TrashFormHandler(cfg *config.Config) fiber.Handler {
+    return func(c *fiber.Ctx) error {
+        if err := requirePermission(c, permFormsWrite); err != nil {
+            return err
+        }
+        f, err := formFromParam(c, cfg)
+        if err != nil {
+            return err
+        }
+        closeForm(c.Context(), cfg, f, "deleted")
+        now := time.Now()
+        update := bson.M{"$set": bson.M{"deletedAt": now, "deletedBy": c.Locals("userID").(string)}}
+        if _, err := storeFor(c, cfg).UpdateForm(c.Context(), f.ID, update); err != nil {
+            return fiber.NewError(fiber.StatusInternalServerError, err.Error())
+        }
+        recordAudit(c, cfg, formAudit("form.trashed", f))
+        return c.JSON(fiber.Map{"id": f.ID, "deletedAt": now, "purgeAt": now.Add(cfg.TrashRetention)})
+    }
+}
+
+// trashedForm loads the :id form from the caller's workspace trash.
+func trashedForm(c *fiber.Ctx, cfg *config.Config) (*Form, error) {
+    oid, err := primitive.ObjectIDFromHex(c.Params("id"))
+    if err != nil {
+        return nil, fiber.NewError(fiber.StatusBadRequest, "invalid id")
+    }
+    forms, err := storeFor(c, cfg).FindForms(c.Context(), bson.M{"_id": oid, "deletedAt": bson.M{"$ne": nil}})
+    if err != nil {
+        return nil, fiber.NewError(fiber.StatusInternalServerError, err.Error())
+    }
+    if len(forms) == 0 {
+        return nil, fiber.NewError(fiber.StatusNotFound, "not found")
+    }
+    return &forms[0], nil
+}
+
+func ListTrashHandler(cfg *config.Config) fiber.Handler {
+    return func(c *fiber.Ctx) error {
+        if err := requirePermission(c, permFormsRead); err != nil {
+            return err
+        }
+        forms, err := storeFor(c, cfg).FindForms(c.Context(), bson.M{"deletedAt": bson.M{"$ne": nil}},
+            options.Find().SetSort(bson.M{"deletedAt": -1}).SetProjection(bson.M{"title": 1, "status": 1, "deletedAt": 1, "deletedBy": 1, "createdAt": 1, "updatedAt": 1}))
+        if err != nil {
+            return fiber.NewError(fiber.StatusInternalServerError, err.Error())
+        }
+        out := make([]fiber.Map, len(forms))
+        for i, f := range forms {
+            out[i] = fiber.Map{
+                "id":        f.ID,
+                "title":     f.Title,
+                "status":    f.Status,
+                "deletedAt": f.DeletedAt,
+                "deletedBy": f.DeletedBy,
+                "purgeAt":   f.DeletedAt.Add(cfg.TrashRetention),
+            }
+        }
+        return c.JSON(out)
+    }
+}
+
+// RestoreFormHandler takes a form out of the trash. It stays closed until
+// it is published again.
+func RestoreFormHandler(cfg *config.Config) fiber.Handler {
+    return func(c *fiber.Ctx) error {
+        if err := requirePermission(c, permFormsWrite); err != nil {
+            return err
+        }
+        f, err := trashedForm(c, cfg)
+        if err != nil {
+            return err
+        }
+        update := bson.M{"$unset": bson.M{"deletedAt": "", "deletedBy": ""}, "$set": bson.M{"updatedAt": time.Now()}}
+        if _, err := storeFor(c, cfg).UpdateForm(c.Context(), f.ID, update); err != nil {
+            return fiber.NewError(fiber.StatusInternalServerError, err.Error())
+        }
+        recordAudit(c, cfg, formAudit("form.restored", f))
+        return reloadForm(c, cfg, f.ID)
+    }
+}
+
+// PurgeFormHandler permanently deletes a trashed form and its responses
+// without waiting for the retention period.
+func PurgeFormHandler(cfg *config.Config) fiber.Handler {
+    return func(c *fiber.Ctx) error {
+        if err := requirePermission(c, permResponsesDelete); err != nil {
+            return err
+        }
+        f, err := trashedForm(c, cfg)
+        if err != nil {
+            return err
+        }
+        if err := deleteForms(c, cfg, bson.M{"_id": f.ID, "workspaceId": f.WorkspaceID, "deletedAt": bson.M{"$ne": nil}}); err != nil {
+            return fiber.NewError(fiber.StatusInternalServerError, err.Error())
+        }
+        return c.SendStatus(fiber.StatusNoContent)
+    }
+}
+
+// purgeTrash hard-deletes forms that have been in the trash longer than
+// the retention period.
+func purgeTrash(ctx context.Context, cfg *config.Config) {
+    cutoff := time.Now().Add(-cfg.TrashRetention)
+    cur, err := formsCol(cfg).Find(ctx, bson.M{"deletedAt": bson.M{"$lt": cutoff}}, options.Find().SetProjection(bson.M{"workspaceId": 1}))
+    if err != nil {
+        log.Printf("trash: %v", err)
+        return
+    }
+    var forms []Form
+    if err := cur.All(ctx, &forms); err != nil {
+        log.Printf("trash: %v", err)
+        return
+    }
+    for _, f := range forms {
+        // Rechecking deletedAt skips forms restored since the scan
+        ids, err := deleteFormData(ctx, cfg, bson.M{"_id": f.ID, "deletedAt": bson.M{"$lt": cutoff}})
+        if err != nil {
+            log.Printf("trash: form=%s: %v", f.ID.Hex(), err)
+            continue
+        }
+        if len(ids) > 0 {
+            insertAudit(ctx, cfg, AuditEvent{Action: "form.purged", TargetType: "form", TargetID: f.ID.Hex(), FormID: f.ID.Hex(), WorkspaceID: f.WorkspaceID.Hex()})
+        }
+    }
+    if len(forms) > 0 {
+        log.Printf("trash: purged %d forms", len(forms))
+    }
+}
@@ -0,0 +1,185 @@
+package api
+
+import (
+    "context"
+    "math"
+    "strconv"
+    "strings"
+    "time"
+    "unicode"
+
+    "github.com/gofiber/fiber/v2"
+    "go.mongodb.org/mongo-driver/bson"
+    "go.mongodb.org/mongo-driver/mongo"
+    "go.mongodb.org/mongo-driver/mongo/options"
+    "golang.org/x/crypto/bcrypt"
+    "formbuilder/backend/config"
+)
+
+// loginAttempt tracks consecutive failures for one key, e.g. an account
+// ("account:<email>") or a client address ("login:ip:<addr>").
+type loginAttempt struct {
+    Key         string    `bson:"_id"`
+    Failures    int       `bson:"failures"`
+    LockedUntil time.Time `bson:"lockedUntil,omitempty"`
+    ExpiresAt   time.Time `bson:"expiresAt"`
+}
+
+// attemptWindow is how long failures are remembered after the last one.
+const attemptWindow = 24 * time.Hour
+
+const minPasswordLength = 10
+
+var commonPasswords = map[string]bool{
+    "password": true, "password1": true, "password123": true, "123456789": true,
+    "1234567890": true, "qwertyuiop": true, "iloveyou": true, "letmein123": true,
+    "welcome123": true, "admin12345": true, "changeme123": true, "1q2w3e4r5t": true,
+}
+
+// dummyHash is compared against when the email is unknown so that the
+// response time does not reveal whether an account exists.
+var dummyHash, _ = bcrypt.GenerateFromPassword([]byte("formbuilder-timing-equalizer"), bcrypt.DefaultCost)
+
+func loginAttemptsCol(cfg *config.Config) *mongo.Collection {
+    return mongoClient(cfg).Database(cfg.MongoDB).Collection("login_attempts")
+}
+
+func accountKey(email string) string {
+    return "account:" + normalizeEmail(email)
+}
+
+func normalizeEmail(email string) string {
+    return strings.ToLower(strings.TrimSpace(email))
+}
+
+func ipKey(scope, ip string) string {
+    return scope + ":ip:" + ip
+}
+
+// lockedFor returns how long the longest of the given keys remains locked.
+func lockedFor(ctx context.Context, cfg *config.Config, keys ...string) (time.Duration, error) {
+    cur, err := loginAttemptsCol(cfg).Find(ctx, bson.M{
+        "_id":         bson.M{"$in": keys},
+        "lockedUntil": bson.M{"$gt": time.Now()},
+    })
+    if err != nil {
+        return 0, err
+    }
+    defer cur.Close(ctx)
+
+    var wait time.Duration
+    for cur.Next(ctx) {
+        var a loginAttempt
+        if err := cur.Decode(&a); err != nil {
+            return 0, err
+        }
+        if d := time.Until(a.LockedUntil); d > wait {
+            wait = d
+        }
+    }
+    return wait, cur.Err()
+}
+
+// recordFailure counts a failed attempt and, once threshold is reached,
+// locks the key with exponential backoff: base, 2*base, 4*base ... up to max.
+// It returns the lock duration when this failure triggered a lock.
+func recordFailure(ctx context.Context, cfg *config.Config, key string, threshold int) (time.Duration, error) {
+    now := time.Now()
+    var a loginAttempt
+    err := loginAttemptsCol(cfg).FindOneAndUpdate(ctx,
+        bson.M{"_id": key},
+        bson.M{
+            "$inc": bson.M{"failures": 1},
+            "$set": bson.M{"expiresAt": now.Add(attemptWindow)},
+        },
+        options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After),
+    ).Decode(&a)
+    if err != nil {
+        return 0, err
+    }
+    if a.Failures < threshold {
+        return 0, nil
+    }
+
+    exp := float64(a.Failures - threshold)
+    lock := time.Duration(float64(cfg.LockoutBase) * math.Pow(2, exp))
+    if lock > cfg.LockoutMax || lock <= 0 {
+        lock = cfg.LockoutMax
+    }
+    _, err = loginAttemptsCol(cfg).UpdateByID(ctx, key, bson.M{"$set": bson.M{
+        "lockedUntil": now.Add(lock),
+        "expiresAt":   now.Add(lock + attemptWindow),
+    }})
+    return lock, err
+}
+
+func clearFailures(ctx context.Context, cfg *config.Config, keys ...string) {
+    _, _ = loginAttemptsCol(cfg).DeleteMany(ctx, bson.M{"_id": bson.M{"$in": keys}})
+}
+
+// checkLocked fails the request with 429 while any of the keys is locked.
+func checkLocked(c *fiber.Ctx, cfg *config.Config, keys ...string) error {
+    wait, err := lockedFor(c.Context(), cfg, keys...)
+    if err != nil {
+        return fiber.NewError(fiber.StatusInternalServerError, err.Error())
+    }
+    if wait > 0 {
+        c.Set(fiber.HeaderRetryAfter, strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+        return fiber.NewError(fiber.StatusTooManyRequests, "Too many attempts, try again later")
+    }
+    return nil
+}
+
+// registerFailure records a failed attempt against the subject key (an
+// account or an MFA challenge) and the client address key, and writes an
+// audit event for any lockout it triggers.
+func registerFailure(c *fiber.Ctx, cfg *config.Config, subject, ip string) {
+    if subject != "" {
+        if lock, err := recordFailure(c.Context(), cfg, subject, cfg.LoginMaxAttempts); err == nil && lock > 0 {
+            parts := strings.SplitN(subject, ":", 2)
+            recordAudit(c, cfg, AuditEvent{
+                Action:     "auth.lockout",
+                TargetType: parts[0],
+                TargetID:   parts[1],
+                Metadata:   bson.M{"lockedForSeconds": int(lock.Seconds())},
+            })
+        }
+    }
+    if ip != "" {
+        if lock, err := recordFailure(c.Context(), cfg, ip, cfg.LoginMaxAttemptsPerIP); err == nil && lock > 0 {
+            recordAudit(c, cfg, AuditEvent{
+                Action:     "auth.lockout",
+                TargetType: "ip",
+                TargetID:   c.IP(),
+                Metadata:   bson.M{"lockedForSeconds": int(lock.Seconds()), "scope": strings.SplitN(ip, ":", 2)[0]},
+            })
+        }
+    }
+}
+
+// validatePassword enforces the registration password rules.
+func validatePassword(password, email string) error {
+    if len(password) < minPasswordLength {
+        return fiber.NewError(fiber.StatusBadRequest, "Password must be at least "+strconv.Itoa(minPasswordLength)+" characters")
+    }
+    var hasLetter, hasDigit bool
+    for _, r := range password {
+        switch {
+        case unicode.IsLetter(r):
+            hasLetter = true
+        case unicode.IsDigit(r):
+            hasDigit = true
+        }
+    }
+    if !hasLetter || !hasDigit {
+        return fiber.NewError(fiber.StatusBadRequest, "Password must contain both letters and numbers")
+    }
+    lower := strings.ToLower(password)
+    if commonPasswords[lower] {
+        return fiber.NewError(fiber.StatusBadRequest, "Password is too common")
+    }
+    if local := strings.SplitN(strings.ToLower(email), "@", 2)[0]; local != "" && strings.Contains(lower, local) {
+        return fiber.NewError(fiber.StatusBadRequest, "Password must not contain your email address")
+    }
+    return nil
+}
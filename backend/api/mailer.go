@@ -0,0 +1,81 @@
+package api
+
+import (
+    "context"
+    "fmt"
+    "mime"
+    "os"
+    "path/filepath"
+    "strings"
+    "sync"
+    "time"
+
+    "go.mongodb.org/mongo-driver/bson/primitive"
+    "formbuilder/backend/config"
+)
+
+type Message struct {
+    To      []string
+    Subject string
+    Text    string
+    HTML    string
+}
+
+// Mailer delivers email. The file sink below is the default; other
+// transports only need to implement Send.
+type Mailer interface {
+    Send(ctx context.Context, msg Message) error
+}
+
+var (
+    mailerMu sync.Mutex
+    _mailer  Mailer
+)
+
+func getMailer(cfg *config.Config) Mailer {
+    mailerMu.Lock()
+    defer mailerMu.Unlock()
+    if _mailer == nil {
+        _mailer = &fileMailer{dir: cfg.MailDir, from: cfg.MailFrom}
+    }
+    return _mailer
+}
+
+// SetMailer replaces the mailer, e.g. with an SMTP implementation.
+func SetMailer(m Mailer) {
+    mailerMu.Lock()
+    defer mailerMu.Unlock()
+    _mailer = m
+}
+
+// fileMailer writes each message as an .eml file, which is enough for local
+// development and for inspecting what would have been sent.
+type fileMailer struct {
+    dir  string
+    from string
+}
+
+func (m *fileMailer) Send(ctx context.Context, msg Message) error {
+    if err := os.MkdirAll(m.dir, 0o755); err != nil {
+        return err
+    }
+    boundary := "fb-" + primitive.NewObjectID().Hex()
+    var b strings.Builder
+    fmt.Fprintf(&b, "From: %s\r\n", m.from)
+    fmt.Fprintf(&b, "To: %s\r\n", strings.Join(msg.To, ", "))
+    fmt.Fprintf(&b, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", msg.Subject))
+    fmt.Fprintf(&b, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+    b.WriteString("MIME-Version: 1.0\r\n")
+    if msg.HTML == "" {
+        b.WriteString("Content-Type: text/plain; charset=utf-8\r\n\r\n")
+        b.WriteString(msg.Text)
+    } else {
+        fmt.Fprintf(&b, "Content-Type: multipart/alternative; boundary=%q\r\n\r\n", boundary)
+        fmt.Fprintf(&b, "--%s\r\nContent-Type: text/plain; charset=utf-8\r\n\r\n%s\r\n", boundary, msg.Text)
+        fmt.Fprintf(&b, "--%s\r\nContent-Type: text/html; charset=utf-8\r\n\r\n%s\r\n", boundary, msg.HTML)
+        fmt.Fprintf(&b, "--%s--\r\n", boundary)
+    }
+
+    name := fmt.Sprintf("%s-%s.eml", time.Now().Format("20060102T150405"), primitive.NewObjectID().Hex())
+    return os.WriteFile(filepath.Join(m.dir, name), []byte(b.String()), 0o600)
+}
@@ -0,0 +1,452 @@
+package api
+
+import (
+    "crypto/hmac"
+    "crypto/rand"
+    "crypto/sha1"
+    "crypto/sha256"
+    "crypto/subtle"
+    "encoding/base32"
+    "encoding/binary"
+    "encoding/hex"
+    "fmt"
+    "net/url"
+    "strings"
+    "time"
+
+    "github.com/gofiber/fiber/v2"
+    "go.mongodb.org/mongo-driver/bson"
+    "go.mongodb.org/mongo-driver/bson/primitive"
+    "golang.org/x/crypto/bcrypt"
+    "formbuilder/backend/config"
+)
+
+const (
+    mfaPendingPurpose = "mfa_pending"
+    mfaPendingTTL     = 5 * time.Minute
+
+    totpPeriod = 30
+    totpDigits = 6
+    totpSkew   = 1 // accept one step either side for clock drift
+
+    recoveryCodeCount = 10
+)
+
+var b32 = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+type MFACodeRequest struct {
+    Code string `json:"code"`
+}
+
+type MFAVerifyRequest struct {
+    MFAToken     string `json:"mfaToken"`
+    Code         string `json:"code"`
+    RecoveryCode string `json:"recoveryCode"`
+}
+
+type MFADisableRequest struct {
+    Password     string `json:"password"`
+    Code         string `json:"code"`
+    RecoveryCode string `json:"recoveryCode"`
+}
+
+func generateTOTPSecret() (string, error) {
+    buf := make([]byte, 20)
+    if _, err := rand.Read(buf); err != nil {
+        return "", err
+    }
+    return b32.EncodeToString(buf), nil
+}
+
+func otpauthURI(issuer, account, secret string) string {
+    label := url.PathEscape(issuer + ":" + account)
+    q := url.Values{}
+    q.Set("secret", secret)
+    q.Set("issuer", issuer)
+    q.Set("algorithm", "SHA1")
+    q.Set("digits", fmt.Sprint(totpDigits))
+    q.Set("period", fmt.Sprint(totpPeriod))
+    // Authenticator apps expect %20 rather than + in the issuer
+    return "otpauth://totp/" + label + "?" + strings.ReplaceAll(q.Encode(), "+", "%20")
+}
+
+// totpCode computes the RFC 6238 code for the given time step.
+func totpCode(secret string, step int64) (string, error) {
+    key, err := b32.DecodeString(strings.ToUpper(secret))
+    if err != nil {
+        return "", err
+    }
+    var msg [8]byte
+    binary.BigEndian.PutUint64(msg[:], uint64(step))
+    mac := hmac.New(sha1.New, key)
+    mac.Write(msg[:])
+    sum := mac.Sum(nil)
+    offset := sum[len(sum)-1] & 0x0f
+    bin := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+    return fmt.Sprintf("%0*d", totpDigits, bin%1000000), nil
+}
+
+// verifyTOTP returns the matched time step, or 0 if the code is invalid.
+// Steps at or before lastStep are rejected so a code cannot be replayed.
+func verifyTOTP(secret, code string, lastStep int64, now time.Time) int64 {
+    code = strings.ReplaceAll(strings.TrimSpace(code), " ", "")
+    if len(code) != totpDigits {
+        return 0
+    }
+    current := now.Unix() / totpPeriod
+    for i := -totpSkew; i <= totpSkew; i++ {
+        step := current + int64(i)
+        if step <= lastStep {
+            continue
+        }
+        expected, err := totpCode(secret, step)
+        if err != nil {
+            return 0
+        }
+        if subtle.ConstantTimeCompare([]byte(expected), []byte(code)) == 1 {
+            return step
+        }
+    }
+    return 0
+}
+
+// generateRecoveryCodes returns the plaintext codes shown once to the user
+// and the hashes stored on the user document.
+func generateRecoveryCodes() ([]string, []string, error) {
+    codes := make([]string, 0, recoveryCodeCount)
+    hashes := make([]string, 0, recoveryCodeCount)
+    for i := 0; i < recoveryCodeCount; i++ {
+        buf := make([]byte, 6)
+        if _, err := rand.Read(buf); err != nil {
+            return nil, nil, err
+        }
+        raw := strings.ToLower(b32.EncodeToString(buf))
+        code := raw[:5] + "-" + raw[5:10]
+        codes = append(codes, code)
+        hashes = append(hashes, hashRecoveryCode(code))
+    }
+    return codes, hashes, nil
+}
+
+func hashRecoveryCode(code string) string {
+    normalized := strings.ToLower(strings.ReplaceAll(strings.TrimSpace(code), " ", ""))
+    sum := sha256.Sum256([]byte(normalized))
+    return hex.EncodeToString(sum[:])
+}
+
+// checkSecondFactor accepts either a TOTP code or a one-time recovery code.
+// Successful TOTP use advances the replay guard; recovery codes are removed
+// atomically so concurrent requests cannot both consume the same code.
+func checkSecondFactor(c *fiber.Ctx, cfg *config.Config, user *User, code, recoveryCode string) bool {
+    if code != "" {
+        step := verifyTOTP(user.MFASecret, code, user.MFALastStep, time.Now())
+        if step == 0 {
+            return false
+        }
+        res, err := usersCol(cfg).UpdateOne(c.Context(),
+            bson.M{"_id": user.ID, "mfaLastStep": bson.M{"$not": bson.M{"$gte": step}}},
+            bson.M{"$set": bson.M{"mfaLastStep": step}})
+        return err == nil && res.ModifiedCount == 1
+    }
+    if recoveryCode != "" {
+        hash := hashRecoveryCode(recoveryCode)
+        res, err := usersCol(cfg).UpdateOne(c.Context(),
+            bson.M{"_id": user.ID, "recoveryCodes": hash},
+            bson.M{"$pull": bson.M{"recoveryCodes": hash}})
+        return err == nil && res.ModifiedCount == 1
+    }
+    return false
+}
+
+func MFAEnrollHandler(cfg *config.Config) fiber.Handler {
+    return func(c *fiber.Ctx) error {
+        user, err := currentUser(c, cfg)
+        if err != nil {
+            return err
+        }
+        if user.MFAEnabled {
+            return fiber.NewError(fiber.StatusConflict, "Two-factor authentication is already enabled")
+        }
+
+        secret, err := generateTOTPSecret()
+        if err != nil {
+            return fiber.NewError(fiber.StatusInternalServerError, "Failed to generate secret")
+        }
+        _, err = usersCol(cfg).UpdateByID(c.Context(), user.ID, bson.M{"$set": bson.M{"mfaPendingSecret": secret}})
+        if err != nil {
+            return fiber.NewError(fiber.StatusInternalServerError, err.Error())
+        }
+
+        return c.JSON(fiber.Map{
+            "secret":     secret,
+            "otpauthUri": otpauthURI(cfg.MFAIssuer, user.Email, secret),
+        })
+    }
+}
+
+func MFAActivateHandler(cfg *config.Config) fiber.Handler {
+    return func(c *fiber.Ctx) error {
+        var req MFACodeRequest
+        if err := c.BodyParser(&req); err != nil {
+            return fiber.NewError(fiber.StatusBadRequest, err.Error())
+        }
+        user, err := currentUser(c, cfg)
+        if err != nil {
+            return err
+        }
+        if user.MFAEnabled {
+            return fiber.NewError(fiber.StatusConflict, "Two-factor authentication is already enabled")
+        }
+        if user.MFAPendingSecret == "" {
+            return fiber.NewError(fiber.StatusBadRequest, "Start enrollment first")
+        }
+
+        step := verifyTOTP(user.MFAPendingSecret, req.Code, 0, time.Now())
+        if step == 0 {
+            return fiber.NewError(fiber.StatusUnauthorized, "Invalid verification code")
+        }
+
+        codes, hashes, err := generateRecoveryCodes()
+        if err != nil {
+            return fiber.NewError(fiber.StatusInternalServerError, "Failed to generate recovery codes")
+        }
+        _, err = usersCol(cfg).UpdateByID(c.Context(), user.ID, bson.M{
+            "$set": bson.M{
+                "mfaEnabled":    true,
+                "mfaSecret":     user.MFAPendingSecret,
+                "mfaLastStep":   step,
+                "recoveryCodes": hashes,
+            },
+            "$unset": bson.M{"mfaPendingSecret": ""},
+        })
+        if err != nil {
+            return fiber.NewError(fiber.StatusInternalServerError, err.Error())
+        }
+        recordAudit(c, cfg, AuditEvent{Action: "auth.mfa_enabled", TargetType: "user", TargetID: user.ID.Hex()})
+
+        // The caller just proved possession of the factor, so upgrade the session
+        token, err := generateJWT(sessionFromLocals(c, true), cfg.JWTSecret)
+        if err != nil {
+            return fiber.NewError(fiber.StatusInternalServerError, "Failed to generate token")
+        }
+
+        return c.JSON(fiber.Map{
+            "token":         token,
+            "recoveryCodes": codes,
+        })
+    }
+}
+
+func MFAVerifyHandler(cfg *config.Config) fiber.Handler {
+    return func(c *fiber.Ctx) error {
+        var req MFAVerifyRequest
+        if err := c.BodyParser(&req); err != nil {
+            return fiber.NewError(fiber.StatusBadRequest, err.Error())
+        }
+
+        claims, err := parseJWT(req.MFAToken, cfg.JWTSecret)
+        if err != nil || claims["purpose"] != mfaPendingPurpose {
+            return fiber.NewError(fiber.StatusUnauthorized, "Invalid or expired MFA token")
+        }
+        userID, _ := claims["user_id"].(string)
+        oid, err := primitive.ObjectIDFromHex(userID)
+        if err != nil {
+            return fiber.NewError(fiber.StatusUnauthorized, "Invalid or expired MFA token")
+        }
+
+        challenge := "mfa:" + userID
+        ipAttempts := ipKey("mfa", c.IP())
+        if err := checkLocked(c, cfg, challenge, ipAttempts); err != nil {
+            return err
+        }
+
+        var user User
+        if err := usersCol(cfg).FindOne(c.Context(), bson.M{"_id": oid}).Decode(&user); err != nil {
+            return fiber.NewError(fiber.StatusUnauthorized, "Invalid or expired MFA token")
+        }
+        if !user.MFAEnabled || !checkSecondFactor(c, cfg, &user, req.Code, req.RecoveryCode) {
+            registerFailure(c, cfg, challenge, ipAttempts)
+            recordAudit(c, cfg, AuditEvent{ActorID: userID, Action: "auth.mfa_failed", TargetType: "user", TargetID: userID})
+            return fiber.NewError(fiber.StatusUnauthorized, "Invalid verification code")
+        }
+        clearFailures(c.Context(), cfg, challenge, accountKey(user.Email))
+        if req.Code == "" {
+            recordAudit(c, cfg, AuditEvent{ActorID: userID, Action: "auth.recovery_code_used", TargetType: "user", TargetID: userID})
+        }
+        recordAudit(c, cfg, AuditEvent{ActorID: userID, Action: "auth.login", TargetType: "user", TargetID: userID, Metadata: bson.M{"mfa": true}})
+
+        token, err := issueSession(c, cfg, &user, true)
+        if err != nil {
+            return err
+        }
+
+        return c.JSON(fiber.Map{
+            "token": token,
+            "user":  user,
+        })
+    }
+}
+
+func MFARecoveryCodesHandler(cfg *config.Config) fiber.Handler {
+    return func(c *fiber.Ctx) error {
+        var req MFACodeRequest
+        if err := c.BodyParser(&req); err != nil {
+            return fiber.NewError(fiber.StatusBadRequest, err.Error())
+        }
+        user, err := currentUser(c, cfg)
+        if err != nil {
+            return err
+        }
+        if !user.MFAEnabled {
+            return fiber.NewError(fiber.StatusBadRequest, "Two-factor authentication is not enabled")
+        }
+        if !checkSecondFactor(c, cfg, user, req.Code, "") {
+            return fiber.NewError(fiber.StatusUnauthorized, "Invalid verification code")
+        }
+
+        codes, hashes, err := generateRecoveryCodes()
+        if err != nil {
+            return fiber.NewError(fiber.StatusInternalServerError, "Failed to generate recovery codes")
+        }
+        _, err = usersCol(cfg).UpdateByID(c.Context(), user.ID, bson.M{"$set": bson.M{"recoveryCodes": hashes}})
+        if err != nil {
+            return fiber.NewError(fiber.StatusInternalServerError, err.Error())
+        }
+        recordAudit(c, cfg, AuditEvent{Action: "auth.recovery_codes_regenerated", TargetType: "user", TargetID: user.ID.Hex()})
+        return c.JSON(fiber.Map{"recoveryCodes": codes})
+    }
+}
+
+func MFADisableHandler(cfg *config.Config) fiber.Handler {
+    return func(c *fiber.Ctx) error {
+        var req MFADisableRequest
+        if err := c.BodyParser(&req); err != nil {
+            return fiber.NewError(fiber.StatusBadRequest, err.Error())
+        }
+        user, err := currentUser(c, cfg)
+        if err != nil {
+            return err
+        }
+        if !user.MFAEnabled {
+            return fiber.NewError(fiber.StatusBadRequest, "Two-factor authentication is not enabled")
+        }
+        if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(req.Password)); err != nil {
+            return fiber.NewError(fiber.StatusUnauthorized, "Invalid credentials")
+        }
+        if !checkSecondFactor(c, cfg, user, req.Code, req.RecoveryCode) {
+            return fiber.NewError(fiber.StatusUnauthorized, "Invalid verification code")
+        }
+
+        _, err = usersCol(cfg).UpdateByID(c.Context(), user.ID, bson.M{
+            "$set":   bson.M{"mfaEnabled": false},
+            "$unset": bson.M{"mfaSecret": "", "mfaPendingSecret": "", "mfaLastStep": "", "recoveryCodes": ""},
+        })
+        if err != nil {
+            return fiber.NewError(fiber.StatusInternalServerError, err.Error())
+        }
+        recordAudit(c, cfg, AuditEvent{Action: "auth.mfa_disabled", TargetType: "user", TargetID: user.ID.Hex()})
+
+        token, err := generateJWT(sessionFromLocals(c, false), cfg.JWTSecret)
+        if err != nil {
+            return fiber.NewError(fiber.StatusInternalServerError, "Failed to generate token")
+        }
+        return c.JSON(fiber.Map{"token": token})
+    }
+}
+
+func formHasPII(f *Form) bool {
+    return len(piiFieldIDs(f)) > 0
+}
+
+func piiFieldIDs(f *Form) []string {
+    var ids []string
+    for _, field := range f.Fields {
+        if field.IsPII {
+            ids = append(ids, field.ID)
+        }
+    }
+    return ids
+}
+
+// isPIIAnswer reports whether a stored answer is treated as PII: it
+// answers a PII field or a field no longer on the form, which may have
+// been PII, or it is encrypted.
+func isPIIAnswer(f *Form, fieldID string, v interface{}) bool {
+    if _, encrypted := ciphertextKey(v); encrypted {
+        return true
+    }
+    field := fieldByID(f, fieldID)
+    return field == nil || field.IsPII
+}
+
+// responseHasPII reports whether any of a response's answers, current or
+// previous, is treated as PII.
+func responseHasPII(f *Form, r *Response) bool {
+    sets := []map[string]interface{}{r.Answers}
+    for _, e := range r.Edits {
+        sets = append(sets, e.Previous)
+    }
+    for _, answers := range sets {
+        for id, v := range answers {
+            if isPIIAnswer(f, id, v) {
+                return true
+            }
+        }
+    }
+    return false
+}
+
+// requireMFAForPII blocks access to forms with PII fields from sessions that
+// were not established with a second factor, when the server config or the
+// form's workspace requires it.
+func requireMFAForPII(c *fiber.Ctx, cfg *config.Config, f *Form) error {
+    if !formHasPII(f) {
+        return nil
+    }
+    if mfa, _ := c.Locals("mfa").(bool); mfa {
+        return nil
+    }
+    required := cfg.RequireMFAForPII
+    if !required {
+        var ws Workspace
+        if err := workspacesCol(cfg).FindOne(c.Context(), bson.M{"_id": f.WorkspaceID}).Decode(&ws); err == nil {
+            required = ws.Settings.RequireMFAForPII
+        }
+    }
+    if !required {
+        return nil
+    }
+    return fiber.NewError(fiber.StatusForbidden, "Two-factor authentication is required to access PII fields")
+}
+
+// canReadPII reports whether the caller may see the form's PII answers
+// unmasked: it needs pii:read and, where required, a session established
+// with a second factor.
+func canReadPII(c *fiber.Ctx, cfg *config.Config, f *Form) bool {
+    role, _ := c.Locals("role").(string)
+    return roleAllows(role, permPIIRead) && requireMFAForPII(c, cfg, f) == nil
+}
+
+// checkPIIChange stops callers without pii:export from changing which of a
+// form's existing fields are PII. Unmarking or removing a PII field would
+// otherwise lift its masking, the export gate and its encryption at rest.
+// New fields may be added as PII by anyone who can edit the form.
+func checkPIIChange(c *fiber.Ctx, cfg *config.Config, before, after *Form) error {
+    changed := false
+    for _, old := range before.Fields {
+        if field := fieldByID(after, old.ID); field == nil && old.IsPII || field != nil && field.IsPII != old.IsPII {
+            changed = true
+            break
+        }
+    }
+    if !changed {
+        return nil
+    }
+    if err := requirePermission(c, permPIIExport); err != nil {
+        return fiber.NewError(fiber.StatusForbidden, "Changing which fields are PII requires PII export access")
+    }
+    if formHasPII(before) {
+        return requireMFAForPII(c, cfg, before)
+    }
+    return requireMFAForPII(c, cfg, after)
+}
@@ -9,11 +9,39 @@ import (
 type Form struct {
     ID        primitive.ObjectID `bson:"_id,omitempty" json:"id"`
     Title     string             `bson:"title" json:"title"`
-    Status    string             `bson:"status" json:"status"` // "draft" or "published"
+    Status    string             `bson:"status" json:"status"` // draft, scheduled, published or closed
     Fields    []Field            `bson:"fields" json:"fields"`
     CreatedAt time.Time          `bson:"createdAt" json:"createdAt"`
     UpdatedAt time.Time          `bson:"updatedAt" json:"updatedAt"`
     OwnerID   string             `bson:"ownerId,omitempty" json:"ownerId,omitempty"`
+    WorkspaceID primitive.ObjectID `bson:"workspaceId,omitempty" json:"workspaceId,omitempty"`
+    Protection *SpamProtection   `bson:"protection,omitempty" json:"protection,omitempty"`
+    Tags      []string            `bson:"tags,omitempty" json:"tags,omitempty"`
+    // Set through POST /forms/:id/move; nil is the workspace root
+    FolderID  *primitive.ObjectID `bson:"folderId,omitempty" json:"folderId,omitempty"`
+
+    // Scheduling and quotas
+    OpensAt       *time.Time `bson:"opensAt,omitempty" json:"opensAt,omitempty"`
+    ClosesAt      *time.Time `bson:"closesAt,omitempty" json:"closesAt,omitempty"`
+    MaxResponses  int        `bson:"maxResponses,omitempty" json:"maxResponses,omitempty"` // 0 means unlimited
+    ClosedMessage string     `bson:"closedMessage,omitempty" json:"closedMessage,omitempty"`
+    // Maintained by SubmitResponseHandler with $inc; omitempty keeps form
+    // updates from overwriting it.
+    ResponseCount int        `bson:"responseCount,omitempty" json:"responseCount"`
+
+    // Who may respond and how often; see respondent.go
+    RespondentPolicy string `bson:"respondentPolicy,omitempty" json:"respondentPolicy,omitempty"`
+    // Minutes after submitting during which a respondent may edit; 0 disables edit links
+    EditWindowMinutes int `bson:"editWindowMinutes,omitempty" json:"editWindowMinutes,omitempty"`
+
+    Notifications *Notifications `bson:"notifications,omitempty" json:"notifications,omitempty"`
+    Rules         []Rule         `bson:"rules,omitempty" json:"rules,omitempty"`
+
+    // Lifecycle; see lifecycle.go. Trashed forms are hidden from every
+    // workspace query except the trash itself.
+    ArchivedAt *time.Time `bson:"archivedAt,omitempty" json:"archivedAt,omitempty"`
+    DeletedAt  *time.Time `bson:"deletedAt,omitempty" json:"deletedAt,omitempty"`
+    DeletedBy  string     `bson:"deletedBy,omitempty" json:"deletedBy,omitempty"`
 }
 
 type Field struct {
@@ -36,8 +64,44 @@ type ShowIf struct {
 type Response struct {
     ID        primitive.ObjectID     `bson:"_id,omitempty" json:"id"`
     FormID    primitive.ObjectID     `bson:"formId" json:"formId"`
+    WorkspaceID primitive.ObjectID   `bson:"workspaceId,omitempty" json:"-"`
+    SubmissionID string              `bson:"submissionId,omitempty" json:"submissionId,omitempty"` // client-generated idempotency key
+    Respondent  *Respondent          `bson:"respondent,omitempty" json:"respondent,omitempty"` // set under restrictive respondent policies
     Answers   map[string]interface{} `bson:"answers" json:"answers"`
     CreatedAt time.Time              `bson:"createdAt" json:"createdAt"`
+
+    // Respondent edits made through an edit link
+    UpdatedAt *time.Time     `bson:"updatedAt,omitempty" json:"updatedAt,omitempty"`
+    Edits     []ResponseEdit `bson:"edits,omitempty" json:"edits,omitempty"`
+    // Returned once from submit when the form allows edits; never stored
+    EditToken string `bson:"-" json:"editToken,omitempty"`
+
+    // Review workflow; rules may set these when the response arrives
+    Status       string   `bson:"status,omitempty" json:"status,omitempty"`
+    Tags         []string `bson:"tags,omitempty" json:"tags,omitempty"`
+    AssigneeID   string   `bson:"assigneeId,omitempty" json:"assigneeId,omitempty"`
+    MatchedRules []string `bson:"matchedRules,omitempty" json:"matchedRules,omitempty"`
+
+    // Free-text answers indexed for search; see search.go
+    SearchText string `bson:"searchText" json:"-"`
+
+    // Spam scoring; flagged responses are kept but left out of analytics
+    SpamScore   float64  `bson:"spamScore" json:"spamScore"`
+    Spam        bool     `bson:"spam" json:"spam"`
+    SpamSignals []string `bson:"spamSignals,omitempty" json:"spamSignals,omitempty"`
+}
+
+// PublicResponse is what respondents get back from submitting or editing
+// a response. Spam scoring and review state stay internal.
+type PublicResponse struct {
+    ID        primitive.ObjectID     `json:"id"`
+    CreatedAt time.Time              `json:"createdAt"`
+    Answers   map[string]interface{} `json:"answers"`
+    EditToken string                 `json:"editToken,omitempty"`
+}
+
+func publicResponse(r *Response) PublicResponse {
+    return PublicResponse{ID: r.ID, CreatedAt: r.CreatedAt, Answers: r.Answers, EditToken: r.EditToken}
 }
 
 type Analytics struct {
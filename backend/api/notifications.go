@@ -0,0 +1,346 @@
+package api
+
+import (
+    "bytes"
+    "context"
+    htmltemplate "html/template"
+    "log"
+    "strings"
+    "text/template"
+    "time"
+
+    "github.com/gofiber/fiber/v2"
+    "go.mongodb.org/mongo-driver/bson"
+    "go.mongodb.org/mongo-driver/bson/primitive"
+    "formbuilder/backend/config"
+)
+
+// Owner notification modes
+const (
+    notifyOff    = "off"
+    notifyEach   = "each"   // one email per response
+    notifyDigest = "digest" // one summary email per day
+)
+
+const (
+    redactedAnswer = "[hidden: personal data]"
+    digestInterval = 24 * time.Hour
+    mailQueueSize  = 256
+    mailWorkers    = 2
+    mailAttempts   = 3
+)
+
+// Notifications configures email sent when a form receives responses.
+// Subjects and bodies are Go text templates; see notificationData for the
+// fields they can use. Empty templates fall back to the defaults below.
+type Notifications struct {
+    OwnerMode  string   `bson:"ownerMode,omitempty" json:"ownerMode,omitempty"`
+    Recipients []string `bson:"recipients,omitempty" json:"recipients,omitempty"` // defaults to the form owner
+    OwnerSubject string `bson:"ownerSubject,omitempty" json:"ownerSubject,omitempty"`
+    OwnerBody    string `bson:"ownerBody,omitempty" json:"ownerBody,omitempty"`
+
+    // Receipts go to the respondent's verified email under the email and
+    // login policies. Addresses typed into an answer are never mailed, so
+    // forms cannot be used to send mail to arbitrary recipients.
+    Receipts       bool   `bson:"receipts,omitempty" json:"receipts,omitempty"`
+    ReceiptSubject string `bson:"receiptSubject,omitempty" json:"receiptSubject,omitempty"`
+    ReceiptBody    string `bson:"receiptBody,omitempty" json:"receiptBody,omitempty"`
+
+    LastDigestAt *time.Time `bson:"lastDigestAt,omitempty" json:"lastDigestAt,omitempty"`
+}
+
+type notificationAnswer struct {
+    Label string
+    Value string
+}
+
+// notificationData is what notification templates are executed with.
+type notificationData struct {
+    FormTitle   string
+    ResponseID  string
+    SubmittedAt string
+    Answers     []notificationAnswer
+    Link        string
+    // Digest only
+    Count int
+    Since string
+    // Rule emails only
+    RuleName string
+}
+
+const (
+    defaultOwnerSubject   = `New response to {{.FormTitle}}`
+    defaultOwnerBody      = "{{.FormTitle}} received a new response on {{.SubmittedAt}}.\n\n{{range .Answers}}{{.Label}}: {{.Value}}\n{{end}}\nView all responses: {{.Link}}\n"
+    defaultReceiptSubject = `Your response to {{.FormTitle}}`
+    defaultReceiptBody    = "Thanks for responding to {{.FormTitle}}. Here is a copy of your answers.\n\n{{range .Answers}}{{.Label}}: {{.Value}}\n{{end}}"
+    digestSubject         = `{{.Count}} new responses to {{.FormTitle}}`
+    digestBody            = "{{.FormTitle}} received {{.Count}} responses since {{.Since}}.\n\nView them: {{.Link}}\n"
+)
+
+// htmlLayout wraps a rendered text body for the HTML part. The text is
+// escaped, so templates cannot inject markup.
+var htmlLayout = htmltemplate.Must(htmltemplate.New("email").Parse(`<!DOCTYPE html>
+<html><body style="font-family: -apple-system, Segoe UI, sans-serif; color: #1f2937; max-width: 600px">
+{{range .Paragraphs}}<p>{{range $i, $l := .}}{{if $i}}<br>{{end}}{{$l}}{{end}}</p>
+{{end}}</body></html>`))
+
+func validateNotifications(n *Notifications) error {
+    if n == nil {
+        return nil
+    }
+    switch n.OwnerMode {
+    case "", notifyOff, notifyEach, notifyDigest:
+    default:
+        return fiber.NewError(fiber.StatusBadRequest, "invalid notifications.ownerMode: "+n.OwnerMode)
+    }
+    for _, r := range n.Recipients {
+        if !strings.Contains(r, "@") {
+            return fiber.NewError(fiber.StatusBadRequest, "invalid notification recipient: "+r)
+        }
+    }
+    for name, tmpl := range map[string]string{
+        "ownerSubject": n.OwnerSubject, "ownerBody": n.OwnerBody,
+        "receiptSubject": n.ReceiptSubject, "receiptBody": n.ReceiptBody,
+    } {
+        if _, err := template.New(name).Parse(tmpl); err != nil {
+            return fiber.NewError(fiber.StatusBadRequest, "invalid notifications."+name+": "+err.Error())
+        }
+    }
+    return nil
+}
+
+func renderTemplate(tmpl, fallback string, data notificationData) (string, error) {
+    if tmpl == "" {
+        tmpl = fallback
+    }
+    t, err := template.New("notification").Parse(tmpl)
+    if err != nil {
+        return "", err
+    }
+    var b bytes.Buffer
+    if err := t.Execute(&b, data); err != nil {
+        return "", err
+    }
+    return b.String(), nil
+}
+
+// renderMessage builds a message with matching text and HTML parts.
+func renderMessage(to []string, subject, body string, subjectFallback, bodyFallback string, data notificationData) (Message, error) {
+    s, err := renderTemplate(subject, subjectFallback, data)
+    if err != nil {
+        return Message{}, err
+    }
+    text, err := renderTemplate(body, bodyFallback, data)
+    if err != nil {
+        return Message{}, err
+    }
+    var paragraphs [][]string
+    for _, p := range strings.Split(strings.TrimSpace(text), "\n\n") {
+        paragraphs = append(paragraphs, strings.Split(p, "\n"))
+    }
+    var html bytes.Buffer
+    if err := htmlLayout.Execute(&html, map[string]interface{}{"Paragraphs": paragraphs}); err != nil {
+        return Message{}, err
+    }
+    return Message{To: to, Subject: strings.TrimSpace(s), Text: text, HTML: html.String()}, nil
+}
+
+// answerText formats an answer for people to read.
+func answerText(v interface{}) string {
+    switch t := v.(type) {
+    case []interface{}:
+        parts := make([]string, len(t))
+        for i, x := range t {
+            parts[i] = answerText(x)
+        }
+        return strings.Join(parts, ", ")
+    case primitive.A:
+        return answerText([]interface{}(t))
+    }
+    return toString(v)
+}
+
+// notificationAnswers lists visible answers in form order. PII answers are
+// replaced unless includePII is set.
+func notificationAnswers(f *Form, r *Response, includePII bool) []notificationAnswer {
+    var out []notificationAnswer
+    for _, field := range f.Fields {
+        v, ok := r.Answers[field.ID]
+        if !ok {
+            continue
+        }
+        value := answerText(v)
+        if field.IsPII && !includePII {
+            value = redactedAnswer
+        }
+        out = append(out, notificationAnswer{Label: field.Label, Value: value})
+    }
+    return out
+}
+
+func ownerRecipients(ctx context.Context, cfg *config.Config, f *Form) []string {
+    if n := f.Notifications; n != nil && len(n.Recipients) > 0 {
+        return n.Recipients
+    }
+    oid, err := primitive.ObjectIDFromHex(f.OwnerID)
+    if err != nil {
+        return nil
+    }
+    var owner User
+    if err := usersCol(cfg).FindOne(ctx, bson.M{"_id": oid}).Decode(&owner); err != nil {
+        return nil
+    }
+    return []string{owner.Email}
+}
+
+// receiptAddress returns the respondent's verified email, if any.
+func receiptAddress(r *Response) string {
+    if r.Respondent != nil {
+        return r.Respondent.Email
+    }
+    return ""
+}
+
+// notifyResponse queues the owner notification and respondent receipt for
+// a new response. Owner emails never contain PII answers; they are read in
+// the app where access is checked and audited. Receipts go only to verified
+// addresses, so they can carry the respondent's own PII answers.
+func notifyResponse(ctx context.Context, cfg *config.Config, f *Form, r *Response) {
+    n := f.Notifications
+    if n == nil {
+        return
+    }
+    data := notificationData{
+        FormTitle:   f.Title,
+        ResponseID:  r.ID.Hex(),
+        SubmittedAt: r.CreatedAt.UTC().Format("Jan 2, 2006 15:04 MST"),
+        Link:        cfg.AppURL + "/forms/" + f.ID.Hex() + "/analytics",
+    }
+
+    if n.OwnerMode == notifyEach {
+        if to := ownerRecipients(ctx, cfg, f); len(to) > 0 {
+            data.Answers = notificationAnswers(f, r, false)
+            msg, err := renderMessage(to, n.OwnerSubject, n.OwnerBody, defaultOwnerSubject, defaultOwnerBody, data)
+            if err != nil {
+                log.Printf("notifications: form=%s: %v", f.ID.Hex(), err)
+            } else {
+                queueMail(msg)
+            }
+        }
+    }
+
+    if n.Receipts {
+        if to := receiptAddress(r); to != "" {
+            data.Answers = notificationAnswers(f, r, true)
+            data.Link = ""
+            msg, err := renderMessage([]string{to}, n.ReceiptSubject, n.ReceiptBody, defaultReceiptSubject, defaultReceiptBody, data)
+            if err != nil {
+                log.Printf("notifications: form=%s: %v", f.ID.Hex(), err)
+            } else {
+                queueMail(msg)
+            }
+        }
+    }
+}
+
+var mailQueue = make(chan Message, mailQueueSize)
+
+// queueMail hands a message to the mail workers so the request that
+// triggered it does not wait on the mail transport. When the queue is full
+// the message is dropped and logged, so a slow transport cannot pile up
+// work without bound.
+func queueMail(msg Message) {
+    select {
+    case mailQueue <- msg:
+    default:
+        log.Printf("notifications: mail queue full, dropping %q to %v", msg.Subject, msg.To)
+    }
+}
+
+func startMailWorkers(cfg *config.Config) {
+    for i := 0; i < mailWorkers; i++ {
+        go func() {
+            for msg := range mailQueue {
+                deliverMail(cfg, msg)
+            }
+        }()
+    }
+}
+
+func deliverMail(cfg *config.Config, msg Message) {
+    var err error
+    for attempt := 1; attempt <= mailAttempts; attempt++ {
+        ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+        err = getMailer(cfg).Send(ctx, msg)
+        cancel()
+        if err == nil {
+            return
+        }
+        time.Sleep(time.Duration(attempt) * time.Second)
+    }
+    log.Printf("notifications: giving up on %q to %v: %v", msg.Subject, msg.To, err)
+}
+
+// sendDigests emails a daily summary for forms in digest mode. Each form is
+// claimed by moving lastDigestAt forward first, so the digest goes out once
+// even with several instances running.
+func sendDigests(ctx context.Context, cfg *config.Config) {
+    now := time.Now()
+    cutoff := now.Add(-digestInterval)
+    cur, err := formsCol(cfg).Find(ctx, bson.M{
+        "notifications.ownerMode": notifyDigest,
+        "$or": bson.A{
+            bson.M{"notifications.lastDigestAt": bson.M{"$exists": false}},
+            bson.M{"notifications.lastDigestAt": bson.M{"$lte": cutoff}},
+        },
+    })
+    if err != nil {
+        log.Printf("digest: %v", err)
+        return
+    }
+    var forms []Form
+    if err := cur.All(ctx, &forms); err != nil {
+        log.Printf("digest: %v", err)
+        return
+    }
+    for i := range forms {
+        f := &forms[i]
+        claim := bson.M{"_id": f.ID, "notifications.lastDigestAt": bson.M{"$exists": false}}
+        since := cutoff
+        if last := f.Notifications.LastDigestAt; last != nil {
+            claim["notifications.lastDigestAt"] = *last
+            since = *last
+        }
+        res, err := formsCol(cfg).UpdateOne(ctx, claim, bson.M{"$set": bson.M{"notifications.lastDigestAt": now}})
+        if err != nil || res.ModifiedCount == 0 {
+            continue
+        }
+        count, err := responsesCol(cfg).CountDocuments(ctx, bson.M{
+            "formId": f.ID, "spam": bson.M{"$ne": true},
+            "createdAt": bson.M{"$gte": since, "$lt": now},
+        })
+        if err != nil {
+            log.Printf("digest: form=%s: %v", f.ID.Hex(), err)
+            continue
+        }
+        if count == 0 {
+            continue
+        }
+        to := ownerRecipients(ctx, cfg, f)
+        if len(to) == 0 {
+            continue
+        }
+        data := notificationData{
+            FormTitle: f.Title,
+            Count:     int(count),
+            Since:     since.UTC().Format("Jan 2, 2006 15:04 MST"),
+            Link:      cfg.AppURL + "/forms/" + f.ID.Hex() + "/analytics",
+        }
+        msg, err := renderMessage(to, "", "", digestSubject, digestBody, data)
+        if err != nil {
+            log.Printf("digest: form=%s: %v", f.ID.Hex(), err)
+            continue
+        }
+        queueMail(msg)
+    }
+}
@@ -0,0 +1,46 @@
+package api
+
+import (
+    "context"
+    "strconv"
+    "time"
+
+    "go.mongodb.org/mongo-driver/bson"
+    "go.mongodb.org/mongo-driver/mongo"
+    "go.mongodb.org/mongo-driver/mongo/options"
+    "formbuilder/backend/config"
+)
+
+type rateWindow struct {
+    ID        string    `bson:"_id"`
+    Count     int       `bson:"count"`
+    ExpiresAt time.Time `bson:"expiresAt"`
+}
+
+func rateLimitsCol(cfg *config.Config) *mongo.Collection {
+    return mongoClient(cfg).Database(cfg.MongoDB).Collection("rate_limits")
+}
+
+// allowRequest counts a hit for key in the current fixed window and reports
+// whether it is within limit. When it is not, the second value is the time
+// until the window resets.
+func allowRequest(ctx context.Context, cfg *config.Config, key string, limit int, window time.Duration) (bool, time.Duration, error) {
+    now := time.Now()
+    start := now.Truncate(window)
+    var w rateWindow
+    err := rateLimitsCol(cfg).FindOneAndUpdate(ctx,
+        bson.M{"_id": key + ":" + strconv.FormatInt(start.Unix(), 10)},
+        bson.M{
+            "$inc":         bson.M{"count": 1},
+            "$setOnInsert": bson.M{"expiresAt": start.Add(window)},
+        },
+        options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After),
+    ).Decode(&w)
+    if err != nil {
+        return false, 0, err
+    }
+    if w.Count > limit {
+        return false, start.Add(window).Sub(now), nil
+    }
+    return true, 0, nil
+}
@@ -0,0 +1,233 @@
+package api
+
+import (
+    "crypto/rand"
+    "encoding/hex"
+    "fmt"
+    "math"
+    "net/url"
+    "strconv"
+    "strings"
+    "time"
+
+    "github.com/gofiber/fiber/v2"
+    "github.com/golang-jwt/jwt/v5"
+    "go.mongodb.org/mongo-driver/bson"
+    "go.mongodb.org/mongo-driver/bson/primitive"
+    "go.mongodb.org/mongo-driver/mongo"
+    "formbuilder/backend/config"
+)
+
+// Respondent policies control who may answer a form and how often.
+const (
+    policyAnonymous = "anonymous" // default: unlimited, unidentified
+    policyBrowser   = "browser"   // one response per browser, via a signed cookie
+    policyEmail     = "email"     // one response per email verified by magic link
+    policyLogin     = "login"     // logged-in users only, one response each
+)
+
+const (
+    respondentCookie         = "fb_respondent"
+    respondentBrowserPurpose = "respondent_browser"
+    respondentEmailPurpose   = "respondent_email"
+    respondentBrowserTTL     = 365 * 24 * time.Hour
+    respondentEmailTTL       = 24 * time.Hour
+
+    // Magic link requests per IP per hour
+    respondentVerifyLimit = 5
+)
+
+// Respondent identifies who submitted a response under a restrictive
+// policy. Key is unique per form, which is what rejects duplicates.
+type Respondent struct {
+    Type   string `bson:"type" json:"type"`
+    Key    string `bson:"key" json:"-"`
+    Email  string `bson:"email,omitempty" json:"email,omitempty"`
+    UserID string `bson:"userId,omitempty" json:"userId,omitempty"`
+}
+
+type RespondentVerifyRequest struct {
+    Email string `json:"email"`
+}
+
+func validateRespondentPolicy(f *Form) error {
+    switch f.RespondentPolicy {
+    case "", policyAnonymous, policyBrowser, policyEmail, policyLogin:
+        return nil
+    }
+    return fiber.NewError(fiber.StatusBadRequest, "invalid respondentPolicy: "+f.RespondentPolicy)
+}
+
+func signRespondentToken(claims jwt.MapClaims, secret string) (string, error) {
+    return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(secret))
+}
+
+func issueBrowserToken(secret string) (string, error) {
+    buf := make([]byte, 16)
+    if _, err := rand.Read(buf); err != nil {
+        return "", err
+    }
+    return signRespondentToken(jwt.MapClaims{
+        "rid":     hex.EncodeToString(buf),
+        "purpose": respondentBrowserPurpose,
+        "exp":     time.Now().Add(respondentBrowserTTL).Unix(),
+    }, secret)
+}
+
+// respondentToken reads the respondent's signed token from the cookie, or
+// from the X-Respondent-Token header for clients that cannot send
+// cross-origin cookies.
+func respondentToken(c *fiber.Ctx) string {
+    if t := c.Get("X-Respondent-Token"); t != "" {
+        return t
+    }
+    return c.Cookies(respondentCookie)
+}
+
+// ensureBrowserToken returns the browser's respondent token, issuing and
+// setting the cookie on first visit.
+func ensureBrowserToken(c *fiber.Ctx, cfg *config.Config) (string, error) {
+    if t := c.Cookies(respondentCookie); t != "" {
+        if claims, err := parseJWT(t, cfg.JWTSecret); err == nil && claims["purpose"] == respondentBrowserPurpose {
+            return t, nil
+        }
+    }
+    t, err := issueBrowserToken(cfg.JWTSecret)
+    if err != nil {
+        return "", err
+    }
+    c.Cookie(&fiber.Cookie{
+        Name:     respondentCookie,
+        Value:    t,
+        Path:     "/",
+        Expires:  time.Now().Add(respondentBrowserTTL),
+        HTTPOnly: true,
+        Secure:   c.Protocol() == "https",
+        SameSite: fiber.CookieSameSiteLaxMode,
+    })
+    return t, nil
+}
+
+// identifyRespondent enforces the form's respondent policy and returns the
+// identity to record, or nil for anonymous forms.
+func identifyRespondent(c *fiber.Ctx, cfg *config.Config, f *Form) (*Respondent, error) {
+    switch f.RespondentPolicy {
+    case policyBrowser:
+        claims, err := parseJWT(respondentToken(c), cfg.JWTSecret)
+        rid, _ := claims["rid"].(string)
+        if err != nil || claims["purpose"] != respondentBrowserPurpose || rid == "" {
+            return nil, fiber.NewError(fiber.StatusForbidden, "Reload the form and try again")
+        }
+        return &Respondent{Type: policyBrowser, Key: "browser:" + rid}, nil
+
+    case policyEmail:
+        claims, err := parseJWT(respondentToken(c), cfg.JWTSecret)
+        email, _ := claims["email"].(string)
+        if err != nil || claims["purpose"] != respondentEmailPurpose || claims["form_id"] != f.ID.Hex() || email == "" {
+            return nil, fiber.NewError(fiber.StatusForbidden, "Verify your email address to respond to this form")
+        }
+        email = strings.ToLower(email)
+        return &Respondent{Type: policyEmail, Key: "email:" + email, Email: email}, nil
+
+    case policyLogin:
+        token := strings.TrimPrefix(c.Get("Authorization"), "Bearer ")
+        if token == "" {
+            return nil, fiber.NewError(fiber.StatusUnauthorized, "Log in to respond to this form")
+        }
+        // The submit route has no session of its own; the respondent's
+        // token identifies them without setting the request's workspace
+        // or role
+        claims, _, err := verifySession(c.Context(), cfg, token)
+        if err != nil {
+            return nil, err
+        }
+        uid, _ := primitive.ObjectIDFromHex(claims["user_id"].(string))
+        var user User
+        if err := usersCol(cfg).FindOne(c.Context(), bson.M{"_id": uid}).Decode(&user); err != nil {
+            return nil, fiber.NewError(fiber.StatusUnauthorized, "User not found")
+        }
+        return &Respondent{Type: policyLogin, Key: "user:" + user.ID.Hex(), UserID: user.ID.Hex(), Email: user.Email}, nil
+    }
+    return nil, nil
+}
+
+// checkAlreadyResponded is a fast path that spares a repeat respondent the
+// spam checks and quota; the unique index is what actually enforces it.
+func checkAlreadyResponded(c *fiber.Ctx, cfg *config.Config, formID primitive.ObjectID, r *Respondent) error {
+    if r == nil {
+        return nil
+    }
+    n, err := responsesCol(cfg).CountDocuments(c.Context(), bson.M{"formId": formID, "respondent.key": r.Key})
+    if err != nil {
+        return fiber.NewError(fiber.StatusInternalServerError, err.Error())
+    }
+    if n > 0 {
+        return errAlreadyResponded
+    }
+    return nil
+}
+
+var errAlreadyResponded = fiber.NewError(fiber.StatusConflict, "You have already responded to this form")
+
+// RequestRespondentVerificationHandler emails a magic link that lets the
+// holder of an address respond once to an email-verified form.
+func RequestRespondentVerificationHandler(cfg *config.Config) fiber.Handler {
+    return func(c *fiber.Ctx) error {
+        oid, err := primitive.ObjectIDFromHex(c.Params("id"))
+        if err != nil {
+            return fiber.NewError(fiber.StatusBadRequest, "invalid id")
+        }
+        var f Form
+        if err := formsCol(cfg).FindOne(c.Context(), bson.M{"_id": oid, "deletedAt": nil}).Decode(&f); err != nil {
+            if err == mongo.ErrNoDocuments {
+                return fiber.NewError(fiber.StatusNotFound, "form not found")
+            }
+            return fiber.NewError(fiber.StatusInternalServerError, err.Error())
+        }
+        if f.RespondentPolicy != policyEmail {
+            return fiber.NewError(fiber.StatusBadRequest, "This form does not use email verification")
+        }
+        if err := checkFormOpen(&f); err != nil {
+            return err
+        }
+
+        var req RespondentVerifyRequest
+        if err := c.BodyParser(&req); err != nil {
+            return fiber.NewError(fiber.StatusBadRequest, "Invalid request body")
+        }
+        email := strings.ToLower(strings.TrimSpace(req.Email))
+        if !strings.Contains(email, "@") {
+            return fiber.NewError(fiber.StatusBadRequest, "A valid email is required")
+        }
+
+        ok, retry, err := allowRequest(c.Context(), cfg, ipKey("respondent_verify", c.IP()), respondentVerifyLimit, time.Hour)
+        if err != nil {
+            return fiber.NewError(fiber.StatusInternalServerError, err.Error())
+        }
+        if !ok {
+            c.Set(fiber.HeaderRetryAfter, strconv.Itoa(int(math.Ceil(retry.Seconds()))))
+            return fiber.NewError(fiber.StatusTooManyRequests, "Too many verification requests, try again later")
+        }
+
+        token, err := signRespondentToken(jwt.MapClaims{
+            "form_id": f.ID.Hex(),
+            "email":   email,
+            "purpose": respondentEmailPurpose,
+            "exp":     time.Now().Add(respondentEmailTTL).Unix(),
+        }, cfg.JWTSecret)
+        if err != nil {
+            return fiber.NewError(fiber.StatusInternalServerError, err.Error())
+        }
+        link := cfg.AppURL + "/forms/" + f.ID.Hex() + "/share?respondent=" + url.QueryEscape(token)
+        err = getMailer(cfg).Send(c.Context(), Message{
+            To:      []string{email},
+            Subject: "Your link to respond to " + f.Title,
+            Text: fmt.Sprintf("Open this link to respond to %q:\n\n%s\n\nThe link expires in 24 hours. If you did not request it, ignore this email.\n",
+                f.Title, link),
+        })
+        if err != nil {
+            return fiber.NewError(fiber.StatusInternalServerError, "Failed to send verification email")
+        }
+        return c.Status(fiber.StatusAccepted).JSON(fiber.Map{"sent": true})
+    }
+}
@@ -0,0 +1,195 @@
+package api
+
+import (
+    "time"
+
+    "github.com/gofiber/fiber/v2"
+    "github.com/golang-jwt/jwt/v5"
+    "go.mongodb.org/mongo-driver/bson"
+    "go.mongodb.org/mongo-driver/bson/primitive"
+    "go.mongodb.org/mongo-driver/mongo"
+    "formbuilder/backend/config"
+)
+
+const responseEditPurpose = "response_edit"
+
+// ResponseEdit keeps the answers a response had before an edit.
+type ResponseEdit struct {
+    EditedAt time.Time              `bson:"editedAt" json:"editedAt"`
+    Previous map[string]interface{} `bson:"previous" json:"previous"`
+}
+
+type EditResponseRequest struct {
+    Answers map[string]interface{} `json:"answers"`
+}
+
+// editWindow is how long after submitting a respondent may edit, or zero
+// when the form does not allow edits.
+func editWindow(f *Form) time.Duration {
+    return time.Duration(f.EditWindowMinutes) * time.Minute
+}
+
+func validateEditWindow(f *Form) error {
+    if f.EditWindowMinutes < 0 {
+        return fiber.NewError(fiber.StatusBadRequest, "editWindowMinutes must not be negative")
+    }
+    return nil
+}
+
+// issueEditToken signs a token that lets the holder edit one response until
+// the form's edit window ends.
+func issueEditToken(f *Form, r *Response, secret string) (string, error) {
+    return jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+        "form_id":     f.ID.Hex(),
+        "response_id": r.ID.Hex(),
+        "purpose":     responseEditPurpose,
+        "exp":         r.CreatedAt.Add(editWindow(f)).Unix(),
+    }).SignedString([]byte(secret))
+}
+
+func editToken(c *fiber.Ctx) string {
+    if t := c.Get("X-Edit-Token"); t != "" {
+        return t
+    }
+    return c.Query("token")
+}
+
+// editableResponse loads the form and response named by the route if the
+// request carries a valid edit token for them and the window is still open.
+// The window is rechecked against the form so shortening or disabling it
+// applies to tokens already issued.
+func editableResponse(c *fiber.Ctx, cfg *config.Config) (*Form, *Response, error) {
+    claims, err := parseJWT(editToken(c), cfg.JWTSecret)
+    if err != nil || claims["purpose"] != responseEditPurpose ||
+        claims["form_id"] != c.Params("id") || claims["response_id"] != c.Params("responseId") {
+        return nil, nil, fiber.NewError(fiber.StatusUnauthorized, "Invalid or expired edit link")
+    }
+    formOID, err := primitive.ObjectIDFromHex(c.Params("id"))
+    if err != nil {
+        return nil, nil, fiber.NewError(fiber.StatusBadRequest, "invalid id")
+    }
+    respOID, err := primitive.ObjectIDFromHex(c.Params("responseId"))
+    if err != nil {
+        return nil, nil, fiber.NewError(fiber.StatusBadRequest, "invalid response id")
+    }
+
+    var f Form
+    if err := formsCol(cfg).FindOne(c.Context(), bson.M{"_id": formOID, "deletedAt": nil}).Decode(&f); err != nil {
+        if err == mongo.ErrNoDocuments {
+            return nil, nil, fiber.NewError(fiber.StatusNotFound, "form not found")
+        }
+        return nil, nil, fiber.NewError(fiber.StatusInternalServerError, err.Error())
+    }
+    var r Response
+    if err := responsesCol(cfg).FindOne(c.Context(), bson.M{"_id": respOID, "formId": formOID}).Decode(&r); err != nil {
+        if err == mongo.ErrNoDocuments {
+            return nil, nil, fiber.NewError(fiber.StatusNotFound, "response not found")
+        }
+        return nil, nil, fiber.NewError(fiber.StatusInternalServerError, err.Error())
+    }
+
+    // Closed forms stay editable so the last respondent can still fix a typo
+    if f.Status != statusPublished && f.Status != statusClosed {
+        return nil, nil, fiber.NewError(fiber.StatusForbidden, "This form is not accepting edits")
+    }
+    if editWindow(&f) <= 0 || time.Since(r.CreatedAt) > editWindow(&f) {
+        return nil, nil, fiber.NewError(fiber.StatusForbidden, "The edit window for this response has ended")
+    }
+    return &f, &r, nil
+}
+
+// GetEditableResponseHandler returns the form and current answers for a
+// respondent's edit link.
+func GetEditableResponseHandler(cfg *config.Config) fiber.Handler {
+    return func(c *fiber.Ctx) error {
+        f, r, err := editableResponse(c, cfg)
+        if err != nil {
+            return err
+        }
+        keys, err := loadFormKeys(c.Context(), cfg, f.ID)
+        if err != nil {
+            return fiber.NewError(fiber.StatusInternalServerError, err.Error())
+        }
+        return c.JSON(fiber.Map{
+            "form": fiber.Map{
+                "id":     f.ID,
+                "title":  f.Title,
+                "status": f.Status,
+                "fields": f.Fields,
+            },
+            "response": fiber.Map{
+                "id":        r.ID,
+                "answers":   keys.decryptAnswers(r.Answers),
+                "createdAt": r.CreatedAt,
+            },
+            "editableUntil": r.CreatedAt.Add(editWindow(f)),
+        })
+    }
+}
+
+// UpdateResponseHandler replaces a response's answers. The previous answers
+// are appended to the response's edit history.
+func UpdateResponseHandler(cfg *config.Config) fiber.Handler {
+    return func(c *fiber.Ctx) error {
+        f, r, err := editableResponse(c, cfg)
+        if err != nil {
+            return err
+        }
+        var req EditResponseRequest
+        if err := c.BodyParser(&req); err != nil {
+            return fiber.NewError(fiber.StatusBadRequest, err.Error())
+        }
+        if req.Answers == nil {
+            req.Answers = map[string]interface{}{}
+        }
+        popHoneypot(f, req.Answers)
+        if err := validateSubmission(f, req.Answers); err != nil {
+            return fiber.NewError(fiber.StatusBadRequest, err.Error())
+        }
+
+        keys, err := encryptionKeys(c.Context(), cfg, f)
+        if err != nil {
+            return fiber.NewError(fiber.StatusInternalServerError, err.Error())
+        }
+        stored, err := keys.encryptAnswers(f, req.Answers)
+        if err != nil {
+            return fiber.NewError(fiber.StatusInternalServerError, err.Error())
+        }
+
+        // The previous answers go into the history as stored. Matching on
+        // the previous updatedAt makes concurrent edits from two tabs fail
+        // instead of silently dropping one from the history.
+        filter := bson.M{"_id": r.ID}
+        if r.UpdatedAt == nil {
+            filter["updatedAt"] = bson.M{"$exists": false}
+        } else {
+            filter["updatedAt"] = *r.UpdatedAt
+        }
+        now := time.Now()
+        res, err := responsesCol(cfg).UpdateOne(c.Context(), filter, bson.M{
+            "$set":  bson.M{"answers": stored, "updatedAt": now, "searchText": responseSearchText(f, req.Answers)},
+            "$push": bson.M{"edits": ResponseEdit{EditedAt: now, Previous: r.Answers}},
+        })
+        if err != nil {
+            return fiber.NewError(fiber.StatusInternalServerError, err.Error())
+        }
+        if res.MatchedCount == 0 {
+            return fiber.NewError(fiber.StatusConflict, "The response was changed by another request; reload and try again")
+        }
+
+        r.Edits = append(r.Edits, ResponseEdit{EditedAt: now, Previous: r.Answers})
+        r.Answers = req.Answers
+        r.UpdatedAt = &now
+        r = keys.decryptResponse(r)
+
+        ev := AuditEvent{Action: "response.edited", TargetType: "response", TargetID: r.ID.Hex(), FormID: f.ID.Hex(), WorkspaceID: f.WorkspaceID.Hex()}
+        recordAudit(c, cfg, ev)
+        if !r.Spam {
+            BroadcastResponseUpdated(f, r)
+            enqueueWebhookEvent(c.Context(), cfg, f, eventResponseUpdated, r)
+        }
+        // Rules may have tagged or assigned the response; respondents only
+        // see their answers
+        return c.JSON(publicResponse(r))
+    }
+}
@@ -0,0 +1,494 @@
+package api
+
+import (
+    "encoding/base64"
+    "encoding/json"
+    "regexp"
+    "strconv"
+    "strings"
+    "time"
+
+    "github.com/gofiber/fiber/v2"
+    "go.mongodb.org/mongo-driver/bson"
+    "go.mongodb.org/mongo-driver/bson/primitive"
+    "go.mongodb.org/mongo-driver/mongo/options"
+    "formbuilder/backend/config"
+)
+
+const (
+    responsesDefaultLimit = 50
+    responsesMaxLimit     = 200
+)
+
+// answerFilterPattern matches answer filter query keys:
+// answers.<fieldId> (equals) or answers.<fieldId>[op].
+var answerFilterPattern = regexp.MustCompile(`^answers\.([^\[\]]+)(?:\[(eq|contains|gt|gte|lt|lte)\])?$`)
+
+// responseMeta is projected alongside the selected answers when the caller
+// asks for a subset of fields.
+var responseMeta = []string{"formId", "submissionId", "createdAt", "updatedAt", "status", "tags", "assigneeId", "matchedRules", "spam", "spamScore", "spamSignals", "respondent"}
+
+// listCursor is the opaque pagination cursor: the sort value and id of the
+// last item on the previous page.
+type listCursor struct {
+    Value interface{}        `json:"v"`
+    ID    primitive.ObjectID `json:"id"`
+}
+
+// responseQuery is a parsed GET /responses request.
+type responseQuery struct {
+    filter  bson.M
+    sortKey string
+    desc    bool
+    limit   int
+    fields  []string
+}
+
+func fieldByID(f *Form, id string) *Field {
+    for i := range f.Fields {
+        if f.Fields[i].ID == id {
+            return &f.Fields[i]
+        }
+    }
+    return nil
+}
+
+// answerFilterValue converts a query value to the type stored for the field,
+// so ratings compare as numbers.
+func answerFilterValue(field *Field, raw string) (interface{}, error) {
+    if field.Type == "rating" {
+        n, err := strconv.ParseFloat(raw, 64)
+        if err != nil {
+            return nil, fiber.NewError(fiber.StatusBadRequest, "answer filter for "+field.ID+" must be a number")
+        }
+        return n, nil
+    }
+    return raw, nil
+}
+
+func parseTime(param, v string) (time.Time, error) {
+    t, err := time.Parse(time.RFC3339, v)
+    if err != nil {
+        return t, fiber.NewError(fiber.StatusBadRequest, "invalid "+param+": expected RFC3339")
+    }
+    return t, nil
+}
+
+// parseResponseQuery builds the Mongo query for a response listing:
+//   status, assignee (user ID, "me" or "none"), tags (comma-separated, all
+//   must match), since/until (RFC3339 on createdAt), spam (false, true, all),
+//   answers.<fieldId>=v, answers.<fieldId>[contains|gt|gte|lt|lte]=v,
+//   sort (createdAt or answers.<fieldId>, "-" prefix for descending),
+//   fields (comma-separated field IDs to return), limit, cursor.
+// Filtering on PII fields requires pii:read, since a filter would otherwise
+// reveal the hidden values one guess at a time, and is not possible at all
+// once PII answers are encrypted at rest.
+func parseResponseQuery(c *fiber.Ctx, cfg *config.Config, f *Form) (*responseQuery, error) {
+    role, _ := c.Locals("role").(string)
+    canPII := roleAllows(role, permPIIRead)
+    q := &responseQuery{filter: bson.M{"formId": f.ID}, sortKey: "createdAt", desc: true}
+    var and bson.A
+
+    switch c.Query("spam", "false") {
+    case "false":
+        q.filter["spam"] = bson.M{"$ne": true}
+    case "true":
+        q.filter["spam"] = true
+    case "all":
+    default:
+        return nil, fiber.NewError(fiber.StatusBadRequest, "spam must be true, false or all")
+    }
+
+    if v := c.Query("status"); v != "" {
+        if !validResponseStatus(v) {
+            return nil, fiber.NewError(fiber.StatusBadRequest, "invalid status")
+        }
+        // Responses from before the review workflow have no status
+        if v == responseNew {
+            q.filter["status"] = bson.M{"$in": bson.A{responseNew, nil}}
+        } else {
+            q.filter["status"] = v
+        }
+    }
+    switch v := c.Query("assignee"); v {
+    case "":
+    case "me":
+        q.filter["assigneeId"] = c.Locals("userID").(string)
+    case "none":
+        q.filter["assigneeId"] = bson.M{"$in": bson.A{"", nil}}
+    default:
+        q.filter["assigneeId"] = v
+    }
+    // "tag" is the single-tag form kept from the review queue
+    tags := c.Query("tags", c.Query("tag"))
+    if tags != "" {
+        if list, _ := cleanTags(strings.Split(tags, ",")); len(list) > 0 {
+            q.filter["tags"] = bson.M{"$all": list}
+        }
+    }
+
+    created := bson.M{}
+    for param, op := range map[string]string{"since": "$gte", "until": "$lt"} {
+        if v := c.Query(param); v != "" {
+            t, err := parseTime(param, v)
+            if err != nil {
+                return nil, err
+            }
+            created[op] = t
+        }
+    }
+    if len(created) > 0 {
+        q.filter["createdAt"] = created
+    }
+
+    var filterErr error
+    c.Context().QueryArgs().VisitAll(func(k, v []byte) {
+        if filterErr != nil {
+            return
+        }
+        m := answerFilterPattern.FindStringSubmatch(string(k))
+        if m == nil {
+            return
+        }
+        field := fieldByID(f, m[1])
+        if field == nil {
+            filterErr = fiber.NewError(fiber.StatusBadRequest, "unknown field "+m[1])
+            return
+        }
+        if field.IsPII && !canPII {
+            filterErr = fiber.NewError(fiber.StatusForbidden, "Filtering on PII fields requires PII access")
+            return
+        }
+        if field.IsPII && encryptionEnabled(cfg) {
+            filterErr = fiber.NewError(fiber.StatusBadRequest, "PII answers are encrypted and cannot be filtered on")
+            return
+        }
+        key := "answers." + field.ID
+        op := m[2]
+        if op == "contains" {
+            and = append(and, bson.M{key: bson.M{"$regex": regexp.QuoteMeta(string(v)), "$options": "i"}})
+            return
+        }
+        val, err := answerFilterValue(field, string(v))
+        if err != nil {
+            filterErr = err
+            return
+        }
+        if op == "" || op == "eq" {
+            // Matches an element for multi-select answers
+            and = append(and, bson.M{key: val})
+        } else {
+            and = append(and, bson.M{key: bson.M{"$" + op: val}})
+        }
+    })
+    if filterErr != nil {
+        return nil, filterErr
+    }
+
+    if s := c.Query("sort"); s != "" {
+        q.desc = strings.HasPrefix(s, "-")
+        key := strings.TrimPrefix(s, "-")
+        if key != "createdAt" {
+            id := strings.TrimPrefix(key, "answers.")
+            field := fieldByID(f, id)
+            if id == key || field == nil {
+                return nil, fiber.NewError(fiber.StatusBadRequest, "sort must be createdAt or answers.<fieldId>")
+            }
+            if field.Type == "multi_select" {
+                return nil, fiber.NewError(fiber.StatusBadRequest, "cannot sort by a multi-select field")
+            }
+            if field.IsPII && !canPII {
+                return nil, fiber.NewError(fiber.StatusForbidden, "Sorting by PII fields requires PII access")
+            }
+            if field.IsPII && encryptionEnabled(cfg) {
+                return nil, fiber.NewError(fiber.StatusBadRequest, "PII answers are encrypted and cannot be sorted by")
+            }
+        }
+        q.sortKey = key
+    }
+
+    if v := c.Query("cursor"); v != "" {
+        cur, err := decodeCursor(v, q.sortKey == "createdAt")
+        if err != nil {
+            return nil, err
+        }
+        and = append(and, cursorFilter(q.sortKey, q.desc, cur))
+    }
+    if len(and) > 0 {
+        q.filter["$and"] = and
+    }
+
+    if v := c.Query("fields"); v != "" {
+        for _, id := range strings.Split(v, ",") {
+            id = strings.TrimSpace(id)
+            if fieldByID(f, id) == nil {
+                return nil, fiber.NewError(fiber.StatusBadRequest, "unknown field "+id)
+            }
+            q.fields = append(q.fields, id)
+        }
+    }
+
+    q.limit = c.QueryInt("limit", responsesDefaultLimit)
+    if q.limit <= 0 || q.limit > responsesMaxLimit {
+        q.limit = responsesDefaultLimit
+    }
+    return q, nil
+}
+
+func encodeCursor(cur listCursor) string {
+    b, _ := json.Marshal(cur)
+    return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// decodeCursor parses a cursor; timeKey says the sort value is a timestamp,
+// which round-trips through JSON as a string.
+func decodeCursor(s string, timeKey bool) (listCursor, error) {
+    var cur listCursor
+    b, err := base64.RawURLEncoding.DecodeString(s)
+    if err != nil || json.Unmarshal(b, &cur) != nil || cur.ID.IsZero() {
+        return cur, fiber.NewError(fiber.StatusBadRequest, "invalid cursor")
+    }
+    if str, ok := cur.Value.(string); ok && timeKey {
+        if t, err := time.Parse(time.RFC3339Nano, str); err == nil {
+            cur.Value = t
+        }
+    }
+    return cur, nil
+}
+
+// cursorFilter selects the documents after the cursor in (key, _id) order.
+// Missing values sort lowest, as they do in Mongo, so they come first
+// ascending and last descending.
+func cursorFilter(key string, desc bool, cur listCursor) bson.M {
+    cmp, idCmp := "$gt", "$gt"
+    if desc {
+        cmp, idCmp = "$lt", "$lt"
+    }
+    if cur.Value == nil {
+        if desc {
+            return bson.M{key: nil, "_id": bson.M{idCmp: cur.ID}}
+        }
+        return bson.M{"$or": bson.A{
+            bson.M{key: nil, "_id": bson.M{idCmp: cur.ID}},
+            bson.M{key: bson.M{"$ne": nil}},
+        }}
+    }
+    or := bson.A{
+        bson.M{key: bson.M{cmp: cur.Value}},
+        bson.M{key: cur.Value, "_id": bson.M{idCmp: cur.ID}},
+    }
+    if desc {
+        or = append(or, bson.M{key: nil})
+    }
+    return bson.M{"$or": or}
+}
+
+func sortValue(r *Response, key string) interface{} {
+    if key == "createdAt" {
+        return r.CreatedAt
+    }
+    return r.Answers[strings.TrimPrefix(key, "answers.")]
+}
+
+// ListResponsesHandler lists a form's responses; see parseResponseQuery for
+// the supported parameters. The response carries nextCursor while more
+// results remain.
+func ListResponsesHandler(cfg *config.Config) fiber.Handler {
+    return func(c *fiber.Ctx) error {
+        if err := requirePermission(c, permResponsesRead); err != nil {
+            return err
+        }
+        f, err := formFromParam(c, cfg)
+        if err != nil {
+            return err
+        }
+        q, err := parseResponseQuery(c, cfg, f)
+        if err != nil {
+            return err
+        }
+        canPII, err := checkPIIView(c, cfg, f)
+        if err != nil {
+            return err
+        }
+        dir := 1
+        if q.desc {
+            dir = -1
+        }
+        opts := options.Find().
+            SetSort(bson.D{{Key: q.sortKey, Value: dir}, {Key: "_id", Value: dir}}).
+            SetLimit(int64(q.limit))
+        if len(q.fields) > 0 {
+            proj := bson.M{}
+            for _, k := range responseMeta {
+                proj[k] = 1
+            }
+            for _, id := range q.fields {
+                proj["answers."+id] = 1
+            }
+            // The sort value is needed to build the next cursor
+            proj[q.sortKey] = 1
+            opts.SetProjection(proj)
+        }
+
+        cur, err := storeFor(c, cfg).FindResponses(c.Context(), q.filter, opts)
+        if err != nil {
+            return fiber.NewError(fiber.StatusInternalServerError, err.Error())
+        }
+        defer cur.Close(c.Context())
+        responses := []Response{}
+        if err := cur.All(c.Context(), &responses); err != nil {
+            return fiber.NewError(fiber.StatusInternalServerError, err.Error())
+        }
+
+        resp := fiber.Map{}
+        if len(responses) == q.limit {
+            last := &responses[len(responses)-1]
+            resp["nextCursor"] = encodeCursor(listCursor{Value: sortValue(last, q.sortKey), ID: last.ID})
+        }
+        keys, err := loadFormKeys(c.Context(), cfg, f.ID)
+        if err != nil {
+            return fiber.NewError(fiber.StatusInternalServerError, err.Error())
+        }
+        viewed := 0
+        for i := range responses {
+            if len(q.fields) > 0 && q.sortKey != "createdAt" && !containsString(q.fields, strings.TrimPrefix(q.sortKey, "answers.")) {
+                delete(responses[i].Answers, strings.TrimPrefix(q.sortKey, "answers."))
+            }
+            if responseHasPII(f, &responses[i]) {
+                viewed++
+            }
+            responses[i] = *maskResponse(keys, f, &responses[i], canPII)
+        }
+        if canPII && viewed > 0 {
+            ev := formAudit("pii.viewed", f)
+            ev.FormID = f.ID.Hex()
+            ev.Metadata = bson.M{"responses": viewed, "query": string(c.Request().URI().QueryString())}
+            recordAudit(c, cfg, ev)
+        }
+        resp["responses"] = responses
+        return c.JSON(resp)
+    }
+}
+
+func containsString(list []string, v string) bool {
+    for _, x := range list {
+        if x == v {
+            return true
+        }
+    }
+    return false
+}
+
+func GetResponseHandler(cfg *config.Config) fiber.Handler {
+    return func(c *fiber.Ctx) error {
+        if err := requirePermission(c, permResponsesRead); err != nil {
+            return err
+        }
+        f, err := formFromParam(c, cfg)
+        if err != nil {
+            return err
+        }
+        canPII, err := checkPIIView(c, cfg, f)
+        if err != nil {
+            return err
+        }
+        r, err := responseFromParam(c, cfg, f)
+        if err != nil {
+            return err
+        }
+        keys, err := loadFormKeys(c.Context(), cfg, f.ID)
+        if err != nil {
+            return fiber.NewError(fiber.StatusInternalServerError, err.Error())
+        }
+        if canPII && responseHasPII(f, r) {
+            recordAudit(c, cfg, AuditEvent{Action: "pii.viewed", TargetType: "response", TargetID: r.ID.Hex(), FormID: f.ID.Hex()})
+        }
+        return c.JSON(maskResponse(keys, f, r, canPII))
+    }
+}
+
+// DeleteResponseHandler permanently deletes a response and its notes. A
+// counted response gives its slot back to the form's quota.
+func DeleteResponseHandler(cfg *config.Config) fiber.Handler {
+    return func(c *fiber.Ctx) error {
+        if err := requirePermission(c, permResponsesDelete); err != nil {
+            return err
+        }
+        f, err := formFromParam(c, cfg)
+        if err != nil {
+            return err
+        }
+        r, err := responseFromParam(c, cfg, f)
+        if err != nil {
+            return err
+        }
+        res, err := responsesCol(cfg).DeleteOne(c.Context(), bson.M{"_id": r.ID, "workspaceId": f.WorkspaceID})
+        if err != nil {
+            return fiber.NewError(fiber.StatusInternalServerError, err.Error())
+        }
+        if res.DeletedCount == 0 {
+            return fiber.NewError(fiber.StatusNotFound, "response not found")
+        }
+        if _, err := notesCol(cfg).DeleteMany(c.Context(), bson.M{"responseId": r.ID}); err != nil {
+            return fiber.NewError(fiber.StatusInternalServerError, err.Error())
+        }
+        if !r.Spam {
+            releaseResponseSlot(c, cfg, f)
+        }
+        recordAudit(c, cfg, AuditEvent{Action: "response.deleted", TargetType: "response", TargetID: r.ID.Hex(), FormID: f.ID.Hex()})
+        broadcast(f.ID.Hex(), "response_deleted", fiber.Map{"responseId": r.ID})
+        return c.SendStatus(fiber.StatusNoContent)
+    }
+}
+
+
+// maskResponse hides PII answers, including those in the edit history,
+// unless canPII, and decrypts the answers the caller may see.
+func maskResponse(keys *formKeys, f *Form, r *Response, canPII bool) *Response {
+    if canPII {
+        return keys.decryptResponse(r)
+    }
+    return keys.decryptResponse(hidePII(f, r))
+}
+
+// checkPIIView reports whether the caller will see the form's PII answers
+// unmasked. Callers with pii:read must meet the MFA requirement for PII
+// before any are returned.
+func checkPIIView(c *fiber.Ctx, cfg *config.Config, f *Form) (bool, error) {
+    role, _ := c.Locals("role").(string)
+    if !roleAllows(role, permPIIRead) {
+        return false, nil
+    }
+    if err := requireMFAForPII(c, cfg, f); err != nil {
+        return false, err
+    }
+    return true, nil
+}
+
+// hidePII returns a copy of r with its PII answers (see isPIIAnswer) and,
+// on forms with PII fields, the respondent's email masked.
+func hidePII(f *Form, r *Response) *Response {
+    masked := *r
+    masked.Answers = maskAnswers(f, r.Answers)
+    masked.Edits = make([]ResponseEdit, len(r.Edits))
+    for i, e := range r.Edits {
+        masked.Edits[i] = ResponseEdit{EditedAt: e.EditedAt, Previous: maskAnswers(f, e.Previous)}
+    }
+    if masked.Respondent != nil && formHasPII(f) {
+        resp := *masked.Respondent
+        resp.Email = ""
+        masked.Respondent = &resp
+    }
+    return &masked
+}
+
+func maskAnswers(f *Form, answers map[string]interface{}) map[string]interface{} {
+    out := make(map[string]interface{}, len(answers))
+    for k, v := range answers {
+        if isPIIAnswer(f, k, v) {
+            v = redactedAnswer
+        }
+        out[k] = v
+    }
+    return out
+}
@@ -0,0 +1,314 @@
+package api
+
+import (
+    "context"
+    "fmt"
+    "regexp"
+    "strings"
+    "time"
+
+    "github.com/gofiber/fiber/v2"
+    "go.mongodb.org/mongo-driver/bson"
+    "go.mongodb.org/mongo-driver/bson/primitive"
+    "go.mongodb.org/mongo-driver/mongo"
+    "go.mongodb.org/mongo-driver/mongo/options"
+    "formbuilder/backend/config"
+)
+
+// Note is an internal comment on a response, visible only to workspace
+// members. Mentions holds the user IDs of members @-mentioned by email.
+type Note struct {
+    ID          primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+    ResponseID  primitive.ObjectID `bson:"responseId" json:"responseId"`
+    FormID      primitive.ObjectID `bson:"formId" json:"formId"`
+    WorkspaceID primitive.ObjectID `bson:"workspaceId" json:"-"`
+    AuthorID    string             `bson:"authorId" json:"authorId"`
+    Body        string             `bson:"body" json:"body"`
+    Mentions    []string           `bson:"mentions,omitempty" json:"mentions,omitempty"`
+    CreatedAt   time.Time          `bson:"createdAt" json:"createdAt"`
+}
+
+// ReviewRequest changes a response's review state. Nil fields are left as
+// they are; an empty AssigneeID unassigns.
+type ReviewRequest struct {
+    Status     *string  `json:"status"`
+    AssigneeID *string  `json:"assigneeId"`
+    AddTags    []string `json:"addTags"`
+    RemoveTags []string `json:"removeTags"`
+}
+
+type NoteRequest struct {
+    Body string `json:"body"`
+}
+
+const (
+    maxNoteLength = 10000
+    maxTagLength  = 50
+)
+
+var mentionPattern = regexp.MustCompile(`@([A-Za-z0-9._%+\-]+@[A-Za-z0-9.\-]+\.[A-Za-z]+)`)
+
+func notesCol(cfg *config.Config) *mongo.Collection {
+    return mongoClient(cfg).Database(cfg.MongoDB).Collection("response_notes")
+}
+
+// responseFromParam loads the :responseId response on an already scoped form.
+func responseFromParam(c *fiber.Ctx, cfg *config.Config, f *Form) (*Response, error) {
+    oid, err := primitive.ObjectIDFromHex(c.Params("responseId"))
+    if err != nil {
+        return nil, fiber.NewError(fiber.StatusBadRequest, "invalid response id")
+    }
+    r, err := storeFor(c, cfg).FindResponse(c.Context(), f.ID, oid)
+    if err != nil {
+        if err == mongo.ErrNoDocuments {
+            return nil, fiber.NewError(fiber.StatusNotFound, "response not found")
+        }
+        return nil, fiber.NewError(fiber.StatusInternalServerError, err.Error())
+    }
+    return r, nil
+}
+
+func cleanTags(tags []string) ([]string, error) {
+    var out []string
+    for _, t := range tags {
+        t = strings.TrimSpace(t)
+        if t == "" {
+            continue
+        }
+        if len(t) > maxTagLength {
+            return nil, fiber.NewError(fiber.StatusBadRequest, "tags must be at most 50 characters")
+        }
+        out = append(out, t)
+    }
+    return out, nil
+}
+
+// reviewState is what review broadcasts carry.
+func reviewState(r *Response) fiber.Map {
+    return fiber.Map{"responseId": r.ID, "status": r.Status, "assigneeId": r.AssigneeID, "tags": r.Tags}
+}
+
+// UpdateReviewHandler sets a response's status, assignee and tags.
+func UpdateReviewHandler(cfg *config.Config) fiber.Handler {
+    return func(c *fiber.Ctx) error {
+        if err := requirePermission(c, permResponsesReview); err != nil {
+            return err
+        }
+        f, err := formFromParam(c, cfg)
+        if err != nil {
+            return err
+        }
+        before, err := responseFromParam(c, cfg, f)
+        if err != nil {
+            return err
+        }
+        var req ReviewRequest
+        if err := c.BodyParser(&req); err != nil {
+            return fiber.NewError(fiber.StatusBadRequest, "Invalid request body")
+        }
+
+        set := bson.M{}
+        var changes []string
+        if req.Status != nil {
+            if !validResponseStatus(*req.Status) {
+                return fiber.NewError(fiber.StatusBadRequest, "status must be one of "+strings.Join(responseStatuses, ", "))
+            }
+            set["status"] = *req.Status
+            changes = append(changes, fmt.Sprintf("status: %s -> %s", before.Status, *req.Status))
+        }
+        if req.AssigneeID != nil {
+            if *req.AssigneeID != "" {
+                if _, err := findMembership(c.Context(), cfg, f.WorkspaceID, *req.AssigneeID); err != nil {
+                    return fiber.NewError(fiber.StatusBadRequest, "Assignee must be a member of this workspace")
+                }
+            }
+            set["assigneeId"] = *req.AssigneeID
+            changes = append(changes, fmt.Sprintf("assignee: %q -> %q", before.AssigneeID, *req.AssigneeID))
+        }
+        add, err := cleanTags(req.AddTags)
+        if err != nil {
+            return err
+        }
+        remove, err := cleanTags(req.RemoveTags)
+        if err != nil {
+            return err
+        }
+        if len(set) == 0 && len(add) == 0 && len(remove) == 0 {
+            return fiber.NewError(fiber.StatusBadRequest, "Nothing to update")
+        }
+
+        // $addToSet and $pull cannot touch the same field in one update
+        store := storeFor(c, cfg)
+        update := bson.M{}
+        if len(set) > 0 {
+            update["$set"] = set
+        }
+        if len(add) > 0 {
+            update["$addToSet"] = bson.M{"tags": bson.M{"$each": add}}
+            changes = append(changes, "tags added: "+strings.Join(add, ", "))
+        }
+        if len(update) > 0 {
+            if _, err := store.UpdateResponse(c.Context(), before.ID, update); err != nil {
+                return fiber.NewError(fiber.StatusInternalServerError, err.Error())
+            }
+        }
+        if len(remove) > 0 {
+            if _, err := store.UpdateResponse(c.Context(), before.ID, bson.M{"$pull": bson.M{"tags": bson.M{"$in": remove}}}); err != nil {
+                return fiber.NewError(fiber.StatusInternalServerError, err.Error())
+            }
+            changes = append(changes, "tags removed: "+strings.Join(remove, ", "))
+        }
+
+        r, err := store.FindResponse(c.Context(), f.ID, before.ID)
+        if err != nil {
+            return fiber.NewError(fiber.StatusInternalServerError, err.Error())
+        }
+        recordAudit(c, cfg, AuditEvent{Action: "response.reviewed", TargetType: "response", TargetID: r.ID.Hex(), FormID: f.ID.Hex(), Changes: changes})
+        broadcast(f.ID.Hex(), "response_reviewed", reviewState(r))
+        if req.AssigneeID != nil && *req.AssigneeID != "" && *req.AssigneeID != before.AssigneeID {
+            notifyMembers(c.Context(), cfg, []string{*req.AssigneeID}, "A response to "+f.Title+" was assigned to you",
+                "You were assigned a response to "+f.Title+".\n\nOpen it: "+responseLink(cfg, f, r)+"\n")
+        }
+        keys, err := loadFormKeys(c.Context(), cfg, f.ID)
+        if err != nil {
+            return fiber.NewError(fiber.StatusInternalServerError, err.Error())
+        }
+        // The review itself needs no PII access; answers stay masked
+        // unless the caller could also read them unmasked
+        return c.JSON(maskResponse(keys, f, r, canReadPII(c, cfg, f)))
+    }
+}
+
+func responseLink(cfg *config.Config, f *Form, r *Response) string {
+    return cfg.AppURL + "/forms/" + f.ID.Hex() + "/responses/" + r.ID.Hex()
+}
+
+// notifyMembers emails workspace members by user ID.
+func notifyMembers(ctx context.Context, cfg *config.Config, userIDs []string, subject, text string) {
+    var oids []primitive.ObjectID
+    for _, id := range userIDs {
+        if oid, err := primitive.ObjectIDFromHex(id); err == nil {
+            oids = append(oids, oid)
+        }
+    }
+    if len(oids) == 0 {
+        return
+    }
+    cur, err := usersCol(cfg).Find(ctx, bson.M{"_id": bson.M{"$in": oids}}, options.Find().SetProjection(bson.M{"email": 1}))
+    if err != nil {
+        return
+    }
+    var users []User
+    if err := cur.All(ctx, &users); err != nil {
+        return
+    }
+    for _, u := range users {
+        queueMail(Message{To: []string{u.Email}, Subject: subject, Text: text})
+    }
+}
+
+// resolveMentions maps @email mentions in a note to workspace members.
+// Addresses that do not belong to a member are ignored.
+func resolveMentions(ctx context.Context, cfg *config.Config, workspaceID primitive.ObjectID, body string) []string {
+    var emails []string
+    for _, m := range mentionPattern.FindAllStringSubmatch(body, -1) {
+        emails = append(emails, strings.ToLower(m[1]))
+    }
+    if len(emails) == 0 {
+        return nil
+    }
+    cur, err := usersCol(cfg).Find(ctx, bson.M{"email": bson.M{"$in": emails}}, options.Find().SetProjection(bson.M{"_id": 1}))
+    if err != nil {
+        return nil
+    }
+    var users []User
+    if err := cur.All(ctx, &users); err != nil {
+        return nil
+    }
+    var ids []string
+    for _, u := range users {
+        if _, err := findMembership(ctx, cfg, workspaceID, u.ID.Hex()); err == nil {
+            ids = appendUnique(ids, u.ID.Hex())
+        }
+    }
+    return ids
+}
+
+func ListNotesHandler(cfg *config.Config) fiber.Handler {
+    return func(c *fiber.Ctx) error {
+        if err := requirePermission(c, permResponsesRead); err != nil {
+            return err
+        }
+        f, err := formFromParam(c, cfg)
+        if err != nil {
+            return err
+        }
+        r, err := responseFromParam(c, cfg, f)
+        if err != nil {
+            return err
+        }
+        cur, err := notesCol(cfg).Find(c.Context(), bson.M{"responseId": r.ID, "workspaceId": f.WorkspaceID},
+            options.Find().SetSort(bson.M{"createdAt": 1}))
+        if err != nil {
+            return fiber.NewError(fiber.StatusInternalServerError, err.Error())
+        }
+        notes := []Note{}
+        if err := cur.All(c.Context(), &notes); err != nil {
+            return fiber.NewError(fiber.StatusInternalServerError, err.Error())
+        }
+        return c.JSON(notes)
+    }
+}
+
+// AddNoteHandler adds an internal note. Mentioned members are emailed a
+// link to the response; the note itself is not included in the email.
+func AddNoteHandler(cfg *config.Config) fiber.Handler {
+    return func(c *fiber.Ctx) error {
+        if err := requirePermission(c, permResponsesReview); err != nil {
+            return err
+        }
+        f, err := formFromParam(c, cfg)
+        if err != nil {
+            return err
+        }
+        r, err := responseFromParam(c, cfg, f)
+        if err != nil {
+            return err
+        }
+        var req NoteRequest
+        if err := c.BodyParser(&req); err != nil {
+            return fiber.NewError(fiber.StatusBadRequest, "Invalid request body")
+        }
+        body := strings.TrimSpace(req.Body)
+        if body == "" || len(body) > maxNoteLength {
+            return fiber.NewError(fiber.StatusBadRequest, "Note must be between 1 and 10000 characters")
+        }
+
+        userID := c.Locals("userID").(string)
+        n := Note{
+            ID:          primitive.NewObjectID(),
+            ResponseID:  r.ID,
+            FormID:      f.ID,
+            WorkspaceID: f.WorkspaceID,
+            AuthorID:    userID,
+            Body:        body,
+            Mentions:    resolveMentions(c.Context(), cfg, f.WorkspaceID, body),
+            CreatedAt:   time.Now(),
+        }
+        if _, err := notesCol(cfg).InsertOne(c.Context(), n); err != nil {
+            return fiber.NewError(fiber.StatusInternalServerError, err.Error())
+        }
+        recordAudit(c, cfg, AuditEvent{Action: "response.note_added", TargetType: "response", TargetID: r.ID.Hex(), FormID: f.ID.Hex()})
+        broadcast(f.ID.Hex(), "note_added", n)
+
+        var others []string
+        for _, id := range n.Mentions {
+            if id != userID {
+                others = append(others, id)
+            }
+        }
+        notifyMembers(c.Context(), cfg, others, "You were mentioned on a response to "+f.Title,
+            "You were mentioned in a note on a response to "+f.Title+".\n\nOpen it: "+responseLink(cfg, f, r)+"\n")
+        return c.Status(fiber.StatusCreated).JSON(n)
+    }
+}
@@ -11,16 +11,101 @@ func AttachRoutes(app *fiber.App, cfg *config.Config) {
     // Auth routes
     api.Post("/auth/register", RegisterHandler(cfg))
     api.Post("/auth/login", LoginHandler(cfg))
+    api.Post("/auth/activate", ActivateHandler(cfg))
+    api.Post("/auth/mfa/verify", MFAVerifyHandler(cfg))
+    api.Post("/account/email/confirm", ConfirmEmailHandler(cfg))
 
     // Public routes (no auth required)
+    api.Get("/forms/:id/public", GetPublicFormHandler(cfg))
     api.Post("/forms/:id/responses", SubmitResponseHandler(cfg))
+    api.Post("/forms/:id/respondent/verify", RequestRespondentVerificationHandler(cfg))
+    api.Get("/forms/:id/responses/:responseId/edit", GetEditableResponseHandler(cfg))
+    api.Put("/forms/:id/responses/:responseId/edit", UpdateResponseHandler(cfg))
 
     // Protected routes
     protected := api.Group("", AuthMiddleware(cfg))
+    protected.Post("/auth/mfa/enroll", MFAEnrollHandler(cfg))
+    protected.Post("/auth/mfa/activate", MFAActivateHandler(cfg))
+    protected.Post("/auth/mfa/recovery-codes", MFARecoveryCodesHandler(cfg))
+    protected.Post("/auth/mfa/disable", MFADisableHandler(cfg))
+
+    protected.Get("/account", GetAccountHandler(cfg))
+    protected.Put("/account", UpdateProfileHandler(cfg))
+    protected.Post("/account/password", ChangePasswordHandler(cfg))
+    protected.Post("/account/email/verify", VerifyEmailHandler(cfg))
+    protected.Delete("/account", DeleteAccountHandler(cfg))
+    protected.Get("/account/export", ExportAccountHandler(cfg))
+
+    protected.Get("/workspaces", ListWorkspacesHandler(cfg))
+    protected.Post("/workspaces", CreateWorkspaceHandler(cfg))
+    protected.Get("/workspaces/:id", GetWorkspaceHandler(cfg))
+    protected.Put("/workspaces/:id", UpdateWorkspaceHandler(cfg))
+    protected.Post("/workspaces/:id/switch", SwitchWorkspaceHandler(cfg))
+    protected.Get("/workspaces/:id/members", ListMembersHandler(cfg))
+    protected.Post("/workspaces/:id/members", AddMemberHandler(cfg))
+    protected.Put("/workspaces/:id/members/:userId", UpdateMemberHandler(cfg))
+    protected.Delete("/workspaces/:id/members/:userId", RemoveMemberHandler(cfg))
+
+    protected.Get("/search", SearchHandler(cfg))
     protected.Get("/forms", GetAllFormsHandler(cfg))
     protected.Post("/forms", CreateFormHandler(cfg))
+    protected.Post("/forms/import", ImportFormHandler(cfg))
     protected.Get("/forms/:id", GetFormHandler(cfg))
     protected.Put("/forms/:id", UpdateFormHandler(cfg))
+    protected.Delete("/forms/:id", TrashFormHandler(cfg))
+    protected.Get("/forms/:id/definition", ExportDefinitionHandler(cfg))
+    protected.Put("/forms/:id/definition", ImportDefinitionHandler(cfg))
+    protected.Post("/forms/:id/move", MoveFormHandler(cfg))
+    protected.Post("/forms/:id/duplicate", DuplicateFormHandler(cfg))
+    protected.Post("/forms/:id/archive", ArchiveFormHandler(cfg))
+    protected.Post("/forms/:id/unarchive", UnarchiveFormHandler(cfg))
+    protected.Post("/forms/:id/template", SaveTemplateHandler(cfg))
+    protected.Get("/templates", ListTemplatesHandler(cfg))
+    protected.Get("/templates/categories", ListTemplateCategoriesHandler(cfg))
+    protected.Get("/templates/:templateId", GetTemplateHandler(cfg))
+    protected.Post("/templates/:templateId/forms", UseTemplateHandler(cfg))
+    protected.Delete("/templates/:templateId", DeleteTemplateHandler(cfg))
+    protected.Get("/trash", ListTrashHandler(cfg))
+    protected.Post("/trash/:id/restore", RestoreFormHandler(cfg))
+    protected.Delete("/trash/:id", PurgeFormHandler(cfg))
+    protected.Get("/folders", ListFoldersHandler(cfg))
+    protected.Post("/folders", CreateFolderHandler(cfg))
+    protected.Put("/folders/:folderId", RenameFolderHandler(cfg))
+    protected.Delete("/folders/:folderId", DeleteFolderHandler(cfg))
     protected.Get("/forms/:id/analytics", AnalyticsHandler(cfg))
-    protected.Get("/forms/:id/export.csv", ExportCSVHandler(cfg))
+    protected.Get("/forms/:id/export", ExportResponsesHandler(cfg))
+    protected.Get("/forms/:id/export.csv", ExportResponsesHandler(cfg))
+    protected.Post("/forms/:id/exports", CreateExportJobHandler(cfg))
+    protected.Get("/forms/:id/exports", ListExportJobsHandler(cfg))
+    protected.Get("/forms/:id/exports/:exportId", GetExportJobHandler(cfg))
+    protected.Get("/forms/:id/exports/:exportId/download", DownloadExportHandler(cfg))
+    protected.Delete("/forms/:id/exports/:exportId", DeleteExportJobHandler(cfg))
+    protected.Get("/forms/:id/keys", ListFormKeysHandler(cfg))
+    protected.Post("/forms/:id/keys/rotate", RotateFormKeyHandler(cfg))
+    protected.Delete("/forms/:id/keys", DestroyFormKeysHandler(cfg))
+    protected.Get("/forms/:id/responses", ListResponsesHandler(cfg))
+    protected.Get("/forms/:id/responses/:responseId", GetResponseHandler(cfg))
+    protected.Delete("/forms/:id/responses/:responseId", DeleteResponseHandler(cfg))
+    protected.Patch("/forms/:id/responses/:responseId/review", UpdateReviewHandler(cfg))
+    protected.Get("/forms/:id/responses/:responseId/notes", ListNotesHandler(cfg))
+    protected.Post("/forms/:id/responses/:responseId/notes", AddNoteHandler(cfg))
+    protected.Post("/forms/:id/rules/dry-run", DryRunRulesHandler(cfg))
+    protected.Get("/forms/:id/webhooks", ListWebhooksHandler(cfg))
+    protected.Post("/forms/:id/webhooks", CreateWebhookHandler(cfg))
+    protected.Put("/forms/:id/webhooks/:webhookId", UpdateWebhookHandler(cfg))
+    protected.Delete("/forms/:id/webhooks/:webhookId", DeleteWebhookHandler(cfg))
+    protected.Post("/forms/:id/webhooks/:webhookId/test", TestWebhookHandler(cfg))
+    protected.Get("/forms/:id/webhooks/:webhookId/deliveries", ListDeliveriesHandler(cfg))
+    protected.Post("/forms/:id/webhooks/:webhookId/deliveries/:deliveryId/redeliver", RedeliverHandler(cfg))
+
+    // Admin routes
+    admin := protected.Group("/admin", AdminMiddleware(cfg))
+    admin.Get("/audit", AuditLogHandler(cfg))
+    admin.Get("/audit/export.jsonl", AuditExportHandler(cfg))
+    admin.Get("/audit/forms/:formId", AuditLogHandler(cfg))
+    admin.Get("/audit/forms/:formId/export.jsonl", AuditExportHandler(cfg))
+    admin.Get("/audit/users/:userId", AuditLogHandler(cfg))
+    admin.Get("/audit/users/:userId/export.jsonl", AuditExportHandler(cfg))
+    admin.Get("/keys", MasterKeysHandler(cfg))
+    admin.Post("/keys/rewrap", RewrapKeysHandler(cfg))
 }
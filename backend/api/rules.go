@@ -0,0 +1,472 @@
+package api
+
+import (
+    "context"
+    "log"
+    "strconv"
+    "strings"
+    "time"
+
+    "github.com/gofiber/fiber/v2"
+    "go.mongodb.org/mongo-driver/bson"
+    "go.mongodb.org/mongo-driver/bson/primitive"
+    "go.mongodb.org/mongo-driver/mongo/options"
+    "formbuilder/backend/config"
+)
+
+// Response review statuses
+const (
+    responseNew      = "new"
+    responseInReview = "in_review"
+    responseResolved = "resolved"
+    responseRejected = "rejected"
+)
+
+var responseStatuses = []string{responseNew, responseInReview, responseResolved, responseRejected}
+
+// Rule is a declarative "if these answers, then do this" evaluated against
+// every new response after it is stored.
+type Rule struct {
+    ID         string      `bson:"id" json:"id"`
+    Name       string      `bson:"name" json:"name"`
+    Enabled    bool        `bson:"enabled" json:"enabled"`
+    Match      string      `bson:"match,omitempty" json:"match,omitempty"` // "all" (default) or "any"
+    Conditions []Condition `bson:"conditions" json:"conditions"`
+    Actions    []Action    `bson:"actions" json:"actions"`
+}
+
+// Condition compares one answer with Value. Ops: equals, not_equals,
+// contains, gt, gte, lt, lte, is_empty, not_empty.
+type Condition struct {
+    FieldID string      `bson:"fieldId" json:"fieldId"`
+    Op      string      `bson:"op" json:"op"`
+    Value   interface{} `bson:"value,omitempty" json:"value,omitempty"`
+}
+
+// Action is one effect of a matching rule:
+//   tag        add Value to the response's tags
+//   assign     assign the response to the user ID in Value
+//   set_status set the response's review status to Value
+//   email      email To (PII answers are hidden)
+//   webhook    send a "rule.matched" event to the form's webhook WebhookID
+type Action struct {
+    Type      string   `bson:"type" json:"type"`
+    Value     string   `bson:"value,omitempty" json:"value,omitempty"`
+    To        []string `bson:"to,omitempty" json:"to,omitempty"`
+    WebhookID string   `bson:"webhookId,omitempty" json:"webhookId,omitempty"`
+}
+
+type DryRunRequest struct {
+    Rules []Rule     `json:"rules"` // defaults to the form's saved rules
+    Since *time.Time `json:"since"`
+    Limit int        `json:"limit"`
+}
+
+const (
+    eventRuleMatched   = "rule.matched"
+    ruleEmailSubject   = `Rule matched: {{.RuleName}} ({{.FormTitle}})`
+    dryRunDefaultLimit = 500
+    dryRunMaxLimit     = 5000
+    dryRunMaxSamples   = 100
+)
+
+var conditionOps = map[string]bool{
+    "equals": true, "not_equals": true, "contains": true,
+    "gt": true, "gte": true, "lt": true, "lte": true,
+    "is_empty": true, "not_empty": true,
+}
+
+func validResponseStatus(s string) bool {
+    for _, st := range responseStatuses {
+        if s == st {
+            return true
+        }
+    }
+    return false
+}
+
+// validateRules checks rules against the form's fields and assigns IDs to
+// new rules.
+func validateRules(f *Form, rules []Rule) error {
+    fields := map[string]bool{}
+    for _, field := range f.Fields {
+        fields[field.ID] = true
+    }
+    for i := range rules {
+        r := &rules[i]
+        if r.ID == "" {
+            r.ID = primitive.NewObjectID().Hex()
+        }
+        if r.Match != "" && r.Match != "all" && r.Match != "any" {
+            return fiber.NewError(fiber.StatusBadRequest, "rule "+r.Name+": match must be all or any")
+        }
+        if len(r.Conditions) == 0 || len(r.Actions) == 0 {
+            return fiber.NewError(fiber.StatusBadRequest, "rule "+r.Name+": needs at least one condition and one action")
+        }
+        for _, c := range r.Conditions {
+            if !fields[c.FieldID] {
+                return fiber.NewError(fiber.StatusBadRequest, "rule "+r.Name+": unknown field "+c.FieldID)
+            }
+            if !conditionOps[c.Op] {
+                return fiber.NewError(fiber.StatusBadRequest, "rule "+r.Name+": unknown op "+c.Op)
+            }
+        }
+        for _, a := range r.Actions {
+            switch a.Type {
+            case "tag", "assign":
+                if a.Value == "" {
+                    return fiber.NewError(fiber.StatusBadRequest, "rule "+r.Name+": "+a.Type+" needs a value")
+                }
+            case "set_status":
+                if !validResponseStatus(a.Value) {
+                    return fiber.NewError(fiber.StatusBadRequest, "rule "+r.Name+": invalid status "+a.Value)
+                }
+            case "email":
+                if len(a.To) == 0 {
+                    return fiber.NewError(fiber.StatusBadRequest, "rule "+r.Name+": email needs recipients")
+                }
+                for _, to := range a.To {
+                    if !strings.Contains(to, "@") {
+                        return fiber.NewError(fiber.StatusBadRequest, "rule "+r.Name+": invalid recipient "+to)
+                    }
+                }
+            case "webhook":
+                if _, err := primitive.ObjectIDFromHex(a.WebhookID); err != nil {
+                    return fiber.NewError(fiber.StatusBadRequest, "rule "+r.Name+": webhook needs a webhookId")
+                }
+            default:
+                return fiber.NewError(fiber.StatusBadRequest, "rule "+r.Name+": unknown action "+a.Type)
+            }
+        }
+    }
+    return nil
+}
+
+func isEmptyAnswer(v interface{}, ok bool) bool {
+    if !ok || v == nil {
+        return true
+    }
+    switch t := v.(type) {
+    case string:
+        return strings.TrimSpace(t) == ""
+    case []interface{}:
+        return len(t) == 0
+    case primitive.A:
+        return len(t) == 0
+    }
+    return false
+}
+
+func toFloat(v interface{}) (float64, bool) {
+    switch t := v.(type) {
+    case float64:
+        return t, true
+    case int:
+        return float64(t), true
+    case int32:
+        return float64(t), true
+    case int64:
+        return float64(t), true
+    case string:
+        n, err := strconv.ParseFloat(strings.TrimSpace(t), 64)
+        return n, err == nil
+    }
+    return 0, false
+}
+
+func answerList(v interface{}) ([]interface{}, bool) {
+    switch t := v.(type) {
+    case []interface{}:
+        return t, true
+    case primitive.A:
+        return []interface{}(t), true
+    }
+    return nil, false
+}
+
+// matchCondition evaluates one condition. Multi-select answers match
+// equals/contains when any selected option matches.
+func matchCondition(c Condition, answers map[string]interface{}) bool {
+    v, ok := answers[c.FieldID]
+    switch c.Op {
+    case "is_empty":
+        return isEmptyAnswer(v, ok)
+    case "not_empty":
+        return !isEmptyAnswer(v, ok)
+    }
+    if !ok {
+        return c.Op == "not_equals"
+    }
+    want := answerText(c.Value)
+    switch c.Op {
+    case "equals", "not_equals":
+        eq := false
+        if list, isList := answerList(v); isList {
+            for _, x := range list {
+                if answerText(x) == want {
+                    eq = true
+                    break
+                }
+            }
+        } else if a, aok := toFloat(v); aok {
+            b, bok := toFloat(c.Value)
+            eq = bok && a == b
+        } else {
+            eq = answerText(v) == want
+        }
+        return eq == (c.Op == "equals")
+    case "contains":
+        if list, isList := answerList(v); isList {
+            for _, x := range list {
+                if answerText(x) == want {
+                    return true
+                }
+            }
+            return false
+        }
+        return strings.Contains(strings.ToLower(answerText(v)), strings.ToLower(want))
+    }
+    a, aok := toFloat(v)
+    b, bok := toFloat(c.Value)
+    if !aok || !bok {
+        return false
+    }
+    switch c.Op {
+    case "gt":
+        return a > b
+    case "gte":
+        return a >= b
+    case "lt":
+        return a < b
+    case "lte":
+        return a <= b
+    }
+    return false
+}
+
+func appendUnique(list []string, v string) []string {
+    for _, x := range list {
+        if x == v {
+            return list
+        }
+    }
+    return append(list, v)
+}
+
+func matchRule(r *Rule, answers map[string]interface{}) bool {
+    if !r.Enabled {
+        return false
+    }
+    any := r.Match == "any"
+    for _, c := range r.Conditions {
+        m := matchCondition(c, answers)
+        if any && m {
+            return true
+        }
+        if !any && !m {
+            return false
+        }
+    }
+    return !any
+}
+
+// matchingRules returns the enabled rules the answers satisfy, in order.
+func matchingRules(rules []Rule, answers map[string]interface{}) []Rule {
+    var out []Rule
+    for i := range rules {
+        if matchRule(&rules[i], answers) {
+            out = append(out, rules[i])
+        }
+    }
+    return out
+}
+
+// responseChanges folds the stored effects of matching rules into one
+// update. For assign and set_status the last matching rule wins.
+func responseChanges(matched []Rule) bson.M {
+    set := bson.M{}
+    var tags bson.A
+    var ids bson.A
+    for _, r := range matched {
+        ids = append(ids, r.ID)
+        for _, a := range r.Actions {
+            switch a.Type {
+            case "tag":
+                tags = append(tags, a.Value)
+            case "assign":
+                set["assigneeId"] = a.Value
+            case "set_status":
+                set["status"] = a.Value
+            }
+        }
+    }
+    update := bson.M{"$addToSet": bson.M{"matchedRules": bson.M{"$each": ids}}}
+    if len(tags) > 0 {
+        update["$addToSet"].(bson.M)["tags"] = bson.M{"$each": tags}
+    }
+    if len(set) > 0 {
+        update["$set"] = set
+    }
+    return update
+}
+
+// applyRules runs the form's rules against a stored response. Rule failures
+// are logged, never returned: the response has already been accepted.
+func applyRules(ctx context.Context, cfg *config.Config, f *Form, r *Response) {
+    matched := matchingRules(f.Rules, r.Answers)
+    if len(matched) == 0 {
+        return
+    }
+    if _, err := responsesCol(cfg).UpdateOne(ctx, bson.M{"_id": r.ID}, responseChanges(matched)); err != nil {
+        log.Printf("rules: response=%s: %v", r.ID.Hex(), err)
+        return
+    }
+    // Keep the in-memory copy in step for broadcasts and webhooks
+    for _, rule := range matched {
+        r.MatchedRules = appendUnique(r.MatchedRules, rule.ID)
+        for _, a := range rule.Actions {
+            switch a.Type {
+            case "tag":
+                r.Tags = appendUnique(r.Tags, a.Value)
+            case "assign":
+                r.AssigneeID = a.Value
+            case "set_status":
+                r.Status = a.Value
+            }
+        }
+    }
+
+    for _, rule := range matched {
+        for _, a := range rule.Actions {
+            switch a.Type {
+            case "email":
+                data := notificationData{
+                    FormTitle:   f.Title,
+                    ResponseID:  r.ID.Hex(),
+                    SubmittedAt: r.CreatedAt.UTC().Format("Jan 2, 2006 15:04 MST"),
+                    Answers:     notificationAnswers(f, r, false),
+                    Link:        cfg.AppURL + "/forms/" + f.ID.Hex() + "/analytics",
+                }
+                data.RuleName = rule.Name
+                msg, err := renderMessage(a.To, "", "", ruleEmailSubject, defaultOwnerBody, data)
+                if err != nil {
+                    log.Printf("rules: rule=%s: %v", rule.ID, err)
+                    continue
+                }
+                queueMail(msg)
+            case "webhook":
+                hookID, _ := primitive.ObjectIDFromHex(a.WebhookID)
+                var h Webhook
+                if err := webhooksCol(cfg).FindOne(ctx, bson.M{"_id": hookID, "formId": f.ID, "active": true}).Decode(&h); err != nil {
+                    log.Printf("rules: rule=%s webhook=%s: %v", rule.ID, a.WebhookID, err)
+                    continue
+                }
+                data := fiber.Map{"rule": fiber.Map{"id": rule.ID, "name": rule.Name}, "response": r}
+                if _, err := queueDelivery(ctx, cfg, &h, f, eventRuleMatched, data); err != nil {
+                    log.Printf("rules: rule=%s webhook=%s: %v", rule.ID, a.WebhookID, err)
+                }
+            }
+        }
+    }
+}
+
+// DryRunRulesHandler evaluates rules against stored responses without
+// changing anything, so a rule can be checked before it is saved.
+func DryRunRulesHandler(cfg *config.Config) fiber.Handler {
+    return func(c *fiber.Ctx) error {
+        if err := requirePermission(c, permResponsesRead); err != nil {
+            return err
+        }
+        f, err := formFromParam(c, cfg)
+        if err != nil {
+            return err
+        }
+        var req DryRunRequest
+        if len(c.Body()) > 0 {
+            if err := c.BodyParser(&req); err != nil {
+                return fiber.NewError(fiber.StatusBadRequest, "Invalid request body")
+            }
+        }
+        rules := req.Rules
+        if rules == nil {
+            rules = f.Rules
+        }
+        if err := validateRules(f, rules); err != nil {
+            return err
+        }
+        // Match counts would otherwise reveal hidden answers one guess at a
+        // time, as filters on the response list would
+        role, _ := c.Locals("role").(string)
+        if !roleAllows(role, permPIIRead) {
+            for _, r := range rules {
+                for _, cond := range r.Conditions {
+                    if field := fieldByID(f, cond.FieldID); field != nil && field.IsPII {
+                        return fiber.NewError(fiber.StatusForbidden, "Rule conditions on PII fields require PII access")
+                    }
+                }
+            }
+        }
+        limit := req.Limit
+        if limit <= 0 || limit > dryRunMaxLimit {
+            limit = dryRunDefaultLimit
+        }
+
+        filter := bson.M{"formId": f.ID, "spam": bson.M{"$ne": true}}
+        if req.Since != nil {
+            filter["createdAt"] = bson.M{"$gte": *req.Since}
+        }
+        // Rules see PII answers decrypted, as they do when a response arrives
+        keys, err := loadFormKeys(c.Context(), cfg, f.ID)
+        if err != nil {
+            return fiber.NewError(fiber.StatusInternalServerError, err.Error())
+        }
+        opts := options.Find().SetSort(bson.M{"createdAt": -1}).SetLimit(int64(limit)).
+            SetProjection(bson.M{"answers": 1, "createdAt": 1})
+        cur, err := storeFor(c, cfg).FindResponses(c.Context(), filter, opts)
+        if err != nil {
+            return fiber.NewError(fiber.StatusInternalServerError, err.Error())
+        }
+        defer cur.Close(c.Context())
+
+        counts := map[string]int{}
+        for _, r := range rules {
+            counts[r.ID] = 0
+        }
+        samples := []fiber.Map{}
+        evaluated, matchedResponses := 0, 0
+        for cur.Next(c.Context()) {
+            var r Response
+            if err := cur.Decode(&r); err != nil {
+                return fiber.NewError(fiber.StatusInternalServerError, err.Error())
+            }
+            evaluated++
+            matched := matchingRules(rules, keys.decryptAnswers(r.Answers))
+            if len(matched) == 0 {
+                continue
+            }
+            matchedResponses++
+            ids := make([]string, len(matched))
+            for i, m := range matched {
+                counts[m.ID]++
+                ids[i] = m.ID
+            }
+            if len(samples) < dryRunMaxSamples {
+                samples = append(samples, fiber.Map{
+                    "responseId": r.ID,
+                    "createdAt":  r.CreatedAt,
+                    "rules":      ids,
+                    "changes":    responseChanges(matched),
+                })
+            }
+        }
+        if err := cur.Err(); err != nil {
+            return fiber.NewError(fiber.StatusInternalServerError, err.Error())
+        }
+        return c.JSON(fiber.Map{
+            "evaluated":  evaluated,
+            "matched":    matchedResponses,
+            "ruleCounts": counts,
+            "samples":    samples,
+        })
+    }
+}
@@ -0,0 +1,236 @@
+package api
+
+import (
+    "context"
+    "log"
+    "time"
+
+    "github.com/gofiber/fiber/v2"
+    "go.mongodb.org/mongo-driver/bson"
+    "go.mongodb.org/mongo-driver/mongo"
+    "go.mongodb.org/mongo-driver/mongo/options"
+    "formbuilder/backend/config"
+)
+
+// Form statuses. "scheduled" and "closed" are derived from the schedule and
+// quota: an owner publishes a form and the server moves it between
+// scheduled, published and closed as opensAt, closesAt and maxResponses
+// dictate.
+const (
+    statusDraft     = "draft"
+    statusScheduled = "scheduled"
+    statusPublished = "published"
+    statusClosed    = "closed"
+)
+
+const defaultClosedMessage = "This form is no longer accepting responses"
+
+func validateSchedule(f *Form) error {
+    switch f.Status {
+    case "", statusDraft, statusScheduled, statusPublished, statusClosed:
+    default:
+        return fiber.NewError(fiber.StatusBadRequest, "invalid status: "+f.Status)
+    }
+    if f.OpensAt != nil && f.ClosesAt != nil && !f.ClosesAt.After(*f.OpensAt) {
+        return fiber.NewError(fiber.StatusBadRequest, "closesAt must be after opensAt")
+    }
+    if f.MaxResponses < 0 {
+        return fiber.NewError(fiber.StatusBadRequest, "maxResponses must not be negative")
+    }
+    return nil
+}
+
+// normalizeSchedule derives the effective status of a live form from its
+// schedule and quota. Drafts and explicitly closed forms are left alone.
+func normalizeSchedule(f *Form, now time.Time) {
+    if f.Status != statusPublished && f.Status != statusScheduled {
+        return
+    }
+    switch {
+    case f.ClosesAt != nil && !f.ClosesAt.After(now):
+        f.Status = statusClosed
+    case f.MaxResponses > 0 && f.ResponseCount >= f.MaxResponses:
+        f.Status = statusClosed
+    case f.OpensAt != nil && f.OpensAt.After(now):
+        f.Status = statusScheduled
+    default:
+        f.Status = statusPublished
+    }
+}
+
+// clearedSettings lists optional settings the update removed; $set with
+// omitempty fields would otherwise leave the old values in place.
+func clearedSettings(f *Form) bson.M {
+    unset := bson.M{}
+    if f.OpensAt == nil {
+        unset["opensAt"] = ""
+    }
+    if f.ClosesAt == nil {
+        unset["closesAt"] = ""
+    }
+    if f.MaxResponses == 0 {
+        unset["maxResponses"] = ""
+    }
+    if f.ClosedMessage == "" {
+        unset["closedMessage"] = ""
+    }
+    if f.RespondentPolicy == "" {
+        unset["respondentPolicy"] = ""
+    }
+    if f.EditWindowMinutes == 0 {
+        unset["editWindowMinutes"] = ""
+    }
+    if f.Notifications == nil {
+        unset["notifications"] = ""
+    }
+    if len(f.Rules) == 0 {
+        unset["rules"] = ""
+    }
+    if len(f.Tags) == 0 {
+        unset["tags"] = ""
+    }
+    return unset
+}
+
+func closedMessage(f *Form) string {
+    if f.ClosedMessage != "" {
+        return f.ClosedMessage
+    }
+    return defaultClosedMessage
+}
+
+// checkFormOpen rejects submissions to forms that are not accepting
+// responses.
+func checkFormOpen(f *Form) error {
+    switch f.Status {
+    case statusPublished:
+        now := time.Now()
+        if f.OpensAt != nil && f.OpensAt.After(now) {
+            return fiber.NewError(fiber.StatusForbidden, "This form is not open yet")
+        }
+        if f.ClosesAt != nil && !f.ClosesAt.After(now) {
+            return fiber.NewError(fiber.StatusForbidden, closedMessage(f))
+        }
+        return nil
+    case statusScheduled:
+        return fiber.NewError(fiber.StatusForbidden, "This form is not open yet")
+    case statusClosed:
+        return fiber.NewError(fiber.StatusForbidden, closedMessage(f))
+    default:
+        return fiber.NewError(fiber.StatusBadRequest, "form not published")
+    }
+}
+
+// reserveResponseSlot atomically counts a response against the form's
+// quota. Concurrent submissions race on a single conditional $inc, so a
+// form with maxResponses N never accepts more than N. The submission that
+// takes the last slot closes the form.
+func reserveResponseSlot(c *fiber.Ctx, cfg *config.Config, f *Form) error {
+    now := time.Now()
+    filter := bson.M{
+        "_id":    f.ID,
+        "status": statusPublished,
+        "$and": bson.A{
+            bson.M{"$or": bson.A{
+                bson.M{"maxResponses": bson.M{"$exists": false}},
+                bson.M{"maxResponses": 0},
+                bson.M{"$expr": bson.M{"$lt": bson.A{bson.M{"$ifNull": bson.A{"$responseCount", 0}}, "$maxResponses"}}},
+            }},
+            bson.M{"$or": bson.A{
+                bson.M{"closesAt": bson.M{"$exists": false}},
+                bson.M{"closesAt": bson.M{"$gt": now}},
+            }},
+        },
+    }
+    opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+    var updated Form
+    err := formsCol(cfg).FindOneAndUpdate(c.Context(), filter, bson.M{"$inc": bson.M{"responseCount": 1}}, opts).Decode(&updated)
+    if err == mongo.ErrNoDocuments {
+        return fiber.NewError(fiber.StatusForbidden, closedMessage(f))
+    }
+    if err != nil {
+        return fiber.NewError(fiber.StatusInternalServerError, err.Error())
+    }
+    if updated.MaxResponses > 0 && updated.ResponseCount >= updated.MaxResponses {
+        closeForm(c.Context(), cfg, &updated, "quota")
+    }
+    return nil
+}
+
+// releaseResponseSlot gives back a slot whose response was never stored or
+// has been deleted. Forms created before counting began may be at zero.
+func releaseResponseSlot(c *fiber.Ctx, cfg *config.Config, f *Form) {
+    if _, err := formsCol(cfg).UpdateOne(c.Context(), bson.M{"_id": f.ID, "responseCount": bson.M{"$gt": 0}}, bson.M{"$inc": bson.M{"responseCount": -1}}); err != nil {
+        log.Printf("schedule: failed to release slot form=%s: %v", f.ID.Hex(), err)
+    }
+}
+
+// closeForm moves a published or scheduled form to closed. The status
+// condition makes it safe to call from concurrent submissions and the
+// scheduler; only the caller that wins the transition announces it.
+func closeForm(ctx context.Context, cfg *config.Config, f *Form, reason string) {
+    transitionForm(ctx, cfg, f, bson.A{statusPublished, statusScheduled}, statusClosed, reason)
+}
+
+func transitionForm(ctx context.Context, cfg *config.Config, f *Form, from bson.A, to, reason string) {
+    res, err := formsCol(cfg).UpdateOne(ctx,
+        bson.M{"_id": f.ID, "status": bson.M{"$in": from}},
+        bson.M{"$set": bson.M{"status": to, "updatedAt": time.Now()}})
+    if err != nil {
+        log.Printf("schedule: form=%s -> %s: %v", f.ID.Hex(), to, err)
+        return
+    }
+    if res.ModifiedCount == 0 {
+        return
+    }
+    BroadcastFormStatus(f.ID.Hex(), to)
+    action := eventFormClosed
+    if to == statusPublished {
+        action = eventFormOpened
+    }
+    enqueueWebhookEvent(ctx, cfg, f, action, fiber.Map{"status": to, "reason": reason})
+    ev := formAudit(action, f)
+    ev.WorkspaceID = f.WorkspaceID.Hex()
+    ev.Metadata = bson.M{"reason": reason}
+    insertAudit(ctx, cfg, ev)
+}
+
+// runScheduler opens scheduled forms whose opensAt has passed and closes
+// live forms whose closesAt has passed or whose quota is used up.
+func runScheduler(ctx context.Context, cfg *config.Config) {
+    now := time.Now()
+    due := bson.M{"$or": bson.A{
+        bson.M{"status": statusScheduled, "opensAt": bson.M{"$lte": now}},
+        bson.M{"status": bson.M{"$in": bson.A{statusPublished, statusScheduled}}, "closesAt": bson.M{"$lte": now}},
+        bson.M{"status": statusPublished, "maxResponses": bson.M{"$gt": 0},
+            "$expr": bson.M{"$gte": bson.A{bson.M{"$ifNull": bson.A{"$responseCount", 0}}, "$maxResponses"}}},
+    }}
+    cur, err := formsCol(cfg).Find(ctx, due)
+    if err != nil {
+        log.Printf("scheduler: %v", err)
+        return
+    }
+    var forms []Form
+    if err := cur.All(ctx, &forms); err != nil {
+        log.Printf("scheduler: %v", err)
+        return
+    }
+    for i := range forms {
+        f := &forms[i]
+        before := f.Status
+        normalizeSchedule(f, now)
+        if f.Status == before {
+            continue
+        }
+        switch f.Status {
+        case statusPublished:
+            transitionForm(ctx, cfg, f, bson.A{statusScheduled}, statusPublished, "opensAt")
+        case statusClosed:
+            reason := "closesAt"
+            if f.ClosesAt == nil || f.ClosesAt.After(now) {
+                reason = "quota"
+            }
+            closeForm(ctx, cfg, f, reason)
+        }
+    }
+}
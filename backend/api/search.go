@@ -0,0 +1,459 @@
+package api
+
+import (
+    "context"
+    "html"
+    "log"
+    "regexp"
+    "strings"
+    "time"
+    "unicode/utf8"
+
+    "github.com/gofiber/fiber/v2"
+    "go.mongodb.org/mongo-driver/bson"
+    "go.mongodb.org/mongo-driver/bson/primitive"
+    "go.mongodb.org/mongo-driver/mongo"
+    "go.mongodb.org/mongo-driver/mongo/options"
+    "formbuilder/backend/config"
+)
+
+const (
+    searchDefaultLimit = 20
+    searchMaxLimit     = 50
+    maxSearchQuery     = 200
+    // Characters of context kept on each side of the first match
+    snippetContext = 60
+    reindexBatch   = 500
+)
+
+var searchWordPattern = regexp.MustCompile(`[\p{L}\p{N}]+`)
+
+// SearchHighlight is a snippet of a matching title, label or answer. The
+// snippet is HTML-escaped with matches wrapped in <mark>.
+type SearchHighlight struct {
+    Field   string `json:"field"` // "title", "label" or "answer"
+    FieldID string `json:"fieldId,omitempty"`
+    Label   string `json:"label,omitempty"`
+    Snippet string `json:"snippet"`
+}
+
+type FormSearchResult struct {
+    ID         primitive.ObjectID `json:"id"`
+    Title      string             `json:"title"`
+    Status     string             `json:"status"`
+    UpdatedAt  time.Time          `json:"updatedAt"`
+    Score      float64            `json:"score"`
+    Highlights []SearchHighlight  `json:"highlights"`
+}
+
+type ResponseSearchResult struct {
+    ID         primitive.ObjectID `json:"id"`
+    FormID     primitive.ObjectID `json:"formId"`
+    FormTitle  string             `json:"formTitle"`
+    Status     string             `json:"status,omitempty"`
+    CreatedAt  time.Time          `json:"createdAt"`
+    Score      float64            `json:"score"`
+    Highlights []SearchHighlight  `json:"highlights"`
+}
+
+// searchableFields are the free-text fields whose answers are indexed. PII
+// answers are never indexed, so search cannot be used to find them.
+func searchableFields(f *Form) []Field {
+    var out []Field
+    for _, field := range f.Fields {
+        if field.Type == "text" && !field.IsPII {
+            out = append(out, field)
+        }
+    }
+    return out
+}
+
+// responseSearchText is the text indexed for a response: its answers to the
+// form's searchable fields.
+func responseSearchText(f *Form, answers map[string]interface{}) string {
+    var parts []string
+    for _, field := range searchableFields(f) {
+        if s, ok := answers[field.ID].(string); ok && strings.TrimSpace(s) != "" {
+            parts = append(parts, s)
+        }
+    }
+    return strings.Join(parts, "\n")
+}
+
+func searchableIDs(f *Form) []string {
+    var ids []string
+    for _, field := range searchableFields(f) {
+        ids = append(ids, field.ID)
+    }
+    return ids
+}
+
+// searchFieldsChanged reports whether a form update changes which answers
+// are indexed, e.g. a text field was added or marked PII.
+func searchFieldsChanged(before, after *Form) bool {
+    return strings.Join(searchableIDs(before), ",") != strings.Join(searchableIDs(after), ",")
+}
+
+// reindexResponses recomputes the indexed text of every response to a form.
+func reindexResponses(ctx context.Context, cfg *config.Config, f *Form) error {
+    cur, err := responsesCol(cfg).Find(ctx, bson.M{"formId": f.ID}, options.Find().SetProjection(bson.M{"answers": 1}))
+    if err != nil {
+        return err
+    }
+    defer cur.Close(ctx)
+    var models []mongo.WriteModel
+    flush := func() error {
+        if len(models) == 0 {
+            return nil
+        }
+        _, err := responsesCol(cfg).BulkWrite(ctx, models, options.BulkWrite().SetOrdered(false))
+        models = models[:0]
+        return err
+    }
+    for cur.Next(ctx) {
+        var r Response
+        if err := cur.Decode(&r); err != nil {
+            return err
+        }
+        models = append(models, mongo.NewUpdateOneModel().
+            SetFilter(bson.M{"_id": r.ID}).
+            SetUpdate(bson.M{"$set": bson.M{"searchText": responseSearchText(f, r.Answers)}}))
+        if len(models) == reindexBatch {
+            if err := flush(); err != nil {
+                return err
+            }
+        }
+    }
+    if err := cur.Err(); err != nil {
+        return err
+    }
+    return flush()
+}
+
+// reindexInBackground reindexes a form's responses after a request that
+// changed its searchable fields has returned.
+func reindexInBackground(cfg *config.Config, f *Form) {
+    go func() {
+        ctx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
+        defer cancel()
+        if err := reindexResponses(ctx, cfg, f); err != nil {
+            log.Printf("search: reindex form=%s: %v", f.ID.Hex(), err)
+        }
+    }()
+}
+
+// backfillSearchText indexes responses stored before search existed. It
+// runs once at startup.
+func backfillSearchText(ctx context.Context, cfg *config.Config) {
+    ids, err := responsesCol(cfg).Distinct(ctx, "formId", bson.M{"searchText": bson.M{"$exists": false}})
+    if err != nil {
+        log.Printf("search: backfill: %v", err)
+        return
+    }
+    for _, id := range ids {
+        var f Form
+        if err := formsCol(cfg).FindOne(ctx, bson.M{"_id": id}).Decode(&f); err != nil {
+            continue
+        }
+        if err := reindexResponses(ctx, cfg, &f); err != nil {
+            log.Printf("search: backfill form=%s: %v", f.ID.Hex(), err)
+        }
+    }
+}
+
+// searchTerms extracts the lowercased words of a query for highlighting.
+// Excluded terms ("-word") are skipped.
+func searchTerms(q string) []string {
+    var terms []string
+    for _, tok := range strings.Fields(q) {
+        if strings.HasPrefix(tok, "-") {
+            continue
+        }
+        for _, w := range searchWordPattern.FindAllString(tok, -1) {
+            terms = appendUnique(terms, stemWord(strings.ToLower(w)))
+        }
+    }
+    return terms
+}
+
+// stemWord strips common English suffixes so highlighting roughly follows
+// Mongo's stemming: "running" highlights for "run".
+func stemWord(w string) string {
+    if len(w) > 4 && strings.HasSuffix(w, "ies") {
+        return strings.TrimSuffix(w, "ies") + "y"
+    }
+    for _, suffix := range []string{"ing", "ed", "es", "ly", "s"} {
+        if len(w) > len(suffix)+2 && strings.HasSuffix(w, suffix) {
+            w = strings.TrimSuffix(w, suffix)
+            break
+        }
+    }
+    // "running" -> "runn" -> "run"
+    if n := len(w); n > 3 && w[n-1] == w[n-2] {
+        w = w[:n-1]
+    }
+    return w
+}
+
+func matchesTerm(word string, terms []string) bool {
+    w := stemWord(strings.ToLower(word))
+    for _, t := range terms {
+        if strings.HasPrefix(w, t) {
+            return true
+        }
+    }
+    return false
+}
+
+// highlight returns an escaped snippet of text around the first matching
+// word, with every match in the snippet wrapped in <mark>, or "" when no
+// word matches.
+func highlight(text string, terms []string) string {
+    words := searchWordPattern.FindAllStringIndex(text, -1)
+    first := -1
+    for i, w := range words {
+        if matchesTerm(text[w[0]:w[1]], terms) {
+            first = i
+            break
+        }
+    }
+    if first < 0 {
+        return ""
+    }
+    start, end := words[first][0], words[first][1]
+    for n := 0; start > 0 && n < snippetContext; n++ {
+        _, size := utf8.DecodeLastRuneInString(text[:start])
+        start -= size
+    }
+    for n := 0; end < len(text) && n < snippetContext; n++ {
+        _, size := utf8.DecodeRuneInString(text[end:])
+        end += size
+    }
+
+    var b strings.Builder
+    if start > 0 {
+        b.WriteString("…")
+    }
+    pos := start
+    for _, w := range words {
+        if w[0] < start || w[1] > end || !matchesTerm(text[w[0]:w[1]], terms) {
+            continue
+        }
+        b.WriteString(html.EscapeString(text[pos:w[0]]))
+        b.WriteString("<mark>" + html.EscapeString(text[w[0]:w[1]]) + "</mark>")
+        pos = w[1]
+    }
+    b.WriteString(html.EscapeString(text[pos:end]))
+    if end < len(text) {
+        b.WriteString("…")
+    }
+    return b.String()
+}
+
+func formHighlights(f *Form, terms []string) []SearchHighlight {
+    out := []SearchHighlight{}
+    if s := highlight(f.Title, terms); s != "" {
+        out = append(out, SearchHighlight{Field: "title", Snippet: s})
+    }
+    for _, field := range f.Fields {
+        if s := highlight(field.Label, terms); s != "" {
+            out = append(out, SearchHighlight{Field: "label", FieldID: field.ID, Snippet: s})
+        }
+    }
+    return out
+}
+
+// answerHighlights uses the form's current searchable fields, so answers
+// to a field marked PII after indexing are never shown.
+func answerHighlights(f *Form, answers map[string]interface{}, terms []string) []SearchHighlight {
+    out := []SearchHighlight{}
+    for _, field := range searchableFields(f) {
+        text, _ := answers[field.ID].(string)
+        if s := highlight(text, terms); s != "" {
+            out = append(out, SearchHighlight{Field: "answer", FieldID: field.ID, Label: field.Label, Snippet: s})
+        }
+    }
+    return out
+}
+
+// Search runs on Mongo text indexes only. tenantStore is the sole store
+// and there is no in-memory backend, so no separate inverted index exists;
+// an in-memory store would need one over titles, labels and the text of
+// searchableFields.
+
+// textSearchOptions sorts by relevance and projects the score alongside
+// the named fields.
+func textSearchOptions(limit int, fields ...string) *options.FindOptions {
+    score := bson.M{"$meta": "textScore"}
+    proj := bson.M{"score": score}
+    for _, k := range fields {
+        proj[k] = 1
+    }
+    return options.Find().
+        SetProjection(proj).
+        SetSort(bson.D{{Key: "score", Value: score}}).
+        SetLimit(int64(limit))
+}
+
+// SearchForms finds forms whose title or field labels match a text query.
+func (s *tenantStore) SearchForms(ctx context.Context, query string, limit int) ([]FormSearchResult, error) {
+    opts := textSearchOptions(limit, "title", "status", "updatedAt", "fields")
+    cur, err := formsCol(s.cfg).Find(ctx, s.scope(liveForms(bson.M{"$text": bson.M{"$search": query}})), opts)
+    if err != nil {
+        return nil, err
+    }
+    defer cur.Close(ctx)
+    terms := searchTerms(query)
+    results := []FormSearchResult{}
+    for cur.Next(ctx) {
+        var doc struct {
+            Form  `bson:",inline"`
+            Score float64 `bson:"score"`
+        }
+        if err := cur.Decode(&doc); err != nil {
+            return nil, err
+        }
+        results = append(results, FormSearchResult{
+            ID:         doc.ID,
+            Title:      doc.Title,
+            Status:     doc.Status,
+            UpdatedAt:  doc.UpdatedAt,
+            Score:      doc.Score,
+            Highlights: formHighlights(&doc.Form, terms),
+        })
+    }
+    return results, cur.Err()
+}
+
+// SearchResponses finds non-spam responses whose free-text answers match a
+// text query, optionally within one form.
+func (s *tenantStore) SearchResponses(ctx context.Context, query string, formID primitive.ObjectID, limit int) ([]ResponseSearchResult, error) {
+    filter := bson.M{"$text": bson.M{"$search": query}, "spam": bson.M{"$ne": true}}
+    if !formID.IsZero() {
+        filter["formId"] = formID
+    }
+    opts := textSearchOptions(limit, "formId", "status", "createdAt", "answers")
+    cur, err := responsesCol(s.cfg).Find(ctx, s.scope(filter), opts)
+    if err != nil {
+        return nil, err
+    }
+    defer cur.Close(ctx)
+    type scored struct {
+        Response `bson:",inline"`
+        Score    float64 `bson:"score"`
+    }
+    var docs []scored
+    if err := cur.All(ctx, &docs); err != nil {
+        return nil, err
+    }
+
+    var formIDs bson.A
+    for _, d := range docs {
+        formIDs = append(formIDs, d.FormID)
+    }
+    forms := map[primitive.ObjectID]*Form{}
+    if len(formIDs) > 0 {
+        list, err := s.FindForms(ctx, bson.M{"_id": bson.M{"$in": formIDs}})
+        if err != nil {
+            return nil, err
+        }
+        for i := range list {
+            forms[list[i].ID] = &list[i]
+        }
+    }
+
+    terms := searchTerms(query)
+    results := []ResponseSearchResult{}
+    for _, d := range docs {
+        f := forms[d.FormID]
+        if f == nil {
+            continue
+        }
+        // Matches only in text indexed before the form changed are dropped
+        highlights := answerHighlights(f, d.Answers, terms)
+        if len(highlights) == 0 {
+            continue
+        }
+        results = append(results, ResponseSearchResult{
+            ID:         d.ID,
+            FormID:     d.FormID,
+            FormTitle:  f.Title,
+            Status:     d.Status,
+            CreatedAt:  d.CreatedAt,
+            Score:      d.Score,
+            Highlights: highlights,
+        })
+    }
+    return results, nil
+}
+
+// SearchHandler searches the caller's workspace. scope is forms, responses
+// or all (the default); formId limits response search to one form. Each
+// scope requires the matching read permission, and scope=all returns only
+// what the caller may read.
+func SearchHandler(cfg *config.Config) fiber.Handler {
+    return func(c *fiber.Ctx) error {
+        q := strings.TrimSpace(c.Query("q"))
+        if q == "" || len(q) > maxSearchQuery {
+            return fiber.NewError(fiber.StatusBadRequest, "q must be between 1 and 200 characters")
+        }
+        limit := c.QueryInt("limit", searchDefaultLimit)
+        if limit <= 0 || limit > searchMaxLimit {
+            limit = searchDefaultLimit
+        }
+        role, _ := c.Locals("role").(string)
+        scope := c.Query("scope", "all")
+        var wantForms, wantResponses bool
+        switch scope {
+        case "forms":
+            if err := requirePermission(c, permFormsRead); err != nil {
+                return err
+            }
+            wantForms = true
+        case "responses":
+            if err := requirePermission(c, permResponsesRead); err != nil {
+                return err
+            }
+            wantResponses = true
+        case "all":
+            wantForms = roleAllows(role, permFormsRead)
+            wantResponses = roleAllows(role, permResponsesRead)
+        default:
+            return fiber.NewError(fiber.StatusBadRequest, "scope must be forms, responses or all")
+        }
+
+        store := storeFor(c, cfg)
+        var formID primitive.ObjectID
+        if v := c.Query("formId"); v != "" {
+            oid, err := primitive.ObjectIDFromHex(v)
+            if err != nil {
+                return fiber.NewError(fiber.StatusBadRequest, "invalid formId")
+            }
+            if _, err := store.FindForm(c.Context(), oid); err != nil {
+                if err == mongo.ErrNoDocuments {
+                    return fiber.NewError(fiber.StatusNotFound, "not found")
+                }
+                return fiber.NewError(fiber.StatusInternalServerError, err.Error())
+            }
+            // A form's own search covers its responses
+            formID, wantForms = oid, false
+        }
+
+        resp := fiber.Map{}
+        if wantForms {
+            forms, err := store.SearchForms(c.Context(), q, limit)
+            if err != nil {
+                return fiber.NewError(fiber.StatusInternalServerError, err.Error())
+            }
+            resp["forms"] = forms
+        }
+        if wantResponses {
+            responses, err := store.SearchResponses(c.Context(), q, formID, limit)
+            if err != nil {
+                return fiber.NewError(fiber.StatusInternalServerError, err.Error())
+            }
+            resp["responses"] = responses
+        }
+        return c.JSON(resp)
+    }
+}
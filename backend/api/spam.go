@@ -0,0 +1,200 @@
+package api
+
+import (
+    "crypto/rand"
+    "crypto/sha256"
+    "encoding/hex"
+    "math"
+    "math/bits"
+    "strconv"
+    "time"
+
+    "github.com/gofiber/fiber/v2"
+    "github.com/golang-jwt/jwt/v5"
+    "go.mongodb.org/mongo-driver/mongo"
+    "formbuilder/backend/config"
+)
+
+const (
+    formLoadPurpose = "form_load"
+    formLoadTTL     = 24 * time.Hour
+
+    defaultSpamThreshold = 0.5
+    maxProofOfWorkBits   = 24
+)
+
+// Weights of the individual spam signals. A response whose total reaches the
+// form's threshold is flagged.
+const (
+    signalHoneypot    = "honeypot"
+    signalTooFast     = "too_fast"
+    signalNoLoadToken = "missing_load_token"
+    signalReusedToken = "reused_load_token"
+)
+
+var signalWeights = map[string]float64{
+    signalHoneypot:    1.0,
+    signalTooFast:     0.6,
+    signalNoLoadToken: 0.5,
+    signalReusedToken: 0.5,
+}
+
+// SpamProtection configures the defenses applied to public submissions.
+// The zero value disables all of them.
+type SpamProtection struct {
+    HoneypotField    string  `bson:"honeypotField,omitempty" json:"honeypotField,omitempty"` // hidden input that must stay empty
+    MinSubmitSeconds int     `bson:"minSubmitSeconds,omitempty" json:"minSubmitSeconds,omitempty"`
+    ProofOfWorkBits  int     `bson:"proofOfWorkBits,omitempty" json:"proofOfWorkBits,omitempty"` // leading zero bits required
+    RateLimitPerHour int     `bson:"rateLimitPerHour,omitempty" json:"rateLimitPerHour,omitempty"` // submissions per IP
+    SpamThreshold    float64 `bson:"spamThreshold,omitempty" json:"spamThreshold,omitempty"`
+}
+
+type usedLoadToken struct {
+    Nonce     string    `bson:"_id"`
+    ExpiresAt time.Time `bson:"expiresAt"`
+}
+
+func usedLoadTokensCol(cfg *config.Config) *mongo.Collection {
+    return mongoClient(cfg).Database(cfg.MongoDB).Collection("used_load_tokens")
+}
+
+func validateSpamProtection(f *Form) error {
+    p := f.Protection
+    if p == nil {
+        return nil
+    }
+    // popHoneypot would otherwise drop a real answer from every submission
+    if p.HoneypotField != "" && fieldByID(f, p.HoneypotField) != nil {
+        return fiber.NewError(fiber.StatusBadRequest, "honeypotField must not be the ID of a form field")
+    }
+    if p.MinSubmitSeconds < 0 || p.RateLimitPerHour < 0 || p.SpamThreshold < 0 {
+        return fiber.NewError(fiber.StatusBadRequest, "Spam protection values must not be negative")
+    }
+    if p.ProofOfWorkBits < 0 || p.ProofOfWorkBits > maxProofOfWorkBits {
+        return fiber.NewError(fiber.StatusBadRequest, "proofOfWorkBits must be between 0 and "+strconv.Itoa(maxProofOfWorkBits))
+    }
+    return nil
+}
+
+// issueLoadToken signs the moment a respondent loaded the form. Its nonce
+// doubles as the proof-of-work challenge.
+func issueLoadToken(formID, secret string) (token, nonce string, err error) {
+    buf := make([]byte, 16)
+    if _, err := rand.Read(buf); err != nil {
+        return "", "", err
+    }
+    nonce = hex.EncodeToString(buf)
+    now := time.Now()
+    token, err = jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+        "form_id": formID,
+        "nonce":   nonce,
+        "purpose": formLoadPurpose,
+        "iat":     now.Unix(),
+        "exp":     now.Add(formLoadTTL).Unix(),
+    }).SignedString([]byte(secret))
+    return token, nonce, err
+}
+
+// checkProofOfWork verifies sha256(challenge + ":" + solution) starts with
+// at least n zero bits.
+func checkProofOfWork(challenge, solution string, n int) bool {
+    if solution == "" {
+        return false
+    }
+    sum := sha256.Sum256([]byte(challenge + ":" + solution))
+    zeros := 0
+    for _, b := range sum {
+        if b == 0 {
+            zeros += 8
+            continue
+        }
+        zeros += bits.LeadingZeros8(b)
+        break
+    }
+    return zeros >= n
+}
+
+// popHoneypot removes the honeypot input from the answers and reports
+// whether a bot filled it in. A honeypot named after a real field, saved
+// before that was rejected, is ignored rather than dropping the answer.
+func popHoneypot(f *Form, answers map[string]interface{}) bool {
+    if f.Protection == nil || f.Protection.HoneypotField == "" || fieldByID(f, f.Protection.HoneypotField) != nil {
+        return false
+    }
+    v, ok := answers[f.Protection.HoneypotField]
+    delete(answers, f.Protection.HoneypotField)
+    if !ok || v == nil {
+        return false
+    }
+    s, isString := v.(string)
+    return !isString || s != ""
+}
+
+// checkSubmissionRate enforces the form's per-IP submission limit.
+func checkSubmissionRate(c *fiber.Ctx, cfg *config.Config, f *Form) error {
+    if f.Protection == nil || f.Protection.RateLimitPerHour <= 0 {
+        return nil
+    }
+    ok, wait, err := allowRequest(c.Context(), cfg, "submit:"+f.ID.Hex()+":"+c.IP(), f.Protection.RateLimitPerHour, time.Hour)
+    if err != nil {
+        return fiber.NewError(fiber.StatusInternalServerError, err.Error())
+    }
+    if !ok {
+        c.Set(fiber.HeaderRetryAfter, strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+        return fiber.NewError(fiber.StatusTooManyRequests, "Too many submissions, try again later")
+    }
+    return nil
+}
+
+// scoreSubmission evaluates the form's defenses for one submission. Proof of
+// work is a hard requirement; the other signals only add to the score.
+// Load tokens are single-use, so this must run after idempotent replays have
+// been answered.
+func scoreSubmission(c *fiber.Ctx, cfg *config.Config, f *Form, honeypot bool) (float64, []string, error) {
+    p := f.Protection
+    if p == nil {
+        return 0, nil, nil
+    }
+    var signals []string
+    if honeypot {
+        signals = append(signals, signalHoneypot)
+    }
+
+    claims, err := parseJWT(c.Get("X-Form-Token"), cfg.JWTSecret)
+    valid := err == nil && claims["purpose"] == formLoadPurpose && claims["form_id"] == f.ID.Hex()
+    nonce, _ := claims["nonce"].(string)
+    if !valid || nonce == "" {
+        if p.ProofOfWorkBits > 0 {
+            return 0, nil, fiber.NewError(fiber.StatusBadRequest, "A valid form token is required; reload the form")
+        }
+        if p.MinSubmitSeconds > 0 {
+            signals = append(signals, signalNoLoadToken)
+        }
+    } else {
+        if p.ProofOfWorkBits > 0 && !checkProofOfWork(nonce, c.Get("X-Proof-Of-Work"), p.ProofOfWorkBits) {
+            return 0, nil, fiber.NewError(fiber.StatusBadRequest, "Invalid proof of work")
+        }
+        if iat, ok := claims["iat"].(float64); ok && p.MinSubmitSeconds > 0 {
+            if time.Since(time.Unix(int64(iat), 0)) < time.Duration(p.MinSubmitSeconds)*time.Second {
+                signals = append(signals, signalTooFast)
+            }
+        }
+        _, err := usedLoadTokensCol(cfg).InsertOne(c.Context(), usedLoadToken{Nonce: nonce, ExpiresAt: time.Now().Add(formLoadTTL)})
+        if mongo.IsDuplicateKeyError(err) {
+            signals = append(signals, signalReusedToken)
+        }
+    }
+
+    score := 0.0
+    for _, s := range signals {
+        score += signalWeights[s]
+    }
+    return math.Min(score, 1), signals, nil
+}
+
+func spamThreshold(f *Form) float64 {
+    if f.Protection == nil || f.Protection.SpamThreshold <= 0 {
+        return defaultSpamThreshold
+    }
+    return f.Protection.SpamThreshold
+}
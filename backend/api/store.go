@@ -0,0 +1,121 @@
+package api
+
+import (
+    "context"
+
+    "github.com/gofiber/fiber/v2"
+    "go.mongodb.org/mongo-driver/bson"
+    "go.mongodb.org/mongo-driver/bson/primitive"
+    "go.mongodb.org/mongo-driver/mongo"
+    "go.mongodb.org/mongo-driver/mongo/options"
+    "formbuilder/backend/config"
+)
+
+// tenantStore is the only way authenticated handlers read or write forms and
+// responses. Every filter it sends to Mongo is pinned to the caller's
+// workspace, overriding any workspaceId the caller supplied, so one tenant
+// can never see another's data even if a handler forgets to check.
+type tenantStore struct {
+    cfg         *config.Config
+    workspaceID primitive.ObjectID
+}
+
+// storeFor returns the store for the request's active workspace, as set by
+// AuthMiddleware.
+func storeFor(c *fiber.Ctx, cfg *config.Config) *tenantStore {
+    ws, _ := c.Locals("workspaceID").(primitive.ObjectID)
+    return &tenantStore{cfg: cfg, workspaceID: ws}
+}
+
+func (s *tenantStore) scope(filter bson.M) bson.M {
+    scoped := bson.M{}
+    for k, v := range filter {
+        scoped[k] = v
+    }
+    // A zero workspace would match documents without one; never allow it.
+    scoped["workspaceId"] = s.workspaceID
+    if s.workspaceID.IsZero() {
+        scoped["_id"] = bson.M{"$exists": false}
+    }
+    return scoped
+}
+
+// liveForms excludes trashed forms unless the filter asks about deletedAt.
+func liveForms(filter bson.M) bson.M {
+    if _, ok := filter["deletedAt"]; ok {
+        return filter
+    }
+    live := bson.M{"deletedAt": nil}
+    for k, v := range filter {
+        live[k] = v
+    }
+    return live
+}
+
+func (s *tenantStore) FindForm(ctx context.Context, id primitive.ObjectID) (*Form, error) {
+    var f Form
+    if err := formsCol(s.cfg).FindOne(ctx, s.scope(bson.M{"_id": id, "deletedAt": nil})).Decode(&f); err != nil {
+        return nil, err
+    }
+    return &f, nil
+}
+
+func (s *tenantStore) FindForms(ctx context.Context, filter bson.M, opts ...*options.FindOptions) ([]Form, error) {
+    cur, err := formsCol(s.cfg).Find(ctx, s.scope(liveForms(filter)), opts...)
+    if err != nil {
+        return nil, err
+    }
+    defer cur.Close(ctx)
+    forms := []Form{}
+    if err := cur.All(ctx, &forms); err != nil {
+        return nil, err
+    }
+    return forms, nil
+}
+
+func (s *tenantStore) InsertForm(ctx context.Context, f *Form) error {
+    f.WorkspaceID = s.workspaceID
+    _, err := formsCol(s.cfg).InsertOne(ctx, f)
+    return err
+}
+
+func (s *tenantStore) UpdateForm(ctx context.Context, id primitive.ObjectID, update bson.M) (*mongo.UpdateResult, error) {
+    return formsCol(s.cfg).UpdateOne(ctx, s.scope(bson.M{"_id": id}), update)
+}
+
+func (s *tenantStore) FindResponses(ctx context.Context, filter bson.M, opts ...*options.FindOptions) (*mongo.Cursor, error) {
+    return responsesCol(s.cfg).Find(ctx, s.scope(filter), opts...)
+}
+
+func (s *tenantStore) FindResponse(ctx context.Context, formID, id primitive.ObjectID) (*Response, error) {
+    var r Response
+    if err := responsesCol(s.cfg).FindOne(ctx, s.scope(bson.M{"_id": id, "formId": formID})).Decode(&r); err != nil {
+        return nil, err
+    }
+    return &r, nil
+}
+
+func (s *tenantStore) UpdateResponse(ctx context.Context, id primitive.ObjectID, update bson.M) (*mongo.UpdateResult, error) {
+    return responsesCol(s.cfg).UpdateOne(ctx, s.scope(bson.M{"_id": id}), update)
+}
+
+func (s *tenantStore) CountResponses(ctx context.Context, filter bson.M) (int64, error) {
+    return responsesCol(s.cfg).CountDocuments(ctx, s.scope(filter))
+}
+
+// formFromParam loads the form named by the :id route parameter within the
+// caller's workspace. Forms in other workspaces are reported as not found.
+func formFromParam(c *fiber.Ctx, cfg *config.Config) (*Form, error) {
+    oid, err := primitive.ObjectIDFromHex(c.Params("id"))
+    if err != nil {
+        return nil, fiber.NewError(fiber.StatusBadRequest, "invalid id")
+    }
+    f, err := storeFor(c, cfg).FindForm(c.Context(), oid)
+    if err != nil {
+        if err == mongo.ErrNoDocuments {
+            return nil, fiber.NewError(fiber.StatusNotFound, "not found")
+        }
+        return nil, fiber.NewError(fiber.StatusInternalServerError, err.Error())
+    }
+    return f, nil
+}
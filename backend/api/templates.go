@@ -0,0 +1,351 @@
+package api
+
+import (
+    "embed"
+    "encoding/json"
+    "regexp"
+    "sort"
+    "strings"
+    "time"
+
+    "github.com/gofiber/fiber/v2"
+    "go.mongodb.org/mongo-driver/bson"
+    "go.mongodb.org/mongo-driver/bson/primitive"
+    "go.mongodb.org/mongo-driver/mongo"
+    "go.mongodb.org/mongo-driver/mongo/options"
+    "formbuilder/backend/config"
+)
+
+// Built-in templates shipped with the backend, one JSON file per template.
+//go:embed templates/*.json
+var builtinTemplateFiles embed.FS
+
+const systemTemplatePrefix = "system-"
+
+var templateCategories = []string{"feedback", "events", "support", "hr", "education", "marketing", "other"}
+
+// Template is a reusable form definition. System templates ship with the
+// backend and are visible to every workspace; the others belong to the
+// workspace that saved them. Field IDs are replaced when a form is created
+// from a template.
+type Template struct {
+    ID          string             `bson:"_id" json:"id"`
+    WorkspaceID primitive.ObjectID `bson:"workspaceId,omitempty" json:"-"`
+    System      bool               `bson:"-" json:"system"`
+    Name        string             `bson:"name" json:"name"`
+    Description string             `bson:"description,omitempty" json:"description,omitempty"`
+    Category    string             `bson:"category" json:"category"`
+
+    Fields            []Field         `bson:"fields" json:"fields"`
+    Rules             []Rule          `bson:"rules,omitempty" json:"rules,omitempty"`
+    Protection        *SpamProtection `bson:"protection,omitempty" json:"protection,omitempty"`
+    RespondentPolicy  string          `bson:"respondentPolicy,omitempty" json:"respondentPolicy,omitempty"`
+    EditWindowMinutes int             `bson:"editWindowMinutes,omitempty" json:"editWindowMinutes,omitempty"`
+    ClosedMessage     string          `bson:"closedMessage,omitempty" json:"closedMessage,omitempty"`
+
+    CreatedBy string    `bson:"createdBy,omitempty" json:"createdBy,omitempty"`
+    CreatedAt time.Time `bson:"createdAt" json:"createdAt"`
+}
+
+type SaveTemplateRequest struct {
+    Name        string `json:"name"`
+    Description string `json:"description"`
+    Category    string `json:"category"`
+}
+
+type UseTemplateRequest struct {
+    Title string `json:"title"` // defaults to the template name
+}
+
+// builtinTemplates is loaded once at startup; a broken built-in is a
+// programming error.
+var builtinTemplates = loadBuiltinTemplates()
+
+func loadBuiltinTemplates() []Template {
+    files, err := builtinTemplateFiles.ReadDir("templates")
+    if err != nil {
+        panic(err)
+    }
+    var out []Template
+    for _, file := range files {
+        b, err := builtinTemplateFiles.ReadFile("templates/" + file.Name())
+        if err != nil {
+            panic(err)
+        }
+        var t Template
+        if err := json.Unmarshal(b, &t); err != nil {
+            panic("template " + file.Name() + ": " + err.Error())
+        }
+        t.System = true
+        if !strings.HasPrefix(t.ID, systemTemplatePrefix) {
+            panic("template " + file.Name() + ": id must start with " + systemTemplatePrefix)
+        }
+        if err := validateForm(t.form()); err != nil {
+            panic("template " + file.Name() + ": " + err.Error())
+        }
+        out = append(out, t)
+    }
+    sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+    return out
+}
+
+func templatesCol(cfg *config.Config) *mongo.Collection {
+    return mongoClient(cfg).Database(cfg.MongoDB).Collection("templates")
+}
+
+// form returns the template's definition as a form, sharing its slices.
+func (t *Template) form() *Form {
+    return &Form{
+        Title:             t.Name,
+        Fields:            t.Fields,
+        Rules:             t.Rules,
+        Protection:        t.Protection,
+        RespondentPolicy:  t.RespondentPolicy,
+        EditWindowMinutes: t.EditWindowMinutes,
+        ClosedMessage:     t.ClosedMessage,
+    }
+}
+
+func (t *Template) matches(category, q string) bool {
+    if category != "" && t.Category != category {
+        return false
+    }
+    q = strings.ToLower(q)
+    return q == "" || strings.Contains(strings.ToLower(t.Name), q) || strings.Contains(strings.ToLower(t.Description), q)
+}
+
+func validCategory(c string) bool {
+    return containsString(templateCategories, c)
+}
+
+// findTemplate loads a system template or one saved in the workspace.
+func findTemplate(c *fiber.Ctx, cfg *config.Config, id string) (*Template, error) {
+    for i := range builtinTemplates {
+        if builtinTemplates[i].ID == id {
+            t := builtinTemplates[i]
+            return &t, nil
+        }
+    }
+    var t Template
+    ws, _ := c.Locals("workspaceID").(primitive.ObjectID)
+    if err := templatesCol(cfg).FindOne(c.Context(), bson.M{"_id": id, "workspaceId": ws}).Decode(&t); err != nil {
+        if err == mongo.ErrNoDocuments {
+            return nil, fiber.NewError(fiber.StatusNotFound, "template not found")
+        }
+        return nil, fiber.NewError(fiber.StatusInternalServerError, err.Error())
+    }
+    return &t, nil
+}
+
+// ListTemplatesHandler lists system and workspace templates, optionally
+// filtered by category and a case-insensitive search of name and
+// description (q).
+func ListTemplatesHandler(cfg *config.Config) fiber.Handler {
+    return func(c *fiber.Ctx) error {
+        if err := requirePermission(c, permFormsRead); err != nil {
+            return err
+        }
+        category, q := c.Query("category"), strings.TrimSpace(c.Query("q"))
+        if category != "" && !validCategory(category) {
+            return fiber.NewError(fiber.StatusBadRequest, "category must be one of "+strings.Join(templateCategories, ", "))
+        }
+        out := []Template{}
+        for _, t := range builtinTemplates {
+            if t.matches(category, q) {
+                out = append(out, t)
+            }
+        }
+
+        filter := bson.M{"workspaceId": c.Locals("workspaceID").(primitive.ObjectID)}
+        if category != "" {
+            filter["category"] = category
+        }
+        if q != "" {
+            pattern := primitive.Regex{Pattern: regexp.QuoteMeta(q), Options: "i"}
+            filter["$or"] = bson.A{bson.M{"name": pattern}, bson.M{"description": pattern}}
+        }
+        cur, err := templatesCol(cfg).Find(c.Context(), filter, options.Find().SetSort(bson.M{"name": 1}))
+        if err != nil {
+            return fiber.NewError(fiber.StatusInternalServerError, err.Error())
+        }
+        var saved []Template
+        if err := cur.All(c.Context(), &saved); err != nil {
+            return fiber.NewError(fiber.StatusInternalServerError, err.Error())
+        }
+        return c.JSON(append(out, saved...))
+    }
+}
+
+// ListTemplateCategoriesHandler returns every category with the number of
+// templates the workspace can see in it.
+func ListTemplateCategoriesHandler(cfg *config.Config) fiber.Handler {
+    return func(c *fiber.Ctx) error {
+        if err := requirePermission(c, permFormsRead); err != nil {
+            return err
+        }
+        counts := map[string]int{}
+        for _, t := range builtinTemplates {
+            counts[t.Category]++
+        }
+        cur, err := templatesCol(cfg).Aggregate(c.Context(), bson.A{
+            bson.M{"$match": bson.M{"workspaceId": c.Locals("workspaceID").(primitive.ObjectID)}},
+            bson.M{"$group": bson.M{"_id": "$category", "count": bson.M{"$sum": 1}}},
+        })
+        if err != nil {
+            return fiber.NewError(fiber.StatusInternalServerError, err.Error())
+        }
+        var groups []struct {
+            Category string `bson:"_id"`
+            Count    int    `bson:"count"`
+        }
+        if err := cur.All(c.Context(), &groups); err != nil {
+            return fiber.NewError(fiber.StatusInternalServerError, err.Error())
+        }
+        for _, g := range groups {
+            counts[g.Category] += g.Count
+        }
+        out := make([]fiber.Map, len(templateCategories))
+        for i, name := range templateCategories {
+            out[i] = fiber.Map{"category": name, "count": counts[name]}
+        }
+        return c.JSON(out)
+    }
+}
+
+func GetTemplateHandler(cfg *config.Config) fiber.Handler {
+    return func(c *fiber.Ctx) error {
+        if err := requirePermission(c, permFormsRead); err != nil {
+            return err
+        }
+        t, err := findTemplate(c, cfg, c.Params("templateId"))
+        if err != nil {
+            return err
+        }
+        return c.JSON(t)
+    }
+}
+
+// SaveTemplateHandler saves a form's fields, logic and respondent settings
+// as a workspace template. Schedules, notifications and webhooks are left
+// out since they only make sense for the original form.
+func SaveTemplateHandler(cfg *config.Config) fiber.Handler {
+    return func(c *fiber.Ctx) error {
+        if err := requirePermission(c, permFormsWrite); err != nil {
+            return err
+        }
+        f, err := formFromParam(c, cfg)
+        if err != nil {
+            return err
+        }
+        var req SaveTemplateRequest
+        if err := c.BodyParser(&req); err != nil {
+            return fiber.NewError(fiber.StatusBadRequest, "Invalid request body")
+        }
+        name := strings.TrimSpace(req.Name)
+        if name == "" {
+            name = f.Title
+        }
+        if req.Category == "" {
+            req.Category = "other"
+        }
+        if !validCategory(req.Category) {
+            return fiber.NewError(fiber.StatusBadRequest, "category must be one of "+strings.Join(templateCategories, ", "))
+        }
+
+        // Rule actions that target this form's webhooks or members do not carry over
+        var rules []Rule
+        for _, rule := range f.Rules {
+            var actions []Action
+            for _, a := range rule.Actions {
+                if a.Type != "webhook" && a.Type != "assign" {
+                    actions = append(actions, a)
+                }
+            }
+            rule.Actions = actions
+            rules = append(rules, rule)
+        }
+        t := Template{
+            ID:                primitive.NewObjectID().Hex(),
+            WorkspaceID:       f.WorkspaceID,
+            Name:              name,
+            Description:       strings.TrimSpace(req.Description),
+            Category:          req.Category,
+            Fields:            f.Fields,
+            Rules:             rules,
+            Protection:        f.Protection,
+            RespondentPolicy:  f.RespondentPolicy,
+            EditWindowMinutes: f.EditWindowMinutes,
+            ClosedMessage:     f.ClosedMessage,
+            CreatedBy:         c.Locals("userID").(string),
+            CreatedAt:         time.Now(),
+        }
+        if _, err := templatesCol(cfg).InsertOne(c.Context(), t); err != nil {
+            return fiber.NewError(fiber.StatusInternalServerError, err.Error())
+        }
+        recordAudit(c, cfg, AuditEvent{Action: "template.created", TargetType: "template", TargetID: t.ID, FormID: f.ID.Hex()})
+        return c.Status(fiber.StatusCreated).JSON(t)
+    }
+}
+
+// UseTemplateHandler creates a draft form from a template with fresh field
+// IDs.
+func UseTemplateHandler(cfg *config.Config) fiber.Handler {
+    return func(c *fiber.Ctx) error {
+        if err := requirePermission(c, permFormsWrite); err != nil {
+            return err
+        }
+        t, err := findTemplate(c, cfg, c.Params("templateId"))
+        if err != nil {
+            return err
+        }
+        var req UseTemplateRequest
+        if len(c.Body()) > 0 {
+            if err := c.BodyParser(&req); err != nil {
+                return fiber.NewError(fiber.StatusBadRequest, "Invalid request body")
+            }
+        }
+
+        f := t.form()
+        f.Fields = append([]Field(nil), t.Fields...)
+        remapFieldIDs(f)
+        if title := strings.TrimSpace(req.Title); title != "" {
+            f.Title = title
+        }
+        if err := validateForm(f); err != nil {
+            return err
+        }
+        f.ID = primitive.NewObjectID()
+        f.Status = statusDraft
+        f.OwnerID = c.Locals("userID").(string)
+        f.CreatedAt = time.Now()
+        f.UpdatedAt = f.CreatedAt
+        if err := storeFor(c, cfg).InsertForm(c.Context(), f); err != nil {
+            return fiber.NewError(fiber.StatusInternalServerError, err.Error())
+        }
+        ev := formAudit("form.created", f)
+        ev.Metadata = map[string]interface{}{"templateId": t.ID}
+        recordAudit(c, cfg, ev)
+        return c.Status(fiber.StatusCreated).JSON(f)
+    }
+}
+
+func DeleteTemplateHandler(cfg *config.Config) fiber.Handler {
+    return func(c *fiber.Ctx) error {
+        if err := requirePermission(c, permFormsWrite); err != nil {
+            return err
+        }
+        id := c.Params("templateId")
+        if strings.HasPrefix(id, systemTemplatePrefix) {
+            return fiber.NewError(fiber.StatusForbidden, "System templates cannot be deleted")
+        }
+        res, err := templatesCol(cfg).DeleteOne(c.Context(), bson.M{"_id": id, "workspaceId": c.Locals("workspaceID").(primitive.ObjectID)})
+        if err != nil {
+            return fiber.NewError(fiber.StatusInternalServerError, err.Error())
+        }
+        if res.DeletedCount == 0 {
+            return fiber.NewError(fiber.StatusNotFound, "template not found")
+        }
+        recordAudit(c, cfg, AuditEvent{Action: "template.deleted", TargetType: "template", TargetID: id})
+        return c.SendStatus(fiber.StatusNoContent)
+    }
+}
@@ -0,0 +1,792 @@
+package api
+
+import (
+    "bytes"
+    "context"
+    "crypto/hmac"
+    "crypto/rand"
+    "crypto/sha256"
+    "encoding/hex"
+    "encoding/json"
+    "fmt"
+    "io"
+    "log"
+    "math"
+    "net"
+    "net/http"
+    "net/url"
+    "strconv"
+    "sync"
+    "syscall"
+    "time"
+
+    "github.com/gofiber/fiber/v2"
+    "go.mongodb.org/mongo-driver/bson"
+    "go.mongodb.org/mongo-driver/bson/primitive"
+    "go.mongodb.org/mongo-driver/mongo"
+    "go.mongodb.org/mongo-driver/mongo/options"
+    "formbuilder/backend/config"
+)
+
+// Webhook events
+const (
+    eventResponseCreated = "response.created"
+    eventResponseUpdated = "response.updated"
+    eventFormOpened      = "form.opened"
+    eventFormClosed      = "form.closed"
+    eventTest            = "test"
+)
+
+var webhookEvents = []string{eventResponseCreated, eventResponseUpdated, eventFormOpened, eventFormClosed}
+
+// Delivery states. A delivery is retried with exponential backoff until it
+// succeeds or runs out of attempts, at which point it is dead-lettered and
+// only an explicit redeliver will try it again.
+const (
+    deliveryPending   = "pending"
+    deliverySucceeded = "succeeded"
+    deliveryDead      = "dead"
+)
+
+const (
+    webhookTimeout      = 10 * time.Second
+    webhookPollInterval = 5 * time.Second
+    // A claimed delivery is hidden from other workers for this long, so a
+    // crash mid-send leads to a retry rather than a lost delivery.
+    webhookLease = time.Minute
+    // Finished deliveries stay in the log this long
+    deliveryLogRetention = 30 * 24 * time.Hour
+)
+
+// Webhook is a per-form subscription. Payloads are signed with Secret, which
+// is only returned when the webhook is created.
+type Webhook struct {
+    ID          primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+    FormID      primitive.ObjectID `bson:"formId" json:"formId"`
+    WorkspaceID primitive.ObjectID `bson:"workspaceId" json:"-"`
+    URL         string             `bson:"url" json:"url"`
+    Events      []string           `bson:"events" json:"events"`
+    Secret      string             `bson:"secret" json:"secret,omitempty"`
+    Active      bool               `bson:"active" json:"active"`
+    CreatedBy   string             `bson:"createdBy" json:"createdBy"`
+    CreatedAt   time.Time          `bson:"createdAt" json:"createdAt"`
+    UpdatedAt   time.Time          `bson:"updatedAt" json:"updatedAt"`
+}
+
+// WebhookDelivery is both the queue entry and the delivery log. Payload is
+// stored with PII answers as they are stored on the response, so encrypted
+// when encryption at rest is on; they are decrypted when the delivery is
+// sent, and every retry sends the same body. The receiver's response body
+// is not kept, so the log cannot be used to read internal services.
+type WebhookDelivery struct {
+    ID             primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+    WebhookID      primitive.ObjectID `bson:"webhookId" json:"webhookId"`
+    FormID         primitive.ObjectID `bson:"formId" json:"formId"`
+    WorkspaceID    primitive.ObjectID `bson:"workspaceId" json:"-"`
+    Event          string             `bson:"event" json:"event"`
+    Payload        string             `bson:"payload" json:"payload"`
+    Status         string             `bson:"status" json:"status"`
+    Attempts       int                `bson:"attempts" json:"attempts"`
+    NextAttemptAt  time.Time          `bson:"nextAttemptAt" json:"nextAttemptAt"`
+    LastStatusCode int                `bson:"lastStatusCode,omitempty" json:"lastStatusCode,omitempty"`
+    LastError      string             `bson:"lastError,omitempty" json:"lastError,omitempty"`
+    CreatedAt      time.Time          `bson:"createdAt" json:"createdAt"`
+    DeliveredAt    *time.Time         `bson:"deliveredAt,omitempty" json:"deliveredAt,omitempty"`
+    ExpiresAt      *time.Time         `bson:"expiresAt,omitempty" json:"-"`
+}
+
+type WebhookRequest struct {
+    URL    string   `json:"url"`
+    Events []string `json:"events"`
+    Secret string   `json:"secret"`
+    Active *bool    `json:"active"`
+}
+
+func webhooksCol(cfg *config.Config) *mongo.Collection {
+    return mongoClient(cfg).Database(cfg.MongoDB).Collection("webhooks")
+}
+
+func deliveriesCol(cfg *config.Config) *mongo.Collection {
+    return mongoClient(cfg).Database(cfg.MongoDB).Collection("webhook_deliveries")
+}
+
+var (
+    webhookClientMu sync.Mutex
+    _webhookClient  *http.Client
+)
+
+func webhookClient() *http.Client {
+    webhookClientMu.Lock()
+    defer webhookClientMu.Unlock()
+    if _webhookClient == nil {
+        _webhookClient = newWebhookClient()
+    }
+    return _webhookClient
+}
+
+// newWebhookClient returns a client that only connects to public
+// addresses. The check runs on the address actually dialed, after DNS
+// resolution and for every redirect, so a hostname that resolves (or is
+// later rebound) to an internal address is refused too. Proxies from the
+// environment are not used, since they would dial on the client's behalf.
+func newWebhookClient() *http.Client {
+    dialer := &net.Dialer{Timeout: webhookTimeout, Control: publicAddressOnly}
+    return &http.Client{
+        Timeout: webhookTimeout,
+        Transport: &http.Transport{
+            DialContext:         dialer.DialContext,
+            TLSHandshakeTimeout: webhookTimeout,
+            MaxIdleConns:        100,
+            IdleConnTimeout:     90 * time.Second,
+        },
+    }
+}
+
+// cgnat is the shared address space carriers use behind NAT (RFC 6598).
+var cgnat = &net.IPNet{IP: net.IPv4(100, 64, 0, 0), Mask: net.CIDRMask(10, 32)}
+
+// internalIP reports whether ip is loopback, private, link-local (which
+// includes cloud metadata endpoints), multicast or unspecified.
+func internalIP(ip net.IP) bool {
+    return ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+        ip.IsInterfaceLocalMulticast() || ip.IsMulticast() || ip.IsUnspecified() || cgnat.Contains(ip)
+}
+
+func publicAddressOnly(network, address string, _ syscall.RawConn) error {
+    host, _, err := net.SplitHostPort(address)
+    if err != nil {
+        return err
+    }
+    ip := net.ParseIP(host)
+    if ip == nil || internalIP(ip) {
+        return fmt.Errorf("webhook address %s is not public", host)
+    }
+    return nil
+}
+
+// SetWebhookClient replaces the HTTP client used for deliveries, e.g. to
+// point it at a local stand-in receiver.
+func SetWebhookClient(c *http.Client) {
+    webhookClientMu.Lock()
+    defer webhookClientMu.Unlock()
+    _webhookClient = c
+}
+
+// signWebhook returns the X-FormBuilder-Signature header value. Receivers
+// recompute HMAC-SHA256(secret, timestamp + "." + body) and compare; the
+// timestamp lets them reject replays.
+func signWebhook(secret string, ts int64, body []byte) string {
+    mac := hmac.New(sha256.New, []byte(secret))
+    fmt.Fprintf(mac, "%d.", ts)
+    mac.Write(body)
+    return fmt.Sprintf("t=%d,v1=%s", ts, hex.EncodeToString(mac.Sum(nil)))
+}
+
+func validateWebhook(req *WebhookRequest) error {
+    u, err := url.Parse(req.URL)
+    if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+        return fiber.NewError(fiber.StatusBadRequest, "url must be an absolute http or https URL")
+    }
+    // Names are checked when dialing; literal addresses can be refused now
+    if ip := net.ParseIP(u.Hostname()); ip != nil && internalIP(ip) || u.Hostname() == "localhost" {
+        return fiber.NewError(fiber.StatusBadRequest, "url must point to a public address")
+    }
+    if len(req.Events) == 0 {
+        return fiber.NewError(fiber.StatusBadRequest, "At least one event is required")
+    }
+    for _, e := range req.Events {
+        known := false
+        for _, k := range webhookEvents {
+            if e == k {
+                known = true
+                break
+            }
+        }
+        if !known {
+            return fiber.NewError(fiber.StatusBadRequest, "unknown event: "+e)
+        }
+    }
+    return nil
+}
+
+func newWebhookSecret() (string, error) {
+    buf := make([]byte, 24)
+    if _, err := rand.Read(buf); err != nil {
+        return "", err
+    }
+    return "whsec_" + hex.EncodeToString(buf), nil
+}
+
+// webhookPayload builds the signed body for an event.
+func webhookPayload(event string, deliveryID primitive.ObjectID, f *Form, data interface{}) ([]byte, error) {
+    return json.Marshal(fiber.Map{
+        "id":        deliveryID.Hex(),
+        "event":     event,
+        "formId":    f.ID.Hex(),
+        "createdAt": time.Now().UTC(),
+        "data":      data,
+    })
+}
+
+// enqueueWebhookEvent queues a delivery for every active webhook on the form
+// subscribed to the event. Failures are logged so webhooks never break the
+// request that triggered them.
+func enqueueWebhookEvent(ctx context.Context, cfg *config.Config, f *Form, event string, data interface{}) {
+    cur, err := webhooksCol(cfg).Find(ctx, bson.M{"formId": f.ID, "active": true, "events": event})
+    if err != nil {
+        log.Printf("webhooks: form=%s event=%s: %v", f.ID.Hex(), event, err)
+        return
+    }
+    var hooks []Webhook
+    if err := cur.All(ctx, &hooks); err != nil {
+        log.Printf("webhooks: form=%s event=%s: %v", f.ID.Hex(), event, err)
+        return
+    }
+    for _, h := range hooks {
+        if _, err := queueDelivery(ctx, cfg, &h, f, event, data); err != nil {
+            log.Printf("webhooks: webhook=%s event=%s: %v", h.ID.Hex(), event, err)
+        }
+    }
+}
+
+func queueDelivery(ctx context.Context, cfg *config.Config, h *Webhook, f *Form, event string, data interface{}) (*WebhookDelivery, error) {
+    d := WebhookDelivery{
+        ID:            primitive.NewObjectID(),
+        WebhookID:     h.ID,
+        FormID:        h.FormID,
+        WorkspaceID:   h.WorkspaceID,
+        Event:         event,
+        Status:        deliveryPending,
+        NextAttemptAt: time.Now(),
+        CreatedAt:     time.Now(),
+    }
+    body, err := webhookPayload(event, d.ID, f, data)
+    if err != nil {
+        return nil, err
+    }
+    keys, err := encryptionKeys(ctx, cfg, f)
+    if err != nil {
+        return nil, err
+    }
+    if d.Payload, err = mapPayloadAnswers(string(body), func(answers map[string]interface{}) (map[string]interface{}, error) {
+        return keys.encryptAnswers(f, answers)
+    }); err != nil {
+        return nil, err
+    }
+    if _, err := deliveriesCol(cfg).InsertOne(ctx, d); err != nil {
+        return nil, err
+    }
+    return &d, nil
+}
+
+// webhookBackoff is the wait before the next attempt after the given number
+// of failed attempts.
+func webhookBackoff(cfg *config.Config, attempts int) time.Duration {
+    d := time.Duration(float64(cfg.WebhookRetryBase) * math.Pow(2, float64(attempts-1)))
+    if d <= 0 || d > cfg.WebhookRetryMax {
+        d = cfg.WebhookRetryMax
+    }
+    return d
+}
+
+// deliveryOutcome decides what happens to a delivery after an attempt: it
+// succeeded, is retried after the returned wait, or is dead-lettered once
+// it has used WebhookMaxAttempts.
+func deliveryOutcome(cfg *config.Config, attempts, code int, err error) (string, time.Duration) {
+    switch {
+    case err == nil && code >= 200 && code < 300:
+        return deliverySucceeded, 0
+    case attempts >= cfg.WebhookMaxAttempts:
+        return deliveryDead, 0
+    }
+    return deliveryPending, webhookBackoff(cfg, attempts)
+}
+
+// processWebhookDeliveries sends every due delivery. Each one is claimed
+// with a conditional update so several server instances can share the queue.
+func processWebhookDeliveries(ctx context.Context, cfg *config.Config) {
+    for ctx.Err() == nil {
+        now := time.Now()
+        var d WebhookDelivery
+        err := deliveriesCol(cfg).FindOneAndUpdate(ctx,
+            bson.M{"status": deliveryPending, "nextAttemptAt": bson.M{"$lte": now}},
+            bson.M{"$set": bson.M{"nextAttemptAt": now.Add(webhookLease)}, "$inc": bson.M{"attempts": 1}},
+            options.FindOneAndUpdate().SetSort(bson.M{"nextAttemptAt": 1}).SetReturnDocument(options.After),
+        ).Decode(&d)
+        if err == mongo.ErrNoDocuments {
+            return
+        }
+        if err != nil {
+            log.Printf("webhooks: %v", err)
+            return
+        }
+        attemptDelivery(cfg, &d)
+    }
+}
+
+// attemptDelivery sends one delivery and records the outcome. It uses its
+// own context so a slow receiver cannot outlive the polling job's deadline
+// between the send and the bookkeeping.
+func attemptDelivery(cfg *config.Config, d *WebhookDelivery) {
+    ctx, cancel := context.WithTimeout(context.Background(), webhookTimeout+5*time.Second)
+    defer cancel()
+
+    var h Webhook
+    err := webhooksCol(cfg).FindOne(ctx, bson.M{"_id": d.WebhookID}).Decode(&h)
+    if err == mongo.ErrNoDocuments {
+        finishDelivery(ctx, cfg, d, deliveryDead, 0, "webhook deleted")
+        return
+    }
+    if err != nil {
+        log.Printf("webhooks: delivery=%s: %v", d.ID.Hex(), err)
+        return
+    }
+
+    body, err := decryptedPayload(ctx, cfg, d)
+    if err != nil {
+        log.Printf("webhooks: delivery=%s: %v", d.ID.Hex(), err)
+        return
+    }
+    code, err := sendWebhook(ctx, &h, d, body)
+    switch status, wait := deliveryOutcome(cfg, d.Attempts, code, err); status {
+    case deliverySucceeded:
+        finishDelivery(ctx, cfg, d, status, code, "")
+    case deliveryDead:
+        finishDelivery(ctx, cfg, d, status, code, deliveryError(code, err))
+    default:
+        set := bson.M{
+            "nextAttemptAt":  time.Now().Add(wait),
+            "lastStatusCode": code,
+            "lastError":      deliveryError(code, err),
+        }
+        if _, err := deliveriesCol(cfg).UpdateOne(ctx, bson.M{"_id": d.ID}, bson.M{"$set": set}); err != nil {
+            log.Printf("webhooks: delivery=%s: %v", d.ID.Hex(), err)
+        }
+    }
+}
+
+// decryptedPayload returns the body to send for a delivery, with the
+// stored PII answers decrypted.
+func decryptedPayload(ctx context.Context, cfg *config.Config, d *WebhookDelivery) ([]byte, error) {
+    keys, err := loadFormKeys(ctx, cfg, d.FormID)
+    if err != nil {
+        return nil, err
+    }
+    body, err := mapPayloadAnswers(d.Payload, func(answers map[string]interface{}) (map[string]interface{}, error) {
+        return keys.decryptAnswers(answers), nil
+    })
+    return []byte(body), err
+}
+
+// mapPayloadAnswers rewrites the answers, and the previous answers in the
+// edit history, of the response a payload carries: the data itself for
+// response events, or data.response for rule.matched. Payloads without a
+// response are returned unchanged.
+func mapPayloadAnswers(payload string, fn func(map[string]interface{}) (map[string]interface{}, error)) (string, error) {
+    var p map[string]interface{}
+    if err := json.Unmarshal([]byte(payload), &p); err != nil {
+        return "", err
+    }
+    data, ok := p["data"].(map[string]interface{})
+    if !ok {
+        return payload, nil
+    }
+    if r, ok := data["response"].(map[string]interface{}); ok {
+        data = r
+    }
+    if _, ok := data["answers"]; !ok {
+        return payload, nil
+    }
+    apply := func(v interface{}) (interface{}, error) {
+        answers, ok := v.(map[string]interface{})
+        if !ok {
+            return v, nil
+        }
+        return fn(answers)
+    }
+    var err error
+    if data["answers"], err = apply(data["answers"]); err != nil {
+        return "", err
+    }
+    if edits, ok := data["edits"].([]interface{}); ok {
+        for _, e := range edits {
+            if edit, ok := e.(map[string]interface{}); ok {
+                if edit["previous"], err = apply(edit["previous"]); err != nil {
+                    return "", err
+                }
+            }
+        }
+    }
+    b, err := json.Marshal(p)
+    return string(b), err
+}
+
+// visiblePayload returns a delivery's payload as the caller may see it:
+// PII answers decrypted when canPII, redacted otherwise.
+func visiblePayload(f *Form, keys *formKeys, canPII bool, payload string) string {
+    out, err := mapPayloadAnswers(payload, func(answers map[string]interface{}) (map[string]interface{}, error) {
+        if !canPII {
+            answers = maskAnswers(f, answers)
+        }
+        return keys.decryptAnswers(answers), nil
+    })
+    if err != nil {
+        return ""
+    }
+    return out
+}
+
+func deliveryError(code int, err error) string {
+    if err != nil {
+        return err.Error()
+    }
+    return "receiver responded " + strconv.Itoa(code)
+}
+
+func sendWebhook(ctx context.Context, h *Webhook, d *WebhookDelivery, body []byte) (int, error) {
+    req, err := http.NewRequestWithContext(ctx, http.MethodPost, h.URL, bytes.NewReader(body))
+    if err != nil {
+        return 0, err
+    }
+    req.Header.Set("Content-Type", "application/json")
+    req.Header.Set("User-Agent", "FormBuilder-Webhooks/1.0")
+    req.Header.Set("X-FormBuilder-Event", d.Event)
+    req.Header.Set("X-FormBuilder-Delivery", d.ID.Hex())
+    req.Header.Set("X-FormBuilder-Signature", signWebhook(h.Secret, time.Now().Unix(), body))
+
+    resp, err := webhookClient().Do(req)
+    if err != nil {
+        return 0, err
+    }
+    defer resp.Body.Close()
+    // Drain a little so the connection can be reused
+    io.Copy(io.Discard, io.LimitReader(resp.Body, 4096))
+    return resp.StatusCode, nil
+}
+
+func finishDelivery(ctx context.Context, cfg *config.Config, d *WebhookDelivery, status string, code int, errMsg string) {
+    now := time.Now()
+    expires := now.Add(deliveryLogRetention)
+    set := bson.M{
+        "status":         status,
+        "lastStatusCode": code,
+        "lastError":      errMsg,
+        "expiresAt":      expires,
+    }
+    if status == deliverySucceeded {
+        set["deliveredAt"] = now
+    }
+    if _, err := deliveriesCol(cfg).UpdateOne(ctx, bson.M{"_id": d.ID}, bson.M{"$set": set}); err != nil {
+        log.Printf("webhooks: delivery=%s: %v", d.ID.Hex(), err)
+        return
+    }
+    d.Status, d.LastStatusCode, d.LastError = status, code, errMsg
+    if status == deliverySucceeded {
+        d.DeliveredAt = &now
+    }
+    if status == deliveryDead {
+        log.Printf("webhooks: delivery=%s dead-lettered after %d attempts: %s", d.ID.Hex(), d.Attempts, errMsg)
+    }
+}
+
+// webhookFromParam loads the :webhookId webhook on an already scoped form.
+func webhookFromParam(c *fiber.Ctx, cfg *config.Config, f *Form) (*Webhook, error) {
+    oid, err := primitive.ObjectIDFromHex(c.Params("webhookId"))
+    if err != nil {
+        return nil, fiber.NewError(fiber.StatusBadRequest, "invalid webhook id")
+    }
+    var h Webhook
+    if err := webhooksCol(cfg).FindOne(c.Context(), bson.M{"_id": oid, "formId": f.ID, "workspaceId": f.WorkspaceID}).Decode(&h); err != nil {
+        if err == mongo.ErrNoDocuments {
+            return nil, fiber.NewError(fiber.StatusNotFound, "webhook not found")
+        }
+        return nil, fiber.NewError(fiber.StatusInternalServerError, err.Error())
+    }
+    return &h, nil
+}
+
+func webhookAudit(action string, h *Webhook) AuditEvent {
+    return AuditEvent{Action: action, TargetType: "webhook", TargetID: h.ID.Hex(), FormID: h.FormID.Hex(),
+        Metadata: map[string]interface{}{"url": h.URL, "events": h.Events}}
+}
+
+func ListWebhooksHandler(cfg *config.Config) fiber.Handler {
+    return func(c *fiber.Ctx) error {
+        if err := requirePermission(c, permFormsWrite); err != nil {
+            return err
+        }
+        f, err := formFromParam(c, cfg)
+        if err != nil {
+            return err
+        }
+        cur, err := webhooksCol(cfg).Find(c.Context(), bson.M{"formId": f.ID, "workspaceId": f.WorkspaceID},
+            options.Find().SetSort(bson.M{"createdAt": 1}).SetProjection(bson.M{"secret": 0}))
+        if err != nil {
+            return fiber.NewError(fiber.StatusInternalServerError, err.Error())
+        }
+        hooks := []Webhook{}
+        if err := cur.All(c.Context(), &hooks); err != nil {
+            return fiber.NewError(fiber.StatusInternalServerError, err.Error())
+        }
+        return c.JSON(hooks)
+    }
+}
+
+func CreateWebhookHandler(cfg *config.Config) fiber.Handler {
+    return func(c *fiber.Ctx) error {
+        if err := requirePermission(c, permFormsWrite); err != nil {
+            return err
+        }
+        f, err := formFromParam(c, cfg)
+        if err != nil {
+            return err
+        }
+        var req WebhookRequest
+        if err := c.BodyParser(&req); err != nil {
+            return fiber.NewError(fiber.StatusBadRequest, "Invalid request body")
+        }
+        if err := validateWebhook(&req); err != nil {
+            return err
+        }
+        if req.Secret == "" {
+            if req.Secret, err = newWebhookSecret(); err != nil {
+                return fiber.NewError(fiber.StatusInternalServerError, err.Error())
+            }
+        }
+        h := Webhook{
+            ID:          primitive.NewObjectID(),
+            FormID:      f.ID,
+            WorkspaceID: f.WorkspaceID,
+            URL:         req.URL,
+            Events:      req.Events,
+            Secret:      req.Secret,
+            Active:      req.Active == nil || *req.Active,
+            CreatedBy:   c.Locals("userID").(string),
+            CreatedAt:   time.Now(),
+        }
+        h.UpdatedAt = h.CreatedAt
+        if _, err := webhooksCol(cfg).InsertOne(c.Context(), h); err != nil {
+            return fiber.NewError(fiber.StatusInternalServerError, err.Error())
+        }
+        recordAudit(c, cfg, webhookAudit("webhook.created", &h))
+        return c.Status(fiber.StatusCreated).JSON(h)
+    }
+}
+
+func UpdateWebhookHandler(cfg *config.Config) fiber.Handler {
+    return func(c *fiber.Ctx) error {
+        if err := requirePermission(c, permFormsWrite); err != nil {
+            return err
+        }
+        f, err := formFromParam(c, cfg)
+        if err != nil {
+            return err
+        }
+        h, err := webhookFromParam(c, cfg, f)
+        if err != nil {
+            return err
+        }
+        req := WebhookRequest{URL: h.URL, Events: h.Events}
+        if err := c.BodyParser(&req); err != nil {
+            return fiber.NewError(fiber.StatusBadRequest, "Invalid request body")
+        }
+        if err := validateWebhook(&req); err != nil {
+            return err
+        }
+        set := bson.M{"url": req.URL, "events": req.Events, "updatedAt": time.Now()}
+        if req.Active != nil {
+            set["active"] = *req.Active
+        }
+        if req.Secret != "" {
+            set["secret"] = req.Secret
+        }
+        if _, err := webhooksCol(cfg).UpdateOne(c.Context(), bson.M{"_id": h.ID}, bson.M{"$set": set}); err != nil {
+            return fiber.NewError(fiber.StatusInternalServerError, err.Error())
+        }
+        h, err = webhookFromParam(c, cfg, f)
+        if err != nil {
+            return err
+        }
+        ev := webhookAudit("webhook.updated", h)
+        if req.Secret != "" {
+            ev.Changes = []string{"secret rotated"}
+        }
+        recordAudit(c, cfg, ev)
+        h.Secret = ""
+        return c.JSON(h)
+    }
+}
+
+func DeleteWebhookHandler(cfg *config.Config) fiber.Handler {
+    return func(c *fiber.Ctx) error {
+        if err := requirePermission(c, permFormsWrite); err != nil {
+            return err
+        }
+        f, err := formFromParam(c, cfg)
+        if err != nil {
+            return err
+        }
+        h, err := webhookFromParam(c, cfg, f)
+        if err != nil {
+            return err
+        }
+        if _, err := webhooksCol(cfg).DeleteOne(c.Context(), bson.M{"_id": h.ID}); err != nil {
+            return fiber.NewError(fiber.StatusInternalServerError, err.Error())
+        }
+        // Anything still queued would only be dead-lettered by the worker
+        _, _ = deliveriesCol(cfg).DeleteMany(c.Context(), bson.M{"webhookId": h.ID, "status": deliveryPending})
+        recordAudit(c, cfg, webhookAudit("webhook.deleted", h))
+        return c.SendStatus(fiber.StatusNoContent)
+    }
+}
+
+// TestWebhookHandler sends a "test" event right away and returns the
+// delivery record, so the caller sees the receiver's status code without
+// waiting for the queue. Failed test deliveries are not retried.
+func TestWebhookHandler(cfg *config.Config) fiber.Handler {
+    return func(c *fiber.Ctx) error {
+        if err := requirePermission(c, permFormsWrite); err != nil {
+            return err
+        }
+        f, err := formFromParam(c, cfg)
+        if err != nil {
+            return err
+        }
+        h, err := webhookFromParam(c, cfg, f)
+        if err != nil {
+            return err
+        }
+        d := WebhookDelivery{
+            ID:          primitive.NewObjectID(),
+            WebhookID:   h.ID,
+            FormID:      h.FormID,
+            WorkspaceID: h.WorkspaceID,
+            Event:       eventTest,
+            Status:      deliveryPending,
+            Attempts:    1,
+            CreatedAt:   time.Now(),
+        }
+        body, err := webhookPayload(eventTest, d.ID, f, fiber.Map{"message": "This is a test event from FormBuilder"})
+        if err != nil {
+            return fiber.NewError(fiber.StatusInternalServerError, err.Error())
+        }
+        d.Payload = string(body)
+        d.NextAttemptAt = d.CreatedAt
+        // Insert as already claimed so the worker leaves it alone
+        claimed := d
+        claimed.NextAttemptAt = d.CreatedAt.Add(webhookLease)
+        if _, err := deliveriesCol(cfg).InsertOne(c.Context(), claimed); err != nil {
+            return fiber.NewError(fiber.StatusInternalServerError, err.Error())
+        }
+
+        code, sendErr := sendWebhook(c.Context(), h, &d, body)
+        status := deliveryDead
+        errMsg := ""
+        if sendErr == nil && code >= 200 && code < 300 {
+            status = deliverySucceeded
+        } else {
+            errMsg = deliveryError(code, sendErr)
+        }
+        finishDelivery(c.Context(), cfg, &d, status, code, errMsg)
+        return c.JSON(d)
+    }
+}
+
+// ListDeliveriesHandler shows a webhook's delivery log, newest first. Pass
+// status=dead to list dead-lettered deliveries. PII answers in payloads are
+// redacted unless the caller may read PII.
+func ListDeliveriesHandler(cfg *config.Config) fiber.Handler {
+    return func(c *fiber.Ctx) error {
+        if err := requirePermission(c, permFormsWrite); err != nil {
+            return err
+        }
+        f, err := formFromParam(c, cfg)
+        if err != nil {
+            return err
+        }
+        h, err := webhookFromParam(c, cfg, f)
+        if err != nil {
+            return err
+        }
+        filter := bson.M{"webhookId": h.ID}
+        if s := c.Query("status"); s != "" {
+            filter["status"] = s
+        }
+        if v := c.Query("before"); v != "" {
+            oid, err := primitive.ObjectIDFromHex(v)
+            if err != nil {
+                return fiber.NewError(fiber.StatusBadRequest, "invalid before cursor")
+            }
+            filter["_id"] = bson.M{"$lt": oid}
+        }
+        limit := c.QueryInt("limit", auditDefaultLimit)
+        if limit <= 0 || limit > auditMaxLimit {
+            limit = auditDefaultLimit
+        }
+        cur, err := deliveriesCol(cfg).Find(c.Context(), filter,
+            options.Find().SetSort(bson.D{{Key: "_id", Value: -1}}).SetLimit(int64(limit)))
+        if err != nil {
+            return fiber.NewError(fiber.StatusInternalServerError, err.Error())
+        }
+        deliveries := []WebhookDelivery{}
+        if err := cur.All(c.Context(), &deliveries); err != nil {
+            return fiber.NewError(fiber.StatusInternalServerError, err.Error())
+        }
+        keys, err := loadFormKeys(c.Context(), cfg, f.ID)
+        if err != nil {
+            return fiber.NewError(fiber.StatusInternalServerError, err.Error())
+        }
+        canPII := canReadPII(c, cfg, f)
+        for i := range deliveries {
+            deliveries[i].Payload = visiblePayload(f, keys, canPII, deliveries[i].Payload)
+        }
+        resp := fiber.Map{"deliveries": deliveries}
+        if len(deliveries) == limit {
+            resp["nextBefore"] = deliveries[len(deliveries)-1].ID.Hex()
+        }
+        return c.JSON(resp)
+    }
+}
+
+// RedeliverHandler puts a delivery back on the queue with a fresh set of
+// attempts, e.g. after the receiver has been fixed.
+func RedeliverHandler(cfg *config.Config) fiber.Handler {
+    return func(c *fiber.Ctx) error {
+        if err := requirePermission(c, permFormsWrite); err != nil {
+            return err
+        }
+        f, err := formFromParam(c, cfg)
+        if err != nil {
+            return err
+        }
+        h, err := webhookFromParam(c, cfg, f)
+        if err != nil {
+            return err
+        }
+        oid, err := primitive.ObjectIDFromHex(c.Params("deliveryId"))
+        if err != nil {
+            return fiber.NewError(fiber.StatusBadRequest, "invalid delivery id")
+        }
+        var d WebhookDelivery
+        err = deliveriesCol(cfg).FindOneAndUpdate(c.Context(),
+            bson.M{"_id": oid, "webhookId": h.ID, "status": bson.M{"$ne": deliveryPending}},
+            bson.M{
+                "$set":   bson.M{"status": deliveryPending, "attempts": 0, "nextAttemptAt": time.Now()},
+                "$unset": bson.M{"expiresAt": ""},
+            },
+            options.FindOneAndUpdate().SetReturnDocument(options.After),
+        ).Decode(&d)
+        if err == mongo.ErrNoDocuments {
+            return fiber.NewError(fiber.StatusNotFound, "delivery not found or already queued")
+        }
+        if err != nil {
+            return fiber.NewError(fiber.StatusInternalServerError, err.Error())
+        }
+        keys, err := loadFormKeys(c.Context(), cfg, f.ID)
+        if err != nil {
+            return fiber.NewError(fiber.StatusInternalServerError, err.Error())
+        }
+        d.Payload = visiblePayload(f, keys, canReadPII(c, cfg, f), d.Payload)
+        return c.JSON(d)
+    }
+}
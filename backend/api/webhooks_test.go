@@ -0,0 +1,253 @@
+package api
+
+import (
+    "context"
+    "crypto/hmac"
+    "crypto/sha256"
+    "encoding/hex"
+    "encoding/json"
+    "fmt"
+    "io"
+    "net/http"
+    "net/http/httptest"
+    "strconv"
+    "strings"
+    "sync/atomic"
+    "testing"
+    "time"
+
+    "go.mongodb.org/mongo-driver/bson/primitive"
+    "formbuilder/backend/config"
+)
+
+// standIn starts a local receiver answering with the given status codes
+// in turn (repeating the last), and points deliveries at it.
+func standIn(t *testing.T, codes ...int) (*httptest.Server, *int32) {
+    t.Helper()
+    var calls int32
+    srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        n := int(atomic.AddInt32(&calls, 1))
+        if n > len(codes) {
+            n = len(codes)
+        }
+        w.WriteHeader(codes[n-1])
+        io.WriteString(w, "internal details")
+    }))
+    SetWebhookClient(srv.Client())
+    t.Cleanup(func() {
+        srv.Close()
+        SetWebhookClient(nil)
+    })
+    return srv, &calls
+}
+
+func testDelivery() *WebhookDelivery {
+    return &WebhookDelivery{ID: primitive.NewObjectID(), Event: eventResponseCreated, Status: deliveryPending}
+}
+
+func TestSendWebhookSignsBody(t *testing.T) {
+    var got http.Header
+    var gotBody []byte
+    srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        got = r.Header.Clone()
+        gotBody, _ = io.ReadAll(r.Body)
+    }))
+    defer srv.Close()
+    SetWebhookClient(srv.Client())
+    defer SetWebhookClient(nil)
+
+    d := testDelivery()
+    body := []byte(`{"event":"response.created"}`)
+    code, err := sendWebhook(context.Background(), &Webhook{URL: srv.URL, Secret: "whsec_test"}, d, body)
+    if err != nil || code != http.StatusOK {
+        t.Fatalf("send: code=%d err=%v", code, err)
+    }
+    if string(gotBody) != string(body) {
+        t.Fatalf("body = %s", gotBody)
+    }
+    if got.Get("X-FormBuilder-Event") != eventResponseCreated || got.Get("X-FormBuilder-Delivery") != d.ID.Hex() {
+        t.Fatalf("headers = %v", got)
+    }
+
+    var ts, sig string
+    for _, part := range strings.Split(got.Get("X-FormBuilder-Signature"), ",") {
+        k, v, _ := strings.Cut(part, "=")
+        switch k {
+        case "t":
+            ts = v
+        case "v1":
+            sig = v
+        }
+    }
+    if n, err := strconv.ParseInt(ts, 10, 64); err != nil || time.Since(time.Unix(n, 0)) > time.Minute {
+        t.Fatalf("timestamp %q", ts)
+    }
+    mac := hmac.New(sha256.New, []byte("whsec_test"))
+    mac.Write([]byte(ts + "."))
+    mac.Write(body)
+    if want := hex.EncodeToString(mac.Sum(nil)); sig != want {
+        t.Fatalf("v1 = %s, want %s", sig, want)
+    }
+}
+
+func TestWebhookBackoff(t *testing.T) {
+    cfg := &config.Config{WebhookRetryBase: 30 * time.Second, WebhookRetryMax: 5 * time.Minute}
+    want := []time.Duration{30 * time.Second, time.Minute, 2 * time.Minute, 4 * time.Minute, 5 * time.Minute, 5 * time.Minute}
+    for i, w := range want {
+        if got := webhookBackoff(cfg, i+1); got != w {
+            t.Errorf("attempt %d: backoff %s, want %s", i+1, got, w)
+        }
+    }
+}
+
+// deliver runs attempts the way the worker does, without the queue, until
+// the delivery is no longer pending.
+func deliver(t *testing.T, cfg *config.Config, url string) (*WebhookDelivery, []time.Duration) {
+    t.Helper()
+    d := testDelivery()
+    var waits []time.Duration
+    for d.Status == deliveryPending {
+        if d.Attempts > 100 {
+            t.Fatal("delivery never finished")
+        }
+        d.Attempts++
+        code, err := sendWebhook(context.Background(), &Webhook{URL: url, Secret: "s"}, d, []byte(`{}`))
+        status, wait := deliveryOutcome(cfg, d.Attempts, code, err)
+        d.Status, d.LastStatusCode = status, code
+        if status == deliveryPending {
+            waits = append(waits, wait)
+        }
+    }
+    return d, waits
+}
+
+func TestDeliveryGivesUpAfterMaxAttempts(t *testing.T) {
+    srv, calls := standIn(t, http.StatusInternalServerError)
+    cfg := &config.Config{WebhookMaxAttempts: 4, WebhookRetryBase: time.Second, WebhookRetryMax: time.Hour}
+
+    d, waits := deliver(t, cfg, srv.URL)
+    if d.Status != deliveryDead || d.Attempts != 4 || *calls != 4 {
+        t.Fatalf("status=%s attempts=%d calls=%d", d.Status, d.Attempts, *calls)
+    }
+    if fmt.Sprint(waits) != fmt.Sprint([]time.Duration{time.Second, 2 * time.Second, 4 * time.Second}) {
+        t.Fatalf("waits = %v", waits)
+    }
+}
+
+func TestDeliverySucceedsAfterRetries(t *testing.T) {
+    srv, calls := standIn(t, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusNoContent)
+    cfg := &config.Config{WebhookMaxAttempts: 8, WebhookRetryBase: time.Second, WebhookRetryMax: time.Hour}
+
+    d, waits := deliver(t, cfg, srv.URL)
+    if d.Status != deliverySucceeded || d.Attempts != 3 || *calls != 3 || len(waits) != 2 {
+        t.Fatalf("status=%s attempts=%d calls=%d waits=%v", d.Status, d.Attempts, *calls, waits)
+    }
+}
+
+func TestWebhookClientRefusesInternalAddresses(t *testing.T) {
+    srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+    defer srv.Close()
+    if _, err := newWebhookClient().Post(srv.URL, "application/json", strings.NewReader("{}")); err == nil {
+        t.Fatal("dialed a loopback receiver")
+    }
+
+    for _, u := range []string{
+        "http://127.0.0.1/hook", "http://localhost:8080/", "http://10.1.2.3/", "http://192.168.0.10/",
+        "http://169.254.169.254/latest/meta-data/", "http://[::1]/", "http://[fd00::1]/", "http://100.64.0.1/",
+    } {
+        if err := validateWebhook(&WebhookRequest{URL: u, Events: []string{eventResponseCreated}}); err == nil {
+            t.Errorf("%s accepted", u)
+        }
+    }
+    if err := validateWebhook(&WebhookRequest{URL: "https://hooks.example.com/x", Events: []string{eventResponseCreated}}); err != nil {
+        t.Errorf("public URL rejected: %v", err)
+    }
+}
+
+func TestDeliveryPayloadPII(t *testing.T) {
+    f := &Form{ID: primitive.NewObjectID(), Fields: []Field{{ID: "email", IsPII: true}, {ID: "score"}}}
+    keys := testKeys(f.ID)
+    r := Response{ID: primitive.NewObjectID(), Answers: map[string]interface{}{"email": "a@example.com", "score": 4.0},
+        Edits: []ResponseEdit{{Previous: map[string]interface{}{"email": "old@example.com"}}}}
+    body, err := webhookPayload(eventResponseCreated, primitive.NewObjectID(), f, r)
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    stored, err := mapPayloadAnswers(string(body), func(a map[string]interface{}) (map[string]interface{}, error) {
+        return keys.encryptAnswers(f, a)
+    })
+    if err != nil {
+        t.Fatal(err)
+    }
+    if strings.Contains(stored, "example.com") {
+        t.Fatalf("stored payload holds plaintext PII: %s", stored)
+    }
+
+    answers := func(payload string) (map[string]interface{}, map[string]interface{}) {
+        var p struct {
+            Data struct {
+                Answers map[string]interface{}
+                Edits   []struct{ Previous map[string]interface{} }
+            }
+        }
+        if err := json.Unmarshal([]byte(payload), &p); err != nil {
+            t.Fatal(err)
+        }
+        return p.Data.Answers, p.Data.Edits[0].Previous
+    }
+    a, prev := answers(visiblePayload(f, keys, true, stored))
+    if a["email"] != "a@example.com" || a["score"] != 4.0 || prev["email"] != "old@example.com" {
+        t.Fatalf("with PII access: %v %v", a, prev)
+    }
+    a, prev = answers(visiblePayload(f, keys, false, stored))
+    if a["email"] != redactedAnswer || a["score"] != 4.0 || prev["email"] != redactedAnswer {
+        t.Fatalf("without PII access: %v %v", a, prev)
+    }
+}
+
+func TestRuleMatchedPayloadPII(t *testing.T) {
+    f := &Form{ID: primitive.NewObjectID(), Fields: []Field{{ID: "email", IsPII: true}, {ID: "score"}}}
+    keys := testKeys(f.ID)
+    r := &Response{ID: primitive.NewObjectID(), Answers: map[string]interface{}{"email": "a@example.com", "score": 1.0},
+        Edits: []ResponseEdit{{Previous: map[string]interface{}{"email": "old@example.com"}}}}
+    data := map[string]interface{}{"rule": map[string]interface{}{"id": "r1", "name": "Unhappy"}, "response": r}
+    body, err := webhookPayload(eventRuleMatched, primitive.NewObjectID(), f, data)
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    stored, err := mapPayloadAnswers(string(body), func(a map[string]interface{}) (map[string]interface{}, error) {
+        return keys.encryptAnswers(f, a)
+    })
+    if err != nil {
+        t.Fatal(err)
+    }
+    if strings.Contains(stored, "example.com") {
+        t.Fatalf("stored payload holds plaintext PII: %s", stored)
+    }
+
+    answers := func(payload string) (map[string]interface{}, map[string]interface{}, string) {
+        var p struct {
+            Data struct {
+                Rule     struct{ Name string }
+                Response struct {
+                    Answers map[string]interface{}
+                    Edits   []struct{ Previous map[string]interface{} }
+                }
+            }
+        }
+        if err := json.Unmarshal([]byte(payload), &p); err != nil {
+            t.Fatal(err)
+        }
+        return p.Data.Response.Answers, p.Data.Response.Edits[0].Previous, p.Data.Rule.Name
+    }
+    a, prev, rule := answers(visiblePayload(f, keys, true, stored))
+    if a["email"] != "a@example.com" || prev["email"] != "old@example.com" || rule != "Unhappy" {
+        t.Fatalf("with PII access: %v %v %q", a, prev, rule)
+    }
+    a, prev, _ = answers(visiblePayload(f, keys, false, stored))
+    if a["email"] != redactedAnswer || a["score"] != 1.0 || prev["email"] != redactedAnswer {
+        t.Fatalf("without PII access: %v %v", a, prev)
+    }
+}
@@ -6,11 +6,20 @@ import (
 
     "github.com/gofiber/fiber/v2"
     "github.com/gofiber/websocket/v2"
+    "formbuilder/backend/config"
 )
 
 type wsClient struct {
     conn   *websocket.Conn
     formID string
+    role   string // the member's workspace role when they subscribed
+    mfaOK  bool   // the session meets the form's MFA requirement for PII
+    mu     sync.Mutex // serializes writes; broadcasts can run concurrently
+}
+
+// canReadPII mirrors the HTTP check for the subscriber's session.
+func (cl *wsClient) canReadPII() bool {
+    return roleAllows(cl.role, permPIIRead) && cl.mfaOK
 }
 
 var (
@@ -18,10 +27,11 @@ var (
     clients   = map[string]map[*wsClient]bool{} // formID -> set of clients
 )
 
-func AttachWebsocket(app *fiber.App) {
-    app.Get("/ws/forms/:id", websocket.New(func(c *websocket.Conn) {
-        formID := c.Params("id")
-        client := &wsClient{conn: c, formID: formID}
+func AttachWebsocket(app *fiber.App, cfg *config.Config) {
+    app.Get("/ws/forms/:id", wsAuth(cfg), websocket.New(func(c *websocket.Conn) {
+        role, _ := c.Locals("role").(string)
+        mfaOK, _ := c.Locals("piiMFA").(bool)
+        client := &wsClient{conn: c, formID: c.Params("id"), role: role, mfaOK: mfaOK}
 
         register(client)
         defer unregister(client)
@@ -34,6 +44,26 @@ func AttachWebsocket(app *fiber.App) {
     }))
 }
 
+// wsAuth authenticates the upgrade request. Browsers cannot set headers on
+// WebSocket connections, so the session token is passed as ?token=. Only
+// members of the form's workspace may subscribe to its live updates.
+func wsAuth(cfg *config.Config) fiber.Handler {
+    return func(c *fiber.Ctx) error {
+        if err := authenticate(c, cfg, c.Query("token")); err != nil {
+            return err
+        }
+        if err := requirePermission(c, permResponsesRead); err != nil {
+            return err
+        }
+        f, err := formFromParam(c, cfg)
+        if err != nil {
+            return err
+        }
+        c.Locals("piiMFA", requireMFAForPII(c, cfg, f) == nil)
+        return c.Next()
+    }
+}
+
 func register(cl *wsClient) {
     clientsMu.Lock()
     defer clientsMu.Unlock()
@@ -53,14 +83,52 @@ func unregister(cl *wsClient) {
     _ = cl.conn.Close()
 }
 
-func BroadcastResponse(formID string, payload interface{}) {
+// BroadcastResponse announces a new response. r must hold decrypted
+// answers; subscribers without PII access get them masked.
+func BroadcastResponse(f *Form, r *Response) {
+    broadcastResponse(f, "response_created", r)
+}
+
+// BroadcastResponseUpdated announces a respondent's edit so live analytics
+// can refresh.
+func BroadcastResponseUpdated(f *Form, r *Response) {
+    broadcastResponse(f, "response_updated", r)
+}
+
+func broadcastResponse(f *Form, eventType string, r *Response) {
+    masked := hidePII(f, r)
+    broadcastEach(f.ID.Hex(), eventType, func(cl *wsClient) interface{} {
+        if cl.canReadPII() {
+            return r
+        }
+        return masked
+    })
+}
+
+// BroadcastFormStatus announces that a form opened, closed or was otherwise
+// moved to a new status.
+func BroadcastFormStatus(formID, status string) {
+    broadcast(formID, "form_status", map[string]string{"formId": formID, "status": status})
+}
+
+func broadcast(formID, eventType string, payload interface{}) {
+    broadcastEach(formID, eventType, func(*wsClient) interface{} { return payload })
+}
+
+// broadcastEach sends each subscriber the payload chosen for it.
+func broadcastEach(formID, eventType string, payloadFor func(*wsClient) interface{}) {
     clientsMu.Lock()
-    set := clients[formID]
+    var targets []*wsClient
+    for cl := range clients[formID] {
+        targets = append(targets, cl)
+    }
     clientsMu.Unlock()
-    for cl := range set {
+    for _, cl := range targets {
+        cl.mu.Lock()
         _ = cl.conn.WriteJSON(map[string]interface{}{
-            "type": "response_created",
-            "data": payload,
+            "type": eventType,
+            "data": payloadFor(cl),
         })
+        cl.mu.Unlock()
     }
 }
@@ -0,0 +1,613 @@
+package api
+
+import (
+    "context"
+    "fmt"
+    "strings"
+    "time"
+
+    "github.com/gofiber/fiber/v2"
+    "go.mongodb.org/mongo-driver/bson"
+    "go.mongodb.org/mongo-driver/bson/primitive"
+    "go.mongodb.org/mongo-driver/mongo"
+    "formbuilder/backend/config"
+)
+
+type Workspace struct {
+    ID        primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+    Name      string             `bson:"name" json:"name"`
+    Settings  WorkspaceSettings  `bson:"settings" json:"settings"`
+    CreatedBy string             `bson:"createdBy" json:"createdBy"`
+    CreatedAt time.Time          `bson:"createdAt" json:"createdAt"`
+    UpdatedAt time.Time          `bson:"updatedAt" json:"updatedAt"`
+}
+
+type WorkspaceSettings struct {
+    Branding         Branding `bson:"branding" json:"branding"`
+    RetentionDays    int      `bson:"retentionDays" json:"retentionDays"` // 0 keeps responses forever
+    AllowedDomains   []string `bson:"allowedDomains,omitempty" json:"allowedDomains,omitempty"` // member email domains
+    RequireMFAForPII bool     `bson:"requireMfaForPII" json:"requireMfaForPII"`
+}
+
+type Branding struct {
+    DisplayName  string `bson:"displayName,omitempty" json:"displayName,omitempty"`
+    LogoURL      string `bson:"logoUrl,omitempty" json:"logoUrl,omitempty"`
+    PrimaryColor string `bson:"primaryColor,omitempty" json:"primaryColor,omitempty"`
+}
+
+type Membership struct {
+    ID          primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+    WorkspaceID primitive.ObjectID `bson:"workspaceId" json:"workspaceId"`
+    UserID      string             `bson:"userId" json:"userId"`
+    Role        string             `bson:"role" json:"role"`
+    CreatedAt   time.Time          `bson:"createdAt" json:"createdAt"`
+}
+
+// Workspace roles, from most to least privileged.
+const (
+    RoleOwner  = "owner"
+    RoleAdmin  = "admin"
+    RoleEditor = "editor"
+    RoleViewer = "viewer"
+)
+
+// Permissions checked by handlers. Roles map to a fixed set of these.
+const (
+    permFormsRead       = "forms:read"
+    permFormsWrite      = "forms:write"
+    permResponsesRead   = "responses:read"
+    permResponsesExport = "responses:export"
+    permResponsesReview = "responses:review"
+    permResponsesDelete = "responses:delete"
+    permPIIRead         = "pii:read"
+    permPIIExport       = "pii:export" // export PII answers unmasked
+    permKeysManage      = "keys:manage" // rotate or destroy a form's encryption keys
+    permMembersManage   = "members:manage"
+    permSettingsManage  = "settings:manage"
+)
+
+var rolePermissions = map[string][]string{
+    RoleOwner:  {permFormsRead, permFormsWrite, permResponsesRead, permResponsesExport, permResponsesReview, permResponsesDelete, permPIIRead, permPIIExport, permKeysManage, permMembersManage, permSettingsManage},
+    RoleAdmin:  {permFormsRead, permFormsWrite, permResponsesRead, permResponsesExport, permResponsesReview, permResponsesDelete, permPIIRead, permPIIExport, permKeysManage, permMembersManage, permSettingsManage},
+    RoleEditor: {permFormsRead, permFormsWrite, permResponsesRead, permResponsesExport, permResponsesReview},
+    RoleViewer: {permFormsRead, permResponsesRead},
+}
+
+func roleAllows(role, perm string) bool {
+    for _, p := range rolePermissions[role] {
+        if p == perm {
+            return true
+        }
+    }
+    return false
+}
+
+// requirePermission checks the caller's role in the active workspace.
+func requirePermission(c *fiber.Ctx, perm string) error {
+    role, _ := c.Locals("role").(string)
+    if !roleAllows(role, perm) {
+        return fiber.NewError(fiber.StatusForbidden, "Your workspace role does not allow this action")
+    }
+    return nil
+}
+
+type WorkspaceRequest struct {
+    Name     string             `json:"name"`
+    Settings *WorkspaceSettings `json:"settings"`
+}
+
+type MemberRequest struct {
+    Email string `json:"email"`
+    Role  string `json:"role"`
+}
+
+func workspacesCol(cfg *config.Config) *mongo.Collection {
+    return mongoClient(cfg).Database(cfg.MongoDB).Collection("workspaces")
+}
+func membersCol(cfg *config.Config) *mongo.Collection {
+    return mongoClient(cfg).Database(cfg.MongoDB).Collection("workspace_members")
+}
+
+func findMembership(ctx context.Context, cfg *config.Config, workspaceID primitive.ObjectID, userID string) (*Membership, error) {
+    var m Membership
+    err := membersCol(cfg).FindOne(ctx, bson.M{"workspaceId": workspaceID, "userId": userID}).Decode(&m)
+    if err != nil {
+        return nil, err
+    }
+    return &m, nil
+}
+
+// resolveMembership returns the caller's membership in the workspace named by
+// the token, or in their default workspace for tokens issued before
+// workspaces existed.
+func resolveMembership(ctx context.Context, cfg *config.Config, userID, workspaceID string) (*Membership, error) {
+    if workspaceID != "" {
+        oid, err := primitive.ObjectIDFromHex(workspaceID)
+        if err != nil {
+            return nil, err
+        }
+        return findMembership(ctx, cfg, oid, userID)
+    }
+    uid, err := primitive.ObjectIDFromHex(userID)
+    if err != nil {
+        return nil, err
+    }
+    var user User
+    if err := usersCol(cfg).FindOne(ctx, bson.M{"_id": uid}).Decode(&user); err != nil {
+        return nil, err
+    }
+    return defaultMembership(ctx, cfg, &user)
+}
+
+// defaultMembership picks the workspace a session starts in: the user's last
+// selected workspace, else any workspace they belong to, else a newly
+// created personal workspace.
+func defaultMembership(ctx context.Context, cfg *config.Config, user *User) (*Membership, error) {
+    userID := user.ID.Hex()
+    if !user.DefaultWorkspaceID.IsZero() {
+        if m, err := findMembership(ctx, cfg, user.DefaultWorkspaceID, userID); err == nil {
+            return m, nil
+        }
+    }
+    var m Membership
+    err := membersCol(cfg).FindOne(ctx, bson.M{"userId": userID}).Decode(&m)
+    if err == nil {
+        return &m, nil
+    }
+    if err != mongo.ErrNoDocuments {
+        return nil, err
+    }
+    return createPersonalWorkspace(ctx, cfg, user)
+}
+
+// createPersonalWorkspace gives a user their own workspace and moves any
+// forms they owned before workspaces existed into it.
+func createPersonalWorkspace(ctx context.Context, cfg *config.Config, user *User) (*Membership, error) {
+    name := user.Name
+    if name == "" {
+        name = strings.SplitN(user.Email, "@", 2)[0]
+    }
+    ws, m, err := createWorkspace(ctx, cfg, name+"'s workspace", user.ID.Hex())
+    if err != nil {
+        return nil, err
+    }
+
+    userID := user.ID.Hex()
+    legacy := bson.M{"ownerId": userID, "workspaceId": bson.M{"$exists": false}}
+    cur, err := formsCol(cfg).Find(ctx, legacy)
+    if err != nil {
+        return nil, err
+    }
+    var forms []Form
+    if err := cur.All(ctx, &forms); err != nil {
+        return nil, err
+    }
+    if len(forms) > 0 {
+        ids := make([]primitive.ObjectID, 0, len(forms))
+        for _, f := range forms {
+            ids = append(ids, f.ID)
+        }
+        if _, err := formsCol(cfg).UpdateMany(ctx, bson.M{"_id": bson.M{"$in": ids}}, bson.M{"$set": bson.M{"workspaceId": ws.ID}}); err != nil {
+            return nil, err
+        }
+        if _, err := responsesCol(cfg).UpdateMany(ctx, bson.M{"formId": bson.M{"$in": ids}}, bson.M{"$set": bson.M{"workspaceId": ws.ID}}); err != nil {
+            return nil, err
+        }
+    }
+
+    _, err = usersCol(cfg).UpdateByID(ctx, user.ID, bson.M{"$set": bson.M{"defaultWorkspaceId": ws.ID}})
+    return m, err
+}
+
+func createWorkspace(ctx context.Context, cfg *config.Config, name, ownerID string) (*Workspace, *Membership, error) {
+    now := time.Now()
+    ws := &Workspace{
+        ID:        primitive.NewObjectID(),
+        Name:      name,
+        CreatedBy: ownerID,
+        CreatedAt: now,
+        UpdatedAt: now,
+    }
+    if _, err := workspacesCol(cfg).InsertOne(ctx, ws); err != nil {
+        return nil, nil, err
+    }
+    m := &Membership{
+        ID:          primitive.NewObjectID(),
+        WorkspaceID: ws.ID,
+        UserID:      ownerID,
+        Role:        RoleOwner,
+        CreatedAt:   now,
+    }
+    if _, err := membersCol(cfg).InsertOne(ctx, m); err != nil {
+        return nil, nil, err
+    }
+    return ws, m, nil
+}
+
+func emailDomainAllowed(settings WorkspaceSettings, email string) bool {
+    if len(settings.AllowedDomains) == 0 {
+        return true
+    }
+    parts := strings.SplitN(strings.ToLower(email), "@", 2)
+    if len(parts) != 2 {
+        return false
+    }
+    for _, d := range settings.AllowedDomains {
+        if strings.ToLower(strings.TrimPrefix(d, "@")) == parts[1] {
+            return true
+        }
+    }
+    return false
+}
+
+func validRole(role string) bool {
+    _, ok := rolePermissions[role]
+    return ok
+}
+
+// workspaceFromParam loads the workspace in the route and the caller's
+// membership in it. Non-members get 404 so workspace IDs cannot be probed.
+func workspaceFromParam(c *fiber.Ctx, cfg *config.Config) (*Workspace, *Membership, error) {
+    oid, err := primitive.ObjectIDFromHex(c.Params("id"))
+    if err != nil {
+        return nil, nil, fiber.NewError(fiber.StatusBadRequest, "invalid id")
+    }
+    m, err := findMembership(c.Context(), cfg, oid, c.Locals("userID").(string))
+    if err != nil {
+        if err == mongo.ErrNoDocuments {
+            return nil, nil, fiber.NewError(fiber.StatusNotFound, "workspace not found")
+        }
+        return nil, nil, fiber.NewError(fiber.StatusInternalServerError, err.Error())
+    }
+    var ws Workspace
+    if err := workspacesCol(cfg).FindOne(c.Context(), bson.M{"_id": oid}).Decode(&ws); err != nil {
+        if err == mongo.ErrNoDocuments {
+            return nil, nil, fiber.NewError(fiber.StatusNotFound, "workspace not found")
+        }
+        return nil, nil, fiber.NewError(fiber.StatusInternalServerError, err.Error())
+    }
+    return &ws, m, nil
+}
+
+// countOwners is used to stop a workspace from losing its last owner.
+func countOwners(ctx context.Context, cfg *config.Config, workspaceID primitive.ObjectID) (int64, error) {
+    return membersCol(cfg).CountDocuments(ctx, bson.M{"workspaceId": workspaceID, "role": RoleOwner})
+}
+
+func ListWorkspacesHandler(cfg *config.Config) fiber.Handler {
+    return func(c *fiber.Ctx) error {
+        userID := c.Locals("userID").(string)
+        cur, err := membersCol(cfg).Find(c.Context(), bson.M{"userId": userID})
+        if err != nil {
+            return fiber.NewError(fiber.StatusInternalServerError, err.Error())
+        }
+        var memberships []Membership
+        if err := cur.All(c.Context(), &memberships); err != nil {
+            return fiber.NewError(fiber.StatusInternalServerError, err.Error())
+        }
+
+        roles := map[primitive.ObjectID]string{}
+        ids := make([]primitive.ObjectID, 0, len(memberships))
+        for _, m := range memberships {
+            roles[m.WorkspaceID] = m.Role
+            ids = append(ids, m.WorkspaceID)
+        }
+        cur, err = workspacesCol(cfg).Find(c.Context(), bson.M{"_id": bson.M{"$in": ids}})
+        if err != nil {
+            return fiber.NewError(fiber.StatusInternalServerError, err.Error())
+        }
+        var workspaces []Workspace
+        if err := cur.All(c.Context(), &workspaces); err != nil {
+            return fiber.NewError(fiber.StatusInternalServerError, err.Error())
+        }
+
+        current, _ := c.Locals("workspaceID").(primitive.ObjectID)
+        out := make([]fiber.Map, 0, len(workspaces))
+        for _, ws := range workspaces {
+            out = append(out, fiber.Map{
+                "workspace": ws,
+                "role":      roles[ws.ID],
+                "current":   ws.ID == current,
+            })
+        }
+        return c.JSON(out)
+    }
+}
+
+func CreateWorkspaceHandler(cfg *config.Config) fiber.Handler {
+    return func(c *fiber.Ctx) error {
+        var req WorkspaceRequest
+        if err := c.BodyParser(&req); err != nil {
+            return fiber.NewError(fiber.StatusBadRequest, err.Error())
+        }
+        req.Name = strings.TrimSpace(req.Name)
+        if req.Name == "" {
+            return fiber.NewError(fiber.StatusBadRequest, "Workspace name is required")
+        }
+
+        ws, _, err := createWorkspace(c.Context(), cfg, req.Name, c.Locals("userID").(string))
+        if err != nil {
+            return fiber.NewError(fiber.StatusInternalServerError, err.Error())
+        }
+        if req.Settings != nil {
+            ws.Settings = *req.Settings
+            if _, err := workspacesCol(cfg).UpdateByID(c.Context(), ws.ID, bson.M{"$set": bson.M{"settings": ws.Settings}}); err != nil {
+                return fiber.NewError(fiber.StatusInternalServerError, err.Error())
+            }
+        }
+        recordAudit(c, cfg, AuditEvent{Action: "workspace.created", TargetType: "workspace", TargetID: ws.ID.Hex()})
+        return c.Status(fiber.StatusCreated).JSON(ws)
+    }
+}
+
+func GetWorkspaceHandler(cfg *config.Config) fiber.Handler {
+    return func(c *fiber.Ctx) error {
+        ws, m, err := workspaceFromParam(c, cfg)
+        if err != nil {
+            return err
+        }
+        return c.JSON(fiber.Map{"workspace": ws, "role": m.Role})
+    }
+}
+
+func UpdateWorkspaceHandler(cfg *config.Config) fiber.Handler {
+    return func(c *fiber.Ctx) error {
+        ws, m, err := workspaceFromParam(c, cfg)
+        if err != nil {
+            return err
+        }
+        if !roleAllows(m.Role, permSettingsManage) {
+            return fiber.NewError(fiber.StatusForbidden, "Your workspace role does not allow this action")
+        }
+        var req WorkspaceRequest
+        if err := c.BodyParser(&req); err != nil {
+            return fiber.NewError(fiber.StatusBadRequest, err.Error())
+        }
+
+        set := bson.M{"updatedAt": time.Now()}
+        var changes []string
+        if name := strings.TrimSpace(req.Name); name != "" {
+            set["name"] = name
+        }
+        if req.Settings != nil {
+            if req.Settings.RetentionDays < 0 {
+                return fiber.NewError(fiber.StatusBadRequest, "retentionDays must not be negative")
+            }
+            // The flag guards every PII answer in the workspace, so only a
+            // session established with 2FA may change it
+            if req.Settings.RequireMFAForPII != ws.Settings.RequireMFAForPII {
+                if mfa, _ := c.Locals("mfa").(bool); !mfa {
+                    return fiber.NewError(fiber.StatusForbidden, "Changing requireMfaForPII requires a session established with two-factor authentication")
+                }
+                changes = append(changes, fmt.Sprintf("requireMfaForPII: %v -> %v", ws.Settings.RequireMFAForPII, req.Settings.RequireMFAForPII))
+            }
+            set["settings"] = req.Settings
+        }
+        if _, err := workspacesCol(cfg).UpdateByID(c.Context(), ws.ID, bson.M{"$set": set}); err != nil {
+            return fiber.NewError(fiber.StatusInternalServerError, err.Error())
+        }
+        if err := workspacesCol(cfg).FindOne(c.Context(), bson.M{"_id": ws.ID}).Decode(ws); err != nil {
+            return fiber.NewError(fiber.StatusInternalServerError, err.Error())
+        }
+        recordAudit(c, cfg, AuditEvent{Action: "workspace.updated", TargetType: "workspace", TargetID: ws.ID.Hex(), Changes: changes})
+        return c.JSON(ws)
+    }
+}
+
+func ListMembersHandler(cfg *config.Config) fiber.Handler {
+    return func(c *fiber.Ctx) error {
+        ws, _, err := workspaceFromParam(c, cfg)
+        if err != nil {
+            return err
+        }
+        cur, err := membersCol(cfg).Find(c.Context(), bson.M{"workspaceId": ws.ID})
+        if err != nil {
+            return fiber.NewError(fiber.StatusInternalServerError, err.Error())
+        }
+        var members []Membership
+        if err := cur.All(c.Context(), &members); err != nil {
+            return fiber.NewError(fiber.StatusInternalServerError, err.Error())
+        }
+
+        ids := make([]primitive.ObjectID, 0, len(members))
+        for _, m := range members {
+            if oid, err := primitive.ObjectIDFromHex(m.UserID); err == nil {
+                ids = append(ids, oid)
+            }
+        }
+        cur, err = usersCol(cfg).Find(c.Context(), bson.M{"_id": bson.M{"$in": ids}})
+        if err != nil {
+            return fiber.NewError(fiber.StatusInternalServerError, err.Error())
+        }
+        var users []User
+        if err := cur.All(c.Context(), &users); err != nil {
+            return fiber.NewError(fiber.StatusInternalServerError, err.Error())
+        }
+        byID := map[string]User{}
+        for _, u := range users {
+            byID[u.ID.Hex()] = u
+        }
+
+        out := make([]fiber.Map, 0, len(members))
+        for _, m := range members {
+            u := byID[m.UserID]
+            out = append(out, fiber.Map{
+                "userId": m.UserID,
+                "email":  u.Email,
+                "name":   u.Name,
+                "role":   m.Role,
+            })
+        }
+        return c.JSON(out)
+    }
+}
+
+func AddMemberHandler(cfg *config.Config) fiber.Handler {
+    return func(c *fiber.Ctx) error {
+        ws, m, err := workspaceFromParam(c, cfg)
+        if err != nil {
+            return err
+        }
+        if !roleAllows(m.Role, permMembersManage) {
+            return fiber.NewError(fiber.StatusForbidden, "Your workspace role does not allow this action")
+        }
+        var req MemberRequest
+        if err := c.BodyParser(&req); err != nil {
+            return fiber.NewError(fiber.StatusBadRequest, err.Error())
+        }
+        if req.Role == "" {
+            req.Role = RoleEditor
+        }
+        if !validRole(req.Role) {
+            return fiber.NewError(fiber.StatusBadRequest, "invalid role")
+        }
+        if req.Role == RoleOwner && m.Role != RoleOwner {
+            return fiber.NewError(fiber.StatusForbidden, "Only owners can add owners")
+        }
+        if !emailDomainAllowed(ws.Settings, req.Email) {
+            return fiber.NewError(fiber.StatusBadRequest, "Email domain is not allowed in this workspace")
+        }
+
+        var user User
+        if err := usersCol(cfg).FindOne(c.Context(), bson.M{"email": req.Email}).Decode(&user); err != nil {
+            if err == mongo.ErrNoDocuments {
+                return fiber.NewError(fiber.StatusNotFound, "No user with that email")
+            }
+            return fiber.NewError(fiber.StatusInternalServerError, err.Error())
+        }
+
+        member := Membership{
+            ID:          primitive.NewObjectID(),
+            WorkspaceID: ws.ID,
+            UserID:      user.ID.Hex(),
+            Role:        req.Role,
+            CreatedAt:   time.Now(),
+        }
+        if _, err := membersCol(cfg).InsertOne(c.Context(), member); err != nil {
+            if mongo.IsDuplicateKeyError(err) {
+                return fiber.NewError(fiber.StatusConflict, "User is already a member")
+            }
+            return fiber.NewError(fiber.StatusInternalServerError, err.Error())
+        }
+        recordAudit(c, cfg, AuditEvent{
+            Action:     "workspace.member_added",
+            TargetType: "workspace",
+            TargetID:   ws.ID.Hex(),
+            Metadata:   bson.M{"userId": member.UserID, "role": member.Role},
+        })
+        return c.Status(fiber.StatusCreated).JSON(member)
+    }
+}
+
+func UpdateMemberHandler(cfg *config.Config) fiber.Handler {
+    return func(c *fiber.Ctx) error {
+        ws, m, err := workspaceFromParam(c, cfg)
+        if err != nil {
+            return err
+        }
+        if !roleAllows(m.Role, permMembersManage) {
+            return fiber.NewError(fiber.StatusForbidden, "Your workspace role does not allow this action")
+        }
+        var req MemberRequest
+        if err := c.BodyParser(&req); err != nil {
+            return fiber.NewError(fiber.StatusBadRequest, err.Error())
+        }
+        if !validRole(req.Role) {
+            return fiber.NewError(fiber.StatusBadRequest, "invalid role")
+        }
+
+        target, err := findMembership(c.Context(), cfg, ws.ID, c.Params("userId"))
+        if err != nil {
+            return fiber.NewError(fiber.StatusNotFound, "member not found")
+        }
+        if (target.Role == RoleOwner || req.Role == RoleOwner) && m.Role != RoleOwner {
+            return fiber.NewError(fiber.StatusForbidden, "Only owners can change owners")
+        }
+        if target.Role == RoleOwner && req.Role != RoleOwner {
+            if n, err := countOwners(c.Context(), cfg, ws.ID); err != nil || n <= 1 {
+                return fiber.NewError(fiber.StatusBadRequest, "A workspace must keep at least one owner")
+            }
+        }
+
+        if _, err := membersCol(cfg).UpdateByID(c.Context(), target.ID, bson.M{"$set": bson.M{"role": req.Role}}); err != nil {
+            return fiber.NewError(fiber.StatusInternalServerError, err.Error())
+        }
+        recordAudit(c, cfg, AuditEvent{
+            Action:     "workspace.member_role_changed",
+            TargetType: "workspace",
+            TargetID:   ws.ID.Hex(),
+            Metadata:   bson.M{"userId": target.UserID, "from": target.Role, "to": req.Role},
+        })
+        target.Role = req.Role
+        return c.JSON(target)
+    }
+}
+
+func RemoveMemberHandler(cfg *config.Config) fiber.Handler {
+    return func(c *fiber.Ctx) error {
+        ws, m, err := workspaceFromParam(c, cfg)
+        if err != nil {
+            return err
+        }
+        targetID := c.Params("userId")
+        leaving := targetID == m.UserID
+        if !leaving && !roleAllows(m.Role, permMembersManage) {
+            return fiber.NewError(fiber.StatusForbidden, "Your workspace role does not allow this action")
+        }
+
+        target, err := findMembership(c.Context(), cfg, ws.ID, targetID)
+        if err != nil {
+            return fiber.NewError(fiber.StatusNotFound, "member not found")
+        }
+        if target.Role == RoleOwner {
+            if !leaving && m.Role != RoleOwner {
+                return fiber.NewError(fiber.StatusForbidden, "Only owners can remove owners")
+            }
+            if n, err := countOwners(c.Context(), cfg, ws.ID); err != nil || n <= 1 {
+                return fiber.NewError(fiber.StatusBadRequest, "A workspace must keep at least one owner")
+            }
+        }
+
+        if _, err := membersCol(cfg).DeleteOne(c.Context(), bson.M{"_id": target.ID}); err != nil {
+            return fiber.NewError(fiber.StatusInternalServerError, err.Error())
+        }
+        recordAudit(c, cfg, AuditEvent{
+            Action:     "workspace.member_removed",
+            TargetType: "workspace",
+            TargetID:   ws.ID.Hex(),
+            Metadata:   bson.M{"userId": target.UserID},
+        })
+        return c.SendStatus(fiber.StatusNoContent)
+    }
+}
+
+// SwitchWorkspaceHandler issues a token scoped to another workspace the
+// caller belongs to and remembers it as their default.
+func SwitchWorkspaceHandler(cfg *config.Config) fiber.Handler {
+    return func(c *fiber.Ctx) error {
+        ws, m, err := workspaceFromParam(c, cfg)
+        if err != nil {
+            return err
+        }
+        user, err := currentUser(c, cfg)
+        if err != nil {
+            return err
+        }
+        if _, err := usersCol(cfg).UpdateByID(c.Context(), user.ID, bson.M{"$set": bson.M{"defaultWorkspaceId": ws.ID}}); err != nil {
+            return fiber.NewError(fiber.StatusInternalServerError, err.Error())
+        }
+
+        mfa, _ := c.Locals("mfa").(bool)
+        s := sessionFromLocals(c, mfa)
+        s.WorkspaceID = ws.ID.Hex()
+        token, err := generateJWT(s, cfg.JWTSecret)
+        if err != nil {
+            return fiber.NewError(fiber.StatusInternalServerError, "Failed to generate token")
+        }
+        return c.JSON(fiber.Map{
+            "token":     token,
+            "workspace": ws,
+            "role":      m.Role,
+        })
+    }
+}
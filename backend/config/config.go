@@ -1,10 +1,17 @@
 package config
 
 import (
+    "crypto/sha256"
+    "encoding/base64"
+    "io"
     "log"
     "os"
+    "strconv"
+    "strings"
+    "time"
 
     "github.com/joho/godotenv"
+    "golang.org/x/crypto/hkdf"
 )
 
 type Config struct {
@@ -13,6 +20,52 @@ type Config struct {
     Port        string
     JWTSecret   string
     AllowOrigin string
+
+    // Two-factor authentication
+    MFAIssuer        string
+    RequireMFAForPII bool
+
+    // Login brute-force protection
+    LoginMaxAttempts      int
+    LoginMaxAttemptsPerIP int
+    LockoutBase           time.Duration
+    LockoutMax            time.Duration
+
+    // Emails of users allowed to use the /api/admin endpoints
+    AdminEmails []string
+
+    // Outgoing email
+    AppURL   string // frontend base URL used in emailed links
+    MailFrom string
+    MailDir  string // file-sink mailer writes one .eml per message here
+
+    // How long submission Idempotency-Keys are remembered
+    IdempotencyTTL time.Duration
+
+    // How often scheduled forms are opened and expired forms closed
+    SchedulerInterval time.Duration
+
+    // Outbound webhook retries: delay doubles from base up to max
+    WebhookMaxAttempts int
+    WebhookRetryBase   time.Duration
+    WebhookRetryMax    time.Duration
+
+    // How long deleted forms stay in the trash before they are purged
+    TrashRetention time.Duration
+
+    // Background exports: where files are written and how long they are kept
+    ExportDir       string
+    ExportRetention time.Duration
+
+    // Secret that per-form pseudonymization keys are derived from;
+    // without PSEUDONYM_KEY it is derived from JWTSecret with HKDF, so
+    // pseudonyms never reuse the token signing key itself
+    PseudonymKey string
+
+    // Master keys that wrap the per-form keys PII answers are encrypted
+    // with: the current key first, then retired keys still being rewrapped.
+    // Encryption at rest is off when none is configured.
+    MasterKeys [][]byte
 }
 
 func Load() *Config {
@@ -23,7 +76,40 @@ func Load() *Config {
         Port:        env("PORT", "8080"),
         JWTSecret:   env("JWT_SECRET", "changeme"),
         AllowOrigin: env("ALLOW_ORIGIN", "*"),
+
+        MFAIssuer:        env("MFA_ISSUER", "FormBuilder"),
+        RequireMFAForPII: envBool("REQUIRE_MFA_FOR_PII", false),
+
+        LoginMaxAttempts:      envInt("LOGIN_MAX_ATTEMPTS", 5),
+        LoginMaxAttemptsPerIP: envInt("LOGIN_MAX_ATTEMPTS_PER_IP", 20),
+        LockoutBase:           envDuration("LOCKOUT_BASE", time.Minute),
+        LockoutMax:            envDuration("LOCKOUT_MAX", time.Hour),
+
+        AdminEmails: envList("ADMIN_EMAILS"),
+
+        AppURL:   env("APP_URL", "http://localhost:3000"),
+        MailFrom: env("MAIL_FROM", "FormBuilder <no-reply@formbuilder.local>"),
+        MailDir:  env("MAIL_DIR", "./mail"),
+
+        IdempotencyTTL: envDuration("IDEMPOTENCY_TTL", 24*time.Hour),
+
+        SchedulerInterval: envDuration("SCHEDULER_INTERVAL", 30*time.Second),
+
+        WebhookMaxAttempts: envInt("WEBHOOK_MAX_ATTEMPTS", 8),
+        WebhookRetryBase:   envDuration("WEBHOOK_RETRY_BASE", 30*time.Second),
+        WebhookRetryMax:    envDuration("WEBHOOK_RETRY_MAX", 6*time.Hour),
+
+        TrashRetention: envDuration("TRASH_RETENTION", 30*24*time.Hour),
+
+        ExportDir:       env("EXPORT_DIR", "./exports"),
+        ExportRetention: envDuration("EXPORT_RETENTION", 24*time.Hour),
+
+        PseudonymKey: env("PSEUDONYM_KEY", ""),
+    }
+    if cfg.PseudonymKey == "" {
+        cfg.PseudonymKey = deriveKey(cfg.JWTSecret, "formbuilder pseudonym key")
     }
+    cfg.MasterKeys = masterKeys()
     log.Printf("Config loaded. DB=%s Port=%s", cfg.MongoDB, cfg.Port)
     return cfg
 }
@@ -34,3 +120,96 @@ func env(k, def string) string {
     }
     return def
 }
+
+func envBool(k string, def bool) bool {
+    if v := os.Getenv(k); v != "" {
+        b, err := strconv.ParseBool(v)
+        if err != nil {
+            log.Printf("Invalid boolean for %s: %q, using %v", k, v, def)
+            return def
+        }
+        return b
+    }
+    return def
+}
+
+func envInt(k string, def int) int {
+    if v := os.Getenv(k); v != "" {
+        n, err := strconv.Atoi(v)
+        if err != nil {
+            log.Printf("Invalid integer for %s: %q, using %d", k, v, def)
+            return def
+        }
+        return n
+    }
+    return def
+}
+
+func envDuration(k string, def time.Duration) time.Duration {
+    if v := os.Getenv(k); v != "" {
+        // Intervals and timeouts must be positive; a zero ticker panics
+        d, err := time.ParseDuration(v)
+        if err != nil || d <= 0 {
+            log.Printf("Invalid duration for %s: %q, using %s", k, v, def)
+            return def
+        }
+        return d
+    }
+    return def
+}
+
+func envList(k string) []string {
+    var out []string
+    for _, v := range strings.Split(os.Getenv(k), ",") {
+        if v = strings.TrimSpace(v); v != "" {
+            out = append(out, v)
+        }
+    }
+    return out
+}
+
+// deriveKey derives an independent 32-byte key from secret for the purpose
+// named by label.
+func deriveKey(secret, label string) string {
+    key := make([]byte, 32)
+    if _, err := io.ReadFull(hkdf.New(sha256.New, []byte(secret), nil, []byte(label)), key); err != nil {
+        log.Fatalf("Deriving %s: %v", label, err)
+    }
+    return string(key)
+}
+
+// masterKeys reads MASTER_KEY, the lines of MASTER_KEY_FILE and then the
+// retired keys in MASTER_KEYS_PREVIOUS; the first key found is current.
+// Keys are base64-encoded 32-byte AES keys. A malformed key stops the
+// server rather than silently storing PII unencrypted.
+func masterKeys() [][]byte {
+    var encoded []string
+    if v := os.Getenv("MASTER_KEY"); v != "" {
+        encoded = append(encoded, v)
+    }
+    if path := os.Getenv("MASTER_KEY_FILE"); path != "" {
+        b, err := os.ReadFile(path)
+        if err != nil {
+            log.Fatalf("Reading MASTER_KEY_FILE: %v", err)
+        }
+        for _, line := range strings.Split(string(b), "\n") {
+            if line = strings.TrimSpace(line); line != "" && !strings.HasPrefix(line, "#") {
+                encoded = append(encoded, line)
+            }
+        }
+    }
+    encoded = append(encoded, envList("MASTER_KEYS_PREVIOUS")...)
+
+    var keys [][]byte
+    for _, v := range encoded {
+        key, err := base64.StdEncoding.DecodeString(v)
+        if err != nil || len(key) != 32 {
+            log.Fatalf("Invalid master key: expected 32 bytes, base64-encoded")
+        }
+        keys = append(keys, key)
+    }
+    if len(keys) == 0 {
+        log.Printf("No MASTER_KEY configured; PII answers are stored unencrypted")
+    }
+    return keys
+}
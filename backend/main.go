@@ -16,15 +16,19 @@ func main() {
     app := fiber.New()
     app.Use(cors.New(cors.Config{
         AllowOrigins: cfg.AllowOrigin,
-        AllowHeaders: "Origin, Content-Type, Accept, Authorization",
-        AllowMethods: "GET,POST,PUT,DELETE,OPTIONS",
+        AllowHeaders: "Origin, Content-Type, Accept, Authorization, Idempotency-Key, X-Form-Token, X-Proof-Of-Work, X-Respondent-Token, X-Edit-Token",
+        AllowMethods: "GET,POST,PUT,PATCH,DELETE,OPTIONS",
+        // Credentialed requests (the respondent cookie) need a concrete origin
+        AllowCredentials: cfg.AllowOrigin != "*",
     }))
 
     app.Get("/health", func(c *fiber.Ctx) error {
         return c.JSON(fiber.Map{"ok": true})
     })
 
+    api.EnsureIndexes(cfg)
     api.AttachRoutes(app, cfg)
+    api.StartJobs(cfg)
 
     app.Use("/ws", func(c *fiber.Ctx) error {
         if websocket.IsWebSocketUpgrade(c) {
@@ -32,7 +36,7 @@ func main() {
         }
         return fiber.ErrUpgradeRequired
     })
-    api.AttachWebsocket(app)
+    api.AttachWebsocket(app, cfg)
 
     log.Printf("Listening on :%s", cfg.Port)
     if err := app.Listen(":" + cfg.Port); err != nil {
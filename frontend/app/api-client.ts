@@ -23,8 +23,9 @@ export async function createForm(body: any) {
   return res.json();
 }
 
-export async function getAllForms() {
-  const res = await fetch(`${API}/api/forms`, {
+export async function getAllForms(cursor?: string) {
+  const query = cursor ? `?cursor=${encodeURIComponent(cursor)}` : "";
+  const res = await fetch(`${API}/api/forms${query}`, {
     cache: "no-store",
     headers: getAuthHeaders(),
   });
@@ -56,16 +57,87 @@ export async function updateForm(id: string, body: any) {
   return res.json();
 }
 
-export async function submitResponse(id: string, answers: Record<string, any>) {
+// Public view of a published form, including the load token and optional
+// proof-of-work challenge the backend expects back on submission.
+export async function getPublicForm(id: string) {
+  const res = await fetch(`${API}/api/forms/${id}/public`, { cache: "no-store" });
+  if (!res.ok) throw new Error("Failed to load form");
+  return res.json();
+}
+
+function leadingZeroBits(bytes: Uint8Array): number {
+  let zeros = 0;
+  for (const b of bytes) {
+    if (b === 0) { zeros += 8; continue; }
+    return zeros + Math.clz32(b) - 24;
+  }
+  return zeros;
+}
+
+// Finds n such that sha256(`${challenge}:${n}`) has the required leading zero bits.
+export async function solveProofOfWork(challenge: string, bits: number): Promise<string> {
+  const enc = new TextEncoder();
+  for (let n = 0; ; n++) {
+    const digest = await crypto.subtle.digest("SHA-256", enc.encode(`${challenge}:${n}`));
+    if (leadingZeroBits(new Uint8Array(digest)) >= bits) return String(n);
+  }
+}
+
+// submissionId should stay the same across retries of one submission so the
+// backend can deduplicate them (sent as Idempotency-Key).
+export async function submitResponse(
+  id: string,
+  answers: Record<string, any>,
+  submissionId?: string,
+  protection?: { loadToken?: string; proofOfWork?: string; respondentToken?: string }
+) {
+  // Auth is only checked for forms restricted to logged-in respondents
+  const headers: Record<string, string> = { "Content-Type": "application/json", ...getAuthHeaders() };
+  if (submissionId) headers["Idempotency-Key"] = submissionId;
+  if (protection?.loadToken) headers["X-Form-Token"] = protection.loadToken;
+  if (protection?.proofOfWork) headers["X-Proof-Of-Work"] = protection.proofOfWork;
+  if (protection?.respondentToken) headers["X-Respondent-Token"] = protection.respondentToken;
+
   const res = await fetch(`${API}/api/forms/${id}/responses`, {
     method: "POST",
-    headers: { "Content-Type": "application/json" },
+    headers,
+    body: JSON.stringify({ answers }),
+  });
+  if (!res.ok) throw new Error(await res.text());
+  return res.json();
+}
+
+// Loads a submitted response through the respondent's edit link.
+export async function getEditableResponse(id: string, responseId: string, token: string) {
+  const res = await fetch(`${API}/api/forms/${id}/responses/${responseId}/edit`, {
+    cache: "no-store",
+    headers: { "X-Edit-Token": token },
+  });
+  if (!res.ok) throw new Error(await res.text());
+  return res.json();
+}
+
+export async function updateResponse(id: string, responseId: string, token: string, answers: Record<string, any>) {
+  const res = await fetch(`${API}/api/forms/${id}/responses/${responseId}/edit`, {
+    method: "PUT",
+    headers: { "Content-Type": "application/json", "X-Edit-Token": token },
     body: JSON.stringify({ answers }),
   });
   if (!res.ok) throw new Error(await res.text());
   return res.json();
 }
 
+// Emails a one-time link for forms that require a verified email.
+export async function requestRespondentLink(id: string, email: string) {
+  const res = await fetch(`${API}/api/forms/${id}/respondent/verify`, {
+    method: "POST",
+    headers: { "Content-Type": "application/json" },
+    body: JSON.stringify({ email }),
+  });
+  if (!res.ok) throw new Error(await res.text());
+  return res.json();
+}
+
 export async function fetchAnalytics(id: string) {
   const res = await fetch(`${API}/api/forms/${id}/analytics`, {
     cache: "no-store",
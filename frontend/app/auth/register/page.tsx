@@ -8,6 +8,7 @@ export default function Register() {
   const [password, setPassword] = useState("");
   const [loading, setLoading] = useState(false);
   const [error, setError] = useState("");
+  const [message, setMessage] = useState("");
   const router = useRouter();
 
   // Check if already logged in
@@ -35,10 +36,9 @@ export default function Register() {
         throw new Error(errorData);
       }
 
+      // The account is activated from the link sent to the email address
       const data = await res.json();
-      localStorage.setItem("token", data.token);
-      localStorage.setItem("user", JSON.stringify(data.user));
-      router.push("/");
+      setMessage(data.message);
     } catch (error: any) {
       setError(error.message);
     } finally {
@@ -57,6 +57,11 @@ export default function Register() {
           </div>
         )}
 
+        {message ? (
+          <div className="p-3 bg-green-100 dark:bg-green-900/20 border border-green-300 dark:border-green-800 rounded-lg text-green-700 dark:text-green-300">
+            {message}
+          </div>
+        ) : (
         <form onSubmit={handleRegister} className="space-y-4">
           <div>
             <label className="block text-sm font-medium text-gray-700 dark:text-gray-300 mb-2">Name</label>
@@ -105,6 +110,7 @@ export default function Register() {
             {loading ? "Creating account..." : "Register"}
           </button>
         </form>
+        )}
 
         <p className="text-center mt-4 text-gray-600 dark:text-gray-400">
           Already have an account?{" "}
@@ -33,7 +33,8 @@ export default function Analytics({ params }: { params: { id: string } }) {
   }, [id]);
 
   useEffect(() => {
-    const ws = new WebSocket(`${WS}/ws/forms/${id}`);
+    const token = localStorage.getItem("token") ?? "";
+    const ws = new WebSocket(`${WS}/ws/forms/${id}?token=${encodeURIComponent(token)}`);
     ws.onopen = () => setConnected(true);
     ws.onclose = () => setConnected(false);
     ws.onmessage = () => refresh();
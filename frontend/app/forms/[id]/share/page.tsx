@@ -1,6 +1,6 @@
 "use client";
 import { useEffect, useState } from "react";
-import { getForm, submitResponse } from "../../../api-client";
+import { getEditableResponse, getPublicForm, requestRespondentLink, solveProofOfWork, submitResponse, updateResponse } from "../../../api-client";
 
 export default function Share({ params }: { params: { id: string } }) {
   const id = params.id;
@@ -11,11 +11,32 @@ export default function Share({ params }: { params: { id: string } }) {
   const [loading, setLoading] = useState(true);
   const [submitting, setSubmitting] = useState(false);
   const [currentStep, setCurrentStep] = useState(0);
+  const [submissionId] = useState(() => crypto.randomUUID());
+  // Magic link token for forms that require a verified email
+  const [emailToken] = useState(() =>
+    typeof window === "undefined" ? null : new URLSearchParams(window.location.search).get("respondent")
+  );
+  const [respondentEmail, setRespondentEmail] = useState("");
+  const [linkSent, setLinkSent] = useState(false);
+  // Set when opened from an edit link, or after submitting to a form that allows edits
+  const [editLink, setEditLink] = useState<{ responseId: string; token: string } | null>(() => {
+    if (typeof window === "undefined") return null;
+    const q = new URLSearchParams(window.location.search);
+    const responseId = q.get("response"), token = q.get("edit");
+    return responseId && token ? { responseId, token } : null;
+  });
+  const [editing] = useState(() => editLink !== null);
 
   useEffect(() => { 
-    getForm(id)
+    const load = editing && editLink
+      ? getEditableResponse(id, editLink.responseId, editLink.token).then(data => {
+          setAnswers(data.response.answers);
+          return data.form;
+        })
+      : getPublicForm(id);
+    load
       .then(setForm)
-      .catch(() => setError("Form not found"))
+      .catch(() => setError(editing ? "This edit link is invalid or has expired" : "Form not found"))
       .finally(() => setLoading(false));
   }, [id]);
 
@@ -42,7 +63,7 @@ export default function Share({ params }: { params: { id: string } }) {
     );
   }
 
-  if (form.status !== "published") {
+  if (form.status !== "published" && !editing) {
     return (
       <div className="min-h-screen bg-gradient-to-br from-blue-50 to-indigo-100 dark:from-gray-900 dark:to-gray-800 flex items-center justify-center">
         <div className="bg-white dark:bg-gray-800 rounded-xl shadow-lg p-8 text-center max-w-md">
@@ -54,6 +75,38 @@ export default function Share({ params }: { params: { id: string } }) {
     );
   }
 
+  if (form.respondentPolicy === "email" && !emailToken) {
+    return (
+      <div className="min-h-screen bg-gradient-to-br from-blue-50 to-indigo-100 dark:from-gray-900 dark:to-gray-800 flex items-center justify-center">
+        <div className="bg-white dark:bg-gray-800 rounded-xl shadow-lg p-8 text-center max-w-md w-full">
+          <span className="text-6xl mb-4 block">✉️</span>
+          <h2 className="text-2xl font-bold mb-2">{form.title}</h2>
+          {linkSent ? (
+            <p className="text-gray-600 dark:text-gray-400">Check your inbox for a link to respond to this form.</p>
+          ) : (
+            <>
+              <p className="text-gray-600 dark:text-gray-400 mb-4">This form accepts one response per email address. Enter yours to get a link.</p>
+              {error && <p className="text-red-600 mb-4">{error}</p>}
+              <input
+                type="email"
+                className="w-full p-3 border-2 border-gray-200 dark:border-gray-600 rounded-lg bg-transparent mb-4"
+                placeholder="you@example.com"
+                value={respondentEmail}
+                onChange={e => setRespondentEmail(e.target.value)}
+              />
+              <button
+                onClick={() => requestRespondentLink(id, respondentEmail).then(() => setLinkSent(true)).catch(() => setError("Could not send the link. Please try again."))}
+                className="w-full px-6 py-3 bg-blue-600 text-white rounded-lg hover:bg-blue-700 transition-colors font-medium"
+              >
+                Send link
+              </button>
+            </>
+          )}
+        </div>
+      </div>
+    );
+  }
+
   function visible(field: any) {
     if (!field.showIf) return true;
     const val = answers[field.showIf.fieldId];
@@ -87,7 +140,20 @@ export default function Share({ params }: { params: { id: string } }) {
     setSubmitting(true);
     
     try {
-      await submitResponse(id, answers);
+      if (editing && editLink) {
+        await updateResponse(id, editLink.responseId, editLink.token, answers);
+        setDone(true);
+        return;
+      }
+      const proofOfWork = form.proofOfWork
+        ? await solveProofOfWork(form.proofOfWork.challenge, form.proofOfWork.bits)
+        : undefined;
+      const created = await submitResponse(id, answers, submissionId, {
+        loadToken: form.loadToken,
+        proofOfWork,
+        respondentToken: form.respondentToken ?? emailToken ?? undefined,
+      });
+      if (created.editToken) setEditLink({ responseId: created.id, token: created.editToken });
       setDone(true);
     } catch (error) {
       setError("Failed to submit response. Please try again.");
@@ -108,9 +174,17 @@ export default function Share({ params }: { params: { id: string } }) {
               <span className="text-4xl">✅</span>
             </div>
             <h2 className="text-2xl font-bold text-gray-800 dark:text-gray-200 mb-2">Thank You!</h2>
-            <p className="text-gray-600 dark:text-gray-400 mb-6">Your response has been submitted successfully.</p>
+            <p className="text-gray-600 dark:text-gray-400 mb-6">Your response has been {editing ? "updated" : "submitted"} successfully.</p>
           </div>
           <div className="space-y-3">
+            {editLink && !editing && (
+              <a
+                href={`/forms/${id}/share?response=${editLink.responseId}&edit=${encodeURIComponent(editLink.token)}`}
+                className="block w-full px-6 py-3 border-2 border-blue-500 text-blue-600 rounded-lg hover:bg-blue-50 dark:hover:bg-gray-700 transition-colors font-medium"
+              >
+                Edit your response
+              </a>
+            )}
             <a 
               href={`/forms/${id}/analytics`}
               className="block w-full px-6 py-3 bg-blue-600 text-white rounded-lg hover:bg-blue-700 transition-colors font-medium"
@@ -6,15 +6,17 @@ import AuthGuard from "../../components/AuthGuard";
 export default function MyForms() {
   const [forms, setForms] = useState<any[]>([]);
   const [loading, setLoading] = useState(true);
+  const [nextCursor, setNextCursor] = useState<string | undefined>();
 
   useEffect(() => {
     loadForms();
   }, []);
 
-  async function loadForms() {
+  async function loadForms(cursor?: string) {
     try {
-      const data = await getAllForms();
-      setForms(data);
+      const data = await getAllForms(cursor);
+      setForms((prev) => (cursor ? [...prev, ...data.forms] : data.forms));
+      setNextCursor(data.nextCursor);
     } catch (error) {
       console.error('Failed to load forms:', error);
     } finally {
@@ -44,7 +46,7 @@ export default function MyForms() {
         </div>
 
         {/* Forms Grid */}
-        {forms.length > 0 ? (
+        {forms.length > 0 && (
           <div className="grid grid-cols-1 md:grid-cols-2 lg:grid-cols-3 gap-6">
             {forms.map((form) => (
               <div key={form.id} className="bg-white dark:bg-gray-800 rounded-xl shadow-lg p-6 border border-gray-200 dark:border-gray-700 hover:shadow-xl transition-all">
@@ -66,6 +68,10 @@ export default function MyForms() {
                 <div className="text-sm text-gray-500 dark:text-gray-400 mb-4">
                   <p>Created: {new Date(form.createdAt).toLocaleDateString()}</p>
                   <p>Updated: {new Date(form.updatedAt).toLocaleDateString()}</p>
+                  <p>
+                    Responses: {form.responseCount}
+                    {form.lastResponseAt && ` (last ${new Date(form.lastResponseAt).toLocaleDateString()})`}
+                  </p>
                 </div>
 
                 <div className="flex flex-wrap gap-2">
@@ -97,7 +103,20 @@ export default function MyForms() {
               </div>
             ))}
           </div>
-        ) : (
+        )}
+
+        {forms.length > 0 && nextCursor && (
+          <div className="text-center mt-8">
+            <button
+              onClick={() => loadForms(nextCursor)}
+              className="px-6 py-3 bg-white dark:bg-gray-800 text-gray-800 dark:text-gray-200 rounded-lg shadow hover:shadow-md transition-all font-medium"
+            >
+              Load more
+            </button>
+          </div>
+        )}
+
+        {forms.length === 0 && (
           <div className="bg-white dark:bg-gray-800 rounded-xl shadow-lg p-12 text-center">
             <span className="text-6xl mb-4 block">📝</span>
             <h3 className="text-2xl font-bold mb-2 text-gray-800 dark:text-gray-200">No Forms Yet</h3>